@@ -0,0 +1,231 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/your-username/notification-relay/delivery"
+	"github.com/your-username/notification-relay/store"
+)
+
+// exportBundleVersion is the current version of the export format
+const exportBundleVersion = 1
+
+// exportBundle holds the configuration and all data files of a relay
+type exportBundle struct {
+	Version          int                                       `json:"version"`
+	ExportedAt       string                                    `json:"exported_at,omitempty"`
+	Config           Config                                    `json:"config"`
+	Credentials      store.Credentials                         `json:"credentials"`
+	UserDeviceMap    store.Devices                             `json:"user_device_map"`
+	Subscriptions    store.Subscriptions                       `json:"topic_subscriptions"`
+	Decorations      map[string]map[string]delivery.Decoration `json:"decorations"`
+	TopicDecorations map[string]delivery.TopicDecoration       `json:"topic_decorations"`
+	Icons            map[string]string                         `json:"icons"`
+}
+
+func runMigrate(args []string, out io.Writer) error {
+	if err := parseFlags(newFlagSet("migrate", "", out), args, 0); err != nil {
+		return err
+	}
+	// Loading creates missing data files with their defaults
+	s, lock, err := loadStoreForWrite("migrate")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.release() }()
+
+	tokens, users := s.devices.Normalize()
+	state := s.currentState()
+	files := []struct {
+		name  string
+		value interface{}
+	}{
+		{CredentialsJSON, s.credentials},
+		{UserDeviceMapJSON, s.devices},
+		{TopicSubscriptionsJSON, s.subscriptions},
+		{DecorationJSON, state.decorations},
+		{TopicDecorationJSON, state.topicDecorations},
+		{IconsJSON, state.icons},
+	}
+	for _, file := range files {
+		if err := s.saveJSON(file.name, file.value); err != nil {
+			return fmt.Errorf("failed to write %s: %v", file.name, err)
+		}
+	}
+
+	fmt.Fprintf(out, "Removed %d duplicate or empty token(s) and %d user(s) without tokens\n", tokens, users)
+	fmt.Fprintf(out, "Rewrote %d data file(s) in %s\n", len(files), filepath.Dir(s.configPath))
+	return nil
+}
+
+func runExport(args []string, out io.Writer) error {
+	flags := newFlagSet("export", "[file]", out)
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	s, err := loadStore()
+	if err != nil {
+		return err
+	}
+
+	state := s.currentState()
+	bundle := exportBundle{
+		Version:          exportBundleVersion,
+		ExportedAt:       time.Now().UTC().Format(time.RFC3339),
+		Config:           state.config,
+		Credentials:      s.credentials,
+		UserDeviceMap:    s.devices,
+		Subscriptions:    s.subscriptions,
+		Decorations:      state.decorations,
+		TopicDecorations: state.topicDecorations,
+		Icons:            state.icons,
+	}
+
+	// The bundle contains API secrets, so files are only readable by the owner
+	if path := flags.Arg(0); path != "" && path != "-" {
+		if err := store.WriteJSON(path, bundle); err != nil {
+			return fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		users, tokens := s.devices.Count()
+		fmt.Fprintf(out, "Exported %d project(s), %d credential(s) and %d token(s) of %d user(s) to %s\n",
+			len(state.config.Projects), len(s.credentials), tokens, users, path)
+		return nil
+	}
+
+	data, err := json.MarshalIndent(bundle, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
+
+func runImport(args []string, out io.Writer) error {
+	flags := newFlagSet("import", "<file>", out)
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	path := flags.Arg(0)
+	if path == "" {
+		return usageError{"import", errors.New("missing bundle file, use - for standard input")}
+	}
+
+	var content []byte
+	var err error
+	if path == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(filepath.Clean(path)) // #nosec G304 -- path is given by the operator
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read bundle: %v", err)
+	}
+
+	// The bundle is validated against the destination, where a relative
+	// jwks_file is resolved. Without a configuration, e.g. on a new host,
+	// import creates it at the default location.
+	configPath, serviceAccountPath, err := (Options{}).locate()
+	if errors.Is(err, errConfigNotFound) {
+		configPath, serviceAccountPath = defaultConfigPath, findServiceAccountPath()
+	} else if err != nil {
+		return err
+	}
+	s := newServer(configPath, serviceAccountPath)
+
+	bundle, err := decodeBundle(configPath, content)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(configPath), 0o700); err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(configPath), err)
+	}
+	lock, err := lockStore(configPath, "import")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.release() }()
+
+	files := []struct {
+		name  string
+		value interface{}
+	}{
+		{CredentialsJSON, bundle.Credentials},
+		{UserDeviceMapJSON, bundle.UserDeviceMap},
+		{TopicSubscriptionsJSON, bundle.Subscriptions},
+		{DecorationJSON, bundle.Decorations},
+		{TopicDecorationJSON, bundle.TopicDecorations},
+		{IconsJSON, bundle.Icons},
+		{ConfigJSON, bundle.Config},
+	}
+	for _, file := range files {
+		if err := s.saveJSON(file.name, file.value); err != nil {
+			return fmt.Errorf("failed to write %s: %v", file.name, err)
+		}
+	}
+
+	users, tokens := bundle.UserDeviceMap.Count()
+	fmt.Fprintf(out, "Imported %d project(s), %d credential(s) and %d token(s) of %d user(s) into %s\n",
+		len(bundle.Config.Projects), len(bundle.Credentials), tokens, users, filepath.Dir(configPath))
+	return nil
+}
+
+// decodeBundle strictly decodes and validates an export bundle to be imported
+// next to configPath
+func decodeBundle(configPath string, content []byte) (*exportBundle, error) {
+	const file = "bundle"
+	var raw interface{}
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %v", err)
+	}
+	problems := checkSchema(file, "", raw, reflect.TypeOf(exportBundle{}))
+	if len(problems) > 0 {
+		return nil, joinProblems(problems)
+	}
+
+	bundle := &exportBundle{}
+	if err := json.Unmarshal(content, bundle); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %v", err)
+	}
+	if bundle.Version != exportBundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d, expected %d", bundle.Version, exportBundleVersion)
+	}
+
+	state := &reloadableState{
+		config:           bundle.Config,
+		decorations:      bundle.Decorations,
+		topicDecorations: bundle.TopicDecorations,
+		icons:            bundle.Icons,
+	}
+	if err := joinProblems(state.validate(configPath)); err != nil {
+		return nil, err
+	}
+
+	// Missing sections import as empty files
+	if bundle.Credentials == nil {
+		bundle.Credentials = make(store.Credentials)
+	}
+	if bundle.UserDeviceMap == nil {
+		bundle.UserDeviceMap = make(map[string]map[string][]string)
+	}
+	if bundle.Subscriptions == nil {
+		bundle.Subscriptions = make(store.Subscriptions)
+	}
+	if bundle.Decorations == nil {
+		bundle.Decorations = make(map[string]map[string]delivery.Decoration)
+	}
+	if bundle.TopicDecorations == nil {
+		bundle.TopicDecorations = make(map[string]delivery.TopicDecoration)
+	}
+	if bundle.Icons == nil {
+		bundle.Icons = make(map[string]string)
+	}
+	return bundle, nil
+}
@@ -0,0 +1,217 @@
+package relay
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/your-username/notification-relay/store"
+)
+
+// DefaultBroadcastMaxUsers is the number of users a broadcast may reach unless
+// broadcast_max_users is configured
+const DefaultBroadcastMaxUsers = 10000
+
+// maxBroadcastHistory limits the finished broadcasts kept for listing
+const maxBroadcastHistory = 20
+
+// Broadcast states
+const (
+	broadcastRunning   = "running"
+	broadcastCompleted = "completed"
+)
+
+// Broadcast is the progress of a broadcast to every user of a site
+type Broadcast struct {
+	ID         string     `json:"id"` // Generated when the broadcast starts
+	Key        string     `json:"key"`
+	Status     string     `json:"status"`
+	Users      int        `json:"users"`
+	Devices    int        `json:"devices"`
+	Processed  int        `json:"processed"` // Devices a send was attempted to
+	Sent       int        `json:"sent"`
+	Failed     int        `json:"failed"`
+	Removed    int        `json:"removed"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"` // Unset while running
+}
+
+// broadcastTracker records running and recently finished broadcasts. Only one
+// broadcast per site runs at a time.
+type broadcastTracker struct {
+	mu      sync.Mutex
+	entries []*Broadcast // Oldest first
+	now     func() time.Time
+}
+
+func newBroadcastTracker() *broadcastTracker {
+	return &broadcastTracker{now: time.Now}
+}
+
+// start records a new broadcast to a site. Returns nil if one is already running.
+func (t *broadcastTracker) start(id, key string, users, devices int) *Broadcast {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, entry := range t.entries {
+		if entry.Key == key && entry.Status == broadcastRunning {
+			return nil
+		}
+	}
+
+	entry := &Broadcast{
+		ID:        id,
+		Key:       key,
+		Status:    broadcastRunning,
+		Users:     users,
+		Devices:   devices,
+		StartedAt: t.now(),
+	}
+	t.entries = append(t.entries, entry)
+	t.prune()
+	return entry
+}
+
+// update records the progress of a broadcast after a batch
+func (t *broadcastTracker) update(entry *Broadcast, processed int, totals DeliveryResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry.Processed = processed
+	entry.Sent, entry.Failed, entry.Removed = totals.Sent, totals.Failed, totals.Removed
+}
+
+// finish marks a broadcast completed and returns a copy of its final state
+func (t *broadcastTracker) finish(entry *Broadcast, result BulkDeliveryResult) Broadcast {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry.Status = broadcastCompleted
+	entry.Processed = entry.Devices
+	entry.Sent, entry.Failed, entry.Removed = result.Sent, result.Failed, result.Removed
+	finishedAt := t.now()
+	entry.FinishedAt = &finishedAt
+	return *entry
+}
+
+// prune drops the oldest finished broadcasts beyond maxBroadcastHistory
+func (t *broadcastTracker) prune() {
+	finished := 0
+	for _, entry := range t.entries {
+		if entry.Status != broadcastRunning {
+			finished++
+		}
+	}
+
+	kept := t.entries[:0]
+	for _, entry := range t.entries {
+		if entry.Status != broadcastRunning && finished > maxBroadcastHistory {
+			finished--
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	t.entries = kept
+}
+
+// list returns copies of the tracked broadcasts, newest first
+func (t *broadcastTracker) list() []Broadcast {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := make([]Broadcast, 0, len(t.entries))
+	for i := len(t.entries) - 1; i >= 0; i-- {
+		list = append(list, *t.entries[i])
+	}
+	return list
+}
+
+// broadcastMaxUsers returns the configured limit of users per broadcast
+func (s *Server) broadcastMaxUsers() int {
+	if maxUsers := s.currentConfig().BroadcastMaxUsers; maxUsers > 0 {
+		return maxUsers
+	}
+	return DefaultBroadcastMaxUsers
+}
+
+// StartBroadcast starts sending a notification to every device of every user
+// of a site. The request must set confirm, and is refused when the site has
+// more users than max_users or the configured limit. Without confirm the error
+// names the number of users and devices that would be reached. The broadcast
+// runs in the background; its progress is listed by Broadcasts, and Shutdown
+// waits until all its batches are sent.
+func (s *Server) StartBroadcast(ctx context.Context, req BroadcastRequest) (Broadcast, error) {
+	if err := s.ValidateProject(req.ProjectName); err != nil {
+		return Broadcast{}, err
+	}
+	if err := ValidateNotification(req.Title, req.Body); err != nil {
+		return Broadcast{}, newOpError(http.StatusBadRequest, "%v", err)
+	}
+	if req.MaxUsers < 0 {
+		return Broadcast{}, newOpError(http.StatusBadRequest, "max_users must not be negative")
+	}
+
+	key := store.Key(req.ProjectName, req.SiteName)
+	s.devicesMu.RLock()
+	users := s.devices.Users(key)
+	devices := 0
+	for _, userID := range users {
+		devices += len(s.devices.Tokens(key, userID))
+	}
+	s.devicesMu.RUnlock()
+	if len(users) == 0 {
+		return Broadcast{}, newOpError(http.StatusNotFound, "No users registered for %s", key)
+	}
+
+	limit := s.broadcastMaxUsers()
+	if req.MaxUsers > 0 && req.MaxUsers < limit {
+		limit = req.MaxUsers
+	}
+	if len(users) > limit {
+		return Broadcast{}, newOpError(http.StatusConflict, "Broadcast would reach %d user(s), more than the limit of %d", len(users), limit)
+	}
+	if !req.Confirm {
+		return Broadcast{}, newOpError(http.StatusBadRequest, "Broadcast would reach %d user(s) on %d device(s), set confirm to send it", len(users), devices)
+	}
+
+	done, err := s.deliveries.start()
+	if err != nil {
+		return Broadcast{}, newOpError(http.StatusServiceUnavailable, "Server is shutting down")
+	}
+	entry := s.broadcasts.start(NewRequestID(), key, len(users), devices)
+	if entry == nil {
+		done()
+		return Broadcast{}, newOpError(http.StatusConflict, "A broadcast to %s is already running", key)
+	}
+	started := *entry
+
+	// The broadcast outlives the request, but keeps its request ID for logs.
+	// Its batches are sent within the delivery started above.
+	ctx = withHeldDelivery(context.WithoutCancel(ctx))
+	logger := deliveryLog.With("project", req.ProjectName, "site", req.SiteName, "broadcast", entry.ID)
+	logger.InfoContext(ctx, "Starting broadcast", "user_count", len(users), "token_count", devices)
+	progress := func(processed, total int, totals DeliveryResult) {
+		s.broadcasts.update(entry, processed, totals)
+		logger.InfoContext(ctx, "Broadcast progress", "processed", processed, "token_count", total, "sent", totals.Sent, "failed", totals.Failed)
+	}
+	msg := BulkMessageRequest{
+		UserIDs: users,
+		Title:   req.Title,
+		Body:    req.Body,
+		Data:    req.Data,
+	}
+
+	go func() {
+		defer done()
+		result := s.deliverToUsers(ctx, req.ProjectName, req.SiteName, msg, progress, logger)
+		final := s.broadcasts.finish(entry, result)
+		logger.InfoContext(ctx, "Finished broadcast", "sent", final.Sent, "failed", final.Failed, "removed", final.Removed)
+	}()
+	return started, nil
+}
+
+// Broadcasts returns running and recently finished broadcasts, newest first
+func (s *Server) Broadcasts() []Broadcast {
+	return s.broadcasts.list()
+}
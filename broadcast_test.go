@@ -0,0 +1,271 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/your-username/notification-relay/delivery"
+	"github.com/your-username/notification-relay/mocks"
+)
+
+// setupTestBroadcastTracker gives the server a tracker with a fixed clock
+func setupTestBroadcastTracker(s *Server) time.Time {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.broadcasts = newBroadcastTracker()
+	s.broadcasts.now = func() time.Time { return now }
+	return now
+}
+
+func TestBroadcastTracker(t *testing.T) {
+	t.Run("one running broadcast per site", func(t *testing.T) {
+		s := &Server{}
+		setupTestBroadcastTracker(s)
+
+		entry := s.broadcasts.start("b1", "p_s", 2, 3)
+		require.NotNil(t, entry)
+		assert.Nil(t, s.broadcasts.start("b2", "p_s", 2, 3))
+		assert.NotNil(t, s.broadcasts.start("b3", "p_other", 1, 1))
+
+		s.broadcasts.update(entry, 2, DeliveryResult{Sent: 1, Failed: 1})
+		list := s.broadcasts.list()
+		require.Len(t, list, 2)
+		assert.Equal(t, "b3", list[0].ID, "newest first")
+		assert.Equal(t, broadcastRunning, list[1].Status)
+		assert.Equal(t, 2, list[1].Processed)
+		assert.Equal(t, 1, list[1].Failed)
+
+		final := s.broadcasts.finish(entry, BulkDeliveryResult{Sent: 2, Failed: 1, Removed: 1})
+		assert.Equal(t, broadcastCompleted, final.Status)
+		assert.Equal(t, 3, final.Processed)
+		assert.Equal(t, 1, final.Removed)
+		require.NotNil(t, final.FinishedAt)
+		assert.False(t, final.FinishedAt.IsZero())
+		assert.NotNil(t, s.broadcasts.start("b4", "p_s", 2, 3), "finished broadcasts don't block new ones")
+	})
+
+	t.Run("keeps a limited history", func(t *testing.T) {
+		s := &Server{}
+		setupTestBroadcastTracker(s)
+
+		running := s.broadcasts.start("running", "p_running", 1, 1)
+		for i := 0; i < maxBroadcastHistory+5; i++ {
+			entry := s.broadcasts.start(fmt.Sprintf("b%d", i), "p_s", 1, 1)
+			s.broadcasts.finish(entry, BulkDeliveryResult{})
+		}
+		s.broadcasts.start("new", "p_s", 1, 1)
+
+		list := s.broadcasts.list()
+		assert.Len(t, list, maxBroadcastHistory+2)
+		assert.Equal(t, "new", list[0].ID)
+		assert.Equal(t, running.ID, list[len(list)-1].ID, "running broadcasts are kept")
+	})
+}
+
+func TestStartBroadcast(t *testing.T) {
+	s, _ := setupTestEnvironment(t)
+	mockClient := &mocks.MockFirebaseMessagingClient{}
+	s.client = mockClient
+
+	key := "test_project_test_site"
+	respond := func(_ context.Context, messages []*messaging.Message) *messaging.BatchResponse {
+		response := &messaging.BatchResponse{}
+		for _, msg := range messages {
+			if strings.HasPrefix(msg.Token, "stale") {
+				response.Responses = append(response.Responses, &messaging.SendResponse{Error: errInvalidToken})
+				continue
+			}
+			response.Responses = append(response.Responses, &messaging.SendResponse{Success: true})
+		}
+		return response
+	}
+
+	tests := []struct {
+		name            string
+		devices         map[string][]string
+		maxUsers        int // broadcast_max_users
+		running         bool
+		setupMock       func()
+		req             BroadcastRequest
+		expectedStatus  int
+		expectedMessage string
+		expectedEntry   *Broadcast
+	}{
+		{
+			name: "sends to every device in batches",
+			devices: func() map[string][]string {
+				devices := map[string][]string{"bob": {"stale-bob"}}
+				for i := 0; i < delivery.MaxBatchSize; i++ {
+					user := fmt.Sprintf("user%d", i%4)
+					devices[user] = append(devices[user], fmt.Sprintf("token-%d", i))
+				}
+				return devices
+			}(),
+			setupMock: func() {
+				mockClient.On("SendEach", mock.Anything, mock.MatchedBy(func(messages []*messaging.Message) bool {
+					return len(messages) == delivery.MaxBatchSize && messages[0].Webpush.Notification.Title == "Maintenance"
+				})).Return(respond, nil).Once()
+				mockClient.On("SendEach", mock.Anything, mock.MatchedBy(func(messages []*messaging.Message) bool {
+					return len(messages) == 1
+				})).Return(respond, nil).Once()
+			},
+			req:            BroadcastRequest{ProjectName: "test_project", SiteName: "test_site", Title: "Maintenance", Body: "Tonight", Confirm: true},
+			expectedStatus: http.StatusAccepted,
+			expectedEntry: &Broadcast{
+				Key: key, Status: broadcastCompleted, Users: 5, Devices: 501, Processed: 501, Sent: 500, Failed: 1, Removed: 1,
+			},
+		},
+		{
+			name:            "requires confirmation",
+			devices:         map[string][]string{"alice": {"a1", "a2"}, "bob": {"b1"}},
+			setupMock:       func() {},
+			req:             BroadcastRequest{ProjectName: "test_project", SiteName: "test_site", Title: "Maintenance", Body: "Tonight"},
+			expectedStatus:  http.StatusBadRequest,
+			expectedMessage: "Broadcast would reach 2 user(s) on 3 device(s), set confirm to send it",
+		},
+		{
+			name:            "refuses more users than max_users",
+			devices:         map[string][]string{"alice": {"a1"}, "bob": {"b1"}},
+			setupMock:       func() {},
+			req:             BroadcastRequest{ProjectName: "test_project", SiteName: "test_site", Title: "Maintenance", Body: "Tonight", Confirm: true, MaxUsers: 1},
+			expectedStatus:  http.StatusConflict,
+			expectedMessage: "Broadcast would reach 2 user(s), more than the limit of 1",
+		},
+		{
+			name:            "refuses more users than configured",
+			devices:         map[string][]string{"alice": {"a1"}, "bob": {"b1"}, "carol": {"c1"}},
+			maxUsers:        2,
+			setupMock:       func() {},
+			req:             BroadcastRequest{ProjectName: "test_project", SiteName: "test_site", Title: "Maintenance", Body: "Tonight", Confirm: true, MaxUsers: 5},
+			expectedStatus:  http.StatusConflict,
+			expectedMessage: "Broadcast would reach 3 user(s), more than the limit of 2",
+		},
+		{
+			name:            "refuses a second broadcast to the site",
+			devices:         map[string][]string{"alice": {"a1"}},
+			running:         true,
+			setupMock:       func() {},
+			req:             BroadcastRequest{ProjectName: "test_project", SiteName: "test_site", Title: "Maintenance", Body: "Tonight", Confirm: true},
+			expectedStatus:  http.StatusConflict,
+			expectedMessage: "A broadcast to test_project_test_site is already running",
+		},
+		{
+			name:            "site without users",
+			setupMock:       func() {},
+			req:             BroadcastRequest{ProjectName: "test_project", SiteName: "test_site", Title: "Maintenance", Body: "Tonight", Confirm: true},
+			expectedStatus:  http.StatusNotFound,
+			expectedMessage: "No users registered for test_project_test_site",
+		},
+		{
+			name:            "unknown project",
+			setupMock:       func() {},
+			req:             BroadcastRequest{ProjectName: "unknown", SiteName: "test_site", Title: "Maintenance", Body: "Tonight", Confirm: true},
+			expectedStatus:  http.StatusNotFound,
+			expectedMessage: "project unknown not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := setupTestBroadcastTracker(s)
+			if tt.running {
+				s.broadcasts.start("earlier", key, 1, 1)
+			}
+			s.devices[key] = tt.devices
+			s.updateState(func(next *reloadableState) { next.config.BroadcastMaxUsers = tt.maxUsers })
+			mockClient.ExpectedCalls = nil
+			tt.setupMock()
+
+			started, err := s.StartBroadcast(context.Background(), tt.req)
+			s.deliveries.wg.Wait()
+			mockClient.AssertExpectations(t)
+			if tt.expectedStatus != http.StatusAccepted {
+				require.Error(t, err)
+				assert.Equal(t, tt.expectedStatus, ErrorStatus(err, http.StatusInternalServerError))
+				assert.Equal(t, tt.expectedMessage, err.Error())
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Len(t, started.ID, 32, "the relay generates the broadcast ID")
+			assert.Equal(t, Broadcast{
+				ID: started.ID, Key: key, Status: broadcastRunning, Users: tt.expectedEntry.Users, Devices: tt.expectedEntry.Devices, StartedAt: now,
+			}, started)
+
+			expected := *tt.expectedEntry
+			expected.ID = started.ID
+			expected.StartedAt, expected.FinishedAt = now, &now
+			assert.Equal(t, []Broadcast{expected}, s.Broadcasts())
+			assert.Empty(t, s.devices.Tokens(key, "bob"), "invalid tokens are removed")
+		})
+	}
+}
+
+func TestBroadcastDuringShutdown(t *testing.T) {
+	s, _ := setupTestEnvironment(t)
+	now := setupTestBroadcastTracker(s)
+
+	mockClient := &mocks.MockFirebaseMessagingClient{}
+	s.client = mockClient
+
+	key := "test_project_test_site"
+	devices := map[string][]string{}
+	for i := 0; i < delivery.MaxBatchSize+1; i++ {
+		user := fmt.Sprintf("user%d", i%4)
+		devices[user] = append(devices[user], fmt.Sprintf("token-%d", i))
+	}
+	s.devices[key] = devices
+
+	respond := func(_ context.Context, messages []*messaging.Message) *messaging.BatchResponse {
+		response := &messaging.BatchResponse{}
+		for range messages {
+			response.Responses = append(response.Responses, &messaging.SendResponse{Success: true})
+		}
+		return response
+	}
+	// The first batch is held until shutdown waits for deliveries
+	sending, release := make(chan struct{}), make(chan struct{})
+	mockClient.On("SendEach", mock.Anything, mock.MatchedBy(func(messages []*messaging.Message) bool {
+		return len(messages) == delivery.MaxBatchSize
+	})).Run(func(mock.Arguments) {
+		close(sending)
+		<-release
+	}).Return(respond, nil).Once()
+	mockClient.On("SendEach", mock.Anything, mock.MatchedBy(func(messages []*messaging.Message) bool {
+		return len(messages) == 1
+	})).Return(respond, nil).Once()
+
+	_, err := s.StartBroadcast(context.Background(), BroadcastRequest{
+		ProjectName: "test_project", SiteName: "test_site", Title: "Maintenance", Body: "Tonight", Confirm: true,
+	})
+	require.NoError(t, err)
+	<-sending
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	waited := make(chan error, 1)
+	go func() { waited <- s.deliveries.wait(ctx) }()
+	require.Eventually(t, func() bool {
+		s.deliveries.mu.Lock()
+		defer s.deliveries.mu.Unlock()
+		return s.deliveries.closed
+	}, time.Second, time.Millisecond)
+	close(release)
+
+	require.NoError(t, <-waited, "shutdown waits for the broadcast")
+	mockClient.AssertExpectations(t)
+	entries := s.Broadcasts()
+	require.Len(t, entries, 1)
+	assert.Equal(t, Broadcast{
+		ID: entries[0].ID, Key: key, Status: broadcastCompleted, Users: 4, Devices: 501, Processed: 501, Sent: 501,
+		StartedAt: now, FinishedAt: &now,
+	}, entries[0], "every batch is sent once shutdown started")
+}
@@ -0,0 +1,584 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"firebase.google.com/go/v4/messaging"
+
+	"github.com/your-username/notification-relay/delivery"
+	"github.com/your-username/notification-relay/store"
+)
+
+// errSilent signals a failed command that already reported why
+var errSilent = errors.New("command failed")
+
+// usageError is returned for invalid arguments and exits with status 2
+type usageError struct {
+	command string
+	err     error
+}
+
+func (e usageError) Error() string {
+	return fmt.Sprintf("%v (run 'notification-relay %s -h' for usage)", e.err, e.command)
+}
+
+// cliCommand is a subcommand of the notification-relay binary
+type cliCommand struct {
+	name    string
+	summary string
+	run     func(args []string, out io.Writer) error
+}
+
+// ServeFunc serves the HTTP API of srv on ln until ctx is canceled, then
+// shuts the server down gracefully. httpapi.Serve is the one the binary uses.
+type ServeFunc func(ctx context.Context, srv *Server, ln net.Listener) error
+
+// cliCommands lists the subcommands in the order they are shown in the usage
+func cliCommands(serve ServeFunc) []cliCommand {
+	return []cliCommand{
+		{"serve", "Start the relay server (default)", runServe(serve)},
+		{"validate", "Check configuration and data files", runValidate},
+		{"creds", "List, create or revoke API credentials", runCreds},
+		{"tokens", "List, remove or prune device tokens", runTokens},
+		{"send", "Send a notification to a user or topic", runSend},
+		{"migrate", "Rewrite data files in the current format", runMigrate},
+		{"export", "Write configuration and data files to a bundle", runExport},
+		{"import", "Restore configuration and data files from a bundle", runImport},
+	}
+}
+
+// RunCLI runs the subcommand named by the first argument and returns the exit code.
+// Without arguments the server is started and served with serve.
+func RunCLI(args []string, stdout, stderr io.Writer, serve ServeFunc) int {
+	// Route the standard logger through slog as well
+	slog.SetDefault(appLog)
+
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" || name == "-h" || name == "--help" {
+		printUsage(stdout)
+		return 0
+	}
+
+	for _, cmd := range cliCommands(serve) {
+		if cmd.name != name {
+			continue
+		}
+		err := cmd.run(args, stdout)
+		var usageErr usageError
+		switch {
+		case err == nil, errors.Is(err, flag.ErrHelp):
+			return 0
+		case errors.Is(err, errSilent):
+			return 1
+		case errors.As(err, &usageErr):
+			fmt.Fprintf(stderr, "notification-relay %s: %v\n", name, err)
+			return 2
+		}
+		fmt.Fprintf(stderr, "notification-relay %s: %v\n", name, err)
+		return 1
+	}
+
+	fmt.Fprintf(stderr, "notification-relay: unknown command %q\n\n", name)
+	printUsage(stderr)
+	return 2
+}
+
+func printUsage(out io.Writer) {
+	fmt.Fprintln(out, "Usage: notification-relay <command> [flags]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, cmd := range cliCommands(nil) {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	_ = w.Flush()
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Run 'notification-relay <command> -h' for the flags of a command.")
+}
+
+// newFlagSet creates the flag set of a command. Parse errors are returned rather
+// than printed, and -h prints the usage to out.
+func newFlagSet(command, arguments string, out io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.Usage = func() {
+		fmt.Fprintf(out, "Usage: notification-relay %s [flags] %s\n", command, arguments)
+		flags.SetOutput(out)
+		flags.PrintDefaults()
+		flags.SetOutput(io.Discard)
+	}
+	return flags
+}
+
+// parseFlags parses args and checks the number of remaining positional arguments
+func parseFlags(flags *flag.FlagSet, args []string, maxArgs int) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			flags.Usage()
+			return err
+		}
+		return usageError{flags.Name(), err}
+	}
+	if flags.NArg() > maxArgs {
+		return usageError{flags.Name(), fmt.Errorf("unexpected argument %q", flags.Arg(maxArgs))}
+	}
+	return nil
+}
+
+// runSubcommand dispatches commands such as "creds list" to their handler
+func runSubcommand(command string, args []string, out io.Writer, subcommands map[string]func([]string, io.Writer) error) error {
+	names := sortedKeys(subcommands)
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		fmt.Fprintf(out, "Usage: notification-relay %s <%s> [flags]\n", command, strings.Join(names, "|"))
+		if len(args) == 0 {
+			return usageError{command, errors.New("missing subcommand")}
+		}
+		return flag.ErrHelp
+	}
+	run, exists := subcommands[args[0]]
+	if !exists {
+		return usageError{command, fmt.Errorf("unknown subcommand %q, must be one of %s", args[0], strings.Join(names, ", "))}
+	}
+	return run(args[1:], out)
+}
+
+// runServe returns the serve command, which starts the server on LISTEN_PORT
+// and serves it with serve until SIGINT or SIGTERM
+func runServe(serve ServeFunc) func(args []string, out io.Writer) error {
+	return func(args []string, out io.Writer) error {
+		flags := newFlagSet("serve", "", out)
+		if err := parseFlags(flags, args, 0); err != nil {
+			return err
+		}
+		if serve == nil {
+			return errors.New("no HTTP API to serve")
+		}
+
+		server, err := NewServer(Options{})
+		if err != nil {
+			appLog.Error("Failed to start", "error", err)
+			return errSilent
+		}
+		defer func() {
+			if err := server.Close(context.Background()); err != nil {
+				appLog.Warn("Failed to close server", "error", err)
+			}
+		}()
+
+		port := os.Getenv("LISTEN_PORT")
+		if port == "" {
+			port = "5000"
+		}
+
+		listener, err := net.Listen("tcp", "0.0.0.0:"+port)
+		if err != nil {
+			appLog.Error("Failed to start server", "error", err)
+			return errSilent
+		}
+
+		// Stop on SIGINT or SIGTERM from Docker and systemd
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		appLog.Info("Starting server", "port", port)
+		if err := serve(ctx, server, listener); err != nil {
+			appLog.Error("Server stopped with errors", "error", err)
+			return errSilent
+		}
+		return nil
+	}
+}
+
+// loadStore loads the configuration and data files the server works with,
+// without locking them or initializing the FCM client
+func loadStore() (*Server, error) {
+	configPath, serviceAccountPath, err := (Options{}).locate()
+	if err != nil {
+		return nil, err
+	}
+	s := newServer(configPath, serviceAccountPath)
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// lockStore locks the data directory of configPath for a command that changes
+// the data files. A running server keeps the data in memory and would overwrite
+// the changes, so the command is refused while one holds the lock. The lock is
+// released when the command is done.
+func lockStore(configPath, command string) (*dataLock, error) {
+	lock, err := lockDataDir(configPath)
+	if errors.Is(err, errDataLocked) {
+		return nil, fmt.Errorf("%s is in use by a running relay server or command, stop the server before running %s",
+			filepath.Dir(configPath), command)
+	}
+	return lock, err
+}
+
+// loadStoreForWrite locks the data directory with lockStore and loads the
+// configuration and data files
+func loadStoreForWrite(command string) (*Server, *dataLock, error) {
+	configPath, _, err := (Options{}).locate()
+	if err != nil {
+		return nil, nil, err
+	}
+	lock, err := lockStore(configPath, command)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := loadStore()
+	if err != nil {
+		_ = lock.release()
+		return nil, nil, err
+	}
+	return s, lock, nil
+}
+
+// targetFlags are the flags selecting the project, site and user a command works on
+type targetFlags struct {
+	project string
+	site    string
+	user    string
+}
+
+func addTargetFlags(flags *flag.FlagSet) *targetFlags {
+	target := &targetFlags{}
+	flags.StringVar(&target.project, "project", "", "Project name (required)")
+	flags.StringVar(&target.site, "site", "", "Site name (required)")
+	flags.StringVar(&target.user, "user", "", "User ID")
+	return target
+}
+
+// check verifies that the project and site are set and that the project exists
+func (t *targetFlags) check(s *Server, command string, requireUser bool) error {
+	if t.project == "" || t.site == "" {
+		return usageError{command, errors.New("-project and -site are required")}
+	}
+	if requireUser && t.user == "" {
+		return usageError{command, errors.New("-user is required")}
+	}
+	return s.ValidateProject(t.project)
+}
+
+func (t *targetFlags) key() string {
+	return store.Key(t.project, t.site)
+}
+
+// users returns the selected user, or all users of the site in sorted order
+func (t *targetFlags) users(s *Server) []string {
+	if t.user != "" {
+		return []string{t.user}
+	}
+	return sortedKeys(s.devices[t.key()])
+}
+
+func runCreds(args []string, out io.Writer) error {
+	return runSubcommand("creds", args, out, map[string]func([]string, io.Writer) error{
+		"list":   runCredsList,
+		"create": runCredsCreate,
+		"revoke": runCredsRevoke,
+	})
+}
+
+func runCredsList(args []string, out io.Writer) error {
+	if err := parseFlags(newFlagSet("creds list", "", out), args, 0); err != nil {
+		return err
+	}
+	s, err := loadStore()
+	if err != nil {
+		return err
+	}
+
+	admins := make(map[string]bool, len(s.currentConfig().AdminAPIKeys))
+	for _, key := range s.currentConfig().AdminAPIKeys {
+		admins[key] = true
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "API KEY\tADMIN")
+	for _, apiKey := range sortedKeys(s.credentials) {
+		fmt.Fprintf(w, "%s\t%t\n", apiKey, admins[apiKey])
+	}
+	return w.Flush()
+}
+
+func runCredsCreate(args []string, out io.Writer) error {
+	if err := parseFlags(newFlagSet("creds create", "", out), args, 0); err != nil {
+		return err
+	}
+	s, lock, err := loadStoreForWrite("creds create")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.release() }()
+
+	apiKey, apiSecret, err := s.CreateCredentials()
+	if err != nil {
+		return fmt.Errorf("failed to save credentials: %v", err)
+	}
+	fmt.Fprintf(out, "api_key: %s\napi_secret: %s\n", apiKey, apiSecret)
+	return nil
+}
+
+func runCredsRevoke(args []string, out io.Writer) error {
+	flags := newFlagSet("creds revoke", "<api_key>", out)
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	apiKey := flags.Arg(0)
+	if apiKey == "" {
+		return usageError{"creds revoke", errors.New("missing API key")}
+	}
+	s, lock, err := loadStoreForWrite("creds revoke")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.release() }()
+
+	if _, exists := s.credentials[apiKey]; !exists {
+		return fmt.Errorf("API key %s not found", apiKey)
+	}
+	delete(s.credentials, apiKey)
+	if err := s.saveJSON(CredentialsJSON, s.credentials); err != nil {
+		return fmt.Errorf("failed to save credentials: %v", err)
+	}
+	fmt.Fprintf(out, "Revoked API key %s\n", apiKey)
+	return nil
+}
+
+func runTokens(args []string, out io.Writer) error {
+	return runSubcommand("tokens", args, out, map[string]func([]string, io.Writer) error{
+		"list":   runTokensList,
+		"remove": runTokensRemove,
+		"prune":  runTokensPrune,
+	})
+}
+
+func runTokensList(args []string, out io.Writer) error {
+	flags := newFlagSet("tokens list", "", out)
+	target := addTargetFlags(flags)
+	full := flags.Bool("full", false, "Print complete tokens instead of shortened ones")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	s, err := loadStore()
+	if err != nil {
+		return err
+	}
+	if err := target.check(s, flags.Name(), false); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tTOKEN")
+	for _, userID := range target.users(s) {
+		for _, token := range s.devices[target.key()][userID] {
+			if !*full {
+				token = redactToken(token)
+			}
+			fmt.Fprintf(w, "%s\t%s\n", userID, token)
+		}
+	}
+	return w.Flush()
+}
+
+func runTokensRemove(args []string, out io.Writer) error {
+	flags := newFlagSet("tokens remove", "", out)
+	target := addTargetFlags(flags)
+	token := flags.String("token", "", "Token to remove, all tokens of the user when empty")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	s, lock, err := loadStoreForWrite("tokens remove")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.release() }()
+	if err := target.check(s, flags.Name(), true); err != nil {
+		return err
+	}
+
+	tokens := []string{*token}
+	if *token == "" {
+		tokens = append([]string(nil), s.devices[target.key()][target.user]...)
+	}
+
+	// Removed tokens are unsubscribed from the user's topics, which needs FCM
+	if len(s.subscriptions.Topics(target.key(), target.user)) > 0 {
+		if err := s.initClient(); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	removed := 0
+	for _, t := range tokens {
+		ok, err := s.RemoveToken(ctx, target.project, target.site, target.user, t)
+		if err != nil {
+			return err
+		}
+		if ok {
+			removed++
+		}
+	}
+	fmt.Fprintf(out, "Removed %d token(s) of %s\n", removed, target.user)
+	return nil
+}
+
+func runTokensPrune(args []string, out io.Writer) error {
+	flags := newFlagSet("tokens prune", "", out)
+	target := addTargetFlags(flags)
+	dryRun := flags.Bool("dry-run", false, "Report invalid tokens without removing them")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	// A dry run changes nothing and may run next to the server
+	var s *Server
+	var err error
+	if *dryRun {
+		s, err = loadStore()
+	} else {
+		var lock *dataLock
+		s, lock, err = loadStoreForWrite("tokens prune")
+		if err == nil {
+			defer func() { _ = lock.release() }()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := target.check(s, flags.Name(), false); err != nil {
+		return err
+	}
+	if err := s.initClient(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// FCM validates a dry run message against the token without delivering it
+	checked, invalid := 0, 0
+	for _, userID := range target.users(s) {
+		stale := make(map[string]bool)
+		for _, token := range s.devices[target.key()][userID] {
+			checked++
+			_, err := s.client.SendDryRun(ctx, &messaging.Message{Token: token})
+			switch {
+			case delivery.IsInvalidTokenError(err):
+				stale[token] = true
+				fmt.Fprintf(out, "%s\t%s\tinvalid\n", userID, redactToken(token))
+			case err != nil:
+				return fmt.Errorf("failed to check token of %s: %v", userID, err)
+			}
+		}
+		invalid += len(stale)
+		if !*dryRun {
+			s.devices.Remove(target.key(), userID, func(t string) bool { return stale[t] })
+			for token := range stale {
+				s.subscriptions.RemoveToken(target.key(), userID, token)
+			}
+		}
+	}
+
+	if invalid > 0 && !*dryRun {
+		if err := s.saveJSON(UserDeviceMapJSON, s.devices); err != nil {
+			return fmt.Errorf("failed to save user device map: %v", err)
+		}
+		if err := s.saveJSON(TopicSubscriptionsJSON, s.subscriptions); err != nil {
+			return fmt.Errorf("failed to save topic subscriptions: %v", err)
+		}
+	}
+	action := "removed"
+	if *dryRun {
+		action = "not removed, dry run"
+	}
+	fmt.Fprintf(out, "%d of %d token(s) invalid (%s)\n", invalid, checked, action)
+	return nil
+}
+
+func runSend(args []string, out io.Writer) error {
+	flags := newFlagSet("send", "", out)
+	target := addTargetFlags(flags)
+	topic := flags.String("topic", "", "Topic to send to instead of a user")
+	title := flags.String("title", "", "Notification title (required)")
+	body := flags.String("body", "", "Notification body (required)")
+	data := flags.String("data", "", "Notification data as a JSON object")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	if (target.user == "") == (*topic == "") {
+		return usageError{flags.Name(), errors.New("exactly one of -user or -topic is required")}
+	}
+	if *topic != "" {
+		if err := ValidateTopicName(*topic); err != nil {
+			return usageError{flags.Name(), err}
+		}
+	}
+	if err := ValidateNotification(*title, *body); err != nil {
+		return usageError{flags.Name(), err}
+	}
+	s, lock, err := loadStoreForWrite("send")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.release() }()
+	if err := target.check(s, flags.Name(), false); err != nil {
+		return err
+	}
+	if err := s.initClient(); err != nil {
+		return err
+	}
+
+	// Notifications go through the same delivery as API requests, so they get
+	// the same decorations, deduplication and invalid token cleanup
+	req := NotificationRequest{
+		ProjectName: target.project,
+		SiteName:    target.site,
+		UserID:      target.user,
+		TopicName:   *topic,
+		Title:       *title,
+		Body:        *body,
+		Data:        NotificationData(*data),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if *topic != "" {
+		response, err := s.NotifyTopic(ctx, req)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Notification sent to %s topic: %s\n", *topic, response)
+		return nil
+	}
+
+	result, err := s.NotifyUser(ctx, req)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%d of %d notification(s) sent to %s, %d invalid token(s) removed\n",
+		result.Sent, result.Sent+result.Failed, target.user, result.Removed)
+	if result.Sent == 0 {
+		return errSilent
+	}
+	return nil
+}
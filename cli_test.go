@@ -0,0 +1,426 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/your-username/notification-relay/delivery"
+	"github.com/your-username/notification-relay/mocks"
+	"github.com/your-username/notification-relay/store"
+)
+
+// setupTestCLI points commands at the files of the test server and mocks
+// Firebase for commands that talk to FCM
+func setupTestCLI(t *testing.T, s *Server) *mocks.MockFirebaseMessagingClient {
+	t.Setenv("NOTIFICATION_RELAY_CONFIG", s.configPath)
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", s.serviceAccountPath)
+
+	mockClient := &mocks.MockFirebaseMessagingClient{}
+	previous := newFirebaseClient
+	newFirebaseClient = func(string) (delivery.Client, error) {
+		return mockClient, nil
+	}
+	t.Cleanup(func() { newFirebaseClient = previous })
+	return mockClient
+}
+
+func TestRunCLI(t *testing.T) {
+	tests := []struct {
+		name           string
+		args           []string
+		expectedCode   int
+		expectedStdout string
+		expectedStderr string
+	}{
+		{
+			name:           "help",
+			args:           []string{"help"},
+			expectedStdout: "Commands:",
+		},
+		{
+			name:           "unknown command",
+			args:           []string{"start"},
+			expectedCode:   2,
+			expectedStderr: "unknown command \"start\"",
+		},
+		{
+			name:           "unknown flag",
+			args:           []string{"tokens", "list", "-verbose"},
+			expectedCode:   2,
+			expectedStderr: "flag provided but not defined: -verbose",
+		},
+		{
+			name:           "missing subcommand",
+			args:           []string{"creds"},
+			expectedCode:   2,
+			expectedStderr: "missing subcommand",
+		},
+		{
+			name:           "command help",
+			args:           []string{"send", "-h"},
+			expectedStdout: "-topic",
+		},
+		{
+			name:           "command error",
+			args:           []string{"tokens", "list", "-project", "missing", "-site", "s"},
+			expectedCode:   1,
+			expectedStderr: "project missing not found",
+		},
+		{
+			name:           "validate",
+			args:           []string{"validate"},
+			expectedStdout: "is valid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := setupTestEnvironment(t)
+			setupTestCLI(t, s)
+
+			var stdout, stderr bytes.Buffer
+			assert.Equal(t, tt.expectedCode, RunCLI(tt.args, &stdout, &stderr, nil), stderr.String())
+			assert.Contains(t, stdout.String(), tt.expectedStdout)
+			assert.Contains(t, stderr.String(), tt.expectedStderr)
+		})
+	}
+}
+
+func TestCredsCommands(t *testing.T) {
+	s, tmpDir := setupTestEnvironment(t)
+	setupTestCLI(t, s)
+	writeTestJSON(t, filepath.Join(tmpDir, CredentialsJSON), store.Credentials{"existing-key": testAPISecret})
+
+	var out bytes.Buffer
+	require.NoError(t, runCreds([]string{"create"}, &out))
+	var apiKey string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if key, found := strings.CutPrefix(line, "api_key: "); found {
+			apiKey = key
+		}
+	}
+	require.Len(t, apiKey, 32)
+	var saved store.Credentials
+	require.NoError(t, s.loadJSON(CredentialsJSON, &saved))
+	assert.Contains(t, saved, apiKey)
+
+	out.Reset()
+	require.NoError(t, runCreds([]string{"list"}, &out))
+	assert.Contains(t, out.String(), "existing-key")
+	assert.Contains(t, out.String(), apiKey)
+	assert.NotContains(t, out.String(), testAPISecret, "secrets are never listed")
+
+	out.Reset()
+	require.NoError(t, runCreds([]string{"revoke", "existing-key"}, &out))
+	saved = nil
+	require.NoError(t, s.loadJSON(CredentialsJSON, &saved))
+	assert.NotContains(t, saved, "existing-key")
+	assert.Contains(t, saved, apiKey)
+
+	assert.ErrorContains(t, runCreds([]string{"revoke", "existing-key"}, &out), "not found")
+}
+
+func TestTokensCommands(t *testing.T) {
+	s, tmpDir := setupTestEnvironment(t)
+	mockClient := setupTestCLI(t, s)
+	target := []string{"-project", "test_project", "-site", "test_site"}
+
+	writeTestJSON(t, filepath.Join(tmpDir, UserDeviceMapJSON), map[string]map[string][]string{
+		"test_project_test_site": {
+			"alice": {testFCMToken, "stale-token-value"},
+			"bob":   {"bob-token-value"},
+		},
+	})
+
+	var out bytes.Buffer
+	require.NoError(t, runTokens(append([]string{"list"}, target...), &out))
+	assert.Contains(t, out.String(), "alice")
+	assert.Contains(t, out.String(), "bob")
+	assert.Contains(t, out.String(), redactToken(testFCMToken))
+	assert.NotContains(t, out.String(), testFCMToken, "tokens are shortened by default")
+
+	out.Reset()
+	require.NoError(t, runTokens(append([]string{"list", "-user", "alice", "-full"}, target...), &out))
+	assert.Contains(t, out.String(), testFCMToken)
+	assert.NotContains(t, out.String(), "bob")
+
+	mockClient.On("SendDryRun", mock.Anything, mock.MatchedBy(func(m *messaging.Message) bool {
+		return m.Token == "stale-token-value"
+	})).Return("", errors.New("registration-token-not-registered"))
+	mockClient.On("SendDryRun", mock.Anything, mock.Anything).Return("projects/p/messages/dry-run", nil)
+
+	subscriptions := store.Subscriptions{}
+	subscriptions.Add("test_project_test_site", "alice", "news", []string{testFCMToken, "stale-token-value"})
+	require.NoError(t, s.saveJSON(TopicSubscriptionsJSON, subscriptions))
+
+	var devices store.Devices
+	out.Reset()
+	require.NoError(t, runTokens(append([]string{"prune", "-dry-run"}, target...), &out))
+	assert.Contains(t, out.String(), "1 of 3 token(s) invalid (not removed, dry run)")
+	require.NoError(t, s.loadJSON(UserDeviceMapJSON, &devices))
+	assert.Len(t, devices["test_project_test_site"]["alice"], 2)
+
+	out.Reset()
+	require.NoError(t, runTokens(append([]string{"prune"}, target...), &out))
+	assert.Contains(t, out.String(), "1 of 3 token(s) invalid (removed)")
+	require.NoError(t, s.loadJSON(UserDeviceMapJSON, &devices))
+	assert.Equal(t, []string{testFCMToken}, devices["test_project_test_site"]["alice"])
+	require.NoError(t, s.loadJSON(TopicSubscriptionsJSON, &subscriptions))
+	assert.Equal(t, []string{testFCMToken}, subscriptions.Tokens("test_project_test_site", "alice", "news"), "pruned tokens leave their topics")
+
+	out.Reset()
+	require.NoError(t, runTokens(append([]string{"remove", "-user", "bob"}, target...), &out))
+	assert.Contains(t, out.String(), "Removed 1 token(s) of bob")
+	require.NoError(t, s.loadJSON(UserDeviceMapJSON, &devices))
+	assert.NotContains(t, devices["test_project_test_site"], "bob")
+
+	// Removed tokens leave the FCM topics and the registry
+	mockClient.On("UnsubscribeFromTopic", mock.Anything, []string{testFCMToken}, "news").
+		Return(&messaging.TopicManagementResponse{SuccessCount: 1}, nil).Once()
+	out.Reset()
+	require.NoError(t, runTokens(append([]string{"remove", "-user", "alice"}, target...), &out))
+	assert.Contains(t, out.String(), "Removed 1 token(s) of alice")
+	mockClient.AssertExpectations(t)
+	require.NoError(t, s.loadJSON(TopicSubscriptionsJSON, &subscriptions))
+	assert.Empty(t, subscriptions.Tokens("test_project_test_site", "alice", "news"))
+	assert.True(t, subscriptions.Subscribed("test_project_test_site", "alice", "news"), "users stay subscribed without tokens")
+
+	assert.ErrorContains(t, runTokens(append([]string{"remove"}, target...), &out), "-user is required")
+}
+
+func TestSendCommand(t *testing.T) {
+	s, tmpDir := setupTestEnvironment(t)
+	mockClient := setupTestCLI(t, s)
+	writeTestJSON(t, filepath.Join(tmpDir, UserDeviceMapJSON), map[string]map[string][]string{
+		"test_project_test_site": {"alice": {testFCMToken, "stale-token"}},
+	})
+
+	// Messages are built like those sent through the API
+	mockClient.On("Send", mock.Anything, mock.MatchedBy(func(m *messaging.Message) bool {
+		return m.Token == testFCMToken && m.Notification.Title == "Hello" && m.Webpush.Data["kind"] == "test" &&
+			m.Webpush.Data["deduplication_id"] != "" && m.Webpush.Notification.Tag == m.Webpush.Data["deduplication_id"]
+	})).Return("projects/p/messages/1", nil).Once()
+	mockClient.On("Send", mock.Anything, mock.MatchedBy(func(m *messaging.Message) bool {
+		return m.Token == "stale-token"
+	})).Return("", errors.New("registration-token-not-registered")).Once()
+	mockClient.On("Send", mock.Anything, mock.MatchedBy(func(m *messaging.Message) bool {
+		return m.Topic == "test_project~test_site~news"
+	})).Return("projects/p/messages/2", nil).Once()
+
+	// Topics are namespaced per site like topics sent through the API
+	writeTestJSON(t, filepath.Join(tmpDir, ConfigJSON), Config{Projects: s.currentConfig().Projects, Topics: &TopicConfig{Namespace: true}})
+
+	target := []string{"-project", "test_project", "-site", "test_site", "-title", "Hello", "-body", "World"}
+
+	var out bytes.Buffer
+	require.NoError(t, runSend(append(target, "-user", "alice", "-data", `{"kind": "test"}`), &out))
+	assert.Contains(t, out.String(), "1 of 2 notification(s) sent to alice, 1 invalid token(s) removed")
+	var devices store.Devices
+	require.NoError(t, s.loadJSON(UserDeviceMapJSON, &devices))
+	assert.Equal(t, []string{testFCMToken}, devices.Tokens("test_project_test_site", "alice"), "invalid tokens are removed")
+
+	out.Reset()
+	require.NoError(t, runSend(append(target, "-topic", "news"), &out))
+	assert.Contains(t, out.String(), "Notification sent to news topic")
+	mockClient.AssertExpectations(t)
+
+	assert.ErrorContains(t, runSend(target, &out), "exactly one of -user or -topic")
+	assert.ErrorContains(t, runSend(append(target, "-topic", "two words"), &out), "invalid topic name")
+	assert.ErrorContains(t, runSend(append(target, "-user", "bob"), &out), "not subscribed")
+}
+
+func TestMigrateCommand(t *testing.T) {
+	s, tmpDir := setupTestEnvironment(t)
+	setupTestCLI(t, s)
+	require.NoError(t, os.Remove(filepath.Join(tmpDir, IconsJSON)))
+	writeTestJSON(t, filepath.Join(tmpDir, UserDeviceMapJSON), map[string]map[string][]string{
+		"test_project_test_site": {
+			"alice": {"token-a", "token-a", ""},
+			"bob":   {},
+		},
+		"test_project_empty": {"carol": {""}},
+	})
+
+	var out bytes.Buffer
+	require.NoError(t, runMigrate(nil, &out))
+	assert.Contains(t, out.String(), "Removed 3 duplicate or empty token(s) and 2 user(s) without tokens")
+
+	var saved map[string]map[string][]string
+	require.NoError(t, s.loadJSON(UserDeviceMapJSON, &saved))
+	assert.Equal(t, map[string]map[string][]string{
+		"test_project_test_site": {"alice": {"token-a"}},
+	}, saved)
+	assert.FileExists(t, filepath.Join(tmpDir, IconsJSON))
+}
+
+func TestExportImportCommands(t *testing.T) {
+	s, tmpDir := setupTestEnvironment(t)
+	setupTestCLI(t, s)
+	writeTestJSON(t, filepath.Join(tmpDir, CredentialsJSON), store.Credentials{"key": testAPISecret})
+	writeTestJSON(t, filepath.Join(tmpDir, UserDeviceMapJSON), map[string]map[string][]string{
+		"test_project_test_site": {"alice": {testFCMToken}},
+	})
+	writeTestJSON(t, filepath.Join(tmpDir, IconsJSON), map[string]string{"test_project_test_site": "/icon.png"})
+
+	bundlePath := filepath.Join(t.TempDir(), "backup.json")
+	var out bytes.Buffer
+	require.NoError(t, runExport([]string{bundlePath}, &out))
+	assert.Contains(t, out.String(), "Exported 1 project(s), 1 credential(s) and 1 token(s) of 1 user(s)")
+	info, err := os.Stat(bundlePath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// Import into a fresh directory
+	importDir := t.TempDir()
+	t.Setenv("NOTIFICATION_RELAY_CONFIG", filepath.Join(importDir, ConfigJSON))
+	out.Reset()
+	require.NoError(t, runImport([]string{bundlePath}, &out))
+	assert.Contains(t, out.String(), "Imported 1 project(s)")
+
+	for _, file := range []string{ConfigJSON, CredentialsJSON, UserDeviceMapJSON, DecorationJSON, TopicDecorationJSON, IconsJSON} {
+		exported, err := os.ReadFile(filepath.Join(tmpDir, file))
+		require.NoError(t, err)
+		imported, err := os.ReadFile(filepath.Join(importDir, file))
+		require.NoError(t, err)
+		assert.JSONEq(t, string(exported), string(imported), file)
+	}
+
+	// Without an explicit path the files go where NOTIFICATION_RELAY_CONFIG points
+	envDir := t.TempDir()
+	t.Setenv("NOTIFICATION_RELAY_CONFIG", filepath.Join(envDir, ConfigJSON))
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	defer func() { require.NoError(t, os.Chdir(wd)) }()
+	out.Reset()
+	require.NoError(t, runImport([]string{bundlePath}, &out))
+	assert.Contains(t, out.String(), "into "+envDir)
+	for _, file := range []string{ConfigJSON, CredentialsJSON, UserDeviceMapJSON, TopicSubscriptionsJSON, DecorationJSON, TopicDecorationJSON, IconsJSON} {
+		assert.FileExists(t, filepath.Join(envDir, file))
+		assert.NoFileExists(t, file, "nothing is written to the working directory")
+	}
+
+	// A relative jwks_file is checked next to the destination, not the working directory
+	var raw map[string]interface{}
+	content, err := os.ReadFile(bundlePath)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(content, &raw))
+	raw["config"].(map[string]interface{})["jwt"] = map[string]interface{}{"jwks_file": "jwks.json"}
+	jwtBundlePath := filepath.Join(t.TempDir(), "jwt-backup.json")
+	writeTestJSON(t, jwtBundlePath, raw)
+	require.NoError(t, os.WriteFile("jwks.json", []byte(`{"keys": []}`), defaultFileMode))
+	jwtDir := t.TempDir()
+	t.Setenv("NOTIFICATION_RELAY_CONFIG", filepath.Join(jwtDir, ConfigJSON))
+	assert.ErrorContains(t, runImport([]string{jwtBundlePath}, &out), "jwt.jwks_file")
+	assert.NoFileExists(t, filepath.Join(jwtDir, ConfigJSON))
+	require.NoError(t, os.Rename("jwks.json", filepath.Join(jwtDir, "jwks.json")))
+	require.NoError(t, runImport([]string{jwtBundlePath}, &out))
+	assert.FileExists(t, filepath.Join(jwtDir, ConfigJSON))
+
+	// On a new host without a configuration it is created at the default location
+	newDir := filepath.Join(t.TempDir(), "etc", "notification-relay")
+	previous := defaultConfigPath
+	defaultConfigPath = filepath.Join(newDir, ConfigJSON)
+	defer func() { defaultConfigPath = previous }()
+	t.Setenv("NOTIFICATION_RELAY_CONFIG", "")
+	out.Reset()
+	require.NoError(t, runImport([]string{bundlePath}, &out))
+	assert.Contains(t, out.String(), "into "+newDir)
+	assert.FileExists(t, filepath.Join(newDir, ConfigJSON))
+
+	// Invalid bundles are refused before anything is written
+	tests := []struct {
+		name        string
+		bundle      string
+		expectedErr string
+	}{
+		{"unknown field", `{"version": 1, "config": {"projects": {}}, "extra": true}`, "bundle: extra: unknown field"},
+		{"unsupported version", `{"version": 2, "config": {}}`, "unsupported bundle version 2"},
+		{"invalid config", `{"version": 1, "config": {"projects": {}}}`, "at least one project is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "bundle.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.bundle), defaultFileMode))
+			assert.ErrorContains(t, runImport([]string{path}, &out), tt.expectedErr)
+		})
+	}
+}
+
+func TestCommandsWhileServing(t *testing.T) {
+	s, tmpDir := setupTestEnvironment(t)
+	setupTestCLI(t, s)
+	writeTestJSON(t, filepath.Join(tmpDir, CredentialsJSON), store.Credentials{"existing-key": testAPISecret})
+	writeTestJSON(t, filepath.Join(tmpDir, UserDeviceMapJSON), map[string]map[string][]string{
+		"test_project_test_site": {"alice": {testFCMToken}},
+	})
+
+	bundlePath := filepath.Join(t.TempDir(), "backup.json")
+	var out bytes.Buffer
+	require.NoError(t, runExport([]string{bundlePath}, &out))
+
+	srv, err := NewServer(Options{})
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(tmpDir, DataLockFile))
+
+	readFiles := func() map[string]string {
+		files := make(map[string]string)
+		for _, file := range []string{ConfigJSON, CredentialsJSON, UserDeviceMapJSON, TopicSubscriptionsJSON} {
+			content, err := os.ReadFile(filepath.Join(tmpDir, file))
+			require.NoError(t, err)
+			files[file] = string(content)
+		}
+		return files
+	}
+	before := readFiles()
+
+	// Commands changing the files would be undone by the server
+	target := []string{"-project", "test_project", "-site", "test_site"}
+	for _, args := range [][]string{
+		{"creds", "create"},
+		{"creds", "revoke", "existing-key"},
+		{"tokens", "remove", "-project", "test_project", "-site", "test_site", "-user", "alice"},
+		append([]string{"tokens", "prune"}, target...),
+		append([]string{"send", "-user", "alice", "-title", "Hi", "-body", "There"}, target...),
+		{"migrate"},
+		{"import", bundlePath},
+	} {
+		var stdout, stderr bytes.Buffer
+		assert.Equal(t, 1, RunCLI(args, &stdout, &stderr, nil), args)
+		assert.Contains(t, stderr.String(), "in use by a running relay server", args)
+	}
+	assert.Equal(t, before, readFiles(), "nothing is written while the server runs")
+
+	// Reading works next to the server
+	for _, args := range [][]string{
+		{"creds", "list"},
+		append([]string{"tokens", "list"}, target...),
+		{"export"},
+		{"validate"},
+	} {
+		var stdout, stderr bytes.Buffer
+		assert.Equal(t, 0, RunCLI(args, &stdout, &stderr, nil), stderr.String())
+	}
+
+	require.NoError(t, srv.Close(context.Background()))
+	out.Reset()
+	require.NoError(t, runCreds([]string{"revoke", "existing-key"}, &out))
+
+	// The server doesn't start while a command holds the lock
+	lock, err := lockStore(s.configPath, "test")
+	require.NoError(t, err)
+	_, err = NewServer(Options{})
+	assert.ErrorContains(t, err, "in use by another relay server or command")
+	require.NoError(t, lock.release())
+}
@@ -0,0 +1,20 @@
+// Command notification-relay runs the relay server and its management commands.
+// The relay lives in the relay package and its HTTP API in the httpapi package.
+package main
+
+import (
+	"os"
+
+	"github.com/gin-gonic/gin"
+
+	relay "github.com/your-username/notification-relay"
+	"github.com/your-username/notification-relay/httpapi"
+)
+
+func main() {
+	// Scrub secrets from everything gin writes outside of the relay's loggers
+	gin.DefaultWriter = relay.NewRedactingWriter(os.Stdout)
+	gin.DefaultErrorWriter = relay.NewRedactingWriter(os.Stderr)
+
+	os.Exit(relay.RunCLI(os.Args[1:], os.Stdout, os.Stderr, httpapi.Serve))
+}
@@ -0,0 +1,291 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// MaxConditionTopics is the largest number of topics FCM accepts in a condition
+	MaxConditionTopics = 5
+	// MaxConditionLength is the longest condition accepted, in bytes. Five topics
+	// fit easily, it bounds the work of parsing untrusted input.
+	MaxConditionLength = 512
+	// MaxConditionDepth is how deeply negations and parentheses may be nested
+	MaxConditionDepth = 10
+)
+
+// topicNamePattern matches the topic names FCM accepts
+var topicNamePattern = regexp.MustCompile(`^[a-zA-Z0-9-_.~%]+$`)
+
+// Condition is a parsed FCM topic condition such as
+// 'alerts' in topics && !('muted' in topics)
+type Condition struct {
+	root *conditionNode
+}
+
+// conditionNode is a topic test or an operator applied to its operands
+type conditionNode struct {
+	op       string // "in", "!", "&&" or "||"
+	topic    string // Topic of an "in" node
+	operands []*conditionNode
+}
+
+// ParseCondition parses and validates an FCM condition. Conditions combine
+// 'topic' in topics tests with &&, || and !, and may use at most
+// MaxConditionTopics topics. Like FCM, && and || have the same precedence and
+// are evaluated from left to right. Conditions longer than MaxConditionLength or
+// nested deeper than MaxConditionDepth are rejected.
+func ParseCondition(s string) (*Condition, error) {
+	if len(s) > MaxConditionLength {
+		return nil, fmt.Errorf("invalid condition: condition must not be longer than %d bytes, got %d", MaxConditionLength, len(s))
+	}
+	tokens, err := lexCondition(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens}
+	root, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, p.errorf("unexpected %s", p.peek().text)
+	}
+
+	condition := &Condition{root: root}
+	if topics := condition.Topics(); len(topics) > MaxConditionTopics {
+		return nil, fmt.Errorf("condition must not use more than %d topics, got %d", MaxConditionTopics, len(topics))
+	}
+	return condition, nil
+}
+
+// ValidTopicName reports whether FCM accepts name as a topic name
+func ValidTopicName(name string) bool {
+	return topicNamePattern.MatchString(name)
+}
+
+// MapTopics returns a copy of the condition with every topic replaced by the
+// result of rename
+func (c *Condition) MapTopics(rename func(topic string) string) *Condition {
+	var mapNode func(n *conditionNode) *conditionNode
+	mapNode = func(n *conditionNode) *conditionNode {
+		mapped := &conditionNode{op: n.op, topic: n.topic}
+		if n.op == "in" {
+			mapped.topic = rename(n.topic)
+		}
+		for _, operand := range n.operands {
+			mapped.operands = append(mapped.operands, mapNode(operand))
+		}
+		return mapped
+	}
+	return &Condition{root: mapNode(c.root)}
+}
+
+// Topics returns the topics the condition tests, in order of appearance
+func (c *Condition) Topics() []string {
+	var topics []string
+	var walk func(n *conditionNode)
+	walk = func(n *conditionNode) {
+		if n.op == "in" {
+			topics = append(topics, n.topic)
+		}
+		for _, operand := range n.operands {
+			walk(operand)
+		}
+	}
+	walk(c.root)
+	return topics
+}
+
+// String returns the condition in the form sent to FCM
+func (c *Condition) String() string {
+	return c.root.String()
+}
+
+func (n *conditionNode) String() string {
+	switch n.op {
+	case "in":
+		return fmt.Sprintf("'%s' in topics", n.topic)
+	case "!":
+		return fmt.Sprintf("!(%s)", n.operands[0])
+	}
+	parts := make([]string, len(n.operands))
+	for i, operand := range n.operands {
+		parts[i] = operand.String()
+		if len(operand.operands) > 1 {
+			parts[i] = "(" + parts[i] + ")"
+		}
+	}
+	return strings.Join(parts, " "+n.op+" ")
+}
+
+// conditionToken is a lexical token of a condition with its byte offset
+type conditionToken struct {
+	text  string
+	topic bool // Quoted topic name, text holds the name without quotes
+	pos   int
+}
+
+// lexCondition splits a condition into tokens
+func lexCondition(s string) ([]conditionToken, error) {
+	var tokens []conditionToken
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == '!':
+			tokens = append(tokens, conditionToken{text: string(c), pos: i})
+			i++
+		case strings.HasPrefix(s[i:], "&&") || strings.HasPrefix(s[i:], "||"):
+			tokens = append(tokens, conditionToken{text: s[i : i+2], pos: i})
+			i += 2
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("invalid condition: unterminated topic name at position %d", i)
+			}
+			name := s[i+1 : i+1+end]
+			if !topicNamePattern.MatchString(name) {
+				return nil, fmt.Errorf("invalid condition: invalid topic name %q at position %d", name, i)
+			}
+			tokens = append(tokens, conditionToken{text: name, topic: true, pos: i})
+			i += end + 2
+		case isWordByte(c):
+			start := i
+			for i < len(s) && isWordByte(s[i]) {
+				i++
+			}
+			tokens = append(tokens, conditionToken{text: s[start:i], pos: start})
+		default:
+			return nil, fmt.Errorf("invalid condition: unexpected %q at position %d", c, i)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("invalid condition: condition is empty")
+	}
+	return tokens, nil
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// conditionParser is a recursive descent parser over condition tokens
+type conditionParser struct {
+	tokens []conditionToken
+	next   int
+	depth  int // Negations and parentheses entered so far
+}
+
+// enter descends one nesting level, failing beyond MaxConditionDepth. Callers
+// leave the level again with p.depth-- once the nested operand is parsed.
+func (p *conditionParser) enter() error {
+	p.depth++
+	if p.depth > MaxConditionDepth {
+		return p.errorf("condition must not be nested deeper than %d levels", MaxConditionDepth)
+	}
+	return nil
+}
+
+func (p *conditionParser) done() bool {
+	return p.next >= len(p.tokens)
+}
+
+func (p *conditionParser) peek() conditionToken {
+	return p.tokens[p.next]
+}
+
+func (p *conditionParser) errorf(format string, args ...interface{}) error {
+	pos := -1
+	if !p.done() {
+		pos = p.peek().pos
+	}
+	message := fmt.Sprintf(format, args...)
+	if pos < 0 {
+		return fmt.Errorf("invalid condition: %s at end of condition", message)
+	}
+	return fmt.Errorf("invalid condition: %s at position %d", message, pos)
+}
+
+// expression parses operands joined by binary operators from left to right, so
+// a || b && c groups as (a || b) && c
+func (p *conditionParser) expression() (*conditionNode, error) {
+	first, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	var node *conditionNode
+	for !p.done() && (p.peek().text == "&&" || p.peek().text == "||") {
+		op := p.peek().text
+		switch {
+		case node == nil:
+			node = &conditionNode{op: op, operands: []*conditionNode{first}}
+		case node.op != op:
+			node = &conditionNode{op: op, operands: []*conditionNode{node}}
+		}
+		p.next++
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		node.operands = append(node.operands, operand)
+	}
+	if node == nil {
+		return first, nil
+	}
+	return node, nil
+}
+
+// unary parses a negation, a parenthesized expression or a topic test
+func (p *conditionParser) unary() (*conditionNode, error) {
+	if p.done() {
+		return nil, p.errorf("expected a topic")
+	}
+	token := p.peek()
+	switch {
+	case token.topic:
+		p.next++
+		if p.done() || p.peek().topic || p.peek().text != "in" {
+			return nil, p.errorf("expected in after topic %q", token.text)
+		}
+		p.next++
+		if p.done() || p.peek().topic || p.peek().text != "topics" {
+			return nil, p.errorf("expected topics")
+		}
+		p.next++
+		return &conditionNode{op: "in", topic: token.text}, nil
+	case token.text == "!":
+		p.next++
+		// !(...) is a single level, as in the normalized form
+		if p.done() || p.peek().text != "(" {
+			if err := p.enter(); err != nil {
+				return nil, err
+			}
+			defer func() { p.depth-- }()
+		}
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &conditionNode{op: "!", operands: []*conditionNode{operand}}, nil
+	case token.text == "(":
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer func() { p.depth-- }()
+		p.next++
+		node, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if p.done() || p.peek().topic || p.peek().text != ")" {
+			return nil, p.errorf("expected )")
+		}
+		p.next++
+		return node, nil
+	}
+	return nil, p.errorf("expected a topic, got %s", token.text)
+}
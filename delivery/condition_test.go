@@ -0,0 +1,164 @@
+package delivery
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCondition(t *testing.T) {
+	tests := []struct {
+		name           string
+		condition      string
+		expected       string
+		expectedTopics []string
+		expectedErr    string
+	}{
+		{
+			name:           "single topic",
+			condition:      `'alerts' in topics`,
+			expected:       `'alerts' in topics`,
+			expectedTopics: []string{"alerts"},
+		},
+		{
+			name:           "and with negation",
+			condition:      `'alerts' in topics && !('muted' in topics)`,
+			expected:       `'alerts' in topics && !('muted' in topics)`,
+			expectedTopics: []string{"alerts", "muted"},
+		},
+		{
+			name:           "nested groups and double quotes",
+			condition:      `"a" in topics&&("b" in topics||'c' in topics) && !'d' in topics`,
+			expected:       `'a' in topics && ('b' in topics || 'c' in topics) && !('d' in topics)`,
+			expectedTopics: []string{"a", "b", "c", "d"},
+		},
+		{
+			name:           "redundant parentheses",
+			condition:      `(('a' in topics)) || ('b-1.x~y%z_w' in topics)`,
+			expected:       `'a' in topics || 'b-1.x~y%z_w' in topics`,
+			expectedTopics: []string{"a", "b-1.x~y%z_w"},
+		},
+		{
+			name:           "five topics",
+			condition:      `'a' in topics || 'b' in topics || 'c' in topics || 'd' in topics || 'e' in topics`,
+			expected:       `'a' in topics || 'b' in topics || 'c' in topics || 'd' in topics || 'e' in topics`,
+			expectedTopics: []string{"a", "b", "c", "d", "e"},
+		},
+		{
+			name:        "too many topics",
+			condition:   `'a' in topics || 'b' in topics || 'c' in topics || 'd' in topics || 'e' in topics || 'f' in topics`,
+			expectedErr: "condition must not use more than 5 topics, got 6",
+		},
+		{
+			name:           "mixed operators",
+			condition:      `'a' in topics && 'b' in topics || 'c' in topics`,
+			expected:       `('a' in topics && 'b' in topics) || 'c' in topics`,
+			expectedTopics: []string{"a", "b", "c"},
+		},
+		{
+			name:           "mixed operators evaluate from left to right",
+			condition:      `'a' in topics || 'b' in topics && 'c' in topics || 'd' in topics`,
+			expected:       `(('a' in topics || 'b' in topics) && 'c' in topics) || 'd' in topics`,
+			expectedTopics: []string{"a", "b", "c", "d"},
+		},
+		{
+			name:        "empty",
+			condition:   "  ",
+			expectedErr: "invalid condition: condition is empty",
+		},
+		{
+			name:        "invalid topic name",
+			condition:   `'a b' in topics`,
+			expectedErr: `invalid condition: invalid topic name "a b" at position 0`,
+		},
+		{
+			name:        "unterminated topic",
+			condition:   `'alerts in topics`,
+			expectedErr: "invalid condition: unterminated topic name at position 0",
+		},
+		{
+			name:        "missing in",
+			condition:   `'alerts' topics`,
+			expectedErr: `invalid condition: expected in after topic "alerts" at position 9`,
+		},
+		{
+			name:        "missing operand",
+			condition:   `'alerts' in topics &&`,
+			expectedErr: "invalid condition: expected a topic at end of condition",
+		},
+		{
+			name:        "unbalanced parentheses",
+			condition:   `('alerts' in topics`,
+			expectedErr: "invalid condition: expected ) at end of condition",
+		},
+		{
+			name:        "trailing tokens",
+			condition:   `'alerts' in topics)`,
+			expectedErr: "invalid condition: unexpected ) at position 18",
+		},
+		{
+			name:           "nested to the limit",
+			condition:      strings.Repeat("!(", 10) + `'a' in topics` + strings.Repeat(")", 10),
+			expected:       strings.Repeat("!(", 10) + `'a' in topics` + strings.Repeat(")", 10),
+			expectedTopics: []string{"a"},
+		},
+		{
+			name:        "nested too deeply",
+			condition:   strings.Repeat("(", 11) + `'a' in topics` + strings.Repeat(")", 11),
+			expectedErr: "invalid condition: condition must not be nested deeper than 10 levels at position 10",
+		},
+		{
+			name:        "negated too deeply",
+			condition:   strings.Repeat("!", 11) + `'a' in topics`,
+			expectedErr: "invalid condition: condition must not be nested deeper than 10 levels at position 11",
+		},
+		{
+			name:        "too long",
+			condition:   strings.Repeat("!", 500000) + `'a' in topics`,
+			expectedErr: "invalid condition: condition must not be longer than 512 bytes, got 500013",
+		},
+		{
+			name:        "unsupported operator",
+			condition:   `'alerts' in topics & 'b' in topics`,
+			expectedErr: `invalid condition: unexpected '&' at position 19`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			condition, err := ParseCondition(tt.condition)
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, condition.String())
+			assert.Equal(t, tt.expectedTopics, condition.Topics())
+
+			// The normalized form parses to itself
+			reparsed, err := ParseCondition(condition.String())
+			require.NoError(t, err)
+			assert.Equal(t, condition.String(), reparsed.String())
+		})
+	}
+}
+
+func TestConditionMapTopics(t *testing.T) {
+	condition, err := ParseCondition(`'a' in topics && !('b' in topics || 'c' in topics)`)
+	require.NoError(t, err)
+
+	mapped := condition.MapTopics(func(topic string) string { return "site~" + topic })
+	assert.Equal(t, `'site~a' in topics && !('site~b' in topics || 'site~c' in topics)`, mapped.String())
+	assert.Equal(t, []string{"a", "b", "c"}, condition.Topics(), "the original is unchanged")
+}
+
+func TestValidTopicName(t *testing.T) {
+	for _, name := range []string{"alerts", "news-2024_v1.0", "a~b", "100%"} {
+		assert.True(t, ValidTopicName(name), name)
+	}
+	for _, name := range []string{"", "two words", "/topics/alerts", "ümlaut", "a:b"} {
+		assert.False(t, ValidTopicName(name), name)
+	}
+}
@@ -0,0 +1,39 @@
+package delivery
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Decoration represents a notification title decoration rule for user notifications
+type Decoration struct {
+	Pattern  string `json:"pattern"`
+	Template string `json:"template"`
+}
+
+// TopicDecoration represents a notification title decoration rule for topic notifications
+type TopicDecoration struct {
+	Pattern  string `json:"pattern"`
+	Template string `json:"template"`
+}
+
+// Apply renders the title with the template if it matches the pattern.
+// Returns the title unchanged and false if it doesn't match.
+func (d Decoration) Apply(title string) (string, bool, error) {
+	return decorate(d.Pattern, d.Template, title)
+}
+
+// Apply renders the title with the template if it matches the pattern.
+// Returns the title unchanged and false if it doesn't match.
+func (d TopicDecoration) Apply(title string) (string, bool, error) {
+	return decorate(d.Pattern, d.Template, title)
+}
+
+// decorate replaces {title} in the template with the title if the pattern matches
+func decorate(pattern, template, title string) (string, bool, error) {
+	matched, err := regexp.MatchString(pattern, title)
+	if err != nil || !matched {
+		return title, false, err
+	}
+	return strings.Replace(template, "{title}", title, 1), true, nil
+}
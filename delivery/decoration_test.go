@@ -0,0 +1,53 @@
+package delivery
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecorationApply(t *testing.T) {
+	tests := []struct {
+		name            string
+		decoration      Decoration
+		title           string
+		expectedTitle   string
+		expectedMatched bool
+		expectError     bool
+	}{
+		{
+			name:            "matching pattern",
+			decoration:      Decoration{Pattern: "^Alert", Template: "🚨 {title}"},
+			title:           "Alert: disk full",
+			expectedTitle:   "🚨 Alert: disk full",
+			expectedMatched: true,
+		},
+		{
+			name:          "no match",
+			decoration:    Decoration{Pattern: "^Alert", Template: "🚨 {title}"},
+			title:         "Hello",
+			expectedTitle: "Hello",
+		},
+		{
+			name:          "invalid pattern",
+			decoration:    Decoration{Pattern: "([", Template: "{title}!"},
+			title:         "Hello",
+			expectedTitle: "Hello",
+			expectError:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			title, matched, err := tt.decoration.Apply(tt.title)
+			assert.Equal(t, tt.expectedTitle, title)
+			assert.Equal(t, tt.expectedMatched, matched)
+			assert.Equal(t, tt.expectError, err != nil)
+
+			// Topic decorations behave the same
+			topicTitle, topicMatched, _ := TopicDecoration(tt.decoration).Apply(tt.title)
+			assert.Equal(t, tt.expectedTitle, topicTitle)
+			assert.Equal(t, tt.expectedMatched, topicMatched)
+		})
+	}
+}
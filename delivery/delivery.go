@@ -0,0 +1,117 @@
+// Package delivery builds web push notifications and sends them through Firebase
+// Cloud Messaging (FCM).
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"firebase.google.com/go/v4/messaging"
+)
+
+// MaxBatchSize is the largest number of messages FCM accepts in one SendEach call
+const MaxBatchSize = 500
+
+// Client defines the methods used from the Firebase messaging client
+type Client interface {
+	Send(ctx context.Context, message *messaging.Message) (string, error)
+	SendEach(ctx context.Context, messages []*messaging.Message) (*messaging.BatchResponse, error)
+	SendDryRun(ctx context.Context, message *messaging.Message) (string, error)
+	SubscribeToTopic(ctx context.Context, tokens []string, topic string) (*messaging.TopicManagementResponse, error)
+	UnsubscribeFromTopic(ctx context.Context, tokens []string, topic string) (*messaging.TopicManagementResponse, error)
+}
+
+// Batches splits messages into batches of at most size messages
+func Batches(messages []*messaging.Message, size int) [][]*messaging.Message {
+	var batches [][]*messaging.Message
+	for len(messages) > size {
+		batches = append(batches, messages[:size:size])
+		messages = messages[size:]
+	}
+	if len(messages) > 0 {
+		batches = append(batches, messages)
+	}
+	return batches
+}
+
+// ParseData decodes notification data given as a JSON object. Empty data returns nil.
+func ParseData(data string) (map[string]interface{}, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var dataMap map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &dataMap); err != nil {
+		return nil, fmt.Errorf("invalid data format: %v", err)
+	}
+	return dataMap, nil
+}
+
+// StringMap converts notification data to the string values FCM requires.
+// Values other than strings are JSON encoded.
+func StringMap(m map[string]interface{}) map[string]string {
+	result := make(map[string]string)
+	for k, v := range m {
+		switch val := v.(type) {
+		case string:
+			result[k] = val
+		default:
+			if bytes, err := json.Marshal(val); err == nil {
+				result[k] = string(bytes)
+			}
+		}
+	}
+	return result
+}
+
+// WebPushConfig creates the web push configuration of a notification. A
+// click_action in data becomes the notification link; FCM requires HTTPS links,
+// so http:// is replaced by https:// here and in data.
+func WebPushConfig(title, body string, data map[string]interface{}) *messaging.WebpushConfig {
+	webpushConfig := &messaging.WebpushConfig{
+		Notification: &messaging.WebpushNotification{
+			Title: title,
+			Body:  body,
+		},
+	}
+	if clickAction, ok := data["click_action"].(string); ok {
+		if strings.HasPrefix(clickAction, "http://") {
+			clickAction = strings.Replace(clickAction, "http://", "https://", 1)
+			data["click_action"] = clickAction
+		}
+		webpushConfig.FCMOptions = &messaging.WebpushFCMOptions{
+			Link: clickAction,
+		}
+	}
+	return webpushConfig
+}
+
+// IsInvalidTokenError reports whether a send failed because the token is no
+// longer valid and should be removed
+func IsInvalidTokenError(err error) bool {
+	if err == nil {
+		return false
+	}
+	errStr := err.Error()
+	return strings.Contains(errStr, "Requested entity was not found") ||
+		strings.Contains(errStr, "invalid registration token") ||
+		strings.Contains(errStr, "registration-token-not-registered") ||
+		strings.Contains(errStr, "InvalidRegistration")
+}
+
+// IsInvalidTopicToken reports whether a topic subscription of a token failed
+// because the token is no longer valid, from the reason FCM reported for it
+func IsInvalidTopicToken(reason string) bool {
+	return reason == "NOT_FOUND" || reason == "INVALID_ARGUMENT"
+}
+
+// IsTransientTopicError reports whether a topic subscription of a token failed
+// for a reason that may go away when it's tried again
+func IsTransientTopicError(reason string) bool {
+	switch reason {
+	case "INTERNAL", "UNAVAILABLE", "RESOURCE_EXHAUSTED":
+		return true
+	}
+	return false
+}
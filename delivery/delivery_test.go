@@ -0,0 +1,111 @@
+package delivery
+
+import (
+	"errors"
+	"testing"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseData(t *testing.T) {
+	data, err := ParseData("")
+	require.NoError(t, err)
+	assert.Nil(t, data)
+
+	data, err = ParseData(`{"click_action": "/app", "count": 2}`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"click_action": "/app", "count": float64(2)}, data)
+
+	_, err = ParseData(`{invalid`)
+	assert.ErrorContains(t, err, "invalid data format")
+}
+
+func TestStringMap(t *testing.T) {
+	assert.Equal(t, map[string]string{
+		"text":   "hello",
+		"count":  "2",
+		"flag":   "true",
+		"nested": `{"a":1}`,
+	}, StringMap(map[string]interface{}{
+		"text":   "hello",
+		"count":  2,
+		"flag":   true,
+		"nested": map[string]int{"a": 1},
+	}))
+}
+
+func TestWebPushConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         map[string]interface{}
+		expectedLink string
+	}{
+		{
+			name: "without data",
+		},
+		{
+			name:         "https link",
+			data:         map[string]interface{}{"click_action": "https://example.com/app"},
+			expectedLink: "https://example.com/app",
+		},
+		{
+			name:         "http link is upgraded",
+			data:         map[string]interface{}{"click_action": "http://example.com/app"},
+			expectedLink: "https://example.com/app",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := WebPushConfig("Title", "Body", tt.data)
+			assert.Equal(t, "Title", config.Notification.Title)
+			assert.Equal(t, "Body", config.Notification.Body)
+			if tt.expectedLink == "" {
+				assert.Nil(t, config.FCMOptions)
+				return
+			}
+			require.NotNil(t, config.FCMOptions)
+			assert.Equal(t, tt.expectedLink, config.FCMOptions.Link)
+			assert.Equal(t, tt.expectedLink, tt.data["click_action"], "data carries the same link")
+		})
+	}
+}
+
+func TestIsInvalidTokenError(t *testing.T) {
+	assert.False(t, IsInvalidTokenError(nil))
+	assert.False(t, IsInvalidTokenError(errors.New("deadline exceeded")))
+	assert.True(t, IsInvalidTokenError(errors.New("registration-token-not-registered")))
+	assert.True(t, IsInvalidTokenError(errors.New("Requested entity was not found.")))
+}
+
+func TestTopicErrorReasons(t *testing.T) {
+	assert.True(t, IsInvalidTopicToken("NOT_FOUND"))
+	assert.True(t, IsInvalidTopicToken("INVALID_ARGUMENT"))
+	assert.False(t, IsInvalidTopicToken("INTERNAL"))
+	assert.True(t, IsTransientTopicError("INTERNAL"))
+	assert.True(t, IsTransientTopicError("UNAVAILABLE"))
+	assert.False(t, IsTransientTopicError("TOO_MANY_TOPICS"))
+	assert.False(t, IsTransientTopicError("NOT_FOUND"))
+}
+
+func TestBatches(t *testing.T) {
+	messages := make([]*messaging.Message, 5)
+	for i := range messages {
+		messages[i] = &messaging.Message{Token: string(rune('a' + i))}
+	}
+
+	assert.Nil(t, Batches(nil, 2))
+	assert.Equal(t, [][]*messaging.Message{messages}, Batches(messages, 5))
+
+	batches := Batches(messages, 2)
+	require.Len(t, batches, 3)
+	assert.Equal(t, messages[:2], batches[0])
+	assert.Equal(t, messages[2:4], batches[1])
+	assert.Equal(t, messages[4:], batches[2])
+
+	// Appending to a batch doesn't overwrite the next one
+	_ = append(batches[0], &messaging.Message{})
+	assert.Equal(t, "c", batches[1][0].Token)
+}
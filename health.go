@@ -0,0 +1,157 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// storeProbeInterval is how long the result of writing a probe file to the
+// data directory is reused, so unauthenticated probes can't cause a write each
+const storeProbeInterval = 30 * time.Second
+
+// healthState tracks conditions that make the relay unready
+type healthState struct {
+	// dataLoaded is set once configuration and data files are loaded
+	dataLoaded atomic.Bool
+	// shuttingDown is set when the server starts draining connections
+	shuttingDown atomic.Bool
+
+	mu sync.Mutex
+	// storeErr is the error of the last failed data file write, cleared on success
+	storeErr error
+	// probedAt and probeErr are the time and result of the last data directory probe
+	probedAt time.Time
+	probeErr error
+	// serviceAccountErr is the result of the service account file check made
+	// at load and on every reload
+	serviceAccountErr error
+}
+
+// markDataLoaded marks configuration and data files as loaded
+func (h *healthState) markDataLoaded() {
+	h.dataLoaded.Store(true)
+}
+
+// markShuttingDown makes readiness fail so load balancers stop routing new requests
+func (h *healthState) markShuttingDown() {
+	h.shuttingDown.Store(true)
+}
+
+// recordStoreResult remembers whether the last data file write succeeded
+func (h *healthState) recordStoreResult(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.storeErr = err
+}
+
+func (h *healthState) lastStoreError() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.storeErr
+}
+
+// probeDataDir checks the data directory is writable, reusing the last result
+// for storeProbeInterval
+func (h *healthState) probeDataDir(configPath string, now time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.probedAt.IsZero() && now.Sub(h.probedAt) < storeProbeInterval {
+		return h.probeErr
+	}
+	h.probeErr = writeProbeFile(configPath)
+	h.probedAt = now
+	return h.probeErr
+}
+
+// recordServiceAccountCheck remembers the result of checking the service account file
+func (h *healthState) recordServiceAccountCheck(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.serviceAccountErr = err
+}
+
+func (h *healthState) serviceAccountError() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.serviceAccountErr
+}
+
+// Checks runs the readiness checks and returns their results by name, nil for
+// the ones that pass: shutdown, config, store, firebase and service_account
+func (s *Server) Checks() map[string]error {
+	return map[string]error{
+		"shutdown":        s.checkShutdown(),
+		"config":          s.checkConfigLoaded(),
+		"store":           s.checkStore(),
+		"firebase":        s.checkFirebaseClient(),
+		"service_account": s.health.serviceAccountError(),
+	}
+}
+
+func (s *Server) checkShutdown() error {
+	if s.health.shuttingDown.Load() {
+		return fmt.Errorf("shutting down")
+	}
+	return nil
+}
+
+func (s *Server) checkConfigLoaded() error {
+	if !s.health.dataLoaded.Load() {
+		return fmt.Errorf("configuration not loaded")
+	}
+	return nil
+}
+
+// checkStore verifies the last write succeeded and the data directory is writable
+func (s *Server) checkStore() error {
+	if err := s.health.lastStoreError(); err != nil {
+		return fmt.Errorf("last write failed: %v", err)
+	}
+	return s.health.probeDataDir(s.configPath, time.Now())
+}
+
+// writeProbeFile creates and removes a file in the data directory
+func writeProbeFile(configPath string) error {
+	probe, err := os.CreateTemp(filepath.Dir(configPath), ".readyz-*")
+	if err != nil {
+		return fmt.Errorf("data directory not writable: %v", err)
+	}
+	name := probe.Name()
+	closeErr := probe.Close()
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("failed to remove probe file: %v", err)
+	}
+	return closeErr
+}
+
+func (s *Server) checkFirebaseClient() error {
+	if s.client == nil {
+		return fmt.Errorf("messaging client not initialized")
+	}
+	return nil
+}
+
+// refreshServiceAccountCheck checks the service account file again for readiness
+func (s *Server) refreshServiceAccountCheck() {
+	s.health.recordServiceAccountCheck(checkServiceAccountFile(s.serviceAccountPath))
+}
+
+// checkServiceAccountFile verifies the service account file is present and has
+// the fields of a service account. Whether Google accepts the key is only seen
+// when sending.
+func checkServiceAccountFile(serviceAccountPath string) error {
+	content, err := readAndValidateServiceAccount(serviceAccountPath)
+	if err != nil {
+		return err
+	}
+	var jsonContent map[string]interface{}
+	if err := json.Unmarshal(content, &jsonContent); err != nil {
+		return fmt.Errorf("invalid service account JSON: %v", err)
+	}
+	return validateServiceAccount(jsonContent)
+}
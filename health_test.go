@@ -0,0 +1,137 @@
+package relay
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/your-username/notification-relay/mocks"
+)
+
+// setupTestHealth sets the health state to that of a loaded relay, with the
+// service account of setupTestEnvironment checked
+func setupTestHealth(s *Server) {
+	s.refreshServiceAccountCheck()
+	s.health.markDataLoaded()
+	s.client = &mocks.MockFirebaseMessagingClient{}
+}
+
+func TestChecks(t *testing.T) {
+	tests := []struct {
+		name          string
+		setup         func(t *testing.T, s *Server, tmpDir string)
+		failingChecks []string
+	}{
+		{
+			name:  "ready",
+			setup: func(*testing.T, *Server, string) {},
+		},
+		{
+			name: "shutting down",
+			setup: func(_ *testing.T, s *Server, _ string) {
+				s.BeginShutdown()
+			},
+			failingChecks: []string{"shutdown"},
+		},
+		{
+			name: "config not loaded",
+			setup: func(_ *testing.T, s *Server, _ string) {
+				s.health.dataLoaded.Store(false)
+			},
+			failingChecks: []string{"config"},
+		},
+		{
+			name: "last store write failed",
+			setup: func(_ *testing.T, s *Server, _ string) {
+				s.health.recordStoreResult(errors.New("disk full"))
+			},
+			failingChecks: []string{"store"},
+		},
+		{
+			name: "data directory not writable",
+			setup: func(_ *testing.T, s *Server, tmpDir string) {
+				s.configPath = filepath.Join(tmpDir, "missing", ConfigJSON)
+			},
+			failingChecks: []string{"store"},
+		},
+		{
+			name: "firebase client missing",
+			setup: func(_ *testing.T, s *Server, _ string) {
+				s.client = nil
+			},
+			failingChecks: []string{"firebase"},
+		},
+		{
+			name: "service account invalid",
+			setup: func(t *testing.T, s *Server, _ string) {
+				require.NoError(t, os.WriteFile(s.serviceAccountPath, []byte(`{"type":"user"}`), defaultFileMode))
+				s.refreshServiceAccountCheck()
+			},
+			failingChecks: []string{"service_account"},
+		},
+		{
+			name: "service account checked at load only",
+			setup: func(t *testing.T, s *Server, _ string) {
+				require.NoError(t, os.Remove(s.serviceAccountPath))
+			},
+		},
+		{
+			name: "service account checked again on reload",
+			setup: func(t *testing.T, s *Server, _ string) {
+				require.NoError(t, os.Remove(s.serviceAccountPath))
+				require.NoError(t, s.reloadConfig("test"))
+			},
+			failingChecks: []string{"service_account"},
+		},
+		{
+			name: "data directory probe is reused",
+			setup: func(t *testing.T, s *Server, tmpDir string) {
+				require.NoError(t, s.checkStore())
+				s.configPath = filepath.Join(tmpDir, "missing", ConfigJSON)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, tmpDir := setupTestEnvironment(t)
+			setupTestHealth(s)
+			tt.setup(t, s, tmpDir)
+
+			checks := s.Checks()
+			require.Len(t, checks, 5)
+			for name, err := range checks {
+				if slices.Contains(tt.failingChecks, name) {
+					assert.Error(t, err, name)
+				} else {
+					assert.NoError(t, err, name)
+				}
+			}
+
+			entries, err := os.ReadDir(tmpDir)
+			require.NoError(t, err)
+			for _, entry := range entries {
+				assert.NotContains(t, entry.Name(), ".readyz-", "probe files must be removed")
+			}
+		})
+	}
+}
+
+func TestSaveJSONRecordsStoreHealth(t *testing.T) {
+	s, tmpDir := setupTestEnvironment(t)
+	setupTestHealth(s)
+	allowedFiles[UserDeviceMapJSON] = true
+
+	s.configPath = filepath.Join(tmpDir, "missing", ConfigJSON)
+	assert.Error(t, s.saveJSON(UserDeviceMapJSON, s.devices))
+	assert.Error(t, s.health.lastStoreError())
+
+	s.configPath = filepath.Join(tmpDir, ConfigJSON)
+	assert.NoError(t, s.saveJSON(UserDeviceMapJSON, s.devices))
+	assert.NoError(t, s.health.lastStoreError())
+}
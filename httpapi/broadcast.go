@@ -0,0 +1,38 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+// broadcastNotification starts sending a notification to every device of every
+// user of a site, see relay.Server.StartBroadcast. The broadcast runs in the
+// background and is responded to with 202; its progress is listed by
+// listBroadcasts.
+func (h *Handler) broadcastNotification(c *gin.Context) {
+	var req relay.BroadcastRequest
+	if !bindOrReject(c, &req) {
+		return
+	}
+
+	started, err := h.relay.StartBroadcast(c.Request.Context(), req)
+	if err != nil {
+		sendErrorResponse(c, relay.ErrorStatus(err, http.StatusInternalServerError), err.Error())
+		return
+	}
+
+	c.JSON(http.StatusAccepted, BroadcastResponse{Message: BroadcastDetail{
+		Success:   http.StatusAccepted,
+		Message:   fmt.Sprintf("Broadcast to %d device(s) of %d user(s) started", started.Devices, started.Users),
+		Broadcast: started,
+	}})
+}
+
+// listBroadcasts returns running and recently finished broadcasts, newest first
+func (h *Handler) listBroadcasts(c *gin.Context) {
+	c.JSON(http.StatusOK, BroadcastsResponse{Message: BroadcastList{Success: 200, Broadcasts: h.relay.Broadcasts()}})
+}
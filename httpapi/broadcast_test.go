@@ -0,0 +1,117 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+func TestBroadcastNotification(t *testing.T) {
+	tests := []struct {
+		name            string
+		body            string
+		expectedStatus  int
+		expectedMessage string
+	}{
+		{
+			name:            "starts the broadcast",
+			body:            `{"project_name": "test_project", "site_name": "test_site", "title": "Maintenance", "body": "Tonight", "confirm": true}`,
+			expectedStatus:  http.StatusAccepted,
+			expectedMessage: "Broadcast to 3 device(s) of 2 user(s) started",
+		},
+		{
+			name:            "requires confirmation",
+			body:            `{"project_name": "test_project", "site_name": "test_site", "title": "Maintenance", "body": "Tonight"}`,
+			expectedStatus:  http.StatusBadRequest,
+			expectedMessage: "Broadcast would reach 2 user(s) on 3 device(s), set confirm to send it",
+		},
+		{
+			name:            "unknown project",
+			body:            `{"project_name": "unknown", "site_name": "test_site", "title": "Maintenance", "body": "Tonight", "confirm": true}`,
+			expectedStatus:  http.StatusNotFound,
+			expectedMessage: "project unknown not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mockClient := newTestHandler(t, func(env *testEnv) {
+				env.Devices["test_project_test_site"] = map[string][]string{"alice": {"a1", "a2"}, "bob": {"b1"}}
+			})
+			if tt.expectedStatus == http.StatusAccepted {
+				mockClient.On("SendEach", mock.Anything, mock.MatchedBy(func(messages []*messaging.Message) bool {
+					return len(messages) == 3
+				})).Return(&messaging.BatchResponse{SuccessCount: 3, Responses: []*messaging.SendResponse{
+					{Success: true}, {Success: true}, {Success: true},
+				}}, nil).Once()
+			}
+
+			w := httptest.NewRecorder()
+			c, _ := createTestContext(w)
+			req, err := http.NewRequest(http.MethodPost, "/broadcast", strings.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			c.Request = req
+
+			h.broadcastNotification(c)
+
+			require.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			require.NoError(t, h.relay.Shutdown(context.Background()))
+			mockClient.AssertExpectations(t)
+			if tt.expectedStatus != http.StatusAccepted {
+				var response ExcResponse
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, tt.expectedMessage, response.Exc.Message)
+				return
+			}
+
+			var response BroadcastResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.expectedMessage, response.Message.Message)
+			assert.Equal(t, "running", response.Message.Broadcast.Status)
+			assert.Len(t, response.Message.Broadcast.ID, 32, "the relay generates the broadcast ID")
+		})
+	}
+}
+
+func TestListBroadcasts(t *testing.T) {
+	h, mockClient := newTestHandler(t, func(env *testEnv) {
+		env.Devices["test_project_test_site"] = map[string][]string{"alice": {"a1"}}
+	})
+	mockClient.On("SendEach", mock.Anything, mock.Anything).Return(&messaging.BatchResponse{
+		SuccessCount: 1, Responses: []*messaging.SendResponse{{Success: true}},
+	}, nil).Once()
+
+	started, err := h.relay.StartBroadcast(context.Background(), relay.BroadcastRequest{
+		ProjectName: "test_project", SiteName: "test_site", Title: "Maintenance", Body: "Tonight", Confirm: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, h.relay.Shutdown(context.Background()))
+
+	w := httptest.NewRecorder()
+	c, _ := createTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/broadcasts", http.NoBody)
+	h.listBroadcasts(c)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Message struct {
+			Broadcasts []map[string]interface{} `json:"broadcasts"`
+		} `json:"message"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Message.Broadcasts, 1)
+	assert.Equal(t, started.ID, response.Message.Broadcasts[0]["id"])
+	assert.Equal(t, "completed", response.Message.Broadcasts[0]["status"])
+	assert.Contains(t, response.Message.Broadcasts[0], "finished_at")
+}
@@ -0,0 +1,285 @@
+package httpapi
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+// Brute-force protection defaults
+const (
+	DefaultMaxAuthFailures = 5
+	DefaultFailureWindow   = 15 * time.Minute
+	DefaultBaseLockout     = time.Minute
+	DefaultMaxLockout      = time.Hour
+
+	// Prefixes of tracked entries, one entry per client IP and per API key
+	lockoutIPPrefix  = "ip:"
+	lockoutKeyPrefix = "key:"
+)
+
+// failureEntry tracks failed authentication attempts of a client IP or API key
+type failureEntry struct {
+	Key         string     `json:"key"`
+	Failures    int        `json:"failures"`
+	Lockouts    int        `json:"lockouts"`
+	LastFailure time.Time  `json:"last_failure"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"` // Unset until the first lockout
+}
+
+// lockoutEnd returns when the last lockout ends, or the zero time if there was none
+func (e *failureEntry) lockoutEnd() time.Time {
+	if e.LockedUntil == nil {
+		return time.Time{}
+	}
+	return *e.LockedUntil
+}
+
+// failureTracker counts failed authentication attempts and locks out offenders.
+// Each lockout doubles the previous lockout duration up to the configured maximum.
+type failureTracker struct {
+	mu      sync.Mutex
+	entries map[string]*failureEntry
+	now     func() time.Time
+	// settings returns the brute_force configuration in use, nil for the defaults
+	settings func() *relay.BruteForceConfig
+}
+
+func newFailureTracker(settings func() *relay.BruteForceConfig) *failureTracker {
+	return &failureTracker{
+		entries:  make(map[string]*failureEntry),
+		now:      time.Now,
+		settings: settings,
+	}
+}
+
+// lockedUntil returns the latest lockout expiry of the given entries, or the zero time
+func (t *failureTracker) lockedUntil(keys ...string) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	var until time.Time
+	for _, key := range keys {
+		if entry, exists := t.entries[key]; exists && entry.lockoutEnd().After(now) && entry.lockoutEnd().After(until) {
+			until = entry.lockoutEnd()
+		}
+	}
+	return until
+}
+
+// recordFailure counts a failed attempt for each key and locks keys that reached the limit
+func (t *failureTracker) recordFailure(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.prune(now)
+
+	maxFailures, window, baseLockout, maxLockout := bruteForceSettings(t.settings())
+	for _, key := range keys {
+		entry, exists := t.entries[key]
+		if !exists {
+			entry = &failureEntry{Key: key}
+			t.entries[key] = entry
+		}
+
+		// Start counting again once the failure window has passed
+		if now.Sub(entry.LastFailure) > window {
+			entry.Failures = 0
+		}
+		entry.Failures++
+		entry.LastFailure = now
+
+		if entry.Failures < maxFailures {
+			continue
+		}
+
+		lockout := baseLockout << entry.Lockouts
+		if lockout <= 0 || lockout > maxLockout {
+			lockout = maxLockout
+		}
+		entry.Lockouts++
+		entry.Failures = 0
+		lockedUntil := now.Add(lockout)
+		entry.LockedUntil = &lockedUntil
+		authLog().Warn("Locked out after repeated authentication failures", "key", key, "lockout", lockout)
+	}
+}
+
+// recordSuccess forgets failed attempts of the keys. Lockout counts are kept
+// until pruned, so authenticating between guesses doesn't shorten the next lockout.
+func (t *failureTracker) recordSuccess(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, key := range keys {
+		entry, exists := t.entries[key]
+		if !exists {
+			continue
+		}
+		if entry.Lockouts == 0 {
+			delete(t.entries, key)
+			continue
+		}
+		entry.Failures = 0
+	}
+}
+
+// locked returns all entries that are currently locked out, sorted by key
+func (t *failureTracker) locked() []failureEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	result := make([]failureEntry, 0)
+	for _, entry := range t.entries {
+		if entry.lockoutEnd().After(now) {
+			result = append(result, *entry)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// unlock removes the entry and reports whether it existed
+func (t *failureTracker) unlock(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, exists := t.entries[key]
+	delete(t.entries, key)
+	return exists
+}
+
+// prune drops entries that are neither locked nor within the failure window.
+// Entries that were locked keep their lockout count until the window passes
+// after the lockout ends, so repeat offenders get progressively longer lockouts.
+func (t *failureTracker) prune(now time.Time) {
+	_, window, _, _ := bruteForceSettings(t.settings())
+	for key, entry := range t.entries {
+		if entry.lockoutEnd().After(now) {
+			continue
+		}
+		if now.Sub(entry.LastFailure) > window && now.Sub(entry.lockoutEnd()) > window {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// bruteForceSettings returns the limits of bf, falling back to defaults
+func bruteForceSettings(bf *relay.BruteForceConfig) (maxFailures int, window, baseLockout, maxLockout time.Duration) {
+	maxFailures = DefaultMaxAuthFailures
+	window = DefaultFailureWindow
+	baseLockout = DefaultBaseLockout
+	maxLockout = DefaultMaxLockout
+
+	if bf != nil {
+		if bf.MaxFailures > 0 {
+			maxFailures = bf.MaxFailures
+		}
+		if bf.FailureWindow > 0 {
+			window = time.Duration(bf.FailureWindow) * time.Second
+		}
+		if bf.BaseLockout > 0 {
+			baseLockout = time.Duration(bf.BaseLockout) * time.Second
+		}
+		if bf.MaxLockout > 0 {
+			maxLockout = time.Duration(bf.MaxLockout) * time.Second
+		}
+	}
+	return maxFailures, window, baseLockout, maxLockout
+}
+
+// requestAPIKey returns the API key a request claims to authenticate with, if any
+func requestAPIKey(c *gin.Context) string {
+	if apiKey := c.GetHeader(HeaderHMACKey); apiKey != "" && isHMACRequest(c) {
+		return apiKey
+	}
+	if apiKey, _, ok := c.Request.BasicAuth(); ok {
+		return apiKey
+	}
+	return ""
+}
+
+// authFailureGuard returns a middleware handler that rejects clients locked out after
+// repeated authentication failures and records the outcome of the authentication
+// middleware that follows it. The client IP honours the trusted proxy configuration.
+// API keys are only tracked when they exist, so guessing unknown keys can't lock
+// out a site and failures with a known key mean its secret or signature was wrong.
+func (h *Handler) authFailureGuard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys := []string{lockoutIPPrefix + c.ClientIP()}
+		if apiKey := requestAPIKey(c); apiKey != "" {
+			if _, exists := h.relay.Credential(apiKey); exists {
+				keys = append(keys, lockoutKeyPrefix+apiKey)
+			}
+		}
+
+		if until := h.failures.lockedUntil(keys...); !until.IsZero() {
+			retryAfter := int(until.Sub(h.failures.now()).Seconds()) + 1
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			sendErrorResponse(c, http.StatusTooManyRequests, "Too many failed authentication attempts")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+
+		if c.Writer.Status() == http.StatusUnauthorized {
+			h.failures.recordFailure(keys...)
+			return
+		}
+		// Authentication middleware aborts on failure, so reaching the handler means success
+		if !c.IsAborted() {
+			h.failures.recordSuccess(keys...)
+		}
+	}
+}
+
+// apiAdminAuth returns a middleware handler that only lets API keys listed in
+// admin_api_keys through. It must run after apiAuth.
+func (h *Handler) apiAdminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey := c.GetString(apiKeyContextKey)
+		for _, adminKey := range h.relay.Config().AdminAPIKeys {
+			if apiKey != "" && apiKey == adminKey {
+				c.Next()
+				return
+			}
+		}
+
+		sendErrorResponse(c, http.StatusForbidden, "Admin access required")
+		c.Abort()
+	}
+}
+
+// listLockouts returns all client IPs and API keys that are currently locked out
+func (h *Handler) listLockouts(c *gin.Context) {
+	c.JSON(http.StatusOK, LockoutsResponse{Message: LockoutList{Success: 200, Lockouts: h.failures.locked()}})
+}
+
+// clearLockout removes the lockout for the entry given by the key query parameter,
+// e.g. "ip:203.0.113.7" or "key:abc123".
+func (h *Handler) clearLockout(c *gin.Context) {
+	key := c.Query("key")
+	if !strings.HasPrefix(key, lockoutIPPrefix) && !strings.HasPrefix(key, lockoutKeyPrefix) {
+		sendErrorResponse(c, http.StatusBadRequest, "key must start with ip: or key:")
+		return
+	}
+
+	if !h.failures.unlock(key) {
+		sendErrorResponse(c, http.StatusNotFound, "No lockout found for "+key)
+		return
+	}
+
+	authLog().InfoContext(c.Request.Context(), "Lockout cleared", "key", key)
+	sendSuccessResponse(c, "Lockout cleared for "+key)
+}
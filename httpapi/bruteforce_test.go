@@ -0,0 +1,249 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+// setupTestFailureTracker gives the tracker a controllable clock
+func setupTestFailureTracker(tracker *failureTracker) *time.Time {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+	return &now
+}
+
+func TestFailureTracker(t *testing.T) {
+	settings := &relay.BruteForceConfig{
+		MaxFailures:   3,
+		FailureWindow: 60,
+		BaseLockout:   10,
+		MaxLockout:    30,
+	}
+	newTracker := func() *failureTracker {
+		return newFailureTracker(func() *relay.BruteForceConfig { return settings })
+	}
+
+	t.Run("locks out after max failures", func(t *testing.T) {
+		authFailures := newTracker()
+		now := setupTestFailureTracker(authFailures)
+
+		authFailures.recordFailure("ip:1.2.3.4")
+		authFailures.recordFailure("ip:1.2.3.4")
+		assert.True(t, authFailures.lockedUntil("ip:1.2.3.4").IsZero())
+
+		authFailures.recordFailure("ip:1.2.3.4")
+		assert.Equal(t, now.Add(10*time.Second), authFailures.lockedUntil("ip:1.2.3.4"))
+
+		*now = now.Add(11 * time.Second)
+		assert.True(t, authFailures.lockedUntil("ip:1.2.3.4").IsZero(), "lockout should expire")
+	})
+
+	t.Run("lockout duration grows and is capped", func(t *testing.T) {
+		authFailures := newTracker()
+		now := setupTestFailureTracker(authFailures)
+
+		expected := []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second}
+		for _, lockout := range expected {
+			for i := 0; i < 3; i++ {
+				authFailures.recordFailure("key:guessed")
+			}
+			assert.Equal(t, now.Add(lockout), authFailures.lockedUntil("key:guessed"))
+			*now = now.Add(lockout + time.Second)
+		}
+	})
+
+	t.Run("failures outside the window are forgotten", func(t *testing.T) {
+		authFailures := newTracker()
+		now := setupTestFailureTracker(authFailures)
+
+		authFailures.recordFailure("ip:1.2.3.4")
+		authFailures.recordFailure("ip:1.2.3.4")
+		*now = now.Add(2 * time.Minute)
+		authFailures.recordFailure("ip:1.2.3.4")
+		assert.True(t, authFailures.lockedUntil("ip:1.2.3.4").IsZero())
+	})
+
+	t.Run("success resets failures", func(t *testing.T) {
+		authFailures := newTracker()
+
+		authFailures.recordFailure("ip:1.2.3.4")
+		authFailures.recordFailure("ip:1.2.3.4")
+		authFailures.recordSuccess("ip:1.2.3.4")
+		authFailures.recordFailure("ip:1.2.3.4")
+		assert.True(t, authFailures.lockedUntil("ip:1.2.3.4").IsZero())
+	})
+
+	t.Run("success keeps lockout count", func(t *testing.T) {
+		authFailures := newTracker()
+		now := setupTestFailureTracker(authFailures)
+
+		for i := 0; i < 3; i++ {
+			authFailures.recordFailure("key:valid")
+		}
+		*now = now.Add(11 * time.Second)
+		authFailures.recordSuccess("key:valid")
+		for i := 0; i < 3; i++ {
+			authFailures.recordFailure("key:valid")
+		}
+		assert.Equal(t, now.Add(20*time.Second), authFailures.lockedUntil("key:valid"), "lockout should still grow")
+	})
+
+	t.Run("list and unlock", func(t *testing.T) {
+		authFailures := newTracker()
+
+		for i := 0; i < 3; i++ {
+			authFailures.recordFailure("ip:1.2.3.4", "key:guessed")
+		}
+		authFailures.recordFailure("ip:5.6.7.8")
+
+		locked := authFailures.locked()
+		require.Len(t, locked, 2)
+		assert.Equal(t, "ip:1.2.3.4", locked[0].Key)
+		assert.Equal(t, "key:guessed", locked[1].Key)
+
+		assert.True(t, authFailures.unlock("ip:1.2.3.4"))
+		assert.False(t, authFailures.unlock("ip:1.2.3.4"))
+		assert.Len(t, authFailures.locked(), 1)
+	})
+
+	t.Run("lockout expiry is only set once locked", func(t *testing.T) {
+		authFailures := newTracker()
+		now := setupTestFailureTracker(authFailures)
+
+		for i := 0; i < 3; i++ {
+			authFailures.recordFailure("ip:1.2.3.4")
+		}
+		authFailures.recordFailure("ip:5.6.7.8")
+
+		authFailures.mu.Lock()
+		locked, counting := *authFailures.entries["ip:1.2.3.4"], *authFailures.entries["ip:5.6.7.8"]
+		authFailures.mu.Unlock()
+		lockedJSON, err := json.Marshal(locked)
+		require.NoError(t, err)
+		assert.Contains(t, string(lockedJSON), `"locked_until":"`+now.Add(10*time.Second).Format(time.RFC3339Nano)+`"`)
+		countingJSON, err := json.Marshal(counting)
+		require.NoError(t, err)
+		assert.NotContains(t, string(countingJSON), "locked_until")
+	})
+}
+
+func TestAuthFailureGuard(t *testing.T) {
+	h, _ := newTestHandler(t, func(env *testEnv) {
+		env.Config.BruteForce = &relay.BruteForceConfig{MaxFailures: 3}
+		env.Credentials["valid-key"] = "valid-secret"
+	})
+	setupTestFailureTracker(h.failures)
+
+	w := httptest.NewRecorder()
+	_, router := createTestContext(w)
+	require.NoError(t, setTrustedProxies(router, "127.0.0.1/32"))
+	router.GET("/test", h.authFailureGuard(), h.apiAuth(relay.RouteGroupAPI), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	send := func(remoteAddr, forwardedFor, apiKey, apiSecret string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/test", http.NoBody)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		req.SetBasicAuth(apiKey, apiSecret)
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A client behind the trusted proxy guesses secrets for an unknown key
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusUnauthorized, send("127.0.0.1:1234", "203.0.113.7", "guess", "guess").Code)
+	}
+
+	// The forwarded client IP is locked out, even with valid credentials
+	rec := send("127.0.0.1:1234", "203.0.113.7", "valid-key", "valid-secret")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "61", rec.Header().Get("Retry-After"))
+
+	// Other clients behind the same proxy are not affected
+	assert.Equal(t, http.StatusOK, send("127.0.0.1:1234", "203.0.113.8", "valid-key", "valid-secret").Code)
+
+	// Guessing a known key's secret locks out that key from any IP
+	for i := 0; i < 3; i++ {
+		send("127.0.0.1:1234", "198.51.100.1", "valid-key", "wrong")
+	}
+	assert.Equal(t, http.StatusTooManyRequests, send("127.0.0.1:1234", "198.51.100.2", "valid-key", "valid-secret").Code)
+
+	// Unknown keys are not tracked, so they can't be used to lock out a site
+	for i := 0; i < 3; i++ {
+		send("127.0.0.1:1234", "198.51.100.3", "other-key", "guess")
+	}
+	assert.True(t, h.failures.lockedUntil(lockoutKeyPrefix+"other-key").IsZero())
+
+	locked := h.failures.locked()
+	require.Len(t, locked, 4)
+	assert.Equal(t, "ip:198.51.100.1", locked[0].Key)
+	assert.Equal(t, "ip:198.51.100.3", locked[1].Key)
+	assert.Equal(t, "ip:203.0.113.7", locked[2].Key)
+	assert.Equal(t, "key:valid-key", locked[3].Key)
+}
+
+func TestLockoutAdminEndpoints(t *testing.T) {
+	h, _ := newTestHandler(t, func(env *testEnv) {
+		env.Config.BruteForce = &relay.BruteForceConfig{MaxFailures: 1}
+		env.Config.AdminAPIKeys = []string{"admin-key"}
+		env.Credentials["admin-key"] = "admin-secret"
+		env.Credentials["site-key"] = "site-secret"
+	})
+	setupTestFailureTracker(h.failures)
+	h.failures.recordFailure("ip:203.0.113.7")
+
+	w := httptest.NewRecorder()
+	_, router := createTestContext(w)
+	admin := router.Group("/", h.apiAuth(relay.RouteGroupAPI), h.apiAdminAuth())
+	admin.GET("/lockouts", h.listLockouts)
+	admin.POST("/unlock", h.clearLockout)
+
+	send := func(method, path, apiKey, apiSecret string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, http.NoBody)
+		req.SetBasicAuth(apiKey, apiSecret)
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("non-admin key is forbidden", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/lockouts", "site-key", "site-secret").Code)
+	})
+
+	t.Run("list lockouts", func(t *testing.T) {
+		rec := send(http.MethodGet, "/lockouts", "admin-key", "admin-secret")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var response struct {
+			Message struct {
+				Lockouts []failureEntry `json:"lockouts"`
+			} `json:"message"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		require.Len(t, response.Message.Lockouts, 1)
+		assert.Equal(t, "ip:203.0.113.7", response.Message.Lockouts[0].Key)
+	})
+
+	t.Run("invalid unlock key", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/unlock?key=203.0.113.7", "admin-key", "admin-secret").Code)
+	})
+
+	t.Run("unlock", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send(http.MethodPost, "/unlock?key=ip:203.0.113.7", "admin-key", "admin-secret").Code)
+		assert.Empty(t, h.failures.locked())
+		assert.Equal(t, http.StatusNotFound, send(http.MethodPost, "/unlock?key=ip:203.0.113.7", "admin-key", "admin-secret").Code)
+	})
+}
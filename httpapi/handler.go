@@ -0,0 +1,97 @@
+// Package httpapi is the HTTP API of a relay server: the Frappe-compatible
+// endpoints, the v2 API, the admin endpoints, health checks, metrics and the
+// OpenAPI document.
+//
+// A Handler serves one relay.Server. It can be served on its own listener with
+// Serve, which also reloads the configuration and shuts down gracefully, or be
+// mounted into an existing http.ServeMux or gin engine. Hosts that mount it
+// reload the server with relay.Server.Reload or WatchConfig, and stop it with
+// relay.Server.BeginShutdown and relay.Server.Shutdown.
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+// Handler serves the HTTP API of a relay server. Each Handler keeps its own
+// brute-force lockouts and HMAC nonces.
+type Handler struct {
+	relay    *relay.Server
+	router   *gin.Engine
+	failures *failureTracker
+	nonces   *nonceCache
+	metrics  *httpMetrics
+}
+
+// New builds the HTTP API of srv. Trusted proxies are read from TRUSTED_PROXIES
+// or the configuration when the handler is built; changing them needs a new
+// Handler.
+func New(srv *relay.Server) *Handler {
+	h := &Handler{
+		relay: srv,
+		failures: newFailureTracker(func() *relay.BruteForceConfig {
+			return srv.Config().BruteForce
+		}),
+		nonces:  newNonceCache(),
+		metrics: newHTTPMetrics(srv.Metrics()),
+	}
+	h.router = h.newRouter()
+	return h
+}
+
+// ServeHTTP serves the relay API, health checks and metrics
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.router.ServeHTTP(w, r)
+}
+
+// Mount registers the relay under prefix, e.g. "/relay", so the API is served at
+// /relay/api/method/... An empty prefix serves it at the root of mux. HMAC
+// signatures cover the full path, including the prefix.
+func (h *Handler) Mount(mux *http.ServeMux, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	mux.Handle(prefix+"/", stripMountPrefix(prefix, h.router))
+}
+
+// MountGin registers the relay under prefix in a gin engine or group. The prefix
+// may only be empty for a group, gin doesn't allow a catch-all route at the root
+// of an engine next to other routes. HMAC signatures cover the full path,
+// including the group path and prefix.
+func (h *Handler) MountGin(router gin.IRoutes, prefix string) {
+	router.Any(strings.TrimSuffix(prefix, "/")+"/*path", func(c *gin.Context) {
+		// Strip the group path and prefix, whatever the caller mounted us under
+		base := strings.TrimSuffix(c.Request.URL.Path, c.Param("path"))
+		stripMountPrefix(base, h.router).ServeHTTP(c.Writer, c.Request)
+	})
+}
+
+// requestPathKey is the request context key holding the path a request was
+// sent to, before the mount prefix was stripped
+type requestPathKey struct{}
+
+// stripMountPrefix serves h with prefix removed from the request path. The
+// path as sent is kept, since that is the path clients sign.
+func stripMountPrefix(prefix string, h http.Handler) http.Handler {
+	strip := http.StripPrefix(prefix, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Keep the outermost path when mounts are nested
+		if _, ok := r.Context().Value(requestPathKey{}).(string); !ok {
+			r = r.WithContext(context.WithValue(r.Context(), requestPathKey{}, r.URL.Path))
+		}
+		strip.ServeHTTP(w, r)
+	})
+}
+
+// requestPath returns the path the client sent the request to, including the
+// prefix the relay is mounted under
+func requestPath(r *http.Request) string {
+	if path, ok := r.Context().Value(requestPathKey{}).(string); ok {
+		return path
+	}
+	return r.URL.Path
+}
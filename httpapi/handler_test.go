@@ -0,0 +1,111 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServe(t *testing.T) {
+	h, _ := newTestHandler(t, func(env *testEnv) {
+		noDrain := 0
+		env.Config.ShutdownDrain = &noDrain
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() { runErr <- Serve(ctx, h.relay, ln) }()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + HealthzPath) // #nosec G107 -- test server URL
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	cancel()
+	select {
+	case err := <-runErr:
+		assert.NoError(t, err)
+		assert.Error(t, h.relay.Checks()["shutdown"], "Serve shuts the relay down")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after cancel")
+	}
+}
+
+func TestMount(t *testing.T) {
+	// Clients sign the path they send the request to, prefix included
+	h, _ := newTestHandler(t, func(env *testEnv) {
+		env.Credentials["signing-key"] = "signing-secret"
+	})
+
+	mux := http.NewServeMux()
+	h.Mount(mux, "/relay/")
+	mux.HandleFunc("/other", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+
+	engine := gin.New()
+	h.MountGin(engine, "/relay")
+	engine.GET("/other", func(c *gin.Context) { c.Status(http.StatusTeapot) })
+
+	group := gin.New()
+	h.MountGin(group.Group("/services/relay"), "")
+
+	tests := []struct {
+		name     string
+		handler  http.Handler
+		path     string
+		expected int
+	}{
+		{"mux relay route", mux, "/relay" + ReadyzPath, http.StatusOK},
+		{"mux relay API", mux, "/relay/api/method/notification_relay.api.get_config?project_name=test_project", http.StatusOK},
+		{"mux own route", mux, "/other", http.StatusTeapot},
+		{"mux unprefixed", mux, ReadyzPath, http.StatusNotFound},
+		{"gin relay route", engine, "/relay" + ReadyzPath, http.StatusOK},
+		{"gin relay API", engine, "/relay/api/method/notification_relay.api.get_config?project_name=test_project", http.StatusOK},
+		{"gin own route", engine, "/other", http.StatusTeapot},
+		{"gin group", group, "/services/relay" + HealthzPath, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, http.NoBody))
+			assert.Equal(t, tt.expected, w.Code, w.Body.String())
+		})
+	}
+
+	signed := []struct {
+		name     string
+		handler  http.Handler
+		path     string
+		signPath string
+		expected int
+	}{
+		{"mux signed with prefix", mux, "/relay", "/relay", http.StatusOK},
+		{"mux signed without prefix", mux, "/relay", "", http.StatusUnauthorized},
+		{"gin signed with prefix", engine, "/relay", "/relay", http.StatusOK},
+		{"gin group signed with prefix", group, "/services/relay", "/services/relay", http.StatusOK},
+		{"gin group signed without prefix", group, "/services/relay", "", http.StatusUnauthorized},
+	}
+	for i, tt := range signed {
+		t.Run(tt.name, func(t *testing.T) {
+			const method = "/api/method/notification_relay.api.topic.list"
+			query := "project_name=test_project&site_name=test_site&user_id=alice"
+			signReq := httptest.NewRequest(http.MethodGet, tt.signPath+method+"?"+query, http.NoBody)
+			signTestRequest(signReq, "signing-key", "signing-secret", nil, time.Now(), fmt.Sprintf("mount-nonce-%08d", i))
+
+			req := httptest.NewRequest(http.MethodGet, tt.path+method+"?"+query, http.NoBody)
+			req.Header = signReq.Header
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, req)
+			assert.Equal(t, tt.expected, w.Code, w.Body.String())
+		})
+	}
+}
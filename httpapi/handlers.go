@@ -0,0 +1,554 @@
+package httpapi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+func (h *Handler) getConfig(c *gin.Context) {
+	projectName := c.Query("project_name")
+	appLog().DebugContext(c.Request.Context(), "Config requested", "project", projectName)
+
+	if projectName == "" {
+		c.JSON(http.StatusBadRequest, errorEnvelope(c, http.StatusBadRequest, "Project name is required"))
+		return
+	}
+
+	// Get project-specific config
+	projectConfig, exists := h.relay.Config().Projects[projectName]
+	if !exists {
+		appLog().WarnContext(c.Request.Context(), "Config requested for unknown project",
+			"project", projectName, "available_projects", h.getProjectNames())
+		c.JSON(http.StatusNotFound, errorEnvelope(c, http.StatusNotFound, fmt.Sprintf("Configuration not found for project: %s", projectName)))
+		return
+	}
+
+	// Check if VAPID public key is configured
+	if projectConfig.VapidPublicKey == "" {
+		c.JSON(http.StatusBadRequest, errorEnvelope(c, http.StatusBadRequest, "VAPID public key not configured"))
+		return
+	}
+
+	// Create Firebase config with exact field names
+	firebaseConfig := gin.H{
+		"apiKey":            projectConfig.FirebaseConfig.ApiKey,
+		"authDomain":        projectConfig.FirebaseConfig.AuthDomain,
+		"projectId":         projectConfig.FirebaseConfig.ProjectID,
+		"messagingSenderId": projectConfig.FirebaseConfig.MessagingSenderId,
+		"appId":             projectConfig.FirebaseConfig.AppId,
+		"storageBucket":     projectConfig.FirebaseConfig.StorageBucket,
+		"measurementId":     projectConfig.FirebaseConfig.MeasurementID,
+	}
+
+	appLog().DebugContext(c.Request.Context(), "Sending Firebase config",
+		"project", projectName,
+		"firebase_project_id", projectConfig.FirebaseConfig.ProjectID,
+		"messaging_sender_id", projectConfig.FirebaseConfig.MessagingSenderId)
+
+	// Return config without the "message" wrapper (Frappe expects config and vapid_public_key at top level)
+	c.JSON(http.StatusOK, ConfigResponse{
+		VapidPublicKey: projectConfig.VapidPublicKey,
+		Config:         firebaseConfig,
+	})
+}
+
+// Helper function to get list of configured projects
+func (h *Handler) getProjectNames() []string {
+	projects := h.relay.Config().Projects
+	names := make([]string, 0, len(projects))
+	for name := range projects {
+		names = append(names, name)
+	}
+	return names
+}
+
+// getCredential handles API credential requests by validating the request,
+// verifying the provided token by making a request to the site's webhook,
+// and returning API credentials if verification is successful.
+// It expects a CredentialRequest with endpoint, protocol, port, token and webhook route.
+// Returns a CredentialResponse with success status and either credentials or error message.
+func (h *Handler) getCredential(c *gin.Context) {
+	authLog().DebugContext(c.Request.Context(), "Credential request received", "headers", redactHeaders(c.Request.Header))
+
+	var req CredentialRequest
+
+	// Try to get parameters from query string first
+	if c.Query("endpoint") != "" {
+		req = CredentialRequest{
+			Endpoint:     c.Query("endpoint"),
+			Protocol:     c.Query("protocol"),
+			Port:         c.Query("port"),
+			Token:        c.Query("token"),
+			WebhookRoute: c.Query("webhook_route"),
+		}
+	} else {
+		// Fall back to JSON body if query parameters aren't present
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusOK, CredentialEnvelope{Message: CredentialResponse{Message: "Invalid request format"}})
+			return
+		}
+	}
+
+	authLog().InfoContext(c.Request.Context(), "Credential request",
+		"endpoint", req.Endpoint,
+		"protocol", req.Protocol,
+		"port", req.Port,
+		"webhook_route", req.WebhookRoute)
+
+	// Force HTTP for localhost
+	if req.Endpoint == "localhost" || req.Endpoint == "127.0.0.1" {
+		authLog().DebugContext(c.Request.Context(), "Forcing HTTP protocol for localhost", "endpoint", req.Endpoint)
+		req.Protocol = "http"
+	}
+
+	// Create HTTP client
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+
+	// Build webhook URL
+	webhookURL := fmt.Sprintf("%s://%s%s%s",
+		req.Protocol,
+		req.Endpoint,
+		func() string {
+			if req.Port != "" {
+				return ":" + req.Port
+			}
+			return ""
+		}(),
+		req.WebhookRoute,
+	)
+
+	authLog().DebugContext(c.Request.Context(), "Verifying token via webhook", "url", webhookURL)
+
+	resp, err := client.Get(webhookURL)
+	if err != nil {
+		authLog().WarnContext(c.Request.Context(), "Webhook request failed", "url", webhookURL, "error", err)
+		c.JSON(http.StatusOK, CredentialEnvelope{Message: CredentialResponse{Message: fmt.Sprintf("Failed to verify token: %v", err)}})
+		return
+	}
+
+	if resp != nil {
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				authLog().WarnContext(c.Request.Context(), "Failed to close webhook response body", "error", err)
+			}
+		}()
+	}
+
+	authLog().DebugContext(c.Request.Context(), "Webhook responded", "url", webhookURL, "status", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		c.JSON(http.StatusOK, CredentialEnvelope{Message: CredentialResponse{Message: "Token verification failed"}})
+		return
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		authLog().WarnContext(c.Request.Context(), "Failed to read webhook response", "url", webhookURL, "error", err)
+		c.JSON(http.StatusOK, CredentialEnvelope{Message: CredentialResponse{Message: "Invalid token"}})
+		return
+	}
+
+	if string(body) != req.Token {
+		authLog().WarnContext(c.Request.Context(), "Webhook returned a different token", "url", webhookURL, "body_bytes", len(body))
+		c.JSON(http.StatusOK, CredentialEnvelope{Message: CredentialResponse{Message: "Invalid token"}})
+		return
+	}
+
+	// Generate new API credentials and save them
+	apiKey, apiSecret, err := h.relay.CreateCredentials()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, CredentialEnvelope{Message: CredentialResponse{Message: fmt.Sprintf("Failed to save credentials: %v", err)}})
+		return
+	}
+
+	// Return response in expected format
+	c.JSON(http.StatusOK, CredentialEnvelope{Message: CredentialResponse{
+		Success:     true,
+		Credentials: &CredentialDetails{APIKey: apiKey, APISecret: apiSecret},
+	}})
+}
+
+// apiKeyContextKey is the gin context key holding the authenticated API key
+const apiKeyContextKey = "api_key"
+
+// apiBasicAuth returns a middleware handler that performs Basic Auth validation
+// using API credentials stored in the credentials map.
+func (h *Handler) apiBasicAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey, apiSecret, hasAuth := c.Request.BasicAuth()
+		if !hasAuth {
+			c.Header("WWW-Authenticate", "Basic realm=Authorization Required")
+			abortUnauthorized(c)
+			return
+		}
+
+		if storedSecret, exists := h.relay.Credential(apiKey); !exists || storedSecret != apiSecret {
+			c.Header("WWW-Authenticate", "Basic realm=Authorization Required")
+			abortUnauthorized(c)
+			return
+		}
+
+		c.Set(apiKeyContextKey, apiKey)
+		c.Next()
+	}
+}
+
+// abortUnauthorized rejects the request with 401. The Frappe-compatible API
+// responds with an empty body, the v2 API with its error body.
+func abortUnauthorized(c *gin.Context) {
+	if isV2Request(c) {
+		v2Error(c, http.StatusUnauthorized, "Authentication required")
+		c.Abort()
+		return
+	}
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
+
+// apiAuth returns a middleware handler for a route group that accepts HMAC signed
+// requests, JWT bearer tokens or Basic Auth, depending on which credentials the
+// request carries. Bearer tokens are only accepted if jwt.route_groups allows
+// the group.
+func (h *Handler) apiAuth(group string) gin.HandlerFunc {
+	basicAuth := h.apiBasicAuth()
+	hmacAuth := h.apiHMACAuth()
+	bearerAuth := h.apiBearerAuth()
+	return func(c *gin.Context) {
+		switch {
+		case isHMACRequest(c):
+			hmacAuth(c)
+		case isBearerRequest(c):
+			if jwtConfig := h.relay.Config().JWT; jwtConfig != nil && !jwtConfig.AllowsRouteGroup(group) {
+				authLog().WarnContext(c.Request.Context(), "Bearer token not accepted for route group", "client_ip", c.ClientIP(), "group", group)
+				c.Header("WWW-Authenticate", `Bearer error="invalid_request"`)
+				abortUnauthorized(c)
+				return
+			}
+			bearerAuth(c)
+		default:
+			basicAuth(c)
+		}
+	}
+}
+
+// subscribeToTopic subscribes a user's devices to a Firebase topic.
+// Takes project name, site name, user ID and topic name from the body or query parameters.
+// Retrieves user's FCM tokens and subscribes them to the specified topic.
+// Returns success response if subscription succeeds, error response otherwise.
+func (h *Handler) subscribeToTopic(c *gin.Context) {
+	var req TopicRequest
+	if !bindOrReject(c, &req) {
+		return
+	}
+	projectName := req.ProjectName
+	siteName := req.SiteName
+	userID := req.UserID
+	topicName := req.TopicName
+
+	// Validate project exists
+	if err := h.relay.ValidateProject(projectName); err != nil {
+		sendErrorResponse(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Subscribe the user's tokens to the topic
+	result, err := h.relay.Subscribe(c.Request.Context(), projectName, siteName, userID, topicName)
+	if err != nil {
+		sendTopicError(c, "subscribe to", err)
+		return
+	}
+
+	// Log subscription result
+	deliveryLog().InfoContext(c.Request.Context(), "Topic subscription result",
+		"project", projectName,
+		"site", siteName,
+		"user_id", userID,
+		"topic", topicName,
+		"success_count", result.SuccessCount,
+		"failure_count", result.FailureCount)
+
+	c.JSON(http.StatusOK, TopicSubscriptionResponse{Message: TopicSubscriptionDetail{
+		Success: 200,
+		Message: fmt.Sprintf("User subscribed to topic %s. Success: %d, Failures: %d",
+			topicName, result.SuccessCount, result.FailureCount),
+		TopicSubscription: result,
+	}})
+}
+
+// sendTopicError responds with the error of a topic subscription change.
+// Failed FCM calls are reported with 400 as the Frappe app expects.
+func sendTopicError(c *gin.Context, action string, err error) {
+	if status := relay.ErrorStatus(err, 0); status != 0 {
+		sendErrorResponse(c, status, err.Error())
+		return
+	}
+	sendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("Failed to %s topic: %v", action, err))
+}
+
+// Add standardized error response helper
+func sendErrorResponse(c *gin.Context, statusCode int, message string) {
+	if isV2Request(c) {
+		v2Error(c, statusCode, message)
+		return
+	}
+	c.JSON(statusCode, errorEnvelope(c, statusCode, message))
+}
+
+// errorEnvelope builds the Frappe-style error body, including the request ID when known
+func errorEnvelope(c *gin.Context, statusCode int, message string) ExcResponse {
+	return ExcResponse{Exc: ExcDetail{
+		StatusCode: statusCode,
+		Message:    message,
+		RequestID:  requestID(c),
+	}}
+}
+
+// Add standardized success response helper
+func sendSuccessResponse(c *gin.Context, message string) {
+	c.JSON(http.StatusOK, MessageResponse{Message: MessageDetail{Success: 200, Message: message}})
+}
+
+// Update unsubscribeFromTopic to use standard responses
+func (h *Handler) unsubscribeFromTopic(c *gin.Context) {
+	var req TopicRequest
+	if !bindOrReject(c, &req) {
+		return
+	}
+	projectName := req.ProjectName
+	siteName := req.SiteName
+	userID := req.UserID
+	topicName := req.TopicName
+
+	// Validate project exists
+	if _, exists := h.relay.Config().Projects[projectName]; !exists {
+		sendErrorResponse(c, http.StatusNotFound, fmt.Sprintf("Project %s not found", projectName))
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// A user without devices left can still leave the topic, only the
+	// registry is updated then
+	result, err := h.relay.Unsubscribe(c.Request.Context(), projectName, siteName, userID, topicName)
+	if err != nil {
+		sendTopicError(c, "unsubscribe from", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, TopicSubscriptionResponse{Message: TopicSubscriptionDetail{
+		Success:           200,
+		Message:           fmt.Sprintf("User %s unsubscribed from %s topic", userID, topicName),
+		TopicSubscription: result,
+	}})
+}
+
+// addToken adds a user's FCM token to the user's device map.
+// Takes project name, site name, user ID and FCM token from the body or query parameters.
+// Checks for duplicate tokens and saves the token to the user's device map.
+// Returns success response if token is added, error response otherwise.
+func (h *Handler) addToken(c *gin.Context) {
+	var req TokenRequest
+	if !bindOrReject(c, &req) {
+		return
+	}
+	projectName := req.ProjectName
+	siteName := req.SiteName
+	userID := req.UserID
+	fcmToken := req.FCMToken
+
+	// Validate project exists in config
+	if _, exists := h.relay.Config().Projects[projectName]; !exists {
+		c.JSON(http.StatusBadRequest, errorEnvelope(c, 404, fmt.Sprintf("Project %s not found", projectName)))
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Check if token is empty
+	if fcmToken == "" {
+		c.JSON(http.StatusOK, MessageResponse{Message: MessageDetail{Success: false, Message: "FCM token is required"}})
+		return
+	}
+
+	// Add token to user's devices
+	added, err := h.relay.AddToken(c.Request.Context(), projectName, siteName, userID, fcmToken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorEnvelope(c, 500, err.Error()))
+		return
+	}
+	if !added {
+		sendSuccessResponse(c, "User Token duplicate found")
+		return
+	}
+
+	sendSuccessResponse(c, "User Token added")
+}
+
+// removeToken removes a user's FCM token from the user's device map.
+// Takes project name, site name, user ID and FCM token from the body or query parameters.
+// Removes the token from the user's device map and saves the updated map to the file.
+// Returns success response if token is removed, error response otherwise.
+func (h *Handler) removeToken(c *gin.Context) {
+	var req TokenRequest
+	if !bindOrReject(c, &req) {
+		return
+	}
+	projectName := req.ProjectName
+	siteName := req.SiteName
+	userID := req.UserID
+	fcmToken := req.FCMToken
+
+	// Validate project exists
+	if _, exists := h.relay.Config().Projects[projectName]; !exists {
+		c.JSON(http.StatusBadRequest, errorEnvelope(c, 404, fmt.Sprintf("Project %s not found", projectName)))
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	removed, err := h.relay.RemoveToken(c.Request.Context(), projectName, siteName, userID, fcmToken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorEnvelope(c, 500, "Failed to save user device map"))
+		return
+	}
+	if removed {
+		sendSuccessResponse(c, "User Token removed")
+		return
+	}
+
+	sendSuccessResponse(c, "User Token not found, removed")
+}
+
+// sendNotificationToUser sends a web push notification to every device of a user.
+// Takes project name, site name, user ID, title, body and data from the body or
+// query parameters. Returns the number of notifications sent.
+func (h *Handler) sendNotificationToUser(c *gin.Context) {
+	var req relay.NotificationRequest
+	if !bindOrReject(c, &req) {
+		return
+	}
+	projectName := req.ProjectName
+	siteName := req.SiteName
+	userID := req.UserID
+
+	ctx := c.Request.Context()
+	deliveryLog().DebugContext(ctx, "User notification request received",
+		"project", projectName,
+		"site", siteName,
+		"user_id", userID,
+		"headers", redactHeaders(c.Request.Header),
+		"query", relay.RedactQuery(c.Request.URL.Query()))
+
+	if err := validateUser(&req); err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, err := h.relay.NotifyUser(ctx, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorEnvelope(c, relay.ErrorStatus(err, http.StatusBadRequest), err.Error()))
+		return
+	}
+
+	// Return response based on success
+	if result.Sent > 0 {
+		sendSuccessResponse(c, fmt.Sprintf("%d Notification(s) sent to %s user", result.Sent, userID))
+		return
+	}
+
+	c.JSON(http.StatusBadRequest, errorEnvelope(c, 404, fmt.Sprintf("%s not subscribed to push notifications", userID)))
+}
+
+// sendNotificationToUsers sends a web push notification to several users.
+// Takes project name, site name, user IDs, title, body, data and per-user
+// overrides from the body or query parameters.
+// Returns the number of notifications sent to each user.
+func (h *Handler) sendNotificationToUsers(c *gin.Context) {
+	var req BulkNotificationRequest
+	if !bindOrReject(c, &req) {
+		return
+	}
+
+	if err := h.relay.ValidateProject(req.ProjectName); err != nil {
+		sendErrorResponse(c, http.StatusNotFound, err.Error())
+		return
+	}
+	if err := req.Validate(); err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result := h.relay.NotifyUsers(c.Request.Context(), req.ProjectName, req.SiteName, req.BulkMessageRequest)
+
+	reached := 0
+	for _, user := range result.Users {
+		if user.Sent > 0 {
+			reached++
+		}
+	}
+	c.JSON(http.StatusOK, BulkMessageResponse{Message: BulkMessageDetail{
+		Success:            200,
+		Message:            fmt.Sprintf("%d Notification(s) sent to %d of %d user(s)", result.Sent, reached, len(result.Users)),
+		BulkDeliveryResult: result,
+	}})
+}
+
+// sendNotificationToTopic sends a web push notification to a Firebase topic.
+// Takes topic name, title, body and additional data from the body or query parameters.
+// Returns a JSON response with the sending result.
+func (h *Handler) sendNotificationToTopic(c *gin.Context) {
+	deliveryLog().DebugContext(c.Request.Context(), "Topic notification request received",
+		"headers", redactHeaders(c.Request.Header),
+		"query", relay.RedactQuery(c.Request.URL.Query()))
+
+	var req relay.NotificationRequest
+	if !bindOrReject(c, &req) {
+		return
+	}
+	topic := req.TopicName
+	projectName := req.ProjectName
+
+	// Validate project exists
+	if err := h.relay.ValidateProject(projectName); err != nil {
+		sendErrorResponse(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	// Check topic name and notification parameters
+	if err := req.ValidateTopic(); err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := h.relay.NotifyTopic(c.Request.Context(), req); err != nil {
+		sendErrorResponse(c, relay.ErrorStatus(err, http.StatusInternalServerError), err.Error())
+		return
+	}
+
+	if req.Condition != "" {
+		sendSuccessResponse(c, fmt.Sprintf("Notification sent to condition %s", req.Condition))
+		return
+	}
+	sendSuccessResponse(c, fmt.Sprintf("Notification sent to %s topic", topic))
+}
@@ -1,82 +1,70 @@
-package main
+package httpapi
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
-	"github.com/your-username/notification-relay/mocks"
-
-	"github.com/stretchr/testify/assert"
-
 	"firebase.google.com/go/v4/messaging"
 	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+
+	relay "github.com/your-username/notification-relay"
+	"github.com/your-username/notification-relay/delivery"
+	"github.com/your-username/notification-relay/mocks"
+	"github.com/your-username/notification-relay/store"
 )
 
 var errInvalidToken = fmt.Errorf("invalid registration token")
 
 func TestGetConfig(t *testing.T) {
-	_, cleanup := setupTestEnvironment(t)
-	defer cleanup()
-
 	tests := []struct {
 		name           string
-		setupConfig    func()
+		setupConfig    func(env *testEnv)
 		expectedStatus int
 		expectedBody   map[string]interface{}
 	}{
 		{
 			name: "successful config retrieval",
-			setupConfig: func() {
-				config = Config{
-					Projects: map[string]ProjectConfig{
-						"test_project": {
-							VapidPublicKey: "test-vapid-key",
-							FirebaseConfig: FirebaseConfig{
-								ApiKey: "test-firebase-key",
-							},
-						},
-					},
-					TrustedProxies: "127.0.0.1",
-				}
+			setupConfig: func(env *testEnv) {
+				project := env.Config.Projects["test_project"]
+				project.FirebaseConfig.ApiKey = "test-firebase-key"
+				project.FirebaseConfig.MeasurementID = "G-TEST"
+				env.Config.Projects["test_project"] = project
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
 				"vapid_public_key": "test-vapid-key",
 				"config": map[string]interface{}{
 					"apiKey":            "test-firebase-key",
-					"appId":             "",
+					"appId":             "test-app-id",
 					"authDomain":        "",
-					"messagingSenderId": "",
-					"projectId":         "",
+					"messagingSenderId": "test-sender-id",
+					"projectId":         "test-project-id",
 					"storageBucket":     "",
+					"measurementId":     "G-TEST",
 				},
 			},
 		},
 		{
-			name: "missing vapid key",
-			setupConfig: func() {
-				config = Config{
-					Projects: map[string]ProjectConfig{
-						"test_project": {
-							VapidPublicKey: "",
-							FirebaseConfig: FirebaseConfig{
-								ApiKey: "test-firebase-key",
-							},
-						},
-					},
-					TrustedProxies: "127.0.0.1",
+			name: "unknown project",
+			setupConfig: func(env *testEnv) {
+				env.Config.Projects = map[string]relay.ProjectConfig{
+					"other_project": env.Config.Projects["test_project"],
 				}
 			},
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusNotFound,
 			expectedBody: map[string]interface{}{
 				"exc": map[string]interface{}{
-					"status_code": float64(400),
-					"message":     "VAPID public key not configured",
+					"status_code": float64(404),
+					"message":     "Configuration not found for project: test_project",
 				},
 			},
 		},
@@ -84,15 +72,14 @@ func TestGetConfig(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestHandler(t, tt.setupConfig)
 			w := httptest.NewRecorder()
 			c, _ := createTestContext(w)
 
-			tt.setupConfig()
-
 			// Setup request with project_name query parameter
 			c.Request, _ = http.NewRequest("GET", "/api/method/notification_relay.api.get_config?project_name=test_project", http.NoBody)
 
-			getConfig(c)
+			h.getConfig(c)
 
 			assert.Equal(t, tt.expectedStatus, w.Code)
 
@@ -106,8 +93,7 @@ func TestGetConfig(t *testing.T) {
 }
 
 func TestGetCredential(t *testing.T) {
-	_, cleanup := setupTestEnvironment(t)
-	defer cleanup()
+	h, _ := newTestHandler(t, nil)
 
 	// Create test server to simulate webhook endpoint
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -203,7 +189,7 @@ func TestGetCredential(t *testing.T) {
 			c.Request, err = makeTestRequest(http.MethodPost, "/get-credential", tt.request)
 			require.NoError(t, err)
 
-			getCredential(c)
+			h.getCredential(c)
 
 			assert.Equal(t, tt.expectedStatus, w.Code)
 			tt.checkResponse(t, w)
@@ -212,30 +198,22 @@ func TestGetCredential(t *testing.T) {
 }
 
 func TestSendNotificationToUser(t *testing.T) {
-	_, cleanup := setupTestEnvironment(t)
-	defer cleanup()
-
-	mockClient := &mocks.MockFirebaseMessagingClient{}
-	messagingClient = mockClient
-
-	// Set up test user and device
+	// Set up test user and device, decorations and icons
 	key := "test_project_test_site"
 	userID := "test_user"
 	token := "test_token"
-	userDeviceMap[key] = map[string][]string{
-		userID: {token},
-	}
-
-	// Set up test decorations
-	decorations[key] = map[string]Decoration{
-		"alert": {
-			Pattern:  "^Alert:",
-			Template: "🚨 {title}",
-		},
-	}
-
-	// Set up test icons
-	icons[key] = "/path/to/icon.png"
+	h, mockClient := newTestHandler(t, func(env *testEnv) {
+		env.Devices[key] = map[string][]string{
+			userID: {token},
+		}
+		env.Decorations[key] = map[string]delivery.Decoration{
+			"alert": {
+				Pattern:  "^Alert:",
+				Template: "🚨 {title}",
+			},
+		}
+		env.Icons[key] = "/path/to/icon.png"
+	})
 
 	tests := []struct {
 		name           string
@@ -326,8 +304,7 @@ func TestSendNotificationToUser(t *testing.T) {
 			},
 			checkMessage: func(t *testing.T, msg *messaging.Message) {
 				// Verify token was removed
-				tokens := userDeviceMap[key][userID]
-				assert.Empty(t, tokens)
+				assert.Empty(t, h.relay.Tokens("test_project", "test_site", userID))
 			},
 		},
 	}
@@ -351,7 +328,7 @@ func TestSendNotificationToUser(t *testing.T) {
 			req.URL.RawQuery = q.Encode()
 			c.Request = req
 
-			sendNotificationToUser(c)
+			h.sendNotificationToUser(c)
 
 			assert.Equal(t, tt.expectedStatus, w.Code)
 
@@ -365,133 +342,163 @@ func TestSendNotificationToUser(t *testing.T) {
 	}
 }
 
-func TestValidateNotificationParams(t *testing.T) {
-	tests := []struct {
-		name        string
-		title       string
-		body        string
-		expectError bool
-		errorMsg    string
-	}{
-		{
-			name:        "valid params",
-			title:       "Test Title",
-			body:        "Test Body",
-			expectError: false,
-		},
-		{
-			name:        "missing title",
-			title:       "",
-			body:        "Test Body",
-			expectError: true,
-			errorMsg:    "title is required",
-		},
-		{
-			name:        "missing body",
-			title:       "Test Title",
-			body:        "",
-			expectError: true,
-			errorMsg:    "body is required",
-		},
-	}
+func TestSendNotificationToUsers(t *testing.T) {
+	key := "test_project_test_site"
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			err := validateNotificationParams(tt.title, tt.body)
-			if tt.expectError {
-				assert.Error(t, err)
-				assert.Equal(t, tt.errorMsg, err.Error())
-			} else {
-				assert.NoError(t, err)
+	// respond succeeds for every message except those to stale tokens
+	respond := func(_ context.Context, messages []*messaging.Message) *messaging.BatchResponse {
+		response := &messaging.BatchResponse{}
+		for _, msg := range messages {
+			if strings.HasPrefix(msg.Token, "stale") {
+				response.Responses = append(response.Responses, &messaging.SendResponse{Error: errInvalidToken})
+				response.FailureCount++
+				continue
 			}
-		})
+			response.Responses = append(response.Responses, &messaging.SendResponse{Success: true, MessageID: "message_id"})
+			response.SuccessCount++
+		}
+		return response
 	}
-}
-
-func TestPrepareWebPushConfig(t *testing.T) {
-	_, cleanup := setupTestEnvironment(t)
-	defer cleanup()
 
 	tests := []struct {
 		name           string
-		key            string
-		title          string
+		devices        map[string][]string
+		setupMock      func(mockClient *mocks.MockFirebaseMessagingClient)
 		body           string
-		data           string
-		setupData      func()
-		expectError    bool
-		validateConfig func(*testing.T, *messaging.WebpushConfig)
+		expectedStatus int
+		expectedBody   map[string]interface{}
+		check          func(*testing.T, *Handler)
 	}{
 		{
-			name:  "basic config",
-			key:   "test_project",
-			title: "Test Title",
-			body:  "Test Body",
-			data:  "",
-			validateConfig: func(t *testing.T, config *messaging.WebpushConfig) {
-				assert.Equal(t, "Test Title", config.Notification.Title)
-				assert.Equal(t, "Test Body", config.Notification.Body)
+			name: "per-user results with overrides",
+			devices: map[string][]string{
+				"alice": {"alice-phone", "alice-laptop"},
+				"bob":   {"bob-phone", "stale-bob"},
+			},
+			setupMock: func(mockClient *mocks.MockFirebaseMessagingClient) {
+				mockClient.On("SendEach", mock.Anything, mock.MatchedBy(func(messages []*messaging.Message) bool {
+					if len(messages) != 4 {
+						return false
+					}
+					for _, msg := range messages {
+						isBob := strings.Contains(msg.Token, "bob")
+						if isBob != (msg.Webpush.Notification.Title == "Hi Bob") ||
+							isBob != (msg.Webpush.Data["room"] == "bob") ||
+							msg.Webpush.Data["message_id"] != "bulk_1" {
+							return false
+						}
+					}
+					return true
+				})).Return(respond, nil).Once()
+			},
+			body: `{"project_name": "test_project", "site_name": "test_site", "user_ids": ["alice", "bob", "alice", "carol"],
+				"title": "Hi", "body": "There", "data": {"message_id": "bulk_1"},
+				"overrides": {"bob": {"title": "Hi Bob", "data": {"room": "bob"}}}}`,
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"message": map[string]interface{}{
+					"success": float64(200),
+					"message": "3 Notification(s) sent to 2 of 3 user(s)",
+					"sent":    float64(3),
+					"failed":  float64(1),
+					"removed": float64(1),
+					"users": []interface{}{
+						map[string]interface{}{"user_id": "alice", "sent": float64(2), "failed": float64(0), "removed": float64(0)},
+						map[string]interface{}{"user_id": "bob", "sent": float64(1), "failed": float64(1), "removed": float64(1)},
+						map[string]interface{}{"user_id": "carol", "sent": float64(0), "failed": float64(0), "removed": float64(0),
+							"error": "user carol not subscribed to push notifications"},
+					},
+				},
+			},
+			check: func(t *testing.T, h *Handler) {
+				assert.Equal(t, []string{"bob-phone"}, h.relay.Tokens("test_project", "test_site", "bob"), "invalid tokens are removed")
 			},
 		},
 		{
-			name:  "with click action",
-			key:   "test_project",
-			title: "Test Title",
-			body:  "Test Body",
-			data:  `{"click_action": "https://example.com"}`,
-			validateConfig: func(t *testing.T, config *messaging.WebpushConfig) {
-				assert.Equal(t, "https://example.com", config.FCMOptions.Link)
+			name: "messages are sent in batches",
+			devices: func() map[string][]string {
+				devices := map[string][]string{}
+				for i := 0; i < delivery.MaxBatchSize+10; i++ {
+					user := fmt.Sprintf("user%d", i%3)
+					devices[user] = append(devices[user], fmt.Sprintf("token-%d", i))
+				}
+				return devices
+			}(),
+			setupMock: func(mockClient *mocks.MockFirebaseMessagingClient) {
+				mockClient.On("SendEach", mock.Anything, mock.MatchedBy(func(messages []*messaging.Message) bool {
+					return len(messages) == delivery.MaxBatchSize
+				})).Return(respond, nil).Once()
+				mockClient.On("SendEach", mock.Anything, mock.MatchedBy(func(messages []*messaging.Message) bool {
+					return len(messages) == 10
+				})).Return(nil, errors.New("unavailable")).Once()
+			},
+			body:           `{"project_name": "test_project", "site_name": "test_site", "user_ids": ["user0", "user1", "user2"], "title": "Hi", "body": "There"}`,
+			expectedStatus: http.StatusOK,
+			check: func(t *testing.T, h *Handler) {
+				assert.Len(t, h.relay.Tokens("test_project", "test_site", "user0"), 170, "failed batches don't remove tokens")
 			},
 		},
 		{
-			name:        "invalid data json",
-			key:         "test_project",
-			title:       "Test Title",
-			body:        "Test Body",
-			data:        "invalid json",
-			expectError: true,
+			name:           "missing user_ids",
+			setupMock:      func(*mocks.MockFirebaseMessagingClient) {},
+			body:           `{"project_name": "test_project", "site_name": "test_site", "title": "Hi", "body": "There"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: map[string]interface{}{
+				"exc": map[string]interface{}{"status_code": float64(400), "message": "user_ids is required"},
+			},
+		},
+		{
+			name:           "unknown project",
+			setupMock:      func(*mocks.MockFirebaseMessagingClient) {},
+			body:           `{"project_name": "unknown", "site_name": "test_site", "user_ids": ["alice"], "title": "Hi", "body": "There"}`,
+			expectedStatus: http.StatusNotFound,
+			expectedBody: map[string]interface{}{
+				"exc": map[string]interface{}{"status_code": float64(404), "message": "project unknown not found"},
+			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if tt.setupData != nil {
-				tt.setupData()
-			}
+			h, mockClient := newTestHandler(t, func(env *testEnv) {
+				env.Devices[key] = tt.devices
+			})
+			tt.setupMock(mockClient)
+			w := httptest.NewRecorder()
+			c, _ := createTestContext(w)
 
-			config, _, err := prepareWebPushConfig(tt.key, tt.title, tt.body, tt.data, "")
+			req, err := http.NewRequest(http.MethodPost, "/send", strings.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			c.Request = req
 
-			if tt.expectError {
-				assert.Error(t, err)
-				return
-			}
+			h.sendNotificationToUsers(c)
 
-			assert.NoError(t, err)
-			assert.NotNil(t, config)
-			if tt.validateConfig != nil {
-				tt.validateConfig(t, config)
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedBody != nil {
+				var response map[string]interface{}
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+				assert.Equal(t, tt.expectedBody, response)
+			}
+			if tt.check != nil {
+				tt.check(t, h)
 			}
+			mockClient.AssertExpectations(t)
 		})
 	}
 }
 
 func TestSubscribeToTopic(t *testing.T) {
-	_, cleanup := setupTestEnvironment(t)
-	defer cleanup()
-
-	mockClient := &mocks.MockFirebaseMessagingClient{}
-	messagingClient = mockClient
-
 	tests := []struct {
 		name           string
-		setupMock      func()
+		setupMock      func(mockClient *mocks.MockFirebaseMessagingClient)
 		queryParams    map[string]string
 		expectedStatus int
 		expectedBody   map[string]interface{}
 	}{
 		{
 			name: "successful subscription",
-			setupMock: func() {
+			setupMock: func(mockClient *mocks.MockFirebaseMessagingClient) {
 				mockClient.On("SubscribeToTopic",
 					mock.Anything,
 					[]string{"test_token"},
@@ -507,14 +514,20 @@ func TestSubscribeToTopic(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
 				"message": map[string]interface{}{
-					"success": float64(200),
-					"message": "User subscribed to topic test_topic. Success: 1, Failures: 0",
+					"success":       float64(200),
+					"message":       "User subscribed to topic test_topic. Success: 1, Failures: 0",
+					"topic":         "test_topic",
+					"success_count": float64(1),
+					"failure_count": float64(0),
+					"results": []interface{}{
+						map[string]interface{}{"device": relay.DeviceID("test_token"), "success": true},
+					},
 				},
 			},
 		},
 		{
 			name:      "missing topic name",
-			setupMock: func() {},
+			setupMock: func(mockClient *mocks.MockFirebaseMessagingClient) {},
 			queryParams: map[string]string{
 				"project_name": "test_project",
 				"site_name":    "test_site",
@@ -530,7 +543,7 @@ func TestSubscribeToTopic(t *testing.T) {
 		},
 		{
 			name:      "user not subscribed",
-			setupMock: func() {},
+			setupMock: func(mockClient *mocks.MockFirebaseMessagingClient) {},
 			queryParams: map[string]string{
 				"project_name": "test_project",
 				"site_name":    "test_site",
@@ -547,7 +560,7 @@ func TestSubscribeToTopic(t *testing.T) {
 		},
 		{
 			name: "firebase client error",
-			setupMock: func() {
+			setupMock: func(mockClient *mocks.MockFirebaseMessagingClient) {
 				mockClient.On("SubscribeToTopic",
 					mock.Anything,
 					[]string{"test_token"},
@@ -572,38 +585,14 @@ func TestSubscribeToTopic(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			key := fmt.Sprintf("%s_%s", tt.queryParams["project_name"], tt.queryParams["site_name"])
+			h, mockClient := newTestHandler(t, func(env *testEnv) {
+				env.Devices[key] = map[string][]string{"test_user": {"test_token"}}
+			})
+			tt.setupMock(mockClient)
 			w := httptest.NewRecorder()
 			c, _ := createTestContext(w)
 
-			// Setup mock
-			mockClient.ExpectedCalls = nil // Clear previous mock expectations
-			tt.setupMock()
-
-			// Setup test environment
-			config = Config{
-				Projects: map[string]ProjectConfig{
-					"test_project": {
-						VapidPublicKey: "test-vapid-key",
-						FirebaseConfig: FirebaseConfig{
-							ApiKey: "test-firebase-key",
-						},
-					},
-				},
-				TrustedProxies: "127.0.0.1",
-			}
-
-			key := fmt.Sprintf("%s_%s", tt.queryParams["project_name"], tt.queryParams["site_name"])
-			userID := tt.queryParams["user_id"]
-			// Only create userDeviceMap entry for users that should have tokens
-			if userID != "nonexistent_user" {
-				userDeviceMap[key] = map[string][]string{
-					userID: {"test_token"},
-				}
-			} else {
-				// For nonexistent_user, create empty map or don't create entry
-				userDeviceMap[key] = map[string][]string{}
-			}
-
 			// Setup request with query parameters
 			req, err := http.NewRequest(http.MethodPost, "/subscribe", http.NoBody)
 			require.NoError(t, err)
@@ -614,7 +603,7 @@ func TestSubscribeToTopic(t *testing.T) {
 			req.URL.RawQuery = q.Encode()
 			c.Request = req
 
-			subscribeToTopic(c)
+			h.subscribeToTopic(c)
 
 			assert.Equal(t, tt.expectedStatus, w.Code)
 
@@ -629,19 +618,15 @@ func TestSubscribeToTopic(t *testing.T) {
 }
 
 func TestUnsubscribeFromTopic(t *testing.T) {
-	_, cleanup := setupTestEnvironment(t)
-	defer cleanup()
-
-	mockClient := &mocks.MockFirebaseMessagingClient{}
-	messagingClient = mockClient
-
 	// Set up test user and device
 	key := "test_project_test_site"
 	userID := "test_user"
 	token := "test_token"
-	userDeviceMap[key] = map[string][]string{
-		userID: {token},
-	}
+	h, mockClient := newTestHandler(t, func(env *testEnv) {
+		env.Devices[key] = map[string][]string{
+			userID: {token},
+		}
+	})
 
 	tests := []struct {
 		name           string
@@ -668,8 +653,14 @@ func TestUnsubscribeFromTopic(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
 				"message": map[string]interface{}{
-					"success": float64(200),
-					"message": "User test_user unsubscribed from test_topic topic",
+					"success":       float64(200),
+					"message":       "User test_user unsubscribed from test_topic topic",
+					"topic":         "test_topic",
+					"success_count": float64(1),
+					"failure_count": float64(0),
+					"results": []interface{}{
+						map[string]interface{}{"device": relay.DeviceID("test_token"), "success": true},
+					},
 				},
 			},
 		},
@@ -693,9 +684,9 @@ func TestUnsubscribeFromTopic(t *testing.T) {
 			},
 		},
 		{
-			name: "user not subscribed",
+			name: "user without devices",
 			setupMock: func() {
-				// No mock setup needed - function should return early
+				// No mock setup needed - FCM is not called without tokens
 			},
 			queryParams: map[string]string{
 				"project_name": "test_project",
@@ -703,11 +694,15 @@ func TestUnsubscribeFromTopic(t *testing.T) {
 				"user_id":      "nonexistent_user",
 				"topic_name":   "test_topic",
 			},
-			expectedStatus: http.StatusNotFound,
+			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
-				"exc": map[string]interface{}{
-					"status_code": float64(404),
-					"message":     "nonexistent_user not subscribed to push notifications",
+				"message": map[string]interface{}{
+					"success":       float64(200),
+					"message":       "User nonexistent_user unsubscribed from test_topic topic",
+					"topic":         "test_topic",
+					"success_count": float64(0),
+					"failure_count": float64(0),
+					"results":       []interface{}{},
 				},
 			},
 		},
@@ -732,7 +727,7 @@ func TestUnsubscribeFromTopic(t *testing.T) {
 			req.URL.RawQuery = q.Encode()
 			c.Request = req
 
-			unsubscribeFromTopic(c)
+			h.unsubscribeFromTopic(c)
 
 			assert.Equal(t, tt.expectedStatus, w.Code)
 
@@ -746,118 +741,15 @@ func TestUnsubscribeFromTopic(t *testing.T) {
 	}
 }
 
-func TestApplyDecorations(t *testing.T) {
-	tests := []struct {
-		name             string
-		key              string
-		title            string
-		setupDecorations func()
-		expected         string
-	}{
-		{
-			name:  "no decorations",
-			key:   "test_project",
-			title: "Test Title",
-			setupDecorations: func() {
-				decorations = make(map[string]map[string]Decoration)
-			},
-			expected: "Test Title",
-		},
-		{
-			name:  "matching decoration",
-			key:   "test_project",
-			title: "Alert: Test Message",
-			setupDecorations: func() {
-				decorations = map[string]map[string]Decoration{
-					"test_project": {
-						"alert": {
-							Pattern:  "^Alert:",
-							Template: "🚨 {title}",
-						},
-					},
-				}
-			},
-			expected: "🚨 Alert: Test Message",
-		},
-		{
-			name:  "non-matching decoration",
-			key:   "test_project",
-			title: "Normal Message",
-			setupDecorations: func() {
-				decorations = map[string]map[string]Decoration{
-					"test_project": {
-						"alert": {
-							Pattern:  "^Alert:",
-							Template: "🚨 {title}",
-						},
-					},
-				}
-			},
-			expected: "Normal Message",
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			tt.setupDecorations()
-			result := applyDecorations(tt.key, tt.title)
-			assert.Equal(t, tt.expected, result)
-		})
-	}
-}
-
-func TestAddIconToConfig(t *testing.T) {
-	tests := []struct {
-		name           string
-		key            string
-		setupIcons     func()
-		validateConfig func(*testing.T, *messaging.WebpushConfig)
-	}{
-		{
-			name: "add icon when available",
-			key:  "test_project",
-			setupIcons: func() {
-				icons = map[string]string{
-					"test_project": "/path/to/icon.png",
-				}
-			},
-			validateConfig: func(t *testing.T, config *messaging.WebpushConfig) {
-				assert.Equal(t, "/path/to/icon.png", config.Data["icon"])
-			},
-		},
-		{
-			name: "no icon available",
-			key:  "test_project",
-			setupIcons: func() {
-				icons = make(map[string]string)
-			},
-			validateConfig: func(t *testing.T, config *messaging.WebpushConfig) {
-				assert.Nil(t, config.Data)
-			},
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			tt.setupIcons()
-			config := &messaging.WebpushConfig{}
-			addIconToConfig(tt.key, config)
-			tt.validateConfig(t, config)
-		})
-	}
-}
-
 func TestAddToken(t *testing.T) {
-	_, cleanup := setupTestEnvironment(t)
-	defer cleanup()
 
 	tests := []struct {
 		name           string
 		queryParams    map[string]string
-		setupUserMap   func()
+		devices        store.Devices
 		expectedStatus int
 		expectedBody   map[string]interface{}
-		checkUserMap   func(*testing.T)
+		checkUserMap   func(*testing.T, *Handler)
 	}{
 		{
 			name: "add new token",
@@ -867,9 +759,6 @@ func TestAddToken(t *testing.T) {
 				"user_id":      "test_user",
 				"fcm_token":    "new_token",
 			},
-			setupUserMap: func() {
-				userDeviceMap = make(map[string]map[string][]string)
-			},
 			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
 				"message": map[string]interface{}{
@@ -877,8 +766,8 @@ func TestAddToken(t *testing.T) {
 					"message": "User Token added",
 				},
 			},
-			checkUserMap: func(t *testing.T) {
-				tokens := userDeviceMap["test_project_test_site"]["test_user"]
+			checkUserMap: func(t *testing.T, h *Handler) {
+				tokens := h.relay.Tokens("test_project", "test_site", "test_user")
 				assert.Equal(t, []string{"new_token"}, tokens)
 			},
 		},
@@ -890,12 +779,10 @@ func TestAddToken(t *testing.T) {
 				"user_id":      "test_user",
 				"fcm_token":    "existing_token",
 			},
-			setupUserMap: func() {
-				userDeviceMap = map[string]map[string][]string{
-					"test_project_test_site": {
-						"test_user": {"existing_token"},
-					},
-				}
+			devices: store.Devices{
+				"test_project_test_site": {
+					"test_user": {"existing_token"},
+				},
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
@@ -904,8 +791,8 @@ func TestAddToken(t *testing.T) {
 					"message": "User Token duplicate found",
 				},
 			},
-			checkUserMap: func(t *testing.T) {
-				tokens := userDeviceMap["test_project_test_site"]["test_user"]
+			checkUserMap: func(t *testing.T, h *Handler) {
+				tokens := h.relay.Tokens("test_project", "test_site", "test_user")
 				assert.Equal(t, []string{"existing_token"}, tokens)
 			},
 		},
@@ -916,9 +803,6 @@ func TestAddToken(t *testing.T) {
 				"site_name":    "test_site",
 				"user_id":      "test_user",
 			},
-			setupUserMap: func() {
-				userDeviceMap = make(map[string]map[string][]string)
-			},
 			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
 				"message": map[string]interface{}{
@@ -931,11 +815,14 @@ func TestAddToken(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestHandler(t, func(env *testEnv) {
+				for key, users := range tt.devices {
+					env.Devices[key] = users
+				}
+			})
 			w := httptest.NewRecorder()
 			c, _ := createTestContext(w)
 
-			tt.setupUserMap()
-
 			// Setup request with query parameters
 			req, err := http.NewRequest(http.MethodPost, "/add-token", http.NoBody)
 			require.NoError(t, err)
@@ -946,7 +833,7 @@ func TestAddToken(t *testing.T) {
 			req.URL.RawQuery = q.Encode()
 			c.Request = req
 
-			addToken(c)
+			h.addToken(c)
 
 			assert.Equal(t, tt.expectedStatus, w.Code)
 
@@ -956,23 +843,21 @@ func TestAddToken(t *testing.T) {
 			assert.Equal(t, tt.expectedBody, response)
 
 			if tt.checkUserMap != nil {
-				tt.checkUserMap(t)
+				tt.checkUserMap(t, h)
 			}
 		})
 	}
 }
 
 func TestRemoveToken(t *testing.T) {
-	_, cleanup := setupTestEnvironment(t)
-	defer cleanup()
 
 	tests := []struct {
 		name           string
 		queryParams    map[string]string
-		setupUserMap   func()
+		devices        store.Devices
 		expectedStatus int
 		expectedBody   map[string]interface{}
-		checkUserMap   func(*testing.T)
+		checkUserMap   func(*testing.T, *Handler)
 	}{
 		{
 			name: "remove existing token",
@@ -982,12 +867,10 @@ func TestRemoveToken(t *testing.T) {
 				"user_id":      "test_user",
 				"fcm_token":    "existing_token",
 			},
-			setupUserMap: func() {
-				userDeviceMap = map[string]map[string][]string{
-					"test_project_test_site": {
-						"test_user": {"existing_token", "other_token"},
-					},
-				}
+			devices: store.Devices{
+				"test_project_test_site": {
+					"test_user": {"existing_token", "other_token"},
+				},
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
@@ -996,8 +879,8 @@ func TestRemoveToken(t *testing.T) {
 					"message": "User Token removed",
 				},
 			},
-			checkUserMap: func(t *testing.T) {
-				tokens := userDeviceMap["test_project_test_site"]["test_user"]
+			checkUserMap: func(t *testing.T, h *Handler) {
+				tokens := h.relay.Tokens("test_project", "test_site", "test_user")
 				assert.Equal(t, []string{"other_token"}, tokens)
 			},
 		},
@@ -1009,12 +892,10 @@ func TestRemoveToken(t *testing.T) {
 				"user_id":      "test_user",
 				"fcm_token":    "nonexistent_token",
 			},
-			setupUserMap: func() {
-				userDeviceMap = map[string]map[string][]string{
-					"test_project_test_site": {
-						"test_user": {"existing_token"},
-					},
-				}
+			devices: store.Devices{
+				"test_project_test_site": {
+					"test_user": {"existing_token"},
+				},
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
@@ -1028,11 +909,14 @@ func TestRemoveToken(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestHandler(t, func(env *testEnv) {
+				for key, users := range tt.devices {
+					env.Devices[key] = users
+				}
+			})
 			w := httptest.NewRecorder()
 			c, _ := createTestContext(w)
 
-			tt.setupUserMap()
-
 			// Setup request with query parameters
 			req, err := http.NewRequest(http.MethodPost, "/remove-token", http.NoBody)
 			require.NoError(t, err)
@@ -1043,7 +927,7 @@ func TestRemoveToken(t *testing.T) {
 			req.URL.RawQuery = q.Encode()
 			c.Request = req
 
-			removeToken(c)
+			h.removeToken(c)
 
 			assert.Equal(t, tt.expectedStatus, w.Code)
 
@@ -1053,85 +937,24 @@ func TestRemoveToken(t *testing.T) {
 			assert.Equal(t, tt.expectedBody, response)
 
 			if tt.checkUserMap != nil {
-				tt.checkUserMap(t)
+				tt.checkUserMap(t, h)
 			}
 		})
 	}
 }
 
-func TestApplyTopicDecorations(t *testing.T) {
-	tests := []struct {
-		name             string
-		topic            string
-		title            string
-		setupDecorations func()
-		expected         string
-	}{
-		{
-			name:  "no decorations",
-			topic: "test_topic",
-			title: "Test Title",
-			setupDecorations: func() {
-				topicDecorations = make(map[string]TopicDecoration)
-			},
-			expected: "Test Title",
-		},
-		{
-			name:  "matching decoration",
-			topic: "test_topic",
-			title: "Alert: Test Message",
-			setupDecorations: func() {
-				topicDecorations = map[string]TopicDecoration{
-					"test_topic": {
-						Pattern:  "^Alert:",
-						Template: "📢 {title}",
-					},
-				}
-			},
-			expected: "📢 Alert: Test Message",
-		},
-		{
-			name:  "non-matching decoration",
-			topic: "test_topic",
-			title: "Normal Message",
-			setupDecorations: func() {
-				topicDecorations = map[string]TopicDecoration{
-					"test_topic": {
-						Pattern:  "^Alert:",
-						Template: "📢 {title}",
-					},
-				}
-			},
-			expected: "Normal Message",
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			tt.setupDecorations()
-			result := applyTopicDecorations(tt.topic, tt.title)
-			assert.Equal(t, tt.expected, result)
-		})
-	}
-}
-
 func TestSendNotificationToTopic(t *testing.T) {
-	_, cleanup := setupTestEnvironment(t)
-	defer cleanup()
-
-	mockClient := &mocks.MockFirebaseMessagingClient{}
-	messagingClient = mockClient
-
 	tests := []struct {
 		name           string
-		setupMock      func()
+		topics         *relay.TopicConfig
+		setupMock      func(mockClient *mocks.MockFirebaseMessagingClient)
 		queryParams    map[string]string
 		expectedStatus int
 		expectedBody   map[string]interface{}
 	}{
 		{
 			name: "successful notification",
-			setupMock: func() {
+			setupMock: func(mockClient *mocks.MockFirebaseMessagingClient) {
 				mockClient.On("Send",
 					mock.Anything,
 					mock.MatchedBy(func(msg *messaging.Message) bool {
@@ -1159,9 +982,142 @@ func TestSendNotificationToTopic(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "condition",
+			setupMock: func(mockClient *mocks.MockFirebaseMessagingClient) {
+				mockClient.On("Send",
+					mock.Anything,
+					mock.MatchedBy(func(msg *messaging.Message) bool {
+						return msg.Topic == "" &&
+							msg.Condition == "'alerts' in topics && !('muted' in topics)"
+					}),
+				).Return("message_id", nil)
+			},
+			queryParams: map[string]string{
+				"project_name": "test_project",
+				"site_name":    "test_site",
+				"condition":    `"alerts" in topics && !"muted" in topics`,
+				"title":        "Test Title",
+				"body":         "Test Body",
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"message": map[string]interface{}{
+					"success": float64(200),
+					"message": `Notification sent to condition "alerts" in topics && !"muted" in topics`,
+				},
+			},
+		},
+		{
+			name:      "invalid condition",
+			setupMock: func(mockClient *mocks.MockFirebaseMessagingClient) {},
+			queryParams: map[string]string{
+				"project_name": "test_project",
+				"site_name":    "test_site",
+				"condition":    "'alerts' in topics || 'a' in topics &&",
+				"title":        "Test Title",
+				"body":         "Test Body",
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: map[string]interface{}{
+				"exc": map[string]interface{}{
+					"status_code": float64(400),
+					"message":     "invalid condition: expected a topic at end of condition",
+				},
+			},
+		},
+		{
+			name:   "namespaced topic",
+			topics: &relay.TopicConfig{Namespace: true},
+			setupMock: func(mockClient *mocks.MockFirebaseMessagingClient) {
+				mockClient.On("Send",
+					mock.Anything,
+					mock.MatchedBy(func(msg *messaging.Message) bool {
+						return msg.Topic == "test_project~test_site~test_topic"
+					}),
+				).Return("message_id", nil)
+			},
+			queryParams: map[string]string{
+				"project_name": "test_project",
+				"site_name":    "test_site",
+				"topic_name":   "test_topic",
+				"title":        "Test Title",
+				"body":         "Test Body",
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"message": map[string]interface{}{
+					"success": float64(200),
+					"message": "Notification sent to test_topic topic",
+				},
+			},
+		},
+		{
+			name:   "namespaced condition with a global topic",
+			topics: &relay.TopicConfig{Namespace: true, GlobalTopics: []string{"everyone"}},
+			setupMock: func(mockClient *mocks.MockFirebaseMessagingClient) {
+				mockClient.On("Send",
+					mock.Anything,
+					mock.MatchedBy(func(msg *messaging.Message) bool {
+						return msg.Condition == "'test_project~test_site~alerts' in topics || 'everyone' in topics"
+					}),
+				).Return("message_id", nil)
+			},
+			queryParams: map[string]string{
+				"project_name": "test_project",
+				"site_name":    "test_site",
+				"condition":    "'alerts' in topics || 'everyone' in topics",
+				"title":        "Test Title",
+				"body":         "Test Body",
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"message": map[string]interface{}{
+					"success": float64(200),
+					"message": "Notification sent to condition 'alerts' in topics || 'everyone' in topics",
+				},
+			},
+		},
+		{
+			name:      "invalid topic name",
+			setupMock: func(mockClient *mocks.MockFirebaseMessagingClient) {},
+			queryParams: map[string]string{
+				"project_name": "test_project",
+				"site_name":    "test_site",
+				"topic_name":   "breaking news",
+				"title":        "Test Title",
+				"body":         "Test Body",
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: map[string]interface{}{
+				"exc": map[string]interface{}{
+					"status_code": float64(400),
+					"message":     `invalid topic name "breaking news": only letters, digits and -_.~% are allowed`,
+				},
+			},
+		},
+		{
+			name:      "topic name and condition",
+			setupMock: func(mockClient *mocks.MockFirebaseMessagingClient) {},
+			queryParams: map[string]string{
+				"project_name": "test_project",
+				"site_name":    "test_site",
+				"topic_name":   "test_topic",
+				"condition":    "'alerts' in topics",
+				"title":        "Test Title",
+				"body":         "Test Body",
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: map[string]interface{}{
+				"exc": map[string]interface{}{
+					"status_code": float64(400),
+					"message":     "topic_name and condition must not both be set",
+				},
+			},
+		},
 		{
 			name:      "missing topic name",
-			setupMock: func() {},
+			setupMock: func(mockClient *mocks.MockFirebaseMessagingClient) {},
 			queryParams: map[string]string{
 				"project_name": "test_project",
 				"site_name":    "test_site",
@@ -1178,7 +1134,7 @@ func TestSendNotificationToTopic(t *testing.T) {
 		},
 		{
 			name:      "missing title",
-			setupMock: func() {},
+			setupMock: func(mockClient *mocks.MockFirebaseMessagingClient) {},
 			queryParams: map[string]string{
 				"project_name": "test_project",
 				"site_name":    "test_site",
@@ -1195,7 +1151,7 @@ func TestSendNotificationToTopic(t *testing.T) {
 		},
 		{
 			name: "firebase client error",
-			setupMock: func() {
+			setupMock: func(mockClient *mocks.MockFirebaseMessagingClient) {
 				mockClient.On("Send",
 					mock.Anything,
 					mock.Anything,
@@ -1220,13 +1176,13 @@ func TestSendNotificationToTopic(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			h, mockClient := newTestHandler(t, func(env *testEnv) {
+				env.Config.Topics = tt.topics
+			})
+			tt.setupMock(mockClient)
 			w := httptest.NewRecorder()
 			c, _ := createTestContext(w)
 
-			// Setup mock
-			mockClient.ExpectedCalls = nil
-			tt.setupMock()
-
 			// Setup request with query parameters
 			req, err := http.NewRequest(http.MethodPost, "/send-topic", http.NoBody)
 			require.NoError(t, err)
@@ -1237,7 +1193,7 @@ func TestSendNotificationToTopic(t *testing.T) {
 			req.URL.RawQuery = q.Encode()
 			c.Request = req
 
-			sendNotificationToTopic(c)
+			h.sendNotificationToTopic(c)
 
 			assert.Equal(t, tt.expectedStatus, w.Code)
 
@@ -1251,90 +1207,19 @@ func TestSendNotificationToTopic(t *testing.T) {
 	}
 }
 
-func TestGetUserTokens(t *testing.T) {
-	_, cleanup := setupTestEnvironment(t)
-	defer cleanup()
-
-	tests := []struct {
-		name        string
-		key         string
-		userID      string
-		setupMap    func()
-		expectError bool
-		expected    []string
-	}{
-		{
-			name:   "existing user with tokens",
-			key:    "test_project_site",
-			userID: "test_user",
-			setupMap: func() {
-				userDeviceMap = map[string]map[string][]string{
-					"test_project_site": {
-						"test_user": {"token1", "token2"},
-					},
-				}
-			},
-			expected: []string{"token1", "token2"},
-		},
-		{
-			name:   "user with no tokens",
-			key:    "test_project_site",
-			userID: "test_user",
-			setupMap: func() {
-				userDeviceMap = map[string]map[string][]string{
-					"test_project_site": {
-						"test_user": {},
-					},
-				}
-			},
-			expectError: true,
-		},
-		{
-			name:   "nonexistent user",
-			key:    "test_project_site",
-			userID: "nonexistent_user",
-			setupMap: func() {
-				userDeviceMap = map[string]map[string][]string{
-					"test_project_site": {},
-				}
-			},
-			expectError: true,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			tt.setupMap()
-
-			tokens, err := getUserTokens(tt.key, tt.userID)
-
-			if tt.expectError {
-				assert.Error(t, err)
-				assert.Contains(t, err.Error(), "not subscribed to push notifications")
-			} else {
-				assert.NoError(t, err)
-				assert.Equal(t, tt.expected, tokens)
-			}
-		})
-	}
-}
-
 func TestAPIBasicAuth(t *testing.T) {
-	_, cleanup := setupTestEnvironment(t)
-	defer cleanup()
+	h, _ := newTestHandler(t, func(env *testEnv) {
+		env.Credentials["valid-key"] = "valid-secret"
+	})
 
 	tests := []struct {
 		name             string
-		setupAuth        func()
 		setupHeader      func(*http.Request)
 		expectedStatus   int
 		expectAuthHeader bool
 	}{
 		{
 			name: "valid credentials",
-			setupAuth: func() {
-				credentials["valid-key"] = "valid-secret"
-			},
 			setupHeader: func(req *http.Request) {
 				req.SetBasicAuth("valid-key", "valid-secret")
 			},
@@ -1342,8 +1227,7 @@ func TestAPIBasicAuth(t *testing.T) {
 			expectAuthHeader: false,
 		},
 		{
-			name:      "missing auth header",
-			setupAuth: func() {},
+			name: "missing auth header",
 			setupHeader: func(req *http.Request) {
 				// No auth header
 			},
@@ -1352,9 +1236,6 @@ func TestAPIBasicAuth(t *testing.T) {
 		},
 		{
 			name: "invalid credentials",
-			setupAuth: func() {
-				credentials["valid-key"] = "valid-secret"
-			},
 			setupHeader: func(req *http.Request) {
 				req.SetBasicAuth("invalid-key", "invalid-secret")
 			},
@@ -1363,9 +1244,6 @@ func TestAPIBasicAuth(t *testing.T) {
 		},
 		{
 			name: "wrong secret for valid key",
-			setupAuth: func() {
-				credentials["valid-key"] = "valid-secret"
-			},
 			setupHeader: func(req *http.Request) {
 				req.SetBasicAuth("valid-key", "wrong-secret")
 			},
@@ -1379,10 +1257,8 @@ func TestAPIBasicAuth(t *testing.T) {
 			w := httptest.NewRecorder()
 			_, router := createTestContext(w)
 
-			tt.setupAuth()
-
 			// Create test endpoint with auth middleware
-			router.GET("/test", apiBasicAuth(), func(c *gin.Context) {
+			router.GET("/test", h.apiBasicAuth(), func(c *gin.Context) {
 				c.Status(http.StatusOK)
 			})
 
@@ -1406,39 +1282,3 @@ func TestAPIBasicAuth(t *testing.T) {
 		})
 	}
 }
-
-func TestGenerateSecureToken(t *testing.T) {
-	tests := []struct {
-		name   string
-		length int
-	}{
-		{
-			name:   "32 characters",
-			length: 32,
-		},
-		{
-			name:   "48 characters",
-			length: 48,
-		},
-		{
-			name:   "64 characters",
-			length: 64,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			token := generateSecureToken(tt.length)
-			assert.Len(t, token, tt.length)
-
-			// Verify token contains only allowed characters
-			for _, char := range token {
-				assert.Contains(t, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", string(char))
-			}
-
-			// Generate another token and verify it's different
-			anotherToken := generateSecureToken(tt.length)
-			assert.NotEqual(t, token, anotherToken, "Tokens should be random")
-		})
-	}
-}
@@ -0,0 +1,54 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Health endpoint paths
+const (
+	HealthzPath = "/healthz"
+	ReadyzPath  = "/readyz"
+
+	healthOK   = "ok"
+	healthFail = "fail"
+)
+
+// readinessChecks are the checks of relay.Server.Checks in the order they are logged
+var readinessChecks = []string{"shutdown", "config", "store", "firebase", "service_account"}
+
+// healthz reports that the process is alive and serving HTTP
+func healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{Status: healthOK})
+}
+
+// readyz reports whether the relay can handle notification requests.
+// Every check is reported so operators can see what is failing. The endpoint
+// is unauthenticated, so failure details such as file paths are only logged.
+func (h *Handler) readyz(c *gin.Context) {
+	results := h.relay.Checks()
+	checks := map[string]string{}
+	ready := true
+	for _, name := range readinessChecks {
+		if err := results[name]; err != nil {
+			appLog().WarnContext(c.Request.Context(), "Readiness check failed", "check", name, "error", err)
+			checks[name] = healthFail
+			ready = false
+			continue
+		}
+		checks[name] = healthOK
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, HealthResponse{Status: "unavailable", Checks: checks})
+		return
+	}
+	c.JSON(http.StatusOK, HealthResponse{Status: healthOK, Checks: checks})
+}
+
+// isHealthPath reports whether the path is a health probe, which is
+// excluded from tracing and logged at debug level to keep noise down
+func isHealthPath(path string) bool {
+	return path == HealthzPath || path == ReadyzPath
+}
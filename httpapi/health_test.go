@@ -0,0 +1,116 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+func TestHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	_, router := createTestContext(w)
+	router.GET(HealthzPath, healthz)
+
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, HealthzPath, http.NoBody))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name          string
+		setup         func(t *testing.T, h *Handler, tmpDir string)
+		expectedCode  int
+		failingChecks []string
+	}{
+		{
+			name:         "ready",
+			setup:        func(*testing.T, *Handler, string) {},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name: "shutting down",
+			setup: func(_ *testing.T, h *Handler, _ string) {
+				h.relay.BeginShutdown()
+			},
+			expectedCode:  http.StatusServiceUnavailable,
+			failingChecks: []string{"shutdown"},
+		},
+		{
+			name: "service account invalid",
+			setup: func(t *testing.T, h *Handler, tmpDir string) {
+				require.NoError(t, os.Remove(filepath.Join(tmpDir, "service-account.json")))
+				require.NoError(t, h.relay.Reload())
+			},
+			expectedCode:  http.StatusServiceUnavailable,
+			failingChecks: []string{"service_account"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tmpDir string
+			h, _ := newTestHandler(t, func(env *testEnv) {
+				tmpDir = env.Dir
+			})
+			tt.setup(t, h, tmpDir)
+
+			w := httptest.NewRecorder()
+			_, router := createTestContext(w)
+			router.GET(ReadyzPath, h.readyz)
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, ReadyzPath, http.NoBody))
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			var response struct {
+				Status string            `json:"status"`
+				Checks map[string]string `json:"checks"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			require.Len(t, response.Checks, len(readinessChecks))
+			for name, result := range response.Checks {
+				if slices.Contains(tt.failingChecks, name) {
+					assert.Equal(t, healthFail, result, name)
+				} else {
+					assert.Equal(t, healthOK, result, name)
+				}
+			}
+		})
+	}
+}
+
+func TestReadyzHidesFailureDetails(t *testing.T) {
+	var tmpDir string
+	h, _ := newTestHandler(t, func(env *testEnv) {
+		env.Config.Logging = &relay.LoggingConfig{Format: relay.LogFormatJSON}
+		tmpDir = env.Dir
+	})
+	buf := captureLogs(t)
+	require.NoError(t, os.Remove(filepath.Join(tmpDir, "service-account.json")))
+	require.NoError(t, h.relay.Reload())
+	buf.Reset()
+
+	w := httptest.NewRecorder()
+	_, router := createTestContext(w)
+	router.GET(ReadyzPath, h.readyz)
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, ReadyzPath, http.NoBody))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.NotContains(t, w.Body.String(), "not found")
+
+	lines := decodeJSONLogLines(t, buf)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "Readiness check failed", lines[0]["msg"])
+	assert.Equal(t, "service_account", lines[0]["check"])
+	assert.Contains(t, lines[0]["error"], "service account file not found")
+}
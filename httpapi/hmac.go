@@ -0,0 +1,246 @@
+package httpapi
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HMAC signature headers
+const (
+	// HeaderHMACKey carries the API key used to sign the request
+	HeaderHMACKey = "X-Relay-Key"
+	// HeaderHMACTimestamp carries the Unix time (seconds) the request was signed at
+	HeaderHMACTimestamp = "X-Relay-Timestamp"
+	// HeaderHMACNonce carries a unique per-request value used for replay protection
+	HeaderHMACNonce = "X-Relay-Nonce"
+	// HeaderHMACContentSHA256 carries the hex encoded SHA-256 of the request body
+	HeaderHMACContentSHA256 = "X-Relay-Content-SHA256"
+	// HeaderHMACSignature carries the hex encoded HMAC-SHA256 signature
+	HeaderHMACSignature = "X-Relay-Signature"
+	// DefaultHMACMaxSkew is the allowed clock difference between client and server
+	DefaultHMACMaxSkew = 300 * time.Second
+
+	minNonceLength = 16
+	maxNonceLength = 128
+	// maxBodySize limits how much of the body is buffered for hashing and binding
+	maxBodySize = 1 << 20
+
+	// hmacBodyTooLarge is the rejection reason answered with 413 instead of 401
+	hmacBodyTooLarge = "request body too large"
+)
+
+// errBodyTooLarge is returned by readBody for bodies over maxBodySize
+var errBodyTooLarge = fmt.Errorf("request body exceeds %d bytes", maxBodySize)
+
+// nonceCache remembers recently used nonces so a signed request cannot be replayed
+// while its timestamp is still within the allowed clock skew.
+type nonceCache struct {
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	expiry nonceHeap // Nonces of seen ordered by expiry, so pruning is O(log n) per nonce
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: make(map[string]time.Time)}
+}
+
+// use records the nonce and reports whether it was unused. Entries are kept until
+// expiresAt, after which the timestamp check rejects the request anyway.
+func (n *nonceCache) use(nonce string, now, expiresAt time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for len(n.expiry) > 0 && now.After(n.expiry[0].expiresAt) {
+		expired := heap.Pop(&n.expiry).(nonceEntry)
+		delete(n.seen, expired.nonce)
+	}
+
+	if _, exists := n.seen[nonce]; exists {
+		return false
+	}
+	n.seen[nonce] = expiresAt
+	heap.Push(&n.expiry, nonceEntry{nonce: nonce, expiresAt: expiresAt})
+	return true
+}
+
+// nonceEntry is a nonce with the time it may be forgotten
+type nonceEntry struct {
+	nonce     string
+	expiresAt time.Time
+}
+
+// nonceHeap is a min-heap of nonces by expiry, for container/heap
+type nonceHeap []nonceEntry
+
+func (h nonceHeap) Len() int           { return len(h) }
+func (h nonceHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+func (h nonceHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *nonceHeap) Push(x interface{}) {
+	*h = append(*h, x.(nonceEntry))
+}
+
+func (h *nonceHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// hmacMaxSkew returns the configured clock skew tolerance or the default
+func (h *Handler) hmacMaxSkew() time.Duration {
+	if skew := h.relay.Config().HMACMaxSkew; skew > 0 {
+		return time.Duration(skew) * time.Second
+	}
+	return DefaultHMACMaxSkew
+}
+
+// hmacCanonicalString builds the string that is signed by the client.
+// Fields are joined with newlines in a fixed order:
+// METHOD, PATH, RAW QUERY, TIMESTAMP, NONCE, BODY SHA-256.
+func hmacCanonicalString(method, path, rawQuery, timestamp, nonce, bodyHash string) string {
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		rawQuery,
+		timestamp,
+		nonce,
+		bodyHash,
+	}, "\n")
+}
+
+// computeHMACSignature returns the hex encoded HMAC-SHA256 of the canonical string
+func computeHMACSignature(secret, canonical string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(canonical))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hashBody returns the hex encoded SHA-256 of the body
+func hashBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// isHMACRequest reports whether the request carries an HMAC signature
+func isHMACRequest(c *gin.Context) bool {
+	return c.GetHeader(HeaderHMACSignature) != ""
+}
+
+// apiHMACAuth returns a middleware handler that verifies HMAC-SHA256 signed requests
+// using API credentials stored in the credentials map. Unlike Basic Auth the secret
+// never leaves the client; requests are bound to their body, timestamp and nonce.
+func (h *Handler) apiHMACAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := h.verifyHMACRequest(c, time.Now()); err != "" {
+			authLog().WarnContext(c.Request.Context(), "Rejected signed request", "client_ip", c.ClientIP(), "reason", err)
+			if err == hmacBodyTooLarge {
+				sendErrorResponse(c, http.StatusRequestEntityTooLarge, "Request body too large")
+				c.Abort()
+				return
+			}
+			c.Header("WWW-Authenticate", "HMAC-SHA256")
+			abortUnauthorized(c)
+			return
+		}
+
+		c.Set(apiKeyContextKey, c.GetHeader(HeaderHMACKey))
+		c.Next()
+	}
+}
+
+// verifyHMACRequest validates the signature headers of the request.
+// Returns an empty string on success or the reason the request was rejected.
+func (h *Handler) verifyHMACRequest(c *gin.Context, now time.Time) string {
+	apiKey := c.GetHeader(HeaderHMACKey)
+	timestamp := c.GetHeader(HeaderHMACTimestamp)
+	nonce := c.GetHeader(HeaderHMACNonce)
+	bodyHash := strings.ToLower(c.GetHeader(HeaderHMACContentSHA256))
+	signature := strings.ToLower(c.GetHeader(HeaderHMACSignature))
+
+	if apiKey == "" || timestamp == "" || nonce == "" || bodyHash == "" || signature == "" {
+		return "missing signature headers"
+	}
+
+	if len(nonce) < minNonceLength || len(nonce) > maxNonceLength {
+		return "invalid nonce length"
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "invalid timestamp"
+	}
+
+	maxSkew := h.hmacMaxSkew()
+	signedAt := time.Unix(unix, 0)
+	if signedAt.Before(now.Add(-maxSkew)) || signedAt.After(now.Add(maxSkew)) {
+		return "timestamp outside allowed clock skew"
+	}
+
+	secret, exists := h.relay.Credential(apiKey)
+	if !exists {
+		return "unknown API key"
+	}
+
+	body, err := readBody(c)
+	if errors.Is(err, errBodyTooLarge) {
+		return hmacBodyTooLarge
+	}
+	if err != nil {
+		return "failed to read request body"
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashBody(body)), []byte(bodyHash)) != 1 {
+		return "body hash mismatch"
+	}
+
+	canonical := hmacCanonicalString(c.Request.Method, requestPath(c.Request),
+		c.Request.URL.RawQuery, timestamp, nonce, bodyHash)
+	expected := computeHMACSignature(secret, canonical)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(signature)) != 1 {
+		return "signature mismatch"
+	}
+
+	// Only remember nonces of correctly signed requests so unauthenticated
+	// clients cannot fill the cache. Keep them until the timestamp expires.
+	if !h.nonces.use(apiKey+":"+nonce, now, signedAt.Add(maxSkew)) {
+		return "nonce already used"
+	}
+
+	return ""
+}
+
+// readBody reads the request body and replaces it so handlers can read it again.
+// Bodies over maxBodySize fail with errBodyTooLarge instead of being truncated.
+func readBody(c *gin.Context) ([]byte, error) {
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return []byte{}, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBodySize {
+		return nil, errBodyTooLarge
+	}
+	if err := c.Request.Body.Close(); err != nil {
+		authLog().WarnContext(c.Request.Context(), "Failed to close request body", "error", err)
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
@@ -0,0 +1,268 @@
+package httpapi
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+// signTestRequest adds HMAC signature headers to the request
+func signTestRequest(req *http.Request, apiKey, secret string, body []byte, signedAt time.Time, nonce string) {
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	bodyHash := hashBody(body)
+	canonical := hmacCanonicalString(req.Method, req.URL.Path, req.URL.RawQuery, timestamp, nonce, bodyHash)
+
+	req.Header.Set(HeaderHMACKey, apiKey)
+	req.Header.Set(HeaderHMACTimestamp, timestamp)
+	req.Header.Set(HeaderHMACNonce, nonce)
+	req.Header.Set(HeaderHMACContentSHA256, bodyHash)
+	req.Header.Set(HeaderHMACSignature, computeHMACSignature(secret, canonical))
+}
+
+func TestAPIHMACAuth(t *testing.T) {
+	h, _ := newTestHandler(t, func(env *testEnv) {
+		env.Credentials["valid-key"] = "valid-secret"
+	})
+	body := []byte(`{"title":"Hello"}`)
+	nonceCounter := 0
+	newNonce := func() string {
+		nonceCounter++
+		return fmt.Sprintf("test-nonce-%016d", nonceCounter)
+	}
+
+	tests := []struct {
+		name           string
+		buildRequest   func() *http.Request
+		expectedStatus int
+	}{
+		{
+			name: "valid signature",
+			buildRequest: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/test?user_id=u1", bytes.NewReader(body))
+				signTestRequest(req, "valid-key", "valid-secret", body, time.Now(), newNonce())
+				return req
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "wrong secret",
+			buildRequest: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(body))
+				signTestRequest(req, "valid-key", "wrong-secret", body, time.Now(), newNonce())
+				return req
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "unknown key",
+			buildRequest: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(body))
+				signTestRequest(req, "unknown-key", "valid-secret", body, time.Now(), newNonce())
+				return req
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "tampered body",
+			buildRequest: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader([]byte(`{"title":"Evil"}`)))
+				signTestRequest(req, "valid-key", "valid-secret", body, time.Now(), newNonce())
+				return req
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "tampered query",
+			buildRequest: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/test?user_id=u1", bytes.NewReader(body))
+				signTestRequest(req, "valid-key", "valid-secret", body, time.Now(), newNonce())
+				req.URL.RawQuery = "user_id=u2"
+				return req
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "timestamp too old",
+			buildRequest: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(body))
+				signTestRequest(req, "valid-key", "valid-secret", body, time.Now().Add(-10*time.Minute), newNonce())
+				return req
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "timestamp within skew",
+			buildRequest: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(body))
+				signTestRequest(req, "valid-key", "valid-secret", body, time.Now().Add(2*time.Minute), newNonce())
+				return req
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "nonce too short",
+			buildRequest: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(body))
+				signTestRequest(req, "valid-key", "valid-secret", body, time.Now(), "short")
+				return req
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "missing headers",
+			buildRequest: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(body))
+				req.Header.Set(HeaderHMACSignature, "deadbeef")
+				return req
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			_, router := createTestContext(w)
+
+			var receivedBody []byte
+			router.POST("/test", h.apiHMACAuth(), func(c *gin.Context) {
+				receivedBody, _ = c.GetRawData()
+				c.Status(http.StatusOK)
+			})
+
+			router.ServeHTTP(w, tt.buildRequest())
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusOK {
+				assert.Equal(t, body, receivedBody, "handler should still see the request body")
+			} else {
+				assert.Equal(t, "HMAC-SHA256", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestAPIHMACAuthReplay(t *testing.T) {
+	h, _ := newTestHandler(t, func(env *testEnv) {
+		env.Credentials["valid-key"] = "valid-secret"
+	})
+	body := []byte(`{}`)
+	signedAt := time.Now()
+
+	w := httptest.NewRecorder()
+	_, router := createTestContext(w)
+	router.POST("/test", h.apiHMACAuth(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	send := func() int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(body))
+		signTestRequest(req, "valid-key", "valid-secret", body, signedAt, "replayed-nonce-0001")
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusOK, send())
+	assert.Equal(t, http.StatusUnauthorized, send(), "replayed request should be rejected")
+}
+
+func TestNonceCacheExpiry(t *testing.T) {
+	cache := newNonceCache()
+	now := time.Now()
+
+	assert.True(t, cache.use("nonce", now, now.Add(time.Minute)))
+	assert.True(t, cache.use("later", now, now.Add(5*time.Minute)))
+	assert.False(t, cache.use("nonce", now.Add(30*time.Second), now.Add(time.Minute)))
+	assert.True(t, cache.use("nonce", now.Add(2*time.Minute), now.Add(3*time.Minute)),
+		"expired nonces should be pruned")
+	assert.False(t, cache.use("later", now.Add(2*time.Minute), now.Add(3*time.Minute)),
+		"unexpired nonces should be kept")
+	assert.Len(t, cache.seen, 2)
+	assert.Len(t, cache.expiry, 2)
+}
+
+func TestAPIHMACAuthBodyTooLarge(t *testing.T) {
+	h, _ := newTestHandler(t, func(env *testEnv) {
+		env.Credentials["valid-key"] = "valid-secret"
+	})
+	body := bytes.Repeat([]byte("a"), maxBodySize+1)
+
+	w := httptest.NewRecorder()
+	_, router := createTestContext(w)
+	router.POST("/test", h.apiHMACAuth(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(body))
+	signTestRequest(req, "valid-key", "valid-secret", body, time.Now(), "oversized-nonce-0001")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestAPIAuth(t *testing.T) {
+	h, _ := newTestHandler(t, func(env *testEnv) {
+		env.Credentials["valid-key"] = "valid-secret"
+	})
+
+	tests := []struct {
+		name           string
+		setupHeader    func(*http.Request)
+		expectedStatus int
+	}{
+		{
+			name: "basic auth",
+			setupHeader: func(req *http.Request) {
+				req.SetBasicAuth("valid-key", "valid-secret")
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "hmac signature",
+			setupHeader: func(req *http.Request) {
+				signTestRequest(req, "valid-key", "valid-secret", nil, time.Now(), "api-auth-nonce-0001")
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "invalid hmac signature does not fall back to basic auth",
+			setupHeader: func(req *http.Request) {
+				req.SetBasicAuth("valid-key", "valid-secret")
+				signTestRequest(req, "valid-key", "wrong-secret", nil, time.Now(), "api-auth-nonce-0002")
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "no credentials",
+			setupHeader:    func(req *http.Request) {},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			_, router := createTestContext(w)
+			router.GET("/test", h.apiAuth(relay.RouteGroupAPI), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			req, err := http.NewRequest(http.MethodGet, "/test", http.NoBody)
+			require.NoError(t, err)
+			tt.setupHeader(req)
+
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MicahParks/keyfunc"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+const (
+	// jwtClaimsKey is the gin context key holding validated token claims
+	jwtClaimsKey = "jwt_claims"
+	// jwtScopeWildcard grants access to every project or site
+	jwtScopeWildcard = "*"
+)
+
+// Only asymmetric algorithms are accepted, HMAC keys would need a shared secret
+var jwtValidMethods = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// isBearerRequest reports whether the request carries a bearer token
+func isBearerRequest(c *gin.Context) bool {
+	return strings.HasPrefix(c.GetHeader("Authorization"), "Bearer ")
+}
+
+// apiBearerAuth returns a middleware handler that validates JWT bearer tokens against
+// the configured JWKS and checks that the token's claims allow access to the
+// requested project and site.
+func (h *Handler) apiBearerAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The keys and the settings they were loaded for come from one state
+		jwtConfig, keys := h.relay.JWT()
+		if keys == nil || jwtConfig == nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_request"`)
+			abortUnauthorized(c)
+			return
+		}
+
+		claims, err := parseBearerToken(keys, jwtConfig, strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+		if err != nil {
+			authLog().WarnContext(c.Request.Context(), "Rejected bearer token", "client_ip", c.ClientIP(), "error", err)
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			abortUnauthorized(c)
+			return
+		}
+
+		projectName, siteName := requestTarget(c)
+		if !claimAllows(claims, jwtConfig.ProjectsClaimName(), projectName) || !claimAllows(claims, jwtConfig.SitesClaimName(), siteName) {
+			authLog().WarnContext(c.Request.Context(), "Bearer token not allowed for project or site",
+				"subject", claims["sub"], "project", projectName, "site", siteName)
+			sendErrorResponse(c, http.StatusForbidden, "Token not allowed for this project or site")
+			c.Abort()
+			return
+		}
+
+		c.Set(jwtClaimsKey, claims)
+		c.Next()
+	}
+}
+
+// parseBearerToken verifies the token signature and standard claims
+func parseBearerToken(keys *keyfunc.JWKS, jwtConfig *relay.JWTConfig, tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(jwtValidMethods))
+	if _, err := parser.ParseWithClaims(tokenString, claims, keys.Keyfunc); err != nil {
+		return nil, err
+	}
+
+	// Short-lived tokens are expected, so an expiry is mandatory
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("token has no expiry or is expired")
+	}
+	if jwtConfig.Issuer != "" && !claims.VerifyIssuer(jwtConfig.Issuer, true) {
+		return nil, fmt.Errorf("unexpected issuer")
+	}
+	if jwtConfig.Audience != "" && !claims.VerifyAudience(jwtConfig.Audience, true) {
+		return nil, fmt.Errorf("unexpected audience")
+	}
+
+	return claims, nil
+}
+
+// claimAllows reports whether the claim grants access to value.
+// The claim may be a single string or a list of strings; "*" matches anything.
+// An empty value is allowed so route-level validation can report missing parameters.
+func claimAllows(claims jwt.MapClaims, claim, value string) bool {
+	if value == "" {
+		return true
+	}
+
+	var allowed []string
+	switch v := claims[claim].(type) {
+	case string:
+		allowed = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				allowed = append(allowed, s)
+			}
+		}
+	}
+
+	for _, a := range allowed {
+		if a == jwtScopeWildcard || a == value {
+			return true
+		}
+	}
+	return false
+}
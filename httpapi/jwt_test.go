@@ -0,0 +1,313 @@
+package httpapi
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+const testJWTKeyID = "test-key"
+
+// setupTestJWKS writes a JWKS file for a fresh RSA key, enables JWT auth in the
+// test files and returns the private key used to sign test tokens
+func setupTestJWKS(t *testing.T, env *testEnv) *rsa.PrivateKey {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": testJWTKeyID,
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(privateKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(privateKey.E)).Bytes()),
+			},
+		},
+	}
+	writeTestJSON(t, filepath.Join(env.Dir, "jwks.json"), jwks)
+
+	env.Config.JWT = &relay.JWTConfig{
+		JWKSFile: "jwks.json",
+		Issuer:   "https://issuer.example.com",
+		Audience: "notification-relay",
+	}
+
+	return privateKey
+}
+
+// signTestJWT signs the claims with the given key
+func signTestJWT(t *testing.T, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testJWTKeyID
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestAPIBearerAuth(t *testing.T) {
+	var privateKey *rsa.PrivateKey
+	h, _ := newTestHandler(t, func(env *testEnv) {
+		privateKey = setupTestJWKS(t, env)
+	})
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":      "frappe-service",
+			"iss":      "https://issuer.example.com",
+			"aud":      "notification-relay",
+			"exp":      time.Now().Add(5 * time.Minute).Unix(),
+			"projects": []string{"test_project"},
+			"sites":    "*",
+		}
+	}
+
+	tests := []struct {
+		name           string
+		token          func() string
+		query          string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "valid token",
+			token:          func() string { return signTestJWT(t, privateKey, validClaims()) },
+			query:          "project_name=test_project&site_name=site1",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "expired token",
+			token: func() string {
+				claims := validClaims()
+				claims["exp"] = time.Now().Add(-time.Minute).Unix()
+				return signTestJWT(t, privateKey, claims)
+			},
+			query:          "project_name=test_project&site_name=site1",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "token without expiry",
+			token: func() string {
+				claims := validClaims()
+				delete(claims, "exp")
+				return signTestJWT(t, privateKey, claims)
+			},
+			query:          "project_name=test_project&site_name=site1",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong issuer",
+			token: func() string {
+				claims := validClaims()
+				claims["iss"] = "https://evil.example.com"
+				return signTestJWT(t, privateKey, claims)
+			},
+			query:          "project_name=test_project&site_name=site1",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong audience",
+			token: func() string {
+				claims := validClaims()
+				claims["aud"] = "other-service"
+				return signTestJWT(t, privateKey, claims)
+			},
+			query:          "project_name=test_project&site_name=site1",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "signed with unknown key",
+			token:          func() string { return signTestJWT(t, otherKey, validClaims()) },
+			query:          "project_name=test_project&site_name=site1",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "malformed token",
+			token:          func() string { return "not-a-jwt" },
+			query:          "project_name=test_project&site_name=site1",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "project not in claims",
+			token:          func() string { return signTestJWT(t, privateKey, validClaims()) },
+			query:          "project_name=other_project&site_name=site1",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "project in JSON body",
+			token:          func() string { return signTestJWT(t, privateKey, validClaims()) },
+			body:           `{"project_name": "test_project", "site_name": "site1"}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "body project not in claims",
+			token:          func() string { return signTestJWT(t, privateKey, validClaims()) },
+			query:          "project_name=test_project&site_name=site1",
+			body:           `{"project_name": "other_project"}`,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "site not in claims",
+			token: func() string {
+				claims := validClaims()
+				claims["sites"] = []string{"site2"}
+				return signTestJWT(t, privateKey, claims)
+			},
+			query:          "project_name=test_project&site_name=site1",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "missing scope claims",
+			token: func() string {
+				claims := validClaims()
+				delete(claims, "projects")
+				return signTestJWT(t, privateKey, claims)
+			},
+			query:          "project_name=test_project&site_name=site1",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			_, router := createTestContext(w)
+
+			var subject interface{}
+			router.POST("/test", h.apiAuth(relay.RouteGroupAPI), func(c *gin.Context) {
+				if claims, ok := c.Get(jwtClaimsKey); ok {
+					subject = claims.(jwt.MapClaims)["sub"]
+				}
+				c.Status(http.StatusOK)
+			})
+
+			req, err := http.NewRequest(http.MethodPost, "/test?"+tt.query, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer "+tt.token())
+			req.Header.Set("Content-Type", "application/json")
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusOK {
+				assert.Equal(t, "frappe-service", subject)
+			}
+		})
+	}
+}
+
+func TestAPIBearerAuthRouteGroups(t *testing.T) {
+	var privateKey *rsa.PrivateKey
+	var dir string
+	h, _ := newTestHandler(t, func(env *testEnv) {
+		privateKey = setupTestJWKS(t, env)
+		env.Config.JWT.RouteGroups = []string{relay.RouteGroupV2}
+		env.Credentials["site-key"] = testAPISecret
+		dir = env.Dir
+	})
+	token := signTestJWT(t, privateKey, jwt.MapClaims{
+		"sub":      "frappe-service",
+		"iss":      "https://issuer.example.com",
+		"aud":      "notification-relay",
+		"exp":      time.Now().Add(5 * time.Minute).Unix(),
+		"projects": "*",
+		"sites":    "*",
+	})
+
+	w := httptest.NewRecorder()
+	_, router := createTestContext(w)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/api", h.apiAuth(relay.RouteGroupAPI), ok)
+	router.GET("/v2", h.apiAuth(relay.RouteGroupV2), ok)
+
+	tests := []struct {
+		name           string
+		path           string
+		bearer         bool
+		expectedStatus int
+	}{
+		{"bearer token on enabled group", "/v2", true, http.StatusOK},
+		{"bearer token on other group", "/api", true, http.StatusUnauthorized},
+		{"basic auth on other group", "/api", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path+"?project_name=test_project&site_name=site1", http.NoBody)
+			if tt.bearer {
+				req.Header.Set("Authorization", "Bearer "+token)
+			} else {
+				req.SetBasicAuth("site-key", testAPISecret)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+
+	t.Run("admin group never accepts bearer tokens", func(t *testing.T) {
+		updateConfig(t, h, dir, func(cfg *relay.Config) {
+			cfg.JWT.RouteGroups = nil
+			cfg.AdminAPIKeys = []string{"site-key"}
+		})
+		router.GET("/admin", h.apiAuth(relay.RouteGroupAdmin), h.apiAdminAuth(), ok)
+
+		req := httptest.NewRequest(http.MethodGet, "/admin", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		req = httptest.NewRequest(http.MethodGet, "/admin", http.NoBody)
+		req.SetBasicAuth("site-key", testAPISecret)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestAPIBearerAuthDisabled(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+
+	w := httptest.NewRecorder()
+	_, router := createTestContext(w)
+	router.POST("/test", h.apiAuth(relay.RouteGroupAPI), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req, err := http.NewRequest(http.MethodPost, "/test", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer some-token")
+
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestClaimAllows(t *testing.T) {
+	var claims jwt.MapClaims
+	require.NoError(t, json.Unmarshal([]byte(`{"projects":["p1","p2"],"sites":"*","single":"only"}`), &claims))
+
+	assert.True(t, claimAllows(claims, "projects", "p1"))
+	assert.False(t, claimAllows(claims, "projects", "p3"))
+	assert.True(t, claimAllows(claims, "sites", "anything"))
+	assert.True(t, claimAllows(claims, "single", "only"))
+	assert.False(t, claimAllows(claims, "missing", "p1"))
+	assert.True(t, claimAllows(claims, "missing", ""))
+}
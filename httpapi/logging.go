@@ -0,0 +1,69 @@
+package httpapi
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+// redactedValue replaces credentials in logged headers
+const redactedValue = "[REDACTED]"
+
+// sensitiveHeaders are removed from logged request and response headers
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", HeaderHMACSignature}
+
+// The subsystem loggers are looked up on use, since relay.SetLogOutput replaces them
+func appLog() *slog.Logger      { return relay.Logger(relay.LogSubsystemApp) }
+func httpLog() *slog.Logger     { return relay.Logger(relay.LogSubsystemHTTP) }
+func corsLog() *slog.Logger     { return relay.Logger(relay.LogSubsystemCORS) }
+func authLog() *slog.Logger     { return relay.Logger(relay.LogSubsystemAuth) }
+func deliveryLog() *slog.Logger { return relay.Logger(relay.LogSubsystemDelivery) }
+
+// redactHeaders returns a copy of the headers with credentials removed
+func redactHeaders(headers http.Header) http.Header {
+	redacted := headers.Clone()
+	for _, name := range sensitiveHeaders {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, redactedValue)
+		}
+	}
+	return redacted
+}
+
+// requestLogger returns a middleware handler that logs each request as a single
+// structured line. The query string is omitted since it may carry tokens.
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		case isHealthPath(c.Request.URL.Path):
+			level = slog.LevelDebug
+		}
+
+		args := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", status,
+			"latency_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		}
+		if spanContext := trace.SpanContextFromContext(c.Request.Context()); spanContext.IsValid() {
+			args = append(args, "trace_id", spanContext.TraceID().String())
+		}
+		httpLog().Log(c.Request.Context(), level, "Request handled", args...)
+	}
+}
@@ -0,0 +1,73 @@
+package httpapi
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace prefixes the HTTP metrics like the metrics of the relay
+const metricsNamespace = "notification_relay"
+
+// httpMetrics are the request metrics of a Handler, registered with the
+// registry of its server
+type httpMetrics struct {
+	registry        *prometheus.Registry
+	requestsTotal   *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+}
+
+// newHTTPMetrics registers the request metrics with registry. Handlers of the
+// same server share them.
+func newHTTPMetrics(registry *prometheus.Registry) *httpMetrics {
+	return &httpMetrics{
+		registry: registry,
+		requestsTotal: register(registry, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "status"})),
+		requestDuration: register(registry, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"})),
+	}
+}
+
+// register registers collector, or returns the one registered before it
+func register[C prometheus.Collector](registry *prometheus.Registry, collector C) C {
+	var registered prometheus.AlreadyRegisteredError
+	if err := registry.Register(collector); errors.As(err, &registered) {
+		return registered.ExistingCollector.(C)
+	} else if err != nil {
+		panic(err)
+	}
+	return collector
+}
+
+// handler serves the Prometheus metrics endpoint
+func (m *httpMetrics) handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// middleware returns a middleware handler that records request counts and latency.
+// Requests that match no route are grouped so unknown paths can't grow the label set.
+func (m *httpMetrics) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		m.requestsTotal.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		m.requestDuration.WithLabelValues(route, c.Request.Method).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,44 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	h, _ := newTestHandler(t, func(env *testEnv) {
+		env.Devices["test_project_test_site"] = map[string][]string{
+			"user1": {"token1", "token2"},
+			"user2": {"token3"},
+		}
+		env.Devices["test_project_other_site"] = map[string][]string{
+			"user3": {"token4"},
+		}
+	})
+
+	w := httptest.NewRecorder()
+	_, router := createTestContext(w)
+	router.Use(h.metrics.middleware())
+	router.GET("/metrics", h.metrics.handler())
+	router.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ping", http.NoBody))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing/abc", http.NoBody))
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `notification_relay_users{key="test_project_test_site"} 2`)
+	assert.Contains(t, body, `notification_relay_devices{key="test_project_test_site"} 3`)
+	assert.Contains(t, body, `notification_relay_users{key="test_project_other_site"} 1`)
+	assert.Contains(t, body, `notification_relay_devices{key="test_project_other_site"} 1`)
+	assert.Contains(t, body, `notification_relay_http_requests_total{method="GET",route="/ping",status="200"}`)
+	assert.Contains(t, body, `route="unmatched"`)
+	assert.NotContains(t, body, "/missing/abc")
+	assert.Contains(t, body, "go_goroutines")
+}
@@ -0,0 +1,424 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+// OpenAPIPath is the path of the OpenAPI document describing the HTTP API
+const OpenAPIPath = "/openapi.json"
+
+// apiOperation documents a route of the HTTP API. Schemas are derived from the
+// Go types of the parameters, body and responses, so the document follows the
+// handlers. The contract tests check every route of newRouter is listed.
+type apiOperation struct {
+	Method    string
+	Path      string // gin path, :name segments are path parameters
+	ID        string
+	Summary   string
+	Tag       string
+	Auth      bool                // Requires API credentials
+	Admin     bool                // Requires an admin API key
+	Query     interface{}         // Struct whose form tags are query parameters
+	Body      interface{}         // Request body, accepted as JSON or form data
+	Responses map[int]interface{} // Body by status, nil for responses without a body
+	Text      bool                // Successful responses are plain text
+}
+
+// v1Params documents that the Frappe-compatible endpoints also read their
+// parameters from the query string
+const v1Params = " Parameters may also be sent as query parameters."
+
+// apiOperations lists every route of the HTTP API
+func apiOperations() []apiOperation {
+	const v1 = "/api/method/notification_relay.api."
+	return []apiOperation{
+		{Method: http.MethodGet, Path: HealthzPath, ID: "healthz", Tag: "Monitoring",
+			Summary:   "Report that the process is alive",
+			Responses: map[int]interface{}{200: HealthResponse{}}},
+		{Method: http.MethodGet, Path: ReadyzPath, ID: "readyz", Tag: "Monitoring",
+			Summary:   "Report whether the relay can handle notification requests",
+			Responses: map[int]interface{}{200: HealthResponse{}, 503: HealthResponse{}}},
+		{Method: http.MethodGet, Path: "/metrics", ID: "metrics", Tag: "Monitoring",
+			Summary:   "Prometheus metrics",
+			Responses: map[int]interface{}{200: nil}, Text: true},
+		{Method: http.MethodGet, Path: OpenAPIPath, ID: "openapi", Tag: "Monitoring",
+			Summary:   "This OpenAPI document",
+			Responses: map[int]interface{}{200: map[string]interface{}{}}},
+
+		{Method: http.MethodGet, Path: v1 + "get_config", ID: "getConfig", Tag: "Authentication",
+			Summary: "Get the Firebase configuration and VAPID key of a project",
+			Query: struct {
+				ProjectName string `form:"project_name"`
+			}{},
+			Responses: map[int]interface{}{200: ConfigResponse{}, 400: ExcResponse{}, 404: ExcResponse{}}},
+		{Method: http.MethodPost, Path: v1 + "auth.get_credential", ID: "getCredential", Tag: "Authentication",
+			Summary:   "Get API credentials after verifying a token with the site's webhook." + v1Params,
+			Body:      CredentialRequest{},
+			Responses: map[int]interface{}{200: CredentialEnvelope{}, 500: CredentialEnvelope{}}},
+
+		{Method: http.MethodPost, Path: v1 + "topic.subscribe", ID: "subscribeToTopic", Tag: "Topics",
+			Summary: "Subscribe a user's devices to a topic." + v1Params, Auth: true, Body: TopicRequest{},
+			Responses: map[int]interface{}{200: TopicSubscriptionResponse{}, 400: ExcResponse{}, 404: ExcResponse{}}},
+		{Method: http.MethodPost, Path: v1 + "topic.unsubscribe", ID: "unsubscribeFromTopic", Tag: "Topics",
+			Summary: "Unsubscribe a user's devices from a topic." + v1Params, Auth: true, Body: TopicRequest{},
+			Responses: map[int]interface{}{200: TopicSubscriptionResponse{}, 400: ExcResponse{}, 404: ExcResponse{}}},
+		{Method: http.MethodGet, Path: v1 + "topic.list", ID: "listUserTopics", Tag: "Topics",
+			Summary: "List the topics a user is subscribed to, as recorded by the relay", Auth: true,
+			Query: struct {
+				ProjectName string `form:"project_name"`
+				SiteName    string `form:"site_name"`
+				UserID      string `form:"user_id"`
+			}{},
+			Responses: map[int]interface{}{200: UserTopicsResponse{}, 400: ExcResponse{}, 404: ExcResponse{}}},
+		{Method: http.MethodGet, Path: v1 + "topic.users", ID: "listTopicUsers", Tag: "Topics",
+			Summary: "List the users of a site subscribed to a topic, as recorded by the relay", Auth: true,
+			Query: struct {
+				ProjectName string `form:"project_name"`
+				SiteName    string `form:"site_name"`
+				TopicName   string `form:"topic_name"`
+			}{},
+			Responses: map[int]interface{}{200: TopicUsersResponse{}, 400: ExcResponse{}, 404: ExcResponse{}}},
+		{Method: http.MethodPost, Path: v1 + "token.add", ID: "addToken", Tag: "Tokens",
+			Summary: "Register a device token for a user." + v1Params, Auth: true, Body: TokenRequest{},
+			Responses: map[int]interface{}{200: MessageResponse{}, 400: ExcResponse{}, 500: ExcResponse{}}},
+		{Method: http.MethodPost, Path: v1 + "token.remove", ID: "removeToken", Tag: "Tokens",
+			Summary: "Remove a device token of a user." + v1Params, Auth: true, Body: TokenRequest{},
+			Responses: map[int]interface{}{200: MessageResponse{}, 400: ExcResponse{}, 500: ExcResponse{}}},
+		{Method: http.MethodPost, Path: v1 + "send_notification.user", ID: "sendNotificationToUser", Tag: "Notifications",
+			Summary: "Send a notification to every device of a user." + v1Params, Auth: true, Body: relay.NotificationRequest{},
+			Responses: map[int]interface{}{200: MessageResponse{}, 400: ExcResponse{}}},
+		{Method: http.MethodPost, Path: v1 + "send_notification.users", ID: "sendNotificationToUsers", Tag: "Notifications",
+			Summary: "Send a notification to several users, with optional per-user overrides." + v1Params, Auth: true, Body: BulkNotificationRequest{},
+			Responses: map[int]interface{}{200: BulkMessageResponse{}, 400: ExcResponse{}, 404: ExcResponse{}}},
+		{Method: http.MethodPost, Path: v1 + "send_notification.topic", ID: "sendNotificationToTopic", Tag: "Notifications",
+			Summary: "Send a notification to a topic, or to the topics matching an FCM condition." + v1Params, Auth: true, Body: relay.NotificationRequest{},
+			Responses: map[int]interface{}{200: MessageResponse{}, 400: ExcResponse{}, 404: ExcResponse{}, 500: ExcResponse{}}},
+
+		{Method: http.MethodGet, Path: v1 + "admin.lockouts", ID: "listLockouts", Tag: "Administration",
+			Summary: "List locked out client IPs and API keys", Auth: true, Admin: true,
+			Responses: map[int]interface{}{200: LockoutsResponse{}}},
+		{Method: http.MethodPost, Path: v1 + "admin.unlock", ID: "clearLockout", Tag: "Administration",
+			Summary: "Clear the lockout of a client IP or API key", Auth: true, Admin: true,
+			Query: struct {
+				Key string `form:"key"`
+			}{},
+			Responses: map[int]interface{}{200: MessageResponse{}, 400: ExcResponse{}, 404: ExcResponse{}}},
+		{Method: http.MethodPost, Path: v1 + "admin.broadcast", ID: "broadcastNotification", Tag: "Administration",
+			Summary: "Start sending a notification to every device of every user of a site. Without confirm the error names the users and devices it would reach." + v1Params,
+			Auth:    true, Admin: true, Body: relay.BroadcastRequest{},
+			Responses: map[int]interface{}{202: BroadcastResponse{}, 400: ExcResponse{}, 404: ExcResponse{}, 409: ExcResponse{}, 503: ExcResponse{}}},
+		{Method: http.MethodGet, Path: v1 + "admin.broadcasts", ID: "listBroadcasts", Tag: "Administration",
+			Summary: "List running and recently finished broadcasts with their progress", Auth: true, Admin: true,
+			Responses: map[int]interface{}{200: BroadcastsResponse{}}},
+
+		{Method: http.MethodGet, Path: v2SitePath + "/users/:user/devices", ID: "v2ListDevices", Tag: "v2",
+			Summary: "List the device tokens of a user", Auth: true,
+			Responses: map[int]interface{}{200: DeviceList{}, 404: ErrorResponse{}}},
+		{Method: http.MethodPost, Path: v2SitePath + "/users/:user/devices", ID: "v2AddDevice", Tag: "v2",
+			Summary: "Register a device token for a user", Auth: true, Body: DeviceRequest{},
+			Responses: map[int]interface{}{200: Device{}, 201: Device{}, 400: ErrorResponse{}, 404: ErrorResponse{}, 500: ErrorResponse{}}},
+		{Method: http.MethodDelete, Path: v2SitePath + "/users/:user/devices/:token", ID: "v2RemoveDevice", Tag: "v2",
+			Summary: "Remove a device token of a user", Auth: true,
+			Responses: map[int]interface{}{204: nil, 404: ErrorResponse{}, 500: ErrorResponse{}}},
+		{Method: http.MethodGet, Path: v2SitePath + "/users/:user/topics", ID: "v2ListUserTopics", Tag: "v2",
+			Summary: "List the topics a user is subscribed to", Auth: true,
+			Responses: map[int]interface{}{200: UserTopics{}, 404: ErrorResponse{}}},
+		{Method: http.MethodGet, Path: v2SitePath + "/users/:user/topics/:topic", ID: "v2GetTopicMembership", Tag: "v2",
+			Summary: "Check whether a user is subscribed to a topic, without asking FCM", Auth: true,
+			Responses: map[int]interface{}{200: TopicMembership{}, 404: ErrorResponse{}}},
+		{Method: http.MethodPut, Path: v2SitePath + "/users/:user/topics/:topic", ID: "v2SubscribeTopic", Tag: "v2",
+			Summary: "Subscribe the devices of a user to a topic", Auth: true,
+			Responses: map[int]interface{}{200: relay.TopicSubscription{}, 400: ErrorResponse{}, 404: ErrorResponse{}, 502: ErrorResponse{}}},
+		{Method: http.MethodDelete, Path: v2SitePath + "/users/:user/topics/:topic", ID: "v2UnsubscribeTopic", Tag: "v2",
+			Summary: "Unsubscribe the devices of a user from a topic", Auth: true,
+			Responses: map[int]interface{}{200: relay.TopicSubscription{}, 400: ErrorResponse{}, 404: ErrorResponse{}, 502: ErrorResponse{}}},
+		{Method: http.MethodPost, Path: v2SitePath + "/users/:user/notifications", ID: "v2NotifyUser", Tag: "v2",
+			Summary: "Send a notification to every device of a user", Auth: true, Body: MessageRequest{},
+			Responses: map[int]interface{}{200: relay.DeliveryResult{}, 400: ErrorResponse{}, 404: ErrorResponse{}, 502: ErrorResponse{}}},
+		{Method: http.MethodPost, Path: v2SitePath + "/notifications", ID: "v2NotifyUsers", Tag: "v2",
+			Summary: "Send a notification to several users, with optional per-user overrides", Auth: true, Body: relay.BulkMessageRequest{},
+			Responses: map[int]interface{}{200: relay.BulkDeliveryResult{}, 400: ErrorResponse{}, 404: ErrorResponse{}}},
+		{Method: http.MethodGet, Path: v2SitePath + "/topics/:topic/users", ID: "v2ListTopicUsers", Tag: "v2",
+			Summary: "List the users subscribed to a topic", Auth: true,
+			Responses: map[int]interface{}{200: TopicUsers{}, 404: ErrorResponse{}}},
+		{Method: http.MethodPost, Path: v2SitePath + "/topics/:topic/notifications", ID: "v2NotifyTopic", Tag: "v2",
+			Summary: "Send a notification to a topic", Auth: true, Body: MessageRequest{},
+			Responses: map[int]interface{}{200: TopicDelivery{}, 400: ErrorResponse{}, 404: ErrorResponse{}, 502: ErrorResponse{}}},
+		{Method: http.MethodPost, Path: v2SitePath + "/conditions/notifications", ID: "v2NotifyCondition", Tag: "v2",
+			Summary: "Send a notification to the devices matching a topic condition", Auth: true, Body: ConditionMessageRequest{},
+			Responses: map[int]interface{}{200: ConditionDelivery{}, 400: ErrorResponse{}, 404: ErrorResponse{}, 502: ErrorResponse{}}},
+	}
+}
+
+// customSchemas describe types whose JSON form differs from their Go kind
+var customSchemas = map[reflect.Type]func() map[string]interface{}{
+	// Data is a JSON object or a string holding one
+	reflect.TypeOf(relay.NotificationData("")): func() map[string]interface{} {
+		return map[string]interface{}{
+			"oneOf": []interface{}{
+				map[string]interface{}{"type": "object"},
+				map[string]interface{}{"type": "string", "description": "A JSON encoded object"},
+			},
+		}
+	},
+}
+
+// schemaRegistry builds JSON schemas from Go types. Named structs become
+// components that are referenced by name.
+type schemaRegistry struct {
+	components map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema returns the schema of t. Fields of response types without omitempty
+// are required; request fields never are, since they may come from the query.
+func (r *schemaRegistry) schema(t reflect.Type, response bool) map[string]interface{} {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if custom, exists := customSchemas[t]; exists {
+		return custom()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": r.schema(t.Elem(), response)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": r.schema(t.Elem(), response)}
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		if t.Name() == "" {
+			return r.object(t, response)
+		}
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, exists := r.components[name]; !exists {
+			r.components[name] = nil // Reserve the name while the fields are described
+			r.components[name] = r.object(t, response)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// object describes a struct by the JSON names of its fields. Undocumented
+// fields are not allowed, so the contract tests catch fields missing here.
+func (r *schemaRegistry) object(t reflect.Type, response bool) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			// Embedded structs are flattened like encoding/json does
+			if field.Anonymous && field.Type.Kind() == reflect.Struct && field.Tag.Get("json") == "" {
+				addFields(field.Type)
+				continue
+			}
+			name, omitEmpty, ok := jsonFieldName(field)
+			if !ok {
+				continue
+			}
+			properties[name] = r.schema(field.Type, response)
+			if response && !omitEmpty {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// jsonFieldName returns the JSON name of an exported struct field
+func jsonFieldName(field reflect.StructField) (name string, omitEmpty, ok bool) {
+	if !field.IsExported() {
+		return "", false, false
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, false
+	}
+	name, options, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name, strings.Contains(options, "omitempty"), true
+}
+
+// ginPathParam matches the :name segments of a gin path
+var ginPathParam = regexp.MustCompile(`:([A-Za-z_]+)`)
+
+// buildOpenAPI returns the OpenAPI 3 document of the operations
+func buildOpenAPI(operations []apiOperation) map[string]interface{} {
+	registry := &schemaRegistry{components: map[string]interface{}{}}
+	jsonContent := func(schema map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+	}
+
+	paths := map[string]interface{}{}
+	for _, op := range operations {
+		operation := map[string]interface{}{
+			"operationId": op.ID,
+			"summary":     op.Summary,
+			"tags":        []string{op.Tag},
+		}
+
+		var parameters []interface{}
+		for _, match := range ginPathParam.FindAllStringSubmatch(op.Path, -1) {
+			parameters = append(parameters, map[string]interface{}{
+				"name": match[1], "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		if op.Query != nil {
+			t := reflect.TypeOf(op.Query)
+			for i := 0; i < t.NumField(); i++ {
+				parameters = append(parameters, map[string]interface{}{
+					"name": t.Field(i).Tag.Get("form"), "in": "query", "schema": registry.schema(t.Field(i).Type, false),
+				})
+			}
+		}
+		if parameters != nil {
+			operation["parameters"] = parameters
+		}
+
+		if op.Body != nil {
+			schema := registry.schema(reflect.TypeOf(op.Body), false)
+			operation["requestBody"] = map[string]interface{}{
+				"content": map[string]interface{}{
+					"application/json":                  map[string]interface{}{"schema": schema},
+					"application/x-www-form-urlencoded": map[string]interface{}{"schema": schema},
+				},
+			}
+		}
+
+		responses := map[string]interface{}{}
+		addResponse := func(status int, body interface{}) {
+			response := map[string]interface{}{"description": http.StatusText(status)}
+			switch {
+			case op.Text && status < 300:
+				response["content"] = map[string]interface{}{
+					"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+				}
+			case body != nil:
+				response["content"] = jsonContent(registry.schema(reflect.TypeOf(body), true))
+			}
+			responses[strconv.Itoa(status)] = response
+		}
+		for status, body := range op.Responses {
+			addResponse(status, body)
+		}
+		if op.Auth {
+			// Authentication failures of the Frappe-compatible API have no body
+			var unauthorized, failure interface{} = nil, ExcResponse{}
+			if strings.HasPrefix(op.Path, v2Prefix) {
+				unauthorized, failure = ErrorResponse{}, ErrorResponse{}
+			}
+			addResponse(http.StatusUnauthorized, unauthorized)
+			addResponse(http.StatusForbidden, failure)
+			addResponse(http.StatusTooManyRequests, failure)
+			security := []interface{}{
+				map[string]interface{}{"basicAuth": []string{}},
+				map[string]interface{}{"hmacSignature": []string{}},
+			}
+			// Admin endpoints only accept admin API keys
+			if !op.Admin {
+				security = append(security, map[string]interface{}{"bearerAuth": []string{}})
+			}
+			operation["security"] = security
+		}
+		operation["responses"] = responses
+
+		path := ginPathParam.ReplaceAllString(op.Path, "{$1}")
+		item, _ := paths[path].(map[string]interface{})
+		if item == nil {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+		item[strings.ToLower(op.Method)] = operation
+	}
+
+	tags := map[string]bool{}
+	for _, op := range operations {
+		tags[op.Tag] = true
+	}
+	tagNames := make([]string, 0, len(tags))
+	for tag := range tags {
+		tagNames = append(tagNames, tag)
+	}
+	sort.Strings(tagNames)
+	tagList := make([]interface{}, 0, len(tagNames))
+	for _, tag := range tagNames {
+		tagList = append(tagList, map[string]interface{}{"name": tag})
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Notification Relay API",
+			"version":     "2.0.0",
+			"description": "Web push notifications through Firebase Cloud Messaging. The /api/method endpoints keep the Frappe request and response format, the /v2 endpoints are resource-oriented.",
+		},
+		"tags":  tagList,
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": registry.components,
+			"securitySchemes": map[string]interface{}{
+				"basicAuth": map[string]interface{}{"type": "http", "scheme": "basic"},
+				"bearerAuth": map[string]interface{}{
+					"type": "http", "scheme": "bearer", "bearerFormat": "JWT",
+				},
+				"hmacSignature": map[string]interface{}{
+					"type": "apiKey", "in": "header", "name": HeaderHMACSignature,
+					"description": "HMAC-SHA256 request signature, sent with the " + HeaderHMACKey + ", " +
+						HeaderHMACTimestamp + " and " + HeaderHMACNonce + " headers",
+				},
+			},
+		},
+	}
+}
+
+// openAPIDocument is the encoded OpenAPI document, built on first use
+var openAPIDocument = sync.OnceValue(func() []byte {
+	document, err := json.Marshal(buildOpenAPI(apiOperations()))
+	if err != nil {
+		panic("Failed to marshal OpenAPI document: " + err.Error())
+	}
+	return document
+})
+
+// serveOpenAPI serves the OpenAPI document
+func serveOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", openAPIDocument())
+}
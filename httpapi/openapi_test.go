@@ -0,0 +1,380 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// loadOpenAPIDocument decodes the served OpenAPI document
+func loadOpenAPIDocument(t *testing.T) map[string]interface{} {
+	var document map[string]interface{}
+	require.NoError(t, json.Unmarshal(openAPIDocument(), &document))
+	return document
+}
+
+// validateSchema checks value against the subset of JSON schema the document uses
+func validateSchema(document, schema map[string]interface{}, value interface{}, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		component, ok := document["components"].(map[string]interface{})["schemas"].(map[string]interface{})[name].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", path, ref)
+		}
+		return validateSchema(document, component, value, path)
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		for _, option := range oneOf {
+			if validateSchema(document, option.(map[string]interface{}), value, path) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: %v matches no oneOf schema", path, value)
+	}
+
+	switch schema["type"] {
+	case nil:
+		return nil
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: expected string, got %T", path, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean, got %T", path, value)
+		}
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("%s: expected %s, got %T", path, schema["type"], value)
+		}
+		if schema["type"] == "integer" && n != math.Trunc(n) {
+			return fmt.Errorf("%s: expected integer, got %v", path, n)
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected array, got %T", path, value)
+		}
+		for i, item := range items {
+			if err := validateSchema(document, schema["items"].(map[string]interface{}), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected object, got %T", path, value)
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, exists := object[name.(string)]; !exists {
+				return fmt.Errorf("%s: missing required property %s", path, name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, property := range object {
+			propertySchema, documented := properties[name].(map[string]interface{})
+			if !documented {
+				additional, ok := schema["additionalProperties"].(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("%s: undocumented property %s", path, name)
+				}
+				propertySchema = additional
+			}
+			if err := validateSchema(document, propertySchema, property, path+"."+name); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s: unsupported schema type %v", path, schema["type"])
+	}
+	return nil
+}
+
+func TestValidateSchema(t *testing.T) {
+	document := map[string]interface{}{"components": map[string]interface{}{"schemas": map[string]interface{}{
+		"Device": map[string]interface{}{
+			"type":                 "object",
+			"properties":           map[string]interface{}{"token": map[string]interface{}{"type": "string"}},
+			"required":             []interface{}{"token"},
+			"additionalProperties": false,
+		},
+	}}}
+	schema := map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/components/schemas/Device"}}
+
+	tests := []struct {
+		name        string
+		value       string
+		expectedErr string
+	}{
+		{name: "valid", value: `[{"token": "a"}]`},
+		{name: "wrong type", value: `[{"token": 1}]`, expectedErr: "$[0].token: expected string"},
+		{name: "missing property", value: `[{}]`, expectedErr: "missing required property token"},
+		{name: "undocumented property", value: `[{"token": "a", "extra": 1}]`, expectedErr: "undocumented property extra"},
+		{name: "not an array", value: `{}`, expectedErr: "expected array"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value interface{}
+			require.NoError(t, json.Unmarshal([]byte(tt.value), &value))
+			err := validateSchema(document, schema, value, "$")
+			if tt.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	w := httptest.NewRecorder()
+	_, router := createTestContext(w)
+	router.GET(OpenAPIPath, serveOpenAPI)
+
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, OpenAPIPath, http.NoBody))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	document := loadOpenAPIDocument(t)
+	assert.Equal(t, "3.0.3", document["openapi"])
+
+	// Path parameters use OpenAPI syntax and are declared
+	paths := document["paths"].(map[string]interface{})
+	operation := paths["/v2/projects/{project}/sites/{site}/users/{user}/devices/{token}"].(map[string]interface{})["delete"].(map[string]interface{})
+	var names []string
+	for _, parameter := range operation["parameters"].([]interface{}) {
+		names = append(names, parameter.(map[string]interface{})["name"].(string))
+	}
+	assert.Equal(t, []string{"project", "site", "user", "token"}, names)
+
+	// Admin endpoints don't offer bearer authentication
+	securitySchemes := func(path, method string) []string {
+		var schemes []string
+		for _, requirement := range paths[path].(map[string]interface{})[method].(map[string]interface{})["security"].([]interface{}) {
+			for scheme := range requirement.(map[string]interface{}) {
+				schemes = append(schemes, scheme)
+			}
+		}
+		return schemes
+	}
+	assert.Equal(t, []string{"basicAuth", "hmacSignature", "bearerAuth"}, securitySchemes("/api/method/notification_relay.api.topic.subscribe", "post"))
+	assert.Equal(t, []string{"basicAuth", "hmacSignature"}, securitySchemes("/api/method/notification_relay.api.admin.lockouts", "get"))
+
+	// Schemas are derived from the Go types
+	schemas := document["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"sent":    map[string]interface{}{"type": "integer"},
+			"failed":  map[string]interface{}{"type": "integer"},
+			"removed": map[string]interface{}{"type": "integer"},
+		},
+		"required":             []interface{}{"sent", "failed", "removed"},
+		"additionalProperties": false,
+	}, schemas["DeliveryResult"])
+	assert.Contains(t, schemas, "FailureEntry")
+	assert.Contains(t, schemas["NotificationRequest"].(map[string]interface{})["properties"], "data")
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+
+	var routes, documented []string
+	for _, route := range h.router.Routes() {
+		routes = append(routes, route.Method+" "+route.Path)
+	}
+	for _, op := range apiOperations() {
+		documented = append(documented, op.Method+" "+op.Path)
+	}
+	sort.Strings(routes)
+	sort.Strings(documented)
+	assert.Equal(t, routes, documented)
+}
+
+// TestOpenAPIContract sends requests to every route and checks that the status
+// is documented and the response body matches its schema
+func TestOpenAPIContract(t *testing.T) {
+	h, mockClient := newTestHandler(t, func(env *testEnv) {
+		env.Credentials["site-key"] = testAPISecret
+		env.Credentials["admin-key"] = testAPISecret
+		env.Config.AdminAPIKeys = []string{"admin-key"}
+		env.Devices["test_project_test_site"] = map[string][]string{"bob": {"stale-token"}}
+		env.Devices["test_project_crowded"] = map[string][]string{"carol": {"carol-token"}, "dave": {"dave-token"}}
+	})
+	mockClient.On("SubscribeToTopic", mock.Anything, mock.Anything, mock.Anything).
+		Return(&messaging.TopicManagementResponse{SuccessCount: 1}, nil)
+	mockClient.On("UnsubscribeFromTopic", mock.Anything, mock.Anything, mock.Anything).
+		Return(&messaging.TopicManagementResponse{SuccessCount: 1}, nil)
+	mockClient.On("Send", mock.Anything, mock.MatchedBy(func(m *messaging.Message) bool {
+		return m.Token == "stale-token"
+	})).Return("", errors.New("registration-token-not-registered"))
+	mockClient.On("Send", mock.Anything, mock.Anything).Return("projects/p/messages/1", nil)
+	mockClient.On("SendEach", mock.Anything, mock.Anything).Return(func(_ context.Context, messages []*messaging.Message) *messaging.BatchResponse {
+		response := &messaging.BatchResponse{}
+		for range messages {
+			response.Responses = append(response.Responses, &messaging.SendResponse{Success: true})
+		}
+		return response
+	}, nil)
+
+	// The credential webhook returns the token the client sends
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("site-token"))
+	}))
+	defer webhook.Close()
+	webhookURL, err := url.Parse(webhook.URL)
+	require.NoError(t, err)
+
+	const (
+		v1   = "/api/method/notification_relay.api."
+		site = "/v2/projects/test_project/sites/test_site"
+		user = `"project_name": "test_project", "site_name": "test_site", "user_id": "alice"`
+	)
+	tests := []struct {
+		operation string
+		method    string
+		path      string
+		body      string
+		apiKey    string // Empty for unauthenticated requests
+		status    int
+	}{
+		{"healthz", http.MethodGet, HealthzPath, "", "", http.StatusOK},
+		{"readyz", http.MethodGet, ReadyzPath, "", "", http.StatusOK},
+		{"metrics", http.MethodGet, "/metrics", "", "", http.StatusOK},
+		{"openapi", http.MethodGet, OpenAPIPath, "", "", http.StatusOK},
+		{"getConfig", http.MethodGet, v1 + "get_config?project_name=test_project", "", "", http.StatusOK},
+		{"getConfig", http.MethodGet, v1 + "get_config", "", "", http.StatusBadRequest},
+		{"getConfig", http.MethodGet, v1 + "get_config?project_name=unknown", "", "", http.StatusNotFound},
+		{"getCredential", http.MethodPost, v1 + "auth.get_credential",
+			`{"endpoint": "localhost", "port": "` + webhookURL.Port() + `", "token": "site-token", "webhook_route": "/verify"}`, "", http.StatusOK},
+		{"getCredential", http.MethodPost, v1 + "auth.get_credential",
+			`{"endpoint": "localhost", "port": "` + webhookURL.Port() + `", "token": "other", "webhook_route": "/verify"}`, "", http.StatusOK},
+		{"addToken", http.MethodPost, v1 + "token.add", `{` + user + `, "fcm_token": "` + testFCMToken + `"}`, "site-key", http.StatusOK},
+		{"addToken", http.MethodPost, v1 + "token.add", `{` + user + `}`, "site-key", http.StatusOK},
+		{"addToken", http.MethodPost, v1 + "token.add", `{"project_name": "unknown"}`, "site-key", http.StatusBadRequest},
+		{"addToken", http.MethodPost, v1 + "token.add", `{}`, "", http.StatusUnauthorized},
+		{"subscribeToTopic", http.MethodPost, v1 + "topic.subscribe", `{` + user + `, "topic_name": "news"}`, "site-key", http.StatusOK},
+		{"subscribeToTopic", http.MethodPost, v1 + "topic.subscribe", `{"project_name": "test_project"}`, "site-key", http.StatusBadRequest},
+		{"subscribeToTopic", http.MethodPost, v1 + "topic.subscribe", `{"project_name": "unknown"}`, "site-key", http.StatusNotFound},
+		{"listUserTopics", http.MethodGet, v1 + "topic.list?project_name=test_project&site_name=test_site&user_id=alice", "", "site-key", http.StatusOK},
+		{"listUserTopics", http.MethodGet, v1 + "topic.list?project_name=test_project", "", "site-key", http.StatusBadRequest},
+		{"listUserTopics", http.MethodGet, v1 + "topic.list?project_name=unknown", "", "site-key", http.StatusNotFound},
+		{"listTopicUsers", http.MethodGet, v1 + "topic.users?project_name=test_project&site_name=test_site&topic_name=news", "", "site-key", http.StatusOK},
+		{"listTopicUsers", http.MethodGet, v1 + "topic.users?project_name=test_project", "", "site-key", http.StatusBadRequest},
+		{"listTopicUsers", http.MethodGet, v1 + "topic.users?project_name=unknown", "", "site-key", http.StatusNotFound},
+		{"unsubscribeFromTopic", http.MethodPost, v1 + "topic.unsubscribe", `{` + user + `, "topic_name": "news"}`, "site-key", http.StatusOK},
+		{"sendNotificationToUser", http.MethodPost, v1 + "send_notification.user", `{` + user + `, "title": "Hi", "body": "There"}`, "site-key", http.StatusOK},
+		{"sendNotificationToUser", http.MethodPost, v1 + "send_notification.user", `{"project_name": "test_project", "user_id": "nobody"}`, "site-key", http.StatusBadRequest},
+		{"sendNotificationToUsers", http.MethodPost, v1 + "send_notification.users", `{"project_name": "test_project", "site_name": "test_site", "user_ids": ["alice", "nobody"], "title": "Hi", "body": "There"}`, "site-key", http.StatusOK},
+		{"sendNotificationToUsers", http.MethodPost, v1 + "send_notification.users", `{"project_name": "test_project", "title": "Hi", "body": "There"}`, "site-key", http.StatusBadRequest},
+		{"sendNotificationToTopic", http.MethodPost, v1 + "send_notification.topic", `{"project_name": "test_project", "topic_name": "news", "title": "Hi", "body": "There"}`, "site-key", http.StatusOK},
+		{"sendNotificationToTopic", http.MethodPost, v1 + "send_notification.topic", `{"project_name": "test_project", "condition": "'news' in topics && !('muted' in topics)", "title": "Hi", "body": "There"}`, "site-key", http.StatusOK},
+		{"sendNotificationToTopic", http.MethodPost, v1 + "send_notification.topic", `{"project_name": "test_project", "condition": "news", "title": "Hi", "body": "There"}`, "site-key", http.StatusBadRequest},
+		{"sendNotificationToTopic", http.MethodPost, v1 + "send_notification.topic", `{"project_name": "unknown"}`, "site-key", http.StatusNotFound},
+		{"removeToken", http.MethodPost, v1 + "token.remove", `{` + user + `, "fcm_token": "` + testFCMToken + `"}`, "site-key", http.StatusOK},
+		{"listLockouts", http.MethodGet, v1 + "admin.lockouts", "", "admin-key", http.StatusOK},
+		{"listLockouts", http.MethodGet, v1 + "admin.lockouts", "", "site-key", http.StatusForbidden},
+		{"clearLockout", http.MethodPost, v1 + "admin.unlock?key=other", "", "admin-key", http.StatusBadRequest},
+		{"clearLockout", http.MethodPost, v1 + "admin.unlock?key=ip:203.0.113.7", "", "admin-key", http.StatusNotFound},
+		{"broadcastNotification", http.MethodPost, v1 + "admin.broadcast", `{"project_name": "test_project", "site_name": "test_site", "title": "Hi", "body": "There"}`, "admin-key", http.StatusBadRequest},
+		{"broadcastNotification", http.MethodPost, v1 + "admin.broadcast", `{"project_name": "test_project", "site_name": "crowded", "title": "Hi", "body": "There", "confirm": true, "max_users": 1}`, "admin-key", http.StatusConflict},
+		{"broadcastNotification", http.MethodPost, v1 + "admin.broadcast", `{"project_name": "test_project", "site_name": "empty", "title": "Hi", "body": "There", "confirm": true}`, "admin-key", http.StatusNotFound},
+		{"broadcastNotification", http.MethodPost, v1 + "admin.broadcast", `{"project_name": "test_project", "site_name": "test_site", "title": "Hi", "body": "There", "confirm": true}`, "admin-key", http.StatusAccepted},
+		{"listBroadcasts", http.MethodGet, v1 + "admin.broadcasts", "", "admin-key", http.StatusOK},
+
+		{"v2ListDevices", http.MethodGet, site + "/users/alice/devices", "", "site-key", http.StatusOK},
+		{"v2ListDevices", http.MethodGet, "/v2/projects/unknown/sites/test_site/users/alice/devices", "", "site-key", http.StatusNotFound},
+		{"v2ListDevices", http.MethodGet, site + "/users/alice/devices", "", "", http.StatusUnauthorized},
+		{"v2AddDevice", http.MethodPost, site + "/users/alice/devices", `{"token": "` + testFCMToken + `"}`, "site-key", http.StatusCreated},
+		{"v2AddDevice", http.MethodPost, site + "/users/alice/devices", `{"token": "` + testFCMToken + `"}`, "site-key", http.StatusOK},
+		{"v2AddDevice", http.MethodPost, site + "/users/alice/devices", `{"token": 1}`, "site-key", http.StatusBadRequest},
+		{"v2SubscribeTopic", http.MethodPut, site + "/users/alice/topics/news", "", "site-key", http.StatusOK},
+		{"v2ListUserTopics", http.MethodGet, site + "/users/alice/topics", "", "site-key", http.StatusOK},
+		{"v2ListUserTopics", http.MethodGet, "/v2/projects/unknown/sites/test_site/users/alice/topics", "", "site-key", http.StatusNotFound},
+		{"v2GetTopicMembership", http.MethodGet, site + "/users/alice/topics/news", "", "site-key", http.StatusOK},
+		{"v2GetTopicMembership", http.MethodGet, site + "/users/alice/topics/sports", "", "site-key", http.StatusNotFound},
+		{"v2ListTopicUsers", http.MethodGet, site + "/topics/news/users", "", "site-key", http.StatusOK},
+		{"v2ListTopicUsers", http.MethodGet, "/v2/projects/unknown/sites/test_site/topics/news/users", "", "site-key", http.StatusNotFound},
+		{"v2UnsubscribeTopic", http.MethodDelete, site + "/users/alice/topics/news", "", "site-key", http.StatusOK},
+		{"v2UnsubscribeTopic", http.MethodDelete, "/v2/projects/unknown/sites/test_site/users/alice/topics/news", "", "site-key", http.StatusNotFound},
+		{"v2NotifyUser", http.MethodPost, site + "/users/alice/notifications", `{"title": "Hi", "body": "There"}`, "site-key", http.StatusOK},
+		{"v2NotifyUser", http.MethodPost, site + "/users/bob/notifications", `{"title": "Hi", "body": "There"}`, "site-key", http.StatusBadGateway},
+		{"v2NotifyUsers", http.MethodPost, site + "/notifications", `{"user_ids": ["alice", "bob"], "title": "Hi", "body": "There", "overrides": {"bob": {"title": "Hey"}}}`, "site-key", http.StatusOK},
+		{"v2NotifyTopic", http.MethodPost, site + "/topics/news/notifications", `{"title": "Hi", "body": "There"}`, "site-key", http.StatusOK},
+		{"v2NotifyTopic", http.MethodPost, site + "/topics/news/notifications", `{}`, "site-key", http.StatusBadRequest},
+		{"v2NotifyCondition", http.MethodPost, site + "/conditions/notifications", `{"condition": "'news' in topics", "title": "Hi", "body": "There"}`, "site-key", http.StatusOK},
+		{"v2NotifyCondition", http.MethodPost, site + "/conditions/notifications", `{"condition": "news", "title": "Hi", "body": "There"}`, "site-key", http.StatusBadRequest},
+		{"v2NotifyCondition", http.MethodPost, "/v2/projects/unknown/sites/test_site/conditions/notifications", `{}`, "site-key", http.StatusNotFound},
+		{"v2RemoveDevice", http.MethodDelete, site + "/users/alice/devices/" + testFCMToken, "", "site-key", http.StatusNoContent},
+		{"v2RemoveDevice", http.MethodDelete, site + "/users/alice/devices/" + testFCMToken, "", "site-key", http.StatusNotFound},
+	}
+
+	document := loadOpenAPIDocument(t)
+	operations := map[string]map[string]interface{}{}
+	for path, item := range document["paths"].(map[string]interface{}) {
+		for method, operation := range item.(map[string]interface{}) {
+			operation := operation.(map[string]interface{})
+			operation["path"] = path + " " + method
+			operations[operation["operationId"].(string)] = operation
+		}
+	}
+
+	covered := map[string]bool{}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d", tt.operation, tt.status), func(t *testing.T) {
+			operation, documented := operations[tt.operation]
+			require.True(t, documented, "operation %s is not documented", tt.operation)
+			covered[tt.operation] = true
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			if tt.apiKey != "" {
+				req.SetBasicAuth(tt.apiKey, testAPISecret)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			require.Equal(t, tt.status, w.Code, w.Body.String())
+
+			response, documented := operation["responses"].(map[string]interface{})[fmt.Sprint(w.Code)].(map[string]interface{})
+			require.True(t, documented, "status %d of %s is not documented", w.Code, operation["path"])
+			content, hasBody := response["content"].(map[string]interface{})
+			if !hasBody {
+				assert.Empty(t, w.Body.String())
+				return
+			}
+			media, isJSON := content["application/json"].(map[string]interface{})
+			if !isJSON {
+				assert.Contains(t, content, strings.Split(w.Header().Get("Content-Type"), ";")[0])
+				return
+			}
+
+			var body interface{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.NoError(t, validateSchema(document, media["schema"].(map[string]interface{}), body, "$"))
+		})
+	}
+	// Wait for the broadcast to finish
+	require.NoError(t, h.relay.Shutdown(context.Background()))
+
+	for _, op := range apiOperations() {
+		assert.True(t, covered[op.ID], "operation %s has no contract test", op.ID)
+	}
+}
@@ -0,0 +1,91 @@
+package httpapi
+
+import (
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+func TestReloadDuringBearerRequests(t *testing.T) {
+	var privateKey *rsa.PrivateKey
+	var tmpDir string
+	h, _ := newTestHandler(t, func(env *testEnv) {
+		privateKey = setupTestJWKS(t, env)
+		tmpDir = env.Dir
+	})
+	token := signTestJWT(t, privateKey, jwt.MapClaims{
+		"sub":      "frappe-service",
+		"iss":      "https://issuer.example.com",
+		"aud":      "notification-relay",
+		"exp":      time.Now().Add(5 * time.Minute).Unix(),
+		"projects": "*",
+		"sites":    "*",
+	})
+
+	// Alternate between equivalent JWT settings, so every reload fetches the keys again
+	configs := make([]relay.Config, 2)
+	for i := range configs {
+		configs[i] = *h.relay.Config()
+		configs[i].JWT = &relay.JWTConfig{
+			JWKSFile:      "jwks.json",
+			Issuer:        "https://issuer.example.com",
+			Audience:      "notification-relay",
+			ProjectsClaim: []string{"", relay.DefaultJWTProjectsClaim}[i],
+		}
+	}
+
+	router := gin.New()
+	router.POST("/test", h.apiAuth(relay.RouteGroupAPI), func(c *gin.Context) {
+		if err := h.relay.ValidateProject("test_project"); err != nil {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
+	done := make(chan struct{})
+	reloaded := make(chan error, 1)
+	go func() {
+		defer close(reloaded)
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			writeTestJSON(t, filepath.Join(tmpDir, relay.ConfigJSON), configs[i%2])
+			if err := h.relay.Reload(); err != nil {
+				reloaded <- err
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodPost, "/test?project_name=test_project&site_name=site1", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				router.ServeHTTP(w, req)
+				assert.Equal(t, http.StatusOK, w.Code)
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	assert.NoError(t, <-reloaded)
+}
@@ -0,0 +1,41 @@
+package httpapi
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+const (
+	// HeaderRequestID carries the request ID in requests and responses
+	HeaderRequestID = "X-Request-ID"
+	// requestIDContextKey is the gin context key holding the request ID
+	requestIDContextKey = "request_id"
+)
+
+// validRequestID limits incoming IDs to a safe length and character set,
+// since they end up in logs, headers and FCM data
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestIDMiddleware returns a middleware handler that accepts the caller's
+// X-Request-ID or generates one, and makes it available to handlers and logs
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(HeaderRequestID)
+		if !validRequestID.MatchString(id) {
+			id = relay.NewRequestID()
+		}
+
+		c.Set(requestIDContextKey, id)
+		c.Request = c.Request.WithContext(relay.ContextWithRequestID(c.Request.Context(), id))
+		c.Header(HeaderRequestID, id)
+		c.Next()
+	}
+}
+
+// requestID returns the ID of the current request, if any
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDContextKey)
+}
@@ -0,0 +1,140 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		incoming   string
+		expectKeep bool
+	}{
+		{name: "generated when missing"},
+		{name: "caller ID kept", incoming: "frappe-job-42:abc.1", expectKeep: true},
+		{name: "unsafe ID replaced", incoming: "bad id\nwith newline"},
+		{name: "overlong ID replaced", incoming: strings.Repeat("a", 129)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			_, router := createTestContext(w)
+			router.Use(requestIDMiddleware())
+
+			var fromContext, fromGin string
+			router.GET("/test", func(c *gin.Context) {
+				fromContext = relay.RequestIDFromContext(c.Request.Context())
+				fromGin = requestID(c)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/test", http.NoBody)
+			if tt.incoming != "" {
+				req.Header.Set(HeaderRequestID, tt.incoming)
+			}
+			router.ServeHTTP(w, req)
+
+			id := w.Header().Get(HeaderRequestID)
+			require.NotEmpty(t, id)
+			assert.Equal(t, id, fromContext)
+			assert.Equal(t, id, fromGin)
+			if tt.expectKeep {
+				assert.Equal(t, tt.incoming, id)
+			} else {
+				assert.NotEqual(t, tt.incoming, id)
+				assert.Len(t, id, 32)
+			}
+		})
+	}
+}
+
+func TestRequestIDEndToEnd(t *testing.T) {
+	h, mockClient := newTestHandler(t, func(env *testEnv) {
+		env.Config.Logging = &relay.LoggingConfig{
+			Format: relay.LogFormatJSON,
+			Levels: map[string]string{relay.LogSubsystemDelivery: "debug"},
+		}
+		env.Devices["test_project_test_site"] = map[string][]string{"test_user": {"token1"}}
+	})
+	logs := captureLogs(t)
+
+	var sent *messaging.Message
+	mockClient.On("Send", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		sent = args.Get(1).(*messaging.Message)
+	}).Return("projects/p/messages/1", nil)
+
+	w := httptest.NewRecorder()
+	_, router := createTestContext(w)
+	router.Use(requestIDMiddleware(), requestLogger())
+	router.POST("/user", h.sendNotificationToUser)
+	router.POST("/topic", h.sendNotificationToTopic)
+
+	const id = "req-123"
+	query := url.Values{
+		"project_name": {"test_project"},
+		"site_name":    {"test_site"},
+		"user_id":      {"test_user"},
+		"title":        {"Title"},
+		"body":         {"Body"},
+	}
+
+	t.Run("attached to FCM data and logs", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/user?"+query.Encode(), http.NoBody)
+		req.Header.Set(HeaderRequestID, id)
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NotNil(t, sent)
+		assert.Equal(t, id, sent.Webpush.Data["request_id"])
+
+		lines := decodeJSONLogLines(t, logs)
+		require.NotEmpty(t, lines)
+		for _, line := range lines {
+			assert.Equal(t, id, line["request_id"], line["msg"])
+		}
+	})
+
+	t.Run("caller data is not overwritten", func(t *testing.T) {
+		topicQuery := url.Values{
+			"project_name": {"test_project"},
+			"site_name":    {"test_site"},
+			"topic_name":   {"news"},
+			"title":        {"Title"},
+			"body":         {"Body"},
+			"data":         {`{"request_id":"frappe-id"}`},
+		}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/topic?"+topicQuery.Encode(), http.NoBody)
+		req.Header.Set(HeaderRequestID, id)
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "frappe-id", sent.Data["request_id"])
+	})
+
+	t.Run("included in error envelope", func(t *testing.T) {
+		query.Set("user_id", "unknown_user")
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/user?"+query.Encode(), http.NoBody)
+		req.Header.Set(HeaderRequestID, id)
+		router.ServeHTTP(w, req)
+
+		var response map[string]map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, id, response["exc"]["request_id"])
+		assert.Equal(t, id, w.Header().Get(HeaderRequestID))
+	})
+}
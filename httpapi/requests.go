@@ -0,0 +1,103 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+// bindRequest fills req from the query string and then from a JSON or form body.
+// Body fields take precedence, query parameters keep working for older clients.
+// The body stays readable for later binds.
+func bindRequest(c *gin.Context, req interface{}) error {
+	if err := c.ShouldBindQuery(req); err != nil {
+		return err
+	}
+
+	switch c.ContentType() {
+	case binding.MIMEJSON:
+		body, err := readBody(c)
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(body)) == 0 {
+			return nil
+		}
+		return json.Unmarshal(body, req)
+	case binding.MIMEPOSTForm:
+		return c.ShouldBindWith(req, binding.FormPost)
+	case binding.MIMEMultipartPOSTForm:
+		return c.ShouldBindWith(req, binding.FormMultipart)
+	}
+	return nil
+}
+
+// requestTarget returns the project and site a request acts on. v2 requests
+// name them in the path. Requests that fail to bind fall back to the query
+// string and are rejected by the handler.
+func requestTarget(c *gin.Context) (projectName, siteName string) {
+	if project := c.Param("project"); project != "" {
+		return project, c.Param("site")
+	}
+
+	var target struct {
+		ProjectName string `json:"project_name" form:"project_name"`
+		SiteName    string `json:"site_name" form:"site_name"`
+	}
+	if err := bindRequest(c, &target); err != nil {
+		return c.Query("project_name"), c.Query("site_name")
+	}
+	return target.ProjectName, target.SiteName
+}
+
+// validate checks the parameters subscribe and unsubscribe require
+func (r *TopicRequest) validate() error {
+	if r.UserID == "" {
+		return errors.New("user_id is required")
+	}
+	if r.TopicName == "" {
+		return errors.New("topic_name is required")
+	}
+	return relay.ValidateTopicName(r.TopicName)
+}
+
+// validate checks the parameters token add and remove require
+func (r *TokenRequest) validate() error {
+	if r.UserID == "" {
+		return errors.New("user_id is required")
+	}
+	return nil
+}
+
+// validateUser checks the parameters sending to a user requires
+func validateUser(r *relay.NotificationRequest) error {
+	if r.UserID == "" {
+		return errors.New("user_id is required")
+	}
+	return nil
+}
+
+// bindErrorStatus returns the status a failed bind is rejected with
+func bindErrorStatus(err error) int {
+	if errors.Is(err, errBodyTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// bindOrReject binds the request and responds with 400 (413 for oversized
+// bodies) when that fails
+func bindOrReject(c *gin.Context, req interface{}) bool {
+	if err := bindRequest(c, req); err != nil {
+		sendErrorResponse(c, bindErrorStatus(err), fmt.Sprintf("Invalid request: %v", err))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,257 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+func TestBindRequest(t *testing.T) {
+	multipartBody := &bytes.Buffer{}
+	writer := multipart.NewWriter(multipartBody)
+	require.NoError(t, writer.WriteField("title", "Multipart"))
+	require.NoError(t, writer.WriteField("data", `{"a": "b"}`))
+	require.NoError(t, writer.Close())
+
+	tests := []struct {
+		name               string
+		query              string
+		contentType        string
+		body               string
+		expected           relay.NotificationRequest
+		expectedErrMessage string
+	}{
+		{
+			name:     "query parameters",
+			query:    "project_name=p&site_name=s&user_id=u&title=Hi&data=%7B%22a%22%3A1%7D",
+			expected: relay.NotificationRequest{ProjectName: "p", SiteName: "s", UserID: "u", Title: "Hi", Data: `{"a":1}`},
+		},
+		{
+			name:        "JSON body overrides query",
+			query:       "project_name=p&site_name=s&title=Old",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"title": "New", "body": "Text", "data": {"click_action": "/app"}}`,
+			expected:    relay.NotificationRequest{ProjectName: "p", SiteName: "s", Title: "New", Body: "Text", Data: `{"click_action": "/app"}`},
+		},
+		{
+			name:        "JSON data as string",
+			contentType: "application/json",
+			body:        `{"topic_name": "news", "data": "{\"a\": 1}"}`,
+			expected:    relay.NotificationRequest{TopicName: "news", Data: `{"a": 1}`},
+		},
+		{
+			name:        "empty JSON body",
+			query:       "project_name=p",
+			contentType: "application/json",
+			expected:    relay.NotificationRequest{ProjectName: "p"},
+		},
+		{
+			name:        "form body",
+			query:       "project_name=p",
+			contentType: "application/x-www-form-urlencoded",
+			body:        url.Values{"title": {"Form"}, "data": {`{"a": "b"}`}}.Encode(),
+			expected:    relay.NotificationRequest{ProjectName: "p", Title: "Form", Data: `{"a": "b"}`},
+		},
+		{
+			name:        "multipart form body",
+			contentType: writer.FormDataContentType(),
+			body:        multipartBody.String(),
+			expected:    relay.NotificationRequest{Title: "Multipart", Data: `{"a": "b"}`},
+		},
+		{
+			name:               "wrong field type",
+			contentType:        "application/json",
+			body:               `{"title": 5}`,
+			expectedErrMessage: "cannot unmarshal number",
+		},
+		{
+			name:               "data not an object",
+			contentType:        "application/json",
+			body:               `{"data": [1, 2]}`,
+			expectedErrMessage: "data must be a JSON object",
+		},
+		{
+			name:               "malformed JSON",
+			contentType:        "application/json",
+			body:               `{"title": `,
+			expectedErrMessage: "unexpected end of JSON input",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := createTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/send?"+tt.query, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				c.Request.Header.Set("Content-Type", tt.contentType)
+			}
+
+			var req relay.NotificationRequest
+			err := bindRequest(c, &req)
+			if tt.expectedErrMessage != "" {
+				assert.ErrorContains(t, err, tt.expectedErrMessage)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, req)
+
+			// The body can be bound again, e.g. after the auth middleware read it
+			var again relay.NotificationRequest
+			require.NoError(t, bindRequest(c, &again))
+			assert.Equal(t, tt.expected, again)
+		})
+	}
+}
+
+func TestHandlersAcceptBodies(t *testing.T) {
+	h, mockClient := newTestHandler(t, nil)
+
+	mockClient.On("Send", mock.Anything, mock.MatchedBy(func(m *messaging.Message) bool {
+		return m.Token == testFCMToken && m.Notification.Title == "Hello" &&
+			m.Webpush.FCMOptions.Link == "https://example.com/app"
+	})).Return("projects/p/messages/1", nil).Once()
+	mockClient.On("Send", mock.Anything, mock.MatchedBy(func(m *messaging.Message) bool {
+		return m.Topic == "news" && m.Data["kind"] == "digest"
+	})).Return("projects/p/messages/2", nil).Once()
+
+	tests := []struct {
+		name            string
+		handler         func(c *gin.Context)
+		contentType     string
+		body            string
+		expectedStatus  int
+		expectedMessage string
+	}{
+		{
+			name:            "add token from JSON",
+			handler:         h.addToken,
+			contentType:     "application/json",
+			body:            `{"project_name": "test_project", "site_name": "test_site", "user_id": "alice", "fcm_token": "` + testFCMToken + `"}`,
+			expectedStatus:  http.StatusOK,
+			expectedMessage: "User Token added",
+		},
+		{
+			name:            "send to user from JSON",
+			handler:         h.sendNotificationToUser,
+			contentType:     "application/json",
+			body:            `{"project_name": "test_project", "site_name": "test_site", "user_id": "alice", "title": "Hello", "body": "World", "data": {"click_action": "http://example.com/app"}}`,
+			expectedStatus:  http.StatusOK,
+			expectedMessage: "1 Notification(s) sent to alice user",
+		},
+		{
+			name:            "send to topic from form",
+			handler:         h.sendNotificationToTopic,
+			contentType:     "application/x-www-form-urlencoded",
+			body:            url.Values{"project_name": {"test_project"}, "topic_name": {"news"}, "title": {"Hello"}, "body": {"World"}, "data": {`{"kind": "digest"}`}}.Encode(),
+			expectedStatus:  http.StatusOK,
+			expectedMessage: "Notification sent to news topic",
+		},
+		{
+			name:            "remove token from JSON",
+			handler:         h.removeToken,
+			contentType:     "application/json",
+			body:            `{"project_name": "test_project", "site_name": "test_site", "user_id": "alice", "fcm_token": "` + testFCMToken + `"}`,
+			expectedStatus:  http.StatusOK,
+			expectedMessage: "User Token removed",
+		},
+		{
+			name:            "invalid JSON",
+			handler:         h.subscribeToTopic,
+			contentType:     "application/json",
+			body:            `{"project_name": ["test_project"]}`,
+			expectedStatus:  http.StatusBadRequest,
+			expectedMessage: "Invalid request: json: cannot unmarshal array",
+		},
+		{
+			name:            "missing user",
+			handler:         h.subscribeToTopic,
+			contentType:     "application/json",
+			body:            `{"project_name": "test_project", "site_name": "test_site", "topic_name": "news"}`,
+			expectedStatus:  http.StatusBadRequest,
+			expectedMessage: "user_id is required",
+		},
+		{
+			name:            "missing user for token",
+			handler:         h.addToken,
+			contentType:     "application/json",
+			body:            `{"project_name": "test_project", "site_name": "test_site", "fcm_token": "` + testFCMToken + `"}`,
+			expectedStatus:  http.StatusBadRequest,
+			expectedMessage: "user_id is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := createTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(tt.body))
+			c.Request.Header.Set("Content-Type", tt.contentType)
+
+			tt.handler(c)
+
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			var response struct {
+				Message struct {
+					Message string `json:"message"`
+				} `json:"message"`
+				Exc struct {
+					Message string `json:"message"`
+				} `json:"exc"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Contains(t, response.Message.Message+response.Exc.Message, tt.expectedMessage)
+		})
+	}
+	mockClient.AssertExpectations(t)
+}
+
+func TestRequestTarget(t *testing.T) {
+	w := httptest.NewRecorder()
+	_, router := createTestContext(w)
+	var project, site string
+	handler := func(c *gin.Context) {
+		project, site = requestTarget(c)
+	}
+	router.POST("/api", handler)
+	router.POST("/v2/projects/:project/sites/:site/topics/:topic/notifications", handler)
+
+	// The body names the target of Frappe-compatible requests
+	req := httptest.NewRequest(http.MethodPost, "/api?site_name=s", strings.NewReader(`{"project_name": "p"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "p", project)
+	assert.Equal(t, "s", site)
+
+	// v2 requests name it in the path, which body parameters can't override
+	req = httptest.NewRequest(http.MethodPost, "/v2/projects/p2/sites/s2/topics/news/notifications",
+		strings.NewReader(`{"project_name": "other", "site_name": "other"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "p2", project)
+	assert.Equal(t, "s2", site)
+}
+
+func TestTopicRequestValidate(t *testing.T) {
+	req := TopicRequest{UserID: "alice", TopicName: "news-2024.v1~site_%20"}
+	require.NoError(t, req.validate())
+
+	req.TopicName = "/topics/news"
+	assert.EqualError(t, req.validate(), `invalid topic name "/topics/news": only letters, digits and -_.~% are allowed`)
+	req.TopicName = ""
+	assert.EqualError(t, req.validate(), "topic_name is required")
+	req.UserID = ""
+	assert.EqualError(t, req.validate(), "user_id is required")
+}
@@ -0,0 +1,183 @@
+package httpapi
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+func (h *Handler) newRouter() *gin.Engine {
+	router := gin.New()
+	router.Use(gin.Recovery(), requestIDMiddleware(), h.tracingMiddleware(), requestLogger(), h.metrics.middleware())
+
+	// Add CORS middleware with logging and origin validation
+	router.Use(h.corsMiddleware())
+
+	// Configure trusted proxies
+	trustedProxies := os.Getenv("TRUSTED_PROXIES")
+	if trustedProxies == "" {
+		trustedProxies = h.relay.Config().TrustedProxies // From config file
+		if trustedProxies == "" {
+			trustedProxies = relay.DefaultTrustedProxies
+		}
+	}
+
+	if err := setTrustedProxies(router, trustedProxies); err != nil {
+		appLog().Warn("Failed to set trusted proxies", "error", err)
+	}
+
+	// Add this before setting up routes
+	router.Use(func(c *gin.Context) {
+		// Normalize double slashes in URL path
+		path := strings.ReplaceAll(c.Request.URL.Path, "//", "/")
+		if path != c.Request.URL.Path {
+			c.Request.URL.Path = path
+		}
+		c.Next()
+	})
+
+	// Health checks and Prometheus metrics
+	router.GET(HealthzPath, healthz)
+	router.GET(ReadyzPath, h.readyz)
+	router.GET("/metrics", h.metrics.handler())
+	router.GET(OpenAPIPath, serveOpenAPI)
+
+	// API routes - make sure the path starts with a single slash
+	router.GET("/api/method/notification_relay.api.get_config", h.getConfig)
+	router.POST("/api/method/notification_relay.api.auth.get_credential", h.getCredential)
+
+	// Protected routes, each group with its own authentication settings
+	auth := router.Group("/", h.authFailureGuard(), h.apiAuth(relay.RouteGroupAPI))
+	auth.POST("/api/method/notification_relay.api.topic.subscribe", h.subscribeToTopic)
+	auth.POST("/api/method/notification_relay.api.topic.unsubscribe", h.unsubscribeFromTopic)
+	auth.GET("/api/method/notification_relay.api.topic.list", h.listUserTopics)
+	auth.GET("/api/method/notification_relay.api.topic.users", h.listTopicUsers)
+	auth.POST("/api/method/notification_relay.api.token.add", h.addToken)
+	auth.POST("/api/method/notification_relay.api.token.remove", h.removeToken)
+	auth.POST("/api/method/notification_relay.api.send_notification.user", h.sendNotificationToUser)
+	auth.POST("/api/method/notification_relay.api.send_notification.users", h.sendNotificationToUsers)
+	auth.POST("/api/method/notification_relay.api.send_notification.topic", h.sendNotificationToTopic)
+	h.registerV2Routes(router.Group("/", h.authFailureGuard(), h.apiAuth(relay.RouteGroupV2)))
+
+	// Admin routes
+	admin := router.Group("/", h.authFailureGuard(), h.apiAuth(relay.RouteGroupAdmin), h.apiAdminAuth())
+	admin.GET("/api/method/notification_relay.api.admin.lockouts", h.listLockouts)
+	admin.POST("/api/method/notification_relay.api.admin.unlock", h.clearLockout)
+	admin.POST("/api/method/notification_relay.api.admin.broadcast", h.broadcastNotification)
+	admin.GET("/api/method/notification_relay.api.admin.broadcasts", h.listBroadcasts)
+
+	router.NoRoute(v2NotFound)
+
+	return router
+}
+
+func setTrustedProxies(router *gin.Engine, trustedProxies string) error {
+	trustedProxies = strings.TrimSpace(trustedProxies)
+
+	if trustedProxies == "" || trustedProxies == "none" {
+		return router.SetTrustedProxies([]string{}) // Trust no proxies
+	}
+
+	if trustedProxies == "*" {
+		return router.SetTrustedProxies(nil) // Trust all proxies
+	}
+
+	if err := relay.ValidateTrustedProxies(trustedProxies); err != nil {
+		return err
+	}
+
+	// Split the comma-separated list
+	return router.SetTrustedProxies(strings.Split(trustedProxies, ","))
+}
+
+func (h *Handler) getAllowedOrigins() []string {
+	// Check environment variable first
+	envOrigins := os.Getenv("ALLOWED_ORIGINS")
+
+	if envOrigins != "" {
+		// Special case for "*"
+		if envOrigins == "*" {
+			corsLog().Debug("Using wildcard for allowed origins", "source", "env")
+			return []string{"*"}
+		}
+		// Split comma-separated list
+		origins := strings.Split(envOrigins, ",")
+		corsLog().Debug("Using allowed origins", "source", "env", "origins", origins)
+		return origins
+	}
+
+	// Fallback to config file
+	if origins := h.relay.Config().AllowedOrigins; len(origins) > 0 {
+		corsLog().Debug("Using allowed origins", "source", "config", "origins", origins)
+		return origins
+	}
+
+	// Default to restrictive setting
+	corsLog().Debug("No allowed origins configured")
+	return []string{}
+}
+
+func (h *Handler) corsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.Request.Header.Get("Origin")
+		allowedOrigins := h.getAllowedOrigins()
+
+		corsLog().DebugContext(c.Request.Context(), "Checking request origin",
+			"origin", origin,
+			"allowed_origins", allowedOrigins,
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path)
+
+		if !handleCORSOrigin(c, origin, allowedOrigins) {
+			return
+		}
+
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept, Authorization, "+HeaderRequestID)
+		c.Writer.Header().Set("Access-Control-Expose-Headers", HeaderRequestID)
+		c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+
+		if c.Request.Method == "OPTIONS" {
+			corsLog().DebugContext(c.Request.Context(), "Handling preflight request", "origin", origin, "path", c.Request.URL.Path)
+			c.AbortWithStatus(http.StatusOK)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func handleCORSOrigin(c *gin.Context, origin string, allowedOrigins []string) bool {
+	if origin == "" {
+		corsLog().DebugContext(c.Request.Context(), "No origin header, using wildcard")
+		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		return true
+	}
+
+	// Special case: allow all origins
+	if len(allowedOrigins) == 1 && allowedOrigins[0] == "*" {
+		corsLog().DebugContext(c.Request.Context(), "Wildcard origin configured, allowing origin", "origin", origin)
+		c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+		return true
+	}
+
+	// Check if origin is in allowed list
+	for _, allowed := range allowedOrigins {
+		if allowed == origin {
+			corsLog().DebugContext(c.Request.Context(), "Origin allowed", "origin", origin)
+			c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+			c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+			return true
+		}
+	}
+
+	corsLog().WarnContext(c.Request.Context(), "Rejected request from unauthorized origin",
+		"origin", origin, "allowed_origins", allowedOrigins)
+	c.AbortWithStatus(http.StatusForbidden)
+	return false
+}
@@ -0,0 +1,82 @@
+package httpapi
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies string
+		expectError    bool
+		validateEngine func(*testing.T, *gin.Engine)
+	}{
+		{
+			name:           "trust all proxies",
+			trustedProxies: "*",
+			expectError:    false,
+			validateEngine: func(t *testing.T, engine *gin.Engine) {
+				assert.NotNil(t, engine)
+			},
+		},
+		{
+			name:           "trust no proxies",
+			trustedProxies: "none",
+			expectError:    false,
+			validateEngine: func(t *testing.T, engine *gin.Engine) {
+				assert.NotNil(t, engine)
+			},
+		},
+		{
+			name:           "valid CIDR",
+			trustedProxies: "127.0.0.1/32,10.0.0.0/8",
+			expectError:    false,
+			validateEngine: func(t *testing.T, engine *gin.Engine) {
+				assert.NotNil(t, engine)
+			},
+		},
+		{
+			name:           "invalid CIDR",
+			trustedProxies: "invalid-cidr",
+			expectError:    true,
+		},
+		{
+			name:           "empty string",
+			trustedProxies: "",
+			expectError:    false,
+			validateEngine: func(t *testing.T, engine *gin.Engine) {
+				assert.NotNil(t, engine)
+			},
+		},
+		{
+			name:           "whitespace string",
+			trustedProxies: "  ",
+			expectError:    false,
+			validateEngine: func(t *testing.T, engine *gin.Engine) {
+				assert.NotNil(t, engine)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := gin.New()
+			err := setTrustedProxies(engine, tt.trustedProxies)
+
+			if tt.expectError {
+				assert.Error(t, err)
+				if err != nil {
+					assert.Contains(t, err.Error(), "invalid CIDR")
+				}
+			} else {
+				assert.NoError(t, err)
+				if tt.validateEngine != nil {
+					tt.validateEngine(t, engine)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+// DefaultShutdownTimeout is how long shutdown waits for in-flight work.
+// Together with DefaultShutdownDrain it stays below Docker's stop grace period
+// configured in docker-compose.
+const DefaultShutdownTimeout = 20 * time.Second
+
+// DefaultShutdownDrain is how long readiness fails before new connections are
+// refused, so load balancers notice and stop routing to the relay
+const DefaultShutdownDrain = 5 * time.Second
+
+func shutdownTimeout(cfg *relay.Config) time.Duration {
+	if timeout := cfg.ShutdownTimeout; timeout > 0 {
+		return time.Duration(timeout) * time.Second
+	}
+	return DefaultShutdownTimeout
+}
+
+func shutdownDrain(cfg *relay.Config) time.Duration {
+	if drain := cfg.ShutdownDrain; drain != nil {
+		return time.Duration(*drain) * time.Second
+	}
+	return DefaultShutdownDrain
+}
+
+// Serve serves the HTTP API of srv on ln until ctx is canceled and then shuts
+// down gracefully. The configuration is reloaded on SIGHUP and, if enabled,
+// when files change. It matches relay.ServeFunc.
+func Serve(ctx context.Context, srv *relay.Server, ln net.Listener) error {
+	srv.WatchConfig(ctx)
+
+	httpSrv := &http.Server{
+		Handler:           New(srv),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	cfg := srv.Config()
+	return serve(ctx, srv, httpSrv, ln, shutdownDrain(cfg), shutdownTimeout(cfg))
+}
+
+// serve runs httpSrv on ln until ctx is canceled, then shuts down gracefully:
+// readiness fails for drain while requests are still served, then new
+// connections are refused, in-flight requests and deliveries get up to timeout
+// to finish, and state is flushed to disk.
+func serve(ctx context.Context, srv *relay.Server, httpSrv *http.Server, ln net.Listener, drain, timeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpSrv.Serve(ln)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	appLog().Info("Shutting down", "drain", drain, "timeout", timeout)
+	srv.BeginShutdown()
+
+	// Keep serving until load balancers saw readiness fail
+	select {
+	case err := <-serveErr:
+		return err
+	case <-time.After(drain):
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var errs []error
+	if err := httpSrv.Shutdown(shutdownCtx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to drain requests: %v", err))
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		errs = append(errs, err)
+	}
+
+	if len(errs) == 0 {
+		appLog().Info("Shutdown complete")
+	}
+	return errors.Join(errs...)
+}
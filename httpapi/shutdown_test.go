@@ -0,0 +1,147 @@
+package httpapi
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	relay "github.com/your-username/notification-relay"
+	"github.com/your-username/notification-relay/store"
+)
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	var dir string
+	h, _ := newTestHandler(t, func(env *testEnv) { dir = env.Dir })
+	srv := h.relay
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	router := gin.New()
+	router.GET("/slow", func(c *gin.Context) {
+		close(started)
+		<-release
+		// A token registered while draining must still reach the disk
+		_, err := srv.AddToken(c.Request.Context(), "test_project", "test_site", "user", "token")
+		assert.NoError(t, err)
+		c.String(http.StatusOK, "done")
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serve(ctx, srv, &http.Server{Handler: router, ReadHeaderTimeout: time.Second}, ln, 0, 5*time.Second)
+	}()
+
+	url := "http://" + ln.Addr().String()
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow") // #nosec G107 -- test server URL
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		responses <- result{status: resp.StatusCode, body: string(body), err: err}
+	}()
+
+	<-started
+	cancel()
+
+	// Readiness flips as soon as shutdown begins
+	require.Eventually(t, func() bool { return srv.Checks()["shutdown"] != nil }, time.Second, 10*time.Millisecond)
+
+	close(release)
+	res := <-responses
+	require.NoError(t, res.err)
+	assert.Equal(t, http.StatusOK, res.status)
+	assert.Equal(t, "done", res.body)
+	require.NoError(t, <-serveErr)
+
+	// New connections are refused
+	_, err = http.Get(url + "/slow") // #nosec G107 -- test server URL
+	assert.Error(t, err)
+
+	var saved store.Devices
+	require.NoError(t, store.ReadJSON(filepath.Join(dir, relay.UserDeviceMapJSON), &saved))
+	assert.Equal(t, []string{"token"}, saved.Tokens("test_project_test_site", "user"))
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	h, mockClient := newTestHandler(t, func(env *testEnv) {
+		env.Devices["test_project_test_site"] = map[string][]string{"user": {testFCMToken}}
+	})
+	srv := h.relay
+
+	// The delivery is held until the test ends
+	sending, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	mockClient.On("Send", mock.Anything, mock.Anything).Run(func(mock.Arguments) {
+		close(sending)
+		<-release
+	}).Return("projects/p/messages/1", nil).Once()
+	go func() {
+		_, _ = srv.NotifyUser(context.Background(), relay.NotificationRequest{
+			ProjectName: "test_project", SiteName: "test_site", UserID: "user", Title: "Title", Body: "Body",
+		})
+	}()
+	<-sending
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	err = serve(ctx, srv, &http.Server{Handler: gin.New(), ReadHeaderTimeout: time.Second}, ln, 0, 50*time.Millisecond)
+	assert.ErrorContains(t, err, "deliveries still in progress")
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	// Deliveries starting after the timeout are refused instead of racing the wait
+	result, _ := srv.NotifyUser(context.Background(), relay.NotificationRequest{
+		ProjectName: "test_project", SiteName: "test_site", UserID: "user", Title: "Title", Body: "Body",
+	})
+	assert.Zero(t, result.Sent)
+	mockClient.AssertNumberOfCalls(t, "Send", 1)
+}
+
+func TestServeDrainsBeforeRefusingConnections(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serve(ctx, h.relay, &http.Server{Handler: h, ReadHeaderTimeout: time.Second}, ln, 500*time.Millisecond, time.Second)
+	}()
+	cancel()
+
+	// During the drain delay new connections still get a failing readiness check
+	require.Eventually(t, func() bool { return h.relay.Checks()["shutdown"] != nil }, time.Second, 10*time.Millisecond)
+	resp, err := http.Get("http://" + ln.Addr().String() + ReadyzPath) // #nosec G107 -- test server URL
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	require.NoError(t, <-serveErr)
+	_, err = http.Get("http://" + ln.Addr().String() + ReadyzPath) // #nosec G107 -- test server URL
+	assert.Error(t, err)
+}
@@ -0,0 +1,54 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listUserTopics lists the topics a user is subscribed to, from the local
+// registry. Takes project name, site name and user ID from the query parameters.
+func (h *Handler) listUserTopics(c *gin.Context) {
+	var req TopicRequest
+	if !bindOrReject(c, &req) {
+		return
+	}
+	if err := h.relay.ValidateProject(req.ProjectName); err != nil {
+		sendErrorResponse(c, http.StatusNotFound, err.Error())
+		return
+	}
+	if req.UserID == "" {
+		sendErrorResponse(c, http.StatusBadRequest, "user_id is required")
+		return
+	}
+
+	topics := h.relay.UserTopics(req.ProjectName, req.SiteName, req.UserID)
+	c.JSON(http.StatusOK, UserTopicsResponse{Message: UserTopicsDetail{
+		Success:    200,
+		UserTopics: UserTopics{UserID: req.UserID, Topics: topics},
+	}})
+}
+
+// listTopicUsers lists the users of a site subscribed to a topic, from the
+// local registry. Takes project name, site name and topic name from the query
+// parameters.
+func (h *Handler) listTopicUsers(c *gin.Context) {
+	var req TopicRequest
+	if !bindOrReject(c, &req) {
+		return
+	}
+	if err := h.relay.ValidateProject(req.ProjectName); err != nil {
+		sendErrorResponse(c, http.StatusNotFound, err.Error())
+		return
+	}
+	if req.TopicName == "" {
+		sendErrorResponse(c, http.StatusBadRequest, "topic_name is required")
+		return
+	}
+
+	users := h.relay.TopicUsers(req.ProjectName, req.SiteName, req.TopicName)
+	c.JSON(http.StatusOK, TopicUsersResponse{Message: TopicUsersDetail{
+		Success:    200,
+		TopicUsers: TopicUsers{Topic: req.TopicName, Users: users},
+	}})
+}
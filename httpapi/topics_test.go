@@ -0,0 +1,159 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	relay "github.com/your-username/notification-relay"
+	"github.com/your-username/notification-relay/store"
+)
+
+func TestTopicRegistry(t *testing.T) {
+	var dir string
+	h, mockClient := newTestHandler(t, func(env *testEnv) {
+		dir = env.Dir
+		env.Credentials["site-key"] = testAPISecret
+		env.Devices["test_project_test_site"] = map[string][]string{
+			"alice": {"alice-phone", "alice-laptop"},
+			"bob":   {"bob-phone"},
+		}
+	})
+	mockClient.On("SubscribeToTopic", mock.Anything, mock.Anything, mock.Anything).
+		Return(&messaging.TopicManagementResponse{SuccessCount: 1}, nil)
+	mockClient.On("UnsubscribeFromTopic", mock.Anything, mock.Anything, mock.Anything).
+		Return(&messaging.TopicManagementResponse{SuccessCount: 1}, nil)
+
+	const v1 = "/api/method/notification_relay.api."
+	request := func(method, path string, params url.Values) *httptest.ResponseRecorder {
+		params.Set("project_name", "test_project")
+		params.Set("site_name", "test_site")
+		req := httptest.NewRequest(method, path+"?"+params.Encode(), http.NoBody)
+		req.SetBasicAuth("site-key", testAPISecret)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	listTopics := func(userID string) []string {
+		w := request(http.MethodGet, v1+"topic.list", url.Values{"user_id": {userID}})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response UserTopicsResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, userID, response.Message.UserID)
+		return response.Message.Topics
+	}
+	listUsers := func(topic string) []string {
+		w := request(http.MethodGet, v1+"topic.users", url.Values{"topic_name": {topic}})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response TopicUsersResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, topic, response.Message.Topic)
+		return response.Message.Users
+	}
+
+	assert.Empty(t, listTopics("alice"))
+	for _, subscription := range []struct{ user, topic string }{{"alice", "news"}, {"alice", "alerts"}, {"bob", "news"}} {
+		w := request(http.MethodPost, v1+"topic.subscribe", url.Values{"user_id": {subscription.user}, "topic_name": {subscription.topic}})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	assert.Equal(t, []string{"alerts", "news"}, listTopics("alice"))
+	assert.Equal(t, []string{"alice", "bob"}, listUsers("news"))
+	devices, subscribed := h.relay.TopicDevices("test_project", "test_site", "alice", "news")
+	assert.True(t, subscribed)
+	assert.Equal(t, []string{relay.DeviceID("alice-phone"), relay.DeviceID("alice-laptop")}, devices)
+
+	// The registry is saved
+	var saved store.Subscriptions
+	require.NoError(t, store.ReadJSON(filepath.Join(dir, relay.TopicSubscriptionsJSON), &saved))
+	assert.Equal(t, []string{"alice-phone", "alice-laptop"}, saved.Tokens("test_project_test_site", "alice", "news"))
+	assert.Equal(t, []string{"alice", "bob"}, saved.Users("test_project_test_site", "news"))
+
+	w := request(http.MethodPost, v1+"topic.unsubscribe", url.Values{"user_id": {"alice"}, "topic_name": {"news"}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []string{"alerts"}, listTopics("alice"))
+	assert.Equal(t, []string{"bob"}, listUsers("news"))
+
+	// Failed FCM calls are not recorded
+	mockClient.ExpectedCalls = nil
+	mockClient.On("SubscribeToTopic", mock.Anything, mock.Anything, "sports").
+		Return(&messaging.TopicManagementResponse{}, assert.AnError)
+	w = request(http.MethodPost, v1+"topic.subscribe", url.Values{"user_id": {"bob"}, "topic_name": {"sports"}})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, listUsers("sports"))
+
+	w = request(http.MethodGet, v1+"topic.list", url.Values{})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = request(http.MethodGet, v1+"topic.users", url.Values{})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestUnsubscribeWithoutDevices(t *testing.T) {
+	h, mockClient := newTestHandler(t, func(env *testEnv) {
+		env.Credentials["site-key"] = testAPISecret
+		env.Devices["test_project_test_site"] = map[string][]string{"alice": {"alice-phone"}}
+		env.Subscriptions.Subscribe("test_project_test_site", "bob", "news")
+	})
+	mockClient.On("SubscribeToTopic", mock.Anything, []string{"alice-phone"}, mock.Anything).
+		Return(&messaging.TopicManagementResponse{SuccessCount: 1}, nil).Once()
+	mockClient.On("UnsubscribeFromTopic", mock.Anything, []string{"alice-phone"}, mock.Anything).
+		Return(&messaging.TopicManagementResponse{SuccessCount: 1}, nil).Once()
+
+	const v1 = "/api/method/notification_relay.api."
+	request := func(method, path string, params url.Values) *httptest.ResponseRecorder {
+		params.Set("project_name", "test_project")
+		params.Set("site_name", "test_site")
+		params.Set("user_id", "alice")
+		req := httptest.NewRequest(method, path+"?"+params.Encode(), http.NoBody)
+		req.SetBasicAuth("site-key", testAPISecret)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	listTopics := func() []string {
+		w := request(http.MethodGet, v1+"topic.list", url.Values{})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response UserTopicsResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Message.Topics
+	}
+
+	w := request(http.MethodPost, v1+"topic.subscribe", url.Values{"topic_name": {"news"}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = request(http.MethodPost, v1+"token.remove", url.Values{"fcm_token": {"alice-phone"}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Empty(t, h.relay.Tokens("test_project", "test_site", "alice"))
+	assert.Equal(t, []string{"news"}, listTopics(), "the user stays subscribed without devices")
+
+	// Leaving the topic only updates the registry
+	w = request(http.MethodPost, v1+"topic.unsubscribe", url.Values{"topic_name": {"news"}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response TopicSubscriptionResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 0, response.Message.SuccessCount)
+	assert.Empty(t, response.Message.Results)
+	assert.Empty(t, listTopics())
+	mockClient.AssertExpectations(t)
+
+	// A device added afterwards is not subscribed to the topic
+	w = request(http.MethodPost, v1+"token.add", url.Values{"fcm_token": {"alice-tablet"}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	mockClient.AssertNotCalled(t, "SubscribeToTopic", mock.Anything, []string{"alice-tablet"}, mock.Anything)
+
+	// v2 leaves topics of users without devices the same way
+	req := httptest.NewRequest(http.MethodDelete, "/v2/projects/test_project/sites/test_site/users/bob/topics/news", http.NoBody)
+	req.SetBasicAuth("site-key", testAPISecret)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	_, subscribed := h.relay.TopicDevices("test_project", "test_site", "bob", "news")
+	assert.False(t, subscribed)
+}
@@ -0,0 +1,21 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+// tracingMiddleware returns a middleware handler that starts a server span for
+// each request with the tracer provider of the server, continuing the trace
+// from an incoming traceparent header
+func (h *Handler) tracingMiddleware() gin.HandlerFunc {
+	return otelgin.Middleware(relay.DefaultServiceName,
+		otelgin.WithTracerProvider(h.relay.TracerProvider()),
+		otelgin.WithFilter(func(r *http.Request) bool {
+			return !isHealthPath(r.URL.Path)
+		}))
+}
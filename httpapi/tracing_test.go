@@ -0,0 +1,101 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spanAttribute returns the value of a span attribute
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestUserNotificationTrace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() {
+		_ = provider.Shutdown(context.Background())
+	}()
+	h, mockClient := newTestHandler(t, func(env *testEnv) {
+		env.TracerProvider = provider
+		env.Devices["test_project_test_site"] = map[string][]string{"test_user": {testFCMToken, "stale-token-value"}}
+	})
+
+	mockClient.On("Send", mock.Anything, mock.MatchedBy(func(m *messaging.Message) bool {
+		return m.Token == testFCMToken
+	})).Return("projects/p/messages/1", nil)
+	mockClient.On("Send", mock.Anything, mock.MatchedBy(func(m *messaging.Message) bool {
+		return m.Token == "stale-token-value"
+	})).Return("", errors.New("registration-token-not-registered"))
+
+	w := httptest.NewRecorder()
+	_, router := createTestContext(w)
+	router.Use(h.tracingMiddleware())
+	router.POST("/send", h.sendNotificationToUser)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPost, "/send?"+url.Values{
+		"project_name": {"test_project"},
+		"site_name":    {"test_site"},
+		"user_id":      {"test_user"},
+		"title":        {"Title"},
+		"body":         {"Body"},
+	}.Encode(), http.NoBody)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	spans := exporter.GetSpans()
+	names := make(map[string]int)
+	var server tracetest.SpanStub
+	var fcmSpans []tracetest.SpanStub
+	for _, span := range spans {
+		assert.Equal(t, traceID, span.SpanContext.TraceID().String(), span.Name)
+		names[span.Name]++
+		switch span.Name {
+		case "/send":
+			server = span
+		case "fcm.send":
+			fcmSpans = append(fcmSpans, span)
+		}
+	}
+
+	assert.Equal(t, map[string]int{
+		"/send":                      1,
+		"store.get_user_tokens":      1,
+		"notification.decorate":      1,
+		"fcm.send":                   2,
+		"store.remove_invalid_token": 1,
+	}, names)
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String(), "server span continues the incoming trace")
+
+	require.Len(t, fcmSpans, 2)
+	for _, span := range fcmSpans {
+		assert.Equal(t, server.SpanContext.SpanID(), span.Parent.SpanID())
+		assert.NotContains(t, spanAttribute(span, "fcm.token").AsString(), "APA91b")
+	}
+	failed := fcmSpans[1]
+	if fcmSpans[0].Status.Code == codes.Error {
+		failed = fcmSpans[0]
+	}
+	assert.Equal(t, codes.Error, failed.Status.Code)
+	assert.Equal(t, "unregistered", spanAttribute(failed, "fcm.error_code").AsString())
+}
@@ -0,0 +1,255 @@
+package httpapi
+
+import relay "github.com/your-username/notification-relay"
+
+// ConfigResponse represents the response structure for the getConfig endpoint
+type ConfigResponse struct {
+	VapidPublicKey string                 `json:"vapid_public_key"`
+	Config         map[string]interface{} `json:"config"`
+	Exc            string                 `json:"exc,omitempty"`
+}
+
+// CredentialRequest represents a request for API credentials with validation requirements
+type CredentialRequest struct {
+	Endpoint     string `json:"endpoint"`      // Required: The endpoint URL
+	Protocol     string `json:"protocol"`      // The protocol (http/https)
+	Port         string `json:"port"`          // Optional: The port number
+	Token        string `json:"token"`         // Required: Authentication token
+	WebhookRoute string `json:"webhook_route"` // The webhook route path
+}
+
+// TopicRequest holds the parameters of the topic subscribe and unsubscribe endpoints
+type TopicRequest struct {
+	ProjectName string `json:"project_name" form:"project_name"`
+	SiteName    string `json:"site_name" form:"site_name"`
+	UserID      string `json:"user_id" form:"user_id"`
+	TopicName   string `json:"topic_name" form:"topic_name"`
+}
+
+// TokenRequest holds the parameters of the token add and remove endpoints
+type TokenRequest struct {
+	ProjectName string `json:"project_name" form:"project_name"`
+	SiteName    string `json:"site_name" form:"site_name"`
+	UserID      string `json:"user_id" form:"user_id"`
+	FCMToken    string `json:"fcm_token" form:"fcm_token"`
+}
+
+// CredentialResponse represents an API credentials response
+type CredentialResponse struct {
+	Success     bool               `json:"success"`
+	Message     string             `json:"message,omitempty"`
+	Credentials *CredentialDetails `json:"credentials,omitempty"`
+	Exc         string             `json:"exc,omitempty"`
+}
+
+// CredentialEnvelope wraps a CredentialResponse in the Frappe envelope
+type CredentialEnvelope struct {
+	Message CredentialResponse `json:"message"`
+}
+
+// CredentialDetails contains generated API credentials
+type CredentialDetails struct {
+	APIKey    string `json:"api_key"`
+	APISecret string `json:"api_secret"`
+}
+
+// Response represents the standard API response structure with optional fields
+type Response struct {
+	Message interface{} `json:"message,omitempty"` // Success message or status information
+	Data    interface{} `json:"data,omitempty"`    // Response payload data
+	Exc     string      `json:"exc,omitempty"`     // Error message for critical failures
+}
+
+// MessageResponse is the Frappe success envelope of the /api/method endpoints
+type MessageResponse struct {
+	Message MessageDetail `json:"message"`
+}
+
+// MessageDetail is the content of a Frappe success envelope
+type MessageDetail struct {
+	Success interface{} `json:"success"` // 200, or false when the request was not processed
+	Message string      `json:"message"`
+}
+
+// ExcResponse is the Frappe error envelope of the /api/method endpoints
+type ExcResponse struct {
+	Exc ExcDetail `json:"exc"`
+}
+
+// ExcDetail describes a failed /api/method request
+type ExcDetail struct {
+	StatusCode int    `json:"status_code"`
+	Message    string `json:"message"`
+	RequestID  string `json:"request_id,omitempty"`
+}
+
+// LockoutsResponse lists the clients and API keys that are locked out
+type LockoutsResponse struct {
+	Message LockoutList `json:"message"`
+}
+
+// LockoutList is the content of a LockoutsResponse
+type LockoutList struct {
+	Success  int            `json:"success"`
+	Lockouts []failureEntry `json:"lockouts"`
+}
+
+// HealthResponse is the body of the health check endpoints
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"` // "ok" or "fail" for each readiness check
+}
+
+// BulkNotificationRequest holds the parameters of the bulk send endpoint
+type BulkNotificationRequest struct {
+	ProjectName string `json:"project_name" form:"project_name"`
+	SiteName    string `json:"site_name" form:"site_name"`
+	relay.BulkMessageRequest
+}
+
+// BroadcastResponse is the Frappe envelope of the broadcast endpoint
+type BroadcastResponse struct {
+	Message BroadcastDetail `json:"message"`
+}
+
+// BroadcastDetail is the content of a BroadcastResponse
+type BroadcastDetail struct {
+	Success   int             `json:"success"`
+	Message   string          `json:"message"`
+	Broadcast relay.Broadcast `json:"broadcast"`
+}
+
+// BroadcastsResponse is the response of the broadcast listing endpoint
+type BroadcastsResponse struct {
+	Message BroadcastList `json:"message"`
+}
+
+// BroadcastList is the content of a BroadcastsResponse
+type BroadcastList struct {
+	Success    int               `json:"success"`
+	Broadcasts []relay.Broadcast `json:"broadcasts"`
+}
+
+// DeviceRequest is the body of the v2 device registration endpoint
+type DeviceRequest struct {
+	Token string `json:"token" form:"token"`
+}
+
+// MessageRequest is the body of the v2 notification endpoints
+type MessageRequest struct {
+	Title string                 `json:"title" form:"title"`
+	Body  string                 `json:"body" form:"body"`
+	Data  relay.NotificationData `json:"data" form:"data"`
+}
+
+// ConditionMessageRequest is the body of a notification to the devices
+// matching an FCM topic condition
+type ConditionMessageRequest struct {
+	Condition string                 `json:"condition" form:"condition"`
+	Title     string                 `json:"title" form:"title"`
+	Body      string                 `json:"body" form:"body"`
+	Data      relay.NotificationData `json:"data" form:"data"`
+}
+
+// Device is a device token registered for a user
+type Device struct {
+	Token string `json:"token"`
+}
+
+// DeviceList lists the device tokens registered for a user
+type DeviceList struct {
+	UserID  string   `json:"user_id"`
+	Devices []Device `json:"devices"`
+}
+
+// TopicSubscriptionResponse is the Frappe-style response of the topic subscription endpoints
+type TopicSubscriptionResponse struct {
+	Message TopicSubscriptionDetail `json:"message"`
+}
+
+// TopicSubscriptionDetail carries the per-device results of a topic subscription change
+type TopicSubscriptionDetail struct {
+	Success int    `json:"success"`
+	Message string `json:"message"`
+	relay.TopicSubscription
+}
+
+// BulkMessageResponse is the Frappe envelope of the bulk send endpoint
+type BulkMessageResponse struct {
+	Message BulkMessageDetail `json:"message"`
+}
+
+// BulkMessageDetail is the content of a BulkMessageResponse
+type BulkMessageDetail struct {
+	Success int    `json:"success"`
+	Message string `json:"message"`
+	relay.BulkDeliveryResult
+}
+
+// UserTopics lists the topics a user is subscribed to
+type UserTopics struct {
+	UserID string   `json:"user_id"`
+	Topics []string `json:"topics"`
+}
+
+// UserTopicsResponse is the Frappe envelope of the user topic listing
+type UserTopicsResponse struct {
+	Message UserTopicsDetail `json:"message"`
+}
+
+// UserTopicsDetail is the content of a UserTopicsResponse
+type UserTopicsDetail struct {
+	Success int `json:"success"`
+	UserTopics
+}
+
+// TopicUsers lists the users subscribed to a topic
+type TopicUsers struct {
+	Topic string   `json:"topic"`
+	Users []string `json:"users"`
+}
+
+// TopicUsersResponse is the Frappe envelope of the topic user listing
+type TopicUsersResponse struct {
+	Message TopicUsersDetail `json:"message"`
+}
+
+// TopicUsersDetail is the content of a TopicUsersResponse
+type TopicUsersDetail struct {
+	Success int `json:"success"`
+	TopicUsers
+}
+
+// TopicMembership is the subscription of a user to a topic with the IDs of the
+// subscribed devices, see relay.DeviceID
+type TopicMembership struct {
+	Topic   string   `json:"topic"`
+	UserID  string   `json:"user_id"`
+	Devices []string `json:"devices"`
+}
+
+// TopicDelivery is the result of sending a notification to a topic
+type TopicDelivery struct {
+	Topic     string `json:"topic"`
+	MessageID string `json:"message_id"`
+}
+
+// ConditionDelivery is the result of sending a notification to a topic
+// condition, in its normalized form
+type ConditionDelivery struct {
+	Condition string `json:"condition"`
+	MessageID string `json:"message_id"`
+}
+
+// ErrorResponse is the error body of every v2 endpoint
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes a failed v2 request
+type ErrorDetail struct {
+	Status    int    `json:"status"`               // HTTP status code
+	Code      string `json:"code"`                 // Status as a snake_case string, e.g. not_found
+	Message   string `json:"message"`              // Human-readable description
+	RequestID string `json:"request_id,omitempty"` // ID of the request, for correlating logs
+}
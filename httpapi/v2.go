@@ -0,0 +1,360 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	relay "github.com/your-username/notification-relay"
+	"github.com/your-username/notification-relay/delivery"
+)
+
+// v2Prefix is the path prefix of the resource-oriented API
+const v2Prefix = "/v2/"
+
+// v2SitePath is the path of a site, the root of every v2 resource
+const v2SitePath = "/v2/projects/:project/sites/:site"
+
+// registerV2Routes adds the v2 API to a router group that authenticates requests
+func (h *Handler) registerV2Routes(group *gin.RouterGroup) {
+	site := group.Group(v2SitePath)
+	site.GET("/users/:user/devices", h.v2ListDevices)
+	site.POST("/users/:user/devices", h.v2AddDevice)
+	site.DELETE("/users/:user/devices/:token", h.v2RemoveDevice)
+	site.GET("/users/:user/topics", h.v2ListUserTopics)
+	site.GET("/users/:user/topics/:topic", h.v2GetTopicMembership)
+	site.PUT("/users/:user/topics/:topic", h.v2SubscribeTopic)
+	site.DELETE("/users/:user/topics/:topic", h.v2UnsubscribeTopic)
+	site.POST("/users/:user/notifications", h.v2NotifyUser)
+	site.POST("/notifications", h.v2NotifyUsers)
+	site.GET("/topics/:topic/users", h.v2ListTopicUsers)
+	site.POST("/topics/:topic/notifications", h.v2NotifyTopic)
+	site.POST("/conditions/notifications", h.v2NotifyCondition)
+}
+
+// v2NotFound responds to unknown v2 paths with the v2 error body. Other paths
+// keep gin's default response.
+func v2NotFound(c *gin.Context) {
+	if strings.HasPrefix(c.Request.URL.Path, v2Prefix) {
+		v2Error(c, http.StatusNotFound, "resource not found")
+	}
+}
+
+// isV2Request reports whether the request was routed to the v2 API
+func isV2Request(c *gin.Context) bool {
+	return strings.HasPrefix(c.FullPath(), v2Prefix)
+}
+
+// v2Error responds with the v2 error body
+func v2Error(c *gin.Context, status int, message string) {
+	c.JSON(status, ErrorResponse{Error: ErrorDetail{
+		Status:    status,
+		Code:      strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"),
+		Message:   message,
+		RequestID: requestID(c),
+	}})
+}
+
+// v2Fail responds with the status err carries, or fallback for errors without one
+func v2Fail(c *gin.Context, err error, fallback int) {
+	v2Error(c, relay.ErrorStatus(err, fallback), err.Error())
+}
+
+// v2Site returns the project and site of the request, or responds with 404
+// when the project isn't configured
+func (h *Handler) v2Site(c *gin.Context) (projectName, siteName string, ok bool) {
+	projectName = c.Param("project")
+	if err := h.relay.ValidateProject(projectName); err != nil {
+		v2Fail(c, err, http.StatusNotFound)
+		return "", "", false
+	}
+	return projectName, c.Param("site"), true
+}
+
+// v2Bind binds the request body and responds with 400 (413 for oversized
+// bodies) when that fails
+func v2Bind(c *gin.Context, req interface{}) bool {
+	if err := bindRequest(c, req); err != nil {
+		v2Error(c, bindErrorStatus(err), "Invalid request: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// v2ListDevices lists the device tokens of a user. Users without devices have
+// an empty list.
+func (h *Handler) v2ListDevices(c *gin.Context) {
+	projectName, siteName, ok := h.v2Site(c)
+	if !ok {
+		return
+	}
+
+	userID := c.Param("user")
+	devices := make([]Device, 0)
+	for _, token := range h.relay.Tokens(projectName, siteName, userID) {
+		devices = append(devices, Device{Token: token})
+	}
+	c.JSON(http.StatusOK, DeviceList{UserID: userID, Devices: devices})
+}
+
+// v2AddDevice registers a device token for a user. It responds with 201 for a
+// new token and 200 when the user already has it.
+func (h *Handler) v2AddDevice(c *gin.Context) {
+	projectName, siteName, ok := h.v2Site(c)
+	if !ok {
+		return
+	}
+
+	var req DeviceRequest
+	if !v2Bind(c, &req) {
+		return
+	}
+	if req.Token == "" {
+		v2Error(c, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	added, err := h.relay.AddToken(c.Request.Context(), projectName, siteName, c.Param("user"), req.Token)
+	if err != nil {
+		v2Fail(c, err, http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusOK
+	if added {
+		status = http.StatusCreated
+	}
+	c.JSON(status, Device{Token: req.Token})
+}
+
+// v2RemoveDevice removes a device token of a user
+func (h *Handler) v2RemoveDevice(c *gin.Context) {
+	projectName, siteName, ok := h.v2Site(c)
+	if !ok {
+		return
+	}
+
+	removed, err := h.relay.RemoveToken(c.Request.Context(), projectName, siteName, c.Param("user"), c.Param("token"))
+	if err != nil {
+		v2Fail(c, err, http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		v2Error(c, http.StatusNotFound, "token not found")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// v2ListUserTopics lists the topics a user is subscribed to. Users without
+// subscriptions have an empty list.
+func (h *Handler) v2ListUserTopics(c *gin.Context) {
+	projectName, siteName, ok := h.v2Site(c)
+	if !ok {
+		return
+	}
+
+	userID := c.Param("user")
+	topics := h.relay.UserTopics(projectName, siteName, userID)
+	c.JSON(http.StatusOK, UserTopics{UserID: userID, Topics: topics})
+}
+
+// v2GetTopicMembership reports whether a user is subscribed to a topic from the
+// local registry, without asking FCM. It responds with 404 when the user isn't.
+func (h *Handler) v2GetTopicMembership(c *gin.Context) {
+	projectName, siteName, ok := h.v2Site(c)
+	if !ok {
+		return
+	}
+
+	userID, topic := c.Param("user"), c.Param("topic")
+	devices, subscribed := h.relay.TopicDevices(projectName, siteName, userID, topic)
+	if !subscribed {
+		v2Error(c, http.StatusNotFound, "user "+userID+" is not subscribed to "+topic)
+		return
+	}
+	c.JSON(http.StatusOK, TopicMembership{Topic: topic, UserID: userID, Devices: devices})
+}
+
+// v2ListTopicUsers lists the users subscribed to a topic
+func (h *Handler) v2ListTopicUsers(c *gin.Context) {
+	projectName, siteName, ok := h.v2Site(c)
+	if !ok {
+		return
+	}
+
+	topic := c.Param("topic")
+	users := h.relay.TopicUsers(projectName, siteName, topic)
+	c.JSON(http.StatusOK, TopicUsers{Topic: topic, Users: users})
+}
+
+// v2SubscribeTopic subscribes the devices of a user to a topic
+func (h *Handler) v2SubscribeTopic(c *gin.Context) {
+	h.v2UpdateTopic(c, true)
+}
+
+// v2UnsubscribeTopic unsubscribes the devices of a user from a topic
+func (h *Handler) v2UnsubscribeTopic(c *gin.Context) {
+	h.v2UpdateTopic(c, false)
+}
+
+// v2UpdateTopic changes the topic subscription of a user's devices. FCM
+// failures for individual tokens are reported per device, a failed call
+// responds with 502.
+func (h *Handler) v2UpdateTopic(c *gin.Context, subscribe bool) {
+	projectName, siteName, ok := h.v2Site(c)
+	if !ok {
+		return
+	}
+
+	topic := c.Param("topic")
+	if err := relay.ValidateTopicName(topic); err != nil {
+		v2Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	update := h.relay.Unsubscribe
+	if subscribe {
+		update = h.relay.Subscribe
+	}
+	result, err := update(c.Request.Context(), projectName, siteName, c.Param("user"), topic)
+	if err != nil {
+		v2Fail(c, err, http.StatusBadGateway)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// v2NotifyUser sends a notification to every device of a user. It responds
+// with 502 when no device accepted it.
+func (h *Handler) v2NotifyUser(c *gin.Context) {
+	projectName, siteName, ok := h.v2Site(c)
+	if !ok {
+		return
+	}
+
+	var msg MessageRequest
+	if !v2Bind(c, &msg) {
+		return
+	}
+	if err := relay.ValidateNotification(msg.Title, msg.Body); err != nil {
+		v2Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	req := relay.NotificationRequest{
+		ProjectName: projectName,
+		SiteName:    siteName,
+		UserID:      c.Param("user"),
+		Title:       msg.Title,
+		Body:        msg.Body,
+		Data:        msg.Data,
+	}
+	result, err := h.relay.NotifyUser(c.Request.Context(), req)
+	if err != nil {
+		v2Fail(c, err, http.StatusInternalServerError)
+		return
+	}
+	if result.Sent == 0 {
+		v2Error(c, http.StatusBadGateway, "notification was not delivered to any device")
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// v2NotifyUsers sends a notification to the devices of several users. Users
+// that can't be notified are reported in the result.
+func (h *Handler) v2NotifyUsers(c *gin.Context) {
+	projectName, siteName, ok := h.v2Site(c)
+	if !ok {
+		return
+	}
+
+	var req relay.BulkMessageRequest
+	if !v2Bind(c, &req) {
+		return
+	}
+	if err := req.Validate(); err != nil {
+		v2Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, h.relay.NotifyUsers(c.Request.Context(), projectName, siteName, req))
+}
+
+// v2NotifyTopic sends a notification to a topic
+func (h *Handler) v2NotifyTopic(c *gin.Context) {
+	projectName, siteName, ok := h.v2Site(c)
+	if !ok {
+		return
+	}
+
+	var msg MessageRequest
+	if !v2Bind(c, &msg) {
+		return
+	}
+	if err := relay.ValidateNotification(msg.Title, msg.Body); err != nil {
+		v2Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	topic := c.Param("topic")
+	if err := relay.ValidateTopicName(topic); err != nil {
+		v2Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	messageID, err := h.relay.NotifyTopic(c.Request.Context(), relay.NotificationRequest{
+		ProjectName: projectName,
+		SiteName:    siteName,
+		TopicName:   topic,
+		Title:       msg.Title,
+		Body:        msg.Body,
+		Data:        msg.Data,
+	})
+	if err != nil {
+		v2Fail(c, err, http.StatusBadGateway)
+		return
+	}
+	c.JSON(http.StatusOK, TopicDelivery{Topic: topic, MessageID: messageID})
+}
+
+// v2NotifyCondition sends a notification to the devices matching a topic
+// condition. Topics in the condition are mapped like topic names are.
+func (h *Handler) v2NotifyCondition(c *gin.Context) {
+	projectName, siteName, ok := h.v2Site(c)
+	if !ok {
+		return
+	}
+
+	var msg ConditionMessageRequest
+	if !v2Bind(c, &msg) {
+		return
+	}
+	if err := relay.ValidateNotification(msg.Title, msg.Body); err != nil {
+		v2Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if msg.Condition == "" {
+		v2Error(c, http.StatusBadRequest, "condition is required")
+		return
+	}
+	condition, err := delivery.ParseCondition(msg.Condition)
+	if err != nil {
+		v2Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	messageID, err := h.relay.NotifyTopic(c.Request.Context(), relay.NotificationRequest{
+		ProjectName: projectName,
+		SiteName:    siteName,
+		Condition:   msg.Condition,
+		Title:       msg.Title,
+		Body:        msg.Body,
+		Data:        msg.Data,
+	})
+	if err != nil {
+		v2Fail(c, err, http.StatusBadGateway)
+		return
+	}
+	c.JSON(http.StatusOK, ConditionDelivery{Condition: condition.String(), MessageID: messageID})
+}
@@ -0,0 +1,327 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	relay "github.com/your-username/notification-relay"
+)
+
+func TestV2API(t *testing.T) {
+	h, mockClient := newTestHandler(t, func(env *testEnv) {
+		env.Credentials["site-key"] = testAPISecret
+		env.Devices["test_project_test_site"] = map[string][]string{"bob": {"stale-token"}}
+	})
+	mockClient.On("SubscribeToTopic", mock.Anything, []string{testFCMToken}, "news").
+		Return(&messaging.TopicManagementResponse{SuccessCount: 1}, nil).Once()
+	mockClient.On("UnsubscribeFromTopic", mock.Anything, []string{testFCMToken}, "broken").
+		Return(&messaging.TopicManagementResponse{}, errors.New("internal error")).Once()
+	// Removing the device unsubscribes it from the topics of the user
+	mockClient.On("UnsubscribeFromTopic", mock.Anything, []string{testFCMToken}, "news").
+		Return(&messaging.TopicManagementResponse{SuccessCount: 1}, nil).Once()
+	mockClient.On("Send", mock.Anything, mock.MatchedBy(func(m *messaging.Message) bool {
+		return m.Token == testFCMToken && m.Webpush.Data["request_id"] == "v2-request"
+	})).Return("projects/p/messages/1", nil).Once()
+	mockClient.On("Send", mock.Anything, mock.MatchedBy(func(m *messaging.Message) bool {
+		return m.Token == "stale-token"
+	})).Return("", errors.New("registration-token-not-registered")).Once()
+	mockClient.On("Send", mock.Anything, mock.MatchedBy(func(m *messaging.Message) bool {
+		return m.Topic == "news"
+	})).Return("projects/p/messages/2", nil).Once()
+	mockClient.On("Send", mock.Anything, mock.MatchedBy(func(m *messaging.Message) bool {
+		return m.Topic == "broken"
+	})).Return("", errors.New("internal error")).Once()
+	mockClient.On("Send", mock.Anything, mock.MatchedBy(func(m *messaging.Message) bool {
+		return m.Condition == "'news' in topics && !('muted' in topics)"
+	})).Return("projects/p/messages/3", nil).Once()
+	mockClient.On("Send", mock.Anything, mock.MatchedBy(func(m *messaging.Message) bool {
+		return m.Condition == "('a' in topics || 'b' in topics) && 'c' in topics"
+	})).Return("projects/p/messages/4", nil).Once()
+
+	const site = "/v2/projects/test_project/sites/test_site"
+
+	// Requests run in order and share the device map
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		anonymous      bool
+		expectedStatus int
+		expectedBody   string // JSON body of successful responses
+		expectedError  string // Message of error responses
+	}{
+		{
+			name:           "list devices of unknown user",
+			method:         http.MethodGet,
+			path:           site + "/users/alice/devices",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"user_id": "alice", "devices": []}`,
+		},
+		{
+			name:           "add device",
+			method:         http.MethodPost,
+			path:           site + "/users/alice/devices",
+			body:           `{"token": "` + testFCMToken + `"}`,
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"token": "` + testFCMToken + `"}`,
+		},
+		{
+			name:           "add existing device",
+			method:         http.MethodPost,
+			path:           site + "/users/alice/devices",
+			body:           `{"token": "` + testFCMToken + `"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"token": "` + testFCMToken + `"}`,
+		},
+		{
+			name:           "add device without token",
+			method:         http.MethodPost,
+			path:           site + "/users/alice/devices",
+			body:           `{}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "token is required",
+		},
+		{
+			name:           "list devices",
+			method:         http.MethodGet,
+			path:           site + "/users/alice/devices",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"user_id": "alice", "devices": [{"token": "` + testFCMToken + `"}]}`,
+		},
+		{
+			name:           "unknown project",
+			method:         http.MethodGet,
+			path:           "/v2/projects/unknown/sites/test_site/users/alice/devices",
+			expectedStatus: http.StatusNotFound,
+			expectedError:  "project unknown not found",
+		},
+		{
+			name:           "subscribe to topic",
+			method:         http.MethodPut,
+			path:           site + "/users/alice/topics/news",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"topic": "news", "success_count": 1, "failure_count": 0, "results": [{"device": "` + relay.DeviceID(testFCMToken) + `", "success": true}]}`,
+		},
+		{
+			name:           "list topics of user",
+			method:         http.MethodGet,
+			path:           site + "/users/alice/topics",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"user_id": "alice", "topics": ["news"]}`,
+		},
+		{
+			name:           "check subscription",
+			method:         http.MethodGet,
+			path:           site + "/users/alice/topics/news",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"topic": "news", "user_id": "alice", "devices": ["` + relay.DeviceID(testFCMToken) + `"]}`,
+		},
+		{
+			name:           "check missing subscription",
+			method:         http.MethodGet,
+			path:           site + "/users/bob/topics/news",
+			expectedStatus: http.StatusNotFound,
+			expectedError:  "user bob is not subscribed to news",
+		},
+		{
+			name:           "list users of topic",
+			method:         http.MethodGet,
+			path:           site + "/topics/news/users",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"topic": "news", "users": ["alice"]}`,
+		},
+		{
+			name:           "unsubscribe user without devices",
+			method:         http.MethodDelete,
+			path:           site + "/users/carol/topics/news",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"topic": "news", "success_count": 0, "failure_count": 0, "results": []}`,
+		},
+		{
+			name:           "unsubscribe fails at FCM",
+			method:         http.MethodDelete,
+			path:           site + "/users/alice/topics/broken",
+			expectedStatus: http.StatusBadGateway,
+			expectedError:  "internal error",
+		},
+		{
+			name:           "notify user",
+			method:         http.MethodPost,
+			path:           site + "/users/alice/notifications",
+			body:           `{"title": "Hello", "body": "World", "data": {"click_action": "/app"}}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"sent": 1, "failed": 0, "removed": 0}`,
+		},
+		{
+			name:           "notify user without title",
+			method:         http.MethodPost,
+			path:           site + "/users/alice/notifications",
+			body:           `{"body": "World"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "title is required",
+		},
+		{
+			name:           "notify user with invalid data",
+			method:         http.MethodPost,
+			path:           site + "/users/alice/notifications",
+			body:           `{"title": "Hello", "body": "World", "data": [1]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Invalid request: data must be a JSON object",
+		},
+		{
+			name:           "notify user whose only token is invalid",
+			method:         http.MethodPost,
+			path:           site + "/users/bob/notifications",
+			body:           `{"title": "Hello", "body": "World"}`,
+			expectedStatus: http.StatusBadGateway,
+			expectedError:  "notification was not delivered to any device",
+		},
+		{
+			name:           "notify topic",
+			method:         http.MethodPost,
+			path:           site + "/topics/news/notifications",
+			body:           `{"title": "Hello", "body": "World"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"topic": "news", "message_id": "projects/p/messages/2"}`,
+		},
+		{
+			name:           "notify topic fails at FCM",
+			method:         http.MethodPost,
+			path:           site + "/topics/broken/notifications",
+			body:           `{"title": "Hello", "body": "World"}`,
+			expectedStatus: http.StatusBadGateway,
+			expectedError:  "Failed to send notification: internal error",
+		},
+		{
+			name:           "notify condition",
+			method:         http.MethodPost,
+			path:           site + "/conditions/notifications",
+			body:           `{"condition": "'news' in topics && !('muted' in topics)", "title": "Hello", "body": "World"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"condition": "'news' in topics && !('muted' in topics)", "message_id": "projects/p/messages/3"}`,
+		},
+		{
+			name:           "notify condition without condition",
+			method:         http.MethodPost,
+			path:           site + "/conditions/notifications",
+			body:           `{"title": "Hello", "body": "World"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "condition is required",
+		},
+		{
+			name:           "notify condition mixing operators",
+			method:         http.MethodPost,
+			path:           site + "/conditions/notifications",
+			body:           `{"condition": "'a' in topics || 'b' in topics && 'c' in topics", "title": "Hello", "body": "World"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"condition": "('a' in topics || 'b' in topics) && 'c' in topics", "message_id": "projects/p/messages/4"}`,
+		},
+		{
+			name:           "remove device",
+			method:         http.MethodDelete,
+			path:           site + "/users/alice/devices/" + testFCMToken,
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "remove missing device",
+			method:         http.MethodDelete,
+			path:           site + "/users/alice/devices/" + testFCMToken,
+			expectedStatus: http.StatusNotFound,
+			expectedError:  "token not found",
+		},
+		{
+			name:           "missing credentials",
+			method:         http.MethodGet,
+			path:           site + "/users/alice/devices",
+			anonymous:      true,
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "Authentication required",
+		},
+		{
+			name:           "unknown resource",
+			method:         http.MethodGet,
+			path:           site + "/devices",
+			expectedStatus: http.StatusNotFound,
+			expectedError:  "resource not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(HeaderRequestID, "v2-request")
+			if !tt.anonymous {
+				req.SetBasicAuth("site-key", testAPISecret)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			require.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			switch {
+			case tt.expectedError != "":
+				var response ErrorResponse
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, tt.expectedStatus, response.Error.Status)
+				assert.NotEmpty(t, response.Error.Code)
+				assert.Equal(t, tt.expectedError, response.Error.Message)
+				assert.Equal(t, "v2-request", response.Error.RequestID)
+			case tt.expectedBody != "":
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			default:
+				assert.Empty(t, w.Body.String())
+			}
+		})
+	}
+
+	mockClient.AssertExpectations(t)
+	assert.Empty(t, h.relay.Tokens("test_project", "test_site", "bob"), "invalid tokens are removed")
+}
+
+func TestV2ErrorResponse(t *testing.T) {
+	tests := []struct {
+		status       int
+		expectedCode string
+	}{
+		{http.StatusBadRequest, "bad_request"},
+		{http.StatusForbidden, "forbidden"},
+		{http.StatusTooManyRequests, "too_many_requests"},
+		{http.StatusInternalServerError, "internal_server_error"},
+		{http.StatusBadGateway, "bad_gateway"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expectedCode, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			_, router := createTestContext(w)
+			router.GET("/v2/test", func(c *gin.Context) {
+				sendErrorResponse(c, tt.status, "failed")
+			})
+			router.GET("/api/test", func(c *gin.Context) {
+				sendErrorResponse(c, tt.status, "failed")
+			})
+
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v2/test", http.NoBody))
+			assert.Equal(t, tt.status, w.Code)
+			assert.JSONEq(t, fmt.Sprintf(`{"error": {"status": %d, "code": %q, "message": "failed"}}`,
+				tt.status, tt.expectedCode), w.Body.String())
+
+			// The Frappe-compatible API keeps its envelope
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/test", http.NoBody))
+			assert.JSONEq(t, fmt.Sprintf(`{"exc": {"status_code": %d, "message": "failed"}}`, tt.status), w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package relay
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/MicahParks/keyfunc"
+)
+
+// Default claim names used to scope bearer tokens
+const (
+	DefaultJWTProjectsClaim = "projects"
+	DefaultJWTSitesClaim    = "sites"
+)
+
+// Route groups of the protected endpoints
+const (
+	RouteGroupAPI   = "api"   // Frappe-compatible endpoints
+	RouteGroupV2    = "v2"    // Resource-oriented v2 endpoints
+	RouteGroupAdmin = "admin" // Admin endpoints, which require an API key listed in admin_api_keys
+)
+
+// routeGroups are the route groups bearer tokens can be enabled for with
+// jwt.route_groups. Admin endpoints only accept admin API keys.
+var routeGroups = []string{RouteGroupAPI, RouteGroupV2}
+
+// AllowsRouteGroup reports whether bearer tokens may be used on a route group
+func (c *JWTConfig) AllowsRouteGroup(group string) bool {
+	if c == nil || !slices.Contains(routeGroups, group) {
+		return false
+	}
+	return len(c.RouteGroups) == 0 || slices.Contains(c.RouteGroups, group)
+}
+
+// ProjectsClaimName returns the claim listing the projects a token may use
+func (c *JWTConfig) ProjectsClaimName() string {
+	if c.ProjectsClaim != "" {
+		return c.ProjectsClaim
+	}
+	return DefaultJWTProjectsClaim
+}
+
+// SitesClaimName returns the claim listing the sites a token may use
+func (c *JWTConfig) SitesClaimName() string {
+	if c.SitesClaim != "" {
+		return c.SitesClaim
+	}
+	return DefaultJWTSitesClaim
+}
+
+// initJWTAuth loads the JWKS of the configuration in use and swaps it in.
+// When loading fails the keys in use are kept.
+func (s *Server) initJWTAuth() error {
+	jwtConfig := s.currentConfig().JWT
+	jwksFile, err := readJWKSFile(s.configPath, jwtConfig)
+	if err != nil {
+		return err
+	}
+	keys, err := loadJWTKeys(jwtConfig, jwksFile)
+	if err != nil {
+		return err
+	}
+	previous := s.currentState().jwtKeys
+	s.updateState(func(next *reloadableState) {
+		next.jwtKeys = keys
+		next.jwksFile = jwksFile
+	})
+	if previous != nil {
+		previous.EndBackground()
+	}
+	return nil
+}
+
+// readJWKSFile returns the contents of the configured jwks_file, or nil when
+// the keys don't come from a file
+func readJWKSFile(configPath string, jwtConfig *JWTConfig) ([]byte, error) {
+	if jwtConfig == nil || jwtConfig.JWKSFile == "" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(jwksFilePath(configPath, jwtConfig.JWKSFile)) // #nosec G304 -- path comes from config
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %v", err)
+	}
+	return content, nil
+}
+
+// loadJWTKeys loads the JWKS configured for bearer token authentication, from
+// jwksFile for a jwks_file. Returns nil keys when JWT authentication is not
+// configured.
+func loadJWTKeys(jwtConfig *JWTConfig, jwksFile []byte) (*keyfunc.JWKS, error) {
+	if jwtConfig == nil {
+		return nil, nil
+	}
+
+	var keys *keyfunc.JWKS
+	switch {
+	case jwtConfig.JWKSFile != "":
+		var err error
+		keys, err = keyfunc.NewJSON(jwksFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JWKS file: %v", err)
+		}
+	case jwtConfig.JWKSURL != "":
+		refresh := time.Hour
+		if jwtConfig.RefreshInterval > 0 {
+			refresh = time.Duration(jwtConfig.RefreshInterval) * time.Second
+		}
+		var err error
+		keys, err = keyfunc.Get(jwtConfig.JWKSURL, keyfunc.Options{
+			RefreshInterval:   refresh,
+			RefreshRateLimit:  time.Minute,
+			RefreshTimeout:    10 * time.Second,
+			RefreshUnknownKID: true,
+			RefreshErrorHandler: func(err error) {
+				authLog.Error("Failed to refresh JWKS", "error", err)
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch JWKS: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("jwt requires jwks_file or jwks_url")
+	}
+
+	authLog.Info("Loaded JWT signing keys", "count", keys.Len())
+	return keys, nil
+}
+
+// jwksFilePath resolves a JWKS file path relative to the directory of configPath
+func jwksFilePath(configPath, path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(configPath), path)
+	}
+	return filepath.Clean(path)
+}
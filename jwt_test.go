@@ -0,0 +1,131 @@
+package relay
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testJWTKeyID = "test-key"
+
+// setupTestJWKS writes a JWKS file for a fresh RSA key, enables JWT auth and
+// returns the private key
+func setupTestJWKS(t *testing.T, s *Server, tmpDir string) *rsa.PrivateKey {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": testJWTKeyID,
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(privateKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(privateKey.E)).Bytes()),
+			},
+		},
+	}
+	writeTestJSON(t, filepath.Join(tmpDir, "jwks.json"), jwks)
+
+	setTestJWTConfig(s, &JWTConfig{
+		JWKSFile: "jwks.json",
+		Issuer:   "https://issuer.example.com",
+		Audience: "notification-relay",
+	})
+	require.NoError(t, s.initJWTAuth())
+
+	return privateKey
+}
+
+// setTestJWTConfig replaces the jwt settings in use
+func setTestJWTConfig(s *Server, jwtConfig *JWTConfig) {
+	s.updateState(func(next *reloadableState) {
+		next.config.JWT = jwtConfig
+	})
+}
+
+func TestInitJWTAuth(t *testing.T) {
+	s, tmpDir := setupTestEnvironment(t)
+	defer func() {
+		require.NoError(t, s.Close(context.Background()))
+	}()
+
+	err := os.WriteFile(filepath.Join(tmpDir, "invalid-jwks.json"), []byte("invalid"), defaultFileMode)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		jwtConfig   *JWTConfig
+		expectError bool
+	}{
+		{
+			name:        "disabled",
+			jwtConfig:   nil,
+			expectError: false,
+		},
+		{
+			name:        "missing key source",
+			jwtConfig:   &JWTConfig{},
+			expectError: true,
+		},
+		{
+			name:        "missing jwks file",
+			jwtConfig:   &JWTConfig{JWKSFile: "missing.json"},
+			expectError: true,
+		},
+		{
+			name:        "invalid jwks file",
+			jwtConfig:   &JWTConfig{JWKSFile: "invalid-jwks.json"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestJWTConfig(s, tt.jwtConfig)
+
+			err := s.initJWTAuth()
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Nil(t, s.currentState().jwtKeys)
+		})
+	}
+}
+
+func TestInitJWTAuthFromURL(t *testing.T) {
+	s, tmpDir := setupTestEnvironment(t)
+	defer func() {
+		require.NoError(t, s.Close(context.Background()))
+	}()
+
+	setupTestJWKS(t, s, tmpDir)
+	raw, err := os.ReadFile(filepath.Join(tmpDir, "jwks.json"))
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(raw); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer ts.Close()
+
+	setTestJWTConfig(s, &JWTConfig{JWKSURL: ts.URL})
+	require.NoError(t, s.initJWTAuth())
+	require.NotNil(t, s.currentState().jwtKeys)
+	assert.Equal(t, []string{testJWTKeyID}, s.currentState().jwtKeys.KIDs())
+}
@@ -0,0 +1,51 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DataLockFile is locked by the server while it runs and by commands that
+// change data files, next to the configuration
+const DataLockFile = "notification-relay.lock"
+
+// errDataLocked is returned when another process holds the data directory lock
+var errDataLocked = errors.New("data directory is locked")
+
+// dataLock is an exclusive lock on the directory of the configuration and data
+// files. The server keeps the data in memory and writes it back on changes and
+// at shutdown, so nothing else may change the files while it runs.
+type dataLock struct {
+	file *os.File
+}
+
+// lockDataDir takes the lock of the directory of configPath without waiting.
+// It fails with errDataLocked while a server or another command holds it.
+func lockDataDir(configPath string) (*dataLock, error) {
+	path := filepath.Join(filepath.Dir(configPath), DataLockFile)
+	file, err := os.OpenFile(filepath.Clean(path), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+	if err := lockFile(file); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	// The PID tells operators who holds the lock
+	if err := file.Truncate(0); err == nil {
+		_, _ = fmt.Fprintf(file, "%d\n", os.Getpid())
+	}
+	return &dataLock{file: file}, nil
+}
+
+// release gives up the lock. The file is kept, removing it would let another
+// process lock a file nobody else sees.
+func (l *dataLock) release() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
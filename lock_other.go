@@ -0,0 +1,11 @@
+//go:build !unix
+
+package relay
+
+import "os"
+
+// lockFile does nothing where flock isn't available; the relay is built and
+// run on Linux
+func lockFile(*os.File) error {
+	return nil
+}
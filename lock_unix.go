@@ -0,0 +1,19 @@
+//go:build unix
+
+package relay
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive advisory lock, which the kernel releases when the
+// process exits, so a crashed server doesn't leave the directory locked
+func lockFile(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return errDataLocked
+	}
+	return err
+}
@@ -0,0 +1,234 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Logging subsystems, each with its own configurable level
+const (
+	LogSubsystemApp      = "app"
+	LogSubsystemHTTP     = "http"
+	LogSubsystemCORS     = "cors"
+	LogSubsystemAuth     = "auth"
+	LogSubsystemDelivery = "delivery"
+	LogSubsystemStore    = "store"
+
+	// Supported log formats
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+var (
+	logSubsystems = []string{
+		LogSubsystemApp,
+		LogSubsystemHTTP,
+		LogSubsystemCORS,
+		LogSubsystemAuth,
+		LogSubsystemDelivery,
+		LogSubsystemStore,
+	}
+	logLevels = newLogLevels()
+	// logFormat is the format in use, switched without rebuilding the loggers
+	// so a reload doesn't race with goroutines that are logging
+	logFormat atomic.Value
+
+	appLog      *slog.Logger
+	httpLog     *slog.Logger
+	corsLog     *slog.Logger
+	authLog     *slog.Logger
+	deliveryLog *slog.Logger
+	storeLog    *slog.Logger
+)
+
+func init() {
+	setupLogging(LogFormatText, NewRedactingWriter(os.Stderr))
+}
+
+func newLogLevels() map[string]*slog.LevelVar {
+	levels := make(map[string]*slog.LevelVar, len(logSubsystems))
+	for _, subsystem := range logSubsystems {
+		levels[subsystem] = new(slog.LevelVar)
+	}
+	return levels
+}
+
+// setupLogging creates the subsystem loggers writing in the given format to out.
+// Levels and format are kept outside the loggers so they can be changed without
+// rebuilding them.
+func setupLogging(format string, out io.Writer) {
+	logFormat.Store(format)
+	newLogger := func(subsystem string) *slog.Logger {
+		opts := &slog.HandlerOptions{
+			Level:       logLevels[subsystem],
+			ReplaceAttr: redactAttr,
+		}
+		handler := formatHandler{
+			text: slog.NewTextHandler(out, opts),
+			json: slog.NewJSONHandler(out, opts),
+		}
+		return slog.New(contextHandler{handler}).With("subsystem", subsystem)
+	}
+
+	appLog = newLogger(LogSubsystemApp)
+	httpLog = newLogger(LogSubsystemHTTP)
+	corsLog = newLogger(LogSubsystemCORS)
+	authLog = newLogger(LogSubsystemAuth)
+	deliveryLog = newLogger(LogSubsystemDelivery)
+	storeLog = newLogger(LogSubsystemStore)
+}
+
+// SetLogOutput makes all subsystem loggers write to out, with secrets redacted.
+// It is meant for hosts and tests that collect the relay's logs; levels and
+// format stay as configured.
+func SetLogOutput(out io.Writer) {
+	setupLogging(logFormat.Load().(string), NewRedactingWriter(out))
+}
+
+// Logger returns the logger of a subsystem, one of the LogSubsystem constants,
+// or nil for an unknown subsystem
+func Logger(subsystem string) *slog.Logger {
+	switch subsystem {
+	case LogSubsystemApp:
+		return appLog
+	case LogSubsystemHTTP:
+		return httpLog
+	case LogSubsystemCORS:
+		return corsLog
+	case LogSubsystemAuth:
+		return authLog
+	case LogSubsystemDelivery:
+		return deliveryLog
+	case LogSubsystemStore:
+		return storeLog
+	}
+	return nil
+}
+
+// initLogging applies the logging configuration of cfg and its redact_keys.
+// Logging is shared by the process, so the configuration loaded last wins.
+// Environment variables take precedence over config.json:
+//   - LOG_FORMAT: text or json
+//   - LOG_LEVEL: default level for all subsystems
+//   - LOG_LEVEL_<SUBSYSTEM>: level for one subsystem, e.g. LOG_LEVEL_CORS=debug
+func initLogging(cfg *Config) error {
+	format := LogFormatText
+	defaultLevel := "info"
+	levels := make(map[string]string)
+
+	if logging := cfg.Logging; logging != nil {
+		if logging.Format != "" {
+			format = logging.Format
+		}
+		if logging.Level != "" {
+			defaultLevel = logging.Level
+		}
+		for subsystem, level := range logging.Levels {
+			levels[strings.ToLower(subsystem)] = level
+		}
+	}
+
+	if env := os.Getenv("LOG_FORMAT"); env != "" {
+		format = env
+	}
+	if env := os.Getenv("LOG_LEVEL"); env != "" {
+		defaultLevel = env
+	}
+	for _, subsystem := range logSubsystems {
+		if env := os.Getenv("LOG_LEVEL_" + strings.ToUpper(subsystem)); env != "" {
+			levels[subsystem] = env
+		}
+	}
+
+	format = strings.ToLower(format)
+	if format != LogFormatText && format != LogFormatJSON {
+		return fmt.Errorf("invalid log format %q: must be text or json", format)
+	}
+
+	for subsystem := range levels {
+		if _, exists := logLevels[subsystem]; !exists {
+			return fmt.Errorf("unknown log subsystem %q", subsystem)
+		}
+	}
+
+	for _, subsystem := range logSubsystems {
+		level := defaultLevel
+		if l, exists := levels[subsystem]; exists {
+			level = l
+		}
+		var parsed slog.Level
+		if err := parsed.UnmarshalText([]byte(level)); err != nil {
+			return fmt.Errorf("invalid log level %q for %s: %v", level, subsystem, err)
+		}
+		logLevels[subsystem].Set(parsed)
+	}
+
+	logFormat.Store(format)
+	setRedactKeys(cfg.RedactKeys)
+	return nil
+}
+
+// contextHandler adds request-scoped attributes, such as the request ID, to log records
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// formatHandler writes records in the format in use
+type formatHandler struct {
+	text slog.Handler
+	json slog.Handler
+}
+
+func (h formatHandler) current() slog.Handler {
+	if logFormat.Load() == LogFormatJSON {
+		return h.json
+	}
+	return h.text
+}
+
+func (h formatHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.current().Enabled(ctx, level)
+}
+
+func (h formatHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.current().Handle(ctx, r)
+}
+
+func (h formatHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return formatHandler{text: h.text.WithAttrs(attrs), json: h.json.WithAttrs(attrs)}
+}
+
+func (h formatHandler) WithGroup(name string) slog.Handler {
+	return formatHandler{text: h.text.WithGroup(name), json: h.json.WithGroup(name)}
+}
+
+// redactAttr redacts attributes whose key marks them as sensitive
+func redactAttr(_ []string, attr slog.Attr) slog.Attr {
+	switch {
+	case attr.Key == "fcm_token":
+		attr.Value = slog.StringValue(redactToken(attr.Value.String()))
+	case isRedactedKey(attr.Key):
+		attr.Value = slog.StringValue(redactedValue)
+	}
+	return attr
+}
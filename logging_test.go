@@ -0,0 +1,173 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"testing"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resetLogging restores default logging after a test changed it
+func resetLogging(t *testing.T) {
+	t.Cleanup(func() {
+		for _, level := range logLevels {
+			level.Set(slog.LevelInfo)
+		}
+		setupLogging(LogFormatText, NewRedactingWriter(os.Stderr))
+	})
+}
+
+// decodeJSONLogLines parses each line of JSON log output
+func decodeJSONLogLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line), "log line must be valid JSON: %s", scanner.Text())
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestInitLogging(t *testing.T) {
+	resetLogging(t)
+
+	tests := []struct {
+		name           string
+		loggingConfig  *LoggingConfig
+		env            map[string]string
+		expectError    bool
+		expectedLevels map[string]slog.Level
+	}{
+		{
+			name: "defaults",
+			expectedLevels: map[string]slog.Level{
+				LogSubsystemApp:      slog.LevelInfo,
+				LogSubsystemDelivery: slog.LevelInfo,
+			},
+		},
+		{
+			name: "config levels",
+			loggingConfig: &LoggingConfig{
+				Format: "json",
+				Level:  "warn",
+				Levels: map[string]string{"CORS": "debug"},
+			},
+			expectedLevels: map[string]slog.Level{
+				LogSubsystemApp:  slog.LevelWarn,
+				LogSubsystemCORS: slog.LevelDebug,
+			},
+		},
+		{
+			name: "env overrides config",
+			loggingConfig: &LoggingConfig{
+				Level:  "warn",
+				Levels: map[string]string{"auth": "error"},
+			},
+			env: map[string]string{
+				"LOG_LEVEL":      "debug",
+				"LOG_LEVEL_AUTH": "info",
+			},
+			expectedLevels: map[string]slog.Level{
+				LogSubsystemApp:  slog.LevelDebug,
+				LogSubsystemAuth: slog.LevelInfo,
+			},
+		},
+		{
+			name:          "invalid format",
+			loggingConfig: &LoggingConfig{Format: "xml"},
+			expectError:   true,
+		},
+		{
+			name:          "invalid level",
+			loggingConfig: &LoggingConfig{Level: "loud"},
+			expectError:   true,
+		},
+		{
+			name:          "unknown subsystem",
+			loggingConfig: &LoggingConfig{Levels: map[string]string{"billing": "debug"}},
+			expectError:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			err := initLogging(&Config{Logging: tt.loggingConfig})
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			for subsystem, level := range tt.expectedLevels {
+				assert.Equal(t, level, logLevels[subsystem].Level(), subsystem)
+			}
+		})
+	}
+}
+
+func TestSubsystemLevels(t *testing.T) {
+	resetLogging(t)
+
+	var buf bytes.Buffer
+	setupLogging(LogFormatJSON, &buf)
+	logLevels[LogSubsystemCORS].Set(slog.LevelWarn)
+	logLevels[LogSubsystemDelivery].Set(slog.LevelDebug)
+
+	corsLog.Info("hidden")
+	corsLog.Warn("shown", "origin", "https://example.com")
+	deliveryLog.Debug("shown", "request_id", "req-1", "project", "p", "site", "s", "user_id", "u")
+
+	lines := decodeJSONLogLines(t, &buf)
+	require.Len(t, lines, 2)
+	assert.Equal(t, "cors", lines[0]["subsystem"])
+	assert.Equal(t, "https://example.com", lines[0]["origin"])
+	assert.Equal(t, "delivery", lines[1]["subsystem"])
+	assert.Equal(t, "req-1", lines[1]["request_id"])
+	assert.Equal(t, "u", lines[1]["user_id"])
+}
+
+func TestRedactAttr(t *testing.T) {
+	resetLogging(t)
+
+	var buf bytes.Buffer
+	setupLogging(LogFormatJSON, &buf)
+
+	appLog.Info("attrs", "fcm_token", testFCMToken, "api_secret", "value", "user_id", "u1")
+
+	lines := decodeJSONLogLines(t, &buf)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "dQw4w9WgXc...", lines[0]["fcm_token"])
+	assert.Equal(t, redactedValue, lines[0]["api_secret"])
+	assert.Equal(t, "u1", lines[0]["user_id"])
+}
+
+func TestLogNotificationSentIsSingleLine(t *testing.T) {
+	resetLogging(t)
+
+	var buf bytes.Buffer
+	setupLogging(LogFormatJSON, &buf)
+	logLevels[LogSubsystemDelivery].Set(slog.LevelDebug)
+
+	logNotificationSent(context.Background(), "topic", "news", &messaging.Message{
+		Topic: "news",
+		Data:  map[string]string{"body": "line one\nline two"},
+	})
+
+	lines := decodeJSONLogLines(t, &buf)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "news", lines[0]["recipient"])
+	message, ok := lines[0]["message"].(map[string]interface{})
+	require.True(t, ok, "message must be logged as a JSON object")
+	assert.Equal(t, "news", message["topic"])
+}
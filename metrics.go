@@ -0,0 +1,160 @@
+package relay
+
+import (
+	"time"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+
+	"github.com/your-username/notification-relay/delivery"
+)
+
+const (
+	metricsNamespace = "notification_relay"
+
+	// Notification types used as metric labels
+	notificationTypeUser  = "user"
+	notificationTypeTopic = "topic"
+
+	// Results used as metric labels
+	resultSuccess = "success"
+	resultFailure = "failure"
+)
+
+var (
+	usersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "users"),
+		"Users with at least one registered device per project key.",
+		[]string{"key"}, nil)
+
+	devicesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "devices"),
+		"Registered device tokens per project key.",
+		[]string{"key"}, nil)
+)
+
+// serverMetrics are the metrics of a server, kept in a registry of its own so
+// servers in one process don't share counters
+type serverMetrics struct {
+	// registry holds the server's metrics plus the Go runtime and process collectors
+	registry *prometheus.Registry
+
+	fcmSendsTotal             *prometheus.CounterVec
+	fcmSendDuration           *prometheus.HistogramVec
+	invalidTokensRemovedTotal *prometheus.CounterVec
+	tokenRegistrationsTotal   *prometheus.CounterVec
+	topicSubscriptionsTotal   *prometheus.CounterVec
+}
+
+func newServerMetrics(s *Server) *serverMetrics {
+	m := &serverMetrics{
+		registry: prometheus.NewRegistry(),
+
+		fcmSendsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "fcm_sends_total",
+			Help:      "FCM send attempts by project, notification type, result and FCM error code.",
+		}, []string{"project", "type", "result", "error_code"}),
+
+		fcmSendDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "fcm_send_duration_seconds",
+			Help:      "Latency of FCM send calls by notification type.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"type"}),
+
+		invalidTokensRemovedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "invalid_tokens_removed_total",
+			Help:      "FCM tokens removed after FCM reported them as invalid.",
+		}, []string{"project"}),
+
+		tokenRegistrationsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "token_registrations_total",
+			Help:      "Token add and remove requests by project, action and result.",
+		}, []string{"project", "action", "result"}),
+
+		topicSubscriptionsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "topic_subscriptions_total",
+			Help:      "Topic subscribe and unsubscribe results per token.",
+		}, []string{"project", "action", "result"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.fcmSendsTotal,
+		m.fcmSendDuration,
+		m.invalidTokensRemovedTotal,
+		m.tokenRegistrationsTotal,
+		m.topicSubscriptionsTotal,
+		deviceMapCollector{s},
+	)
+	return m
+}
+
+// deviceMapCollector reports user and device gauges per project key from the
+// user device map of a server at scrape time
+type deviceMapCollector struct {
+	s *Server
+}
+
+func (deviceMapCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- usersDesc
+	ch <- devicesDesc
+}
+
+func (c deviceMapCollector) Collect(ch chan<- prometheus.Metric) {
+	c.s.devicesMu.RLock()
+	sites := c.s.devices.CountSites()
+	c.s.devicesMu.RUnlock()
+
+	for key, count := range sites {
+		ch <- prometheus.MustNewConstMetric(usersDesc, prometheus.GaugeValue, float64(count.Users), key)
+		ch <- prometheus.MustNewConstMetric(devicesDesc, prometheus.GaugeValue, float64(count.Tokens), key)
+	}
+}
+
+// observeFCMSend records the result and latency of a single FCM send call
+func (m *serverMetrics) observeFCMSend(projectName, notificationType string, start time.Time, err error) {
+	m.fcmSendDuration.WithLabelValues(notificationType).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.fcmSendsTotal.WithLabelValues(projectName, notificationType, resultFailure, fcmErrorCode(err)).Inc()
+		return
+	}
+	m.fcmSendsTotal.WithLabelValues(projectName, notificationType, resultSuccess, "").Inc()
+}
+
+// observeTopicSubscription records per-token results of a topic (un)subscription
+func (m *serverMetrics) observeTopicSubscription(projectName, action string, response *messaging.TopicManagementResponse, err error) {
+	if err != nil || response == nil {
+		m.topicSubscriptionsTotal.WithLabelValues(projectName, action, resultFailure).Inc()
+		return
+	}
+	m.topicSubscriptionsTotal.WithLabelValues(projectName, action, resultSuccess).Add(float64(response.SuccessCount))
+	m.topicSubscriptionsTotal.WithLabelValues(projectName, action, resultFailure).Add(float64(response.FailureCount))
+}
+
+// fcmErrorCode maps an FCM error to a short, bounded label value
+func fcmErrorCode(err error) string {
+	switch {
+	case messaging.IsUnregistered(err), delivery.IsInvalidTokenError(err):
+		return "unregistered"
+	case messaging.IsInvalidArgument(err):
+		return "invalid_argument"
+	case messaging.IsSenderIDMismatch(err):
+		return "sender_id_mismatch"
+	case messaging.IsQuotaExceeded(err):
+		return "quota_exceeded"
+	case messaging.IsThirdPartyAuthError(err):
+		return "third_party_auth_error"
+	case messaging.IsUnavailable(err):
+		return "unavailable"
+	case messaging.IsInternal(err):
+		return "internal"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,89 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/your-username/notification-relay/mocks"
+)
+
+func TestMetricsDuringRegistrations(t *testing.T) {
+	s, _ := setupTestEnvironment(t)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			_, err := s.AddToken(context.Background(), "test_project", "test_site", fmt.Sprintf("user%d", i), "token")
+			assert.NoError(t, err)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		_, err := s.Metrics().Gather()
+		require.NoError(t, err)
+	}
+	<-done
+}
+
+func TestSendMetrics(t *testing.T) {
+	s, _ := setupTestEnvironment(t)
+	mockClient := &mocks.MockFirebaseMessagingClient{}
+	s.client = mockClient
+
+	key := "test_project_test_site"
+	s.devices[key] = map[string][]string{"test_user": {"good-token", "stale-token"}}
+
+	mockClient.On("Send", mock.Anything, mock.MatchedBy(func(m *messaging.Message) bool {
+		return m.Token == "good-token"
+	})).Return("projects/p/messages/1", nil)
+	mockClient.On("Send", mock.Anything, mock.MatchedBy(func(m *messaging.Message) bool {
+		return m.Token == "stale-token"
+	})).Return("", errors.New("registration-token-not-registered"))
+
+	_, err := s.NotifyUser(context.Background(), NotificationRequest{
+		ProjectName: "test_project",
+		SiteName:    "test_site",
+		UserID:      "test_user",
+		Title:       "Title",
+		Body:        "Body",
+	})
+	require.NoError(t, err)
+
+	// Every server counts in a registry of its own
+	assert.Equal(t, 1.0, testutil.ToFloat64(s.metrics.fcmSendsTotal.WithLabelValues("test_project", notificationTypeUser, resultSuccess, "")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(s.metrics.fcmSendsTotal.WithLabelValues("test_project", notificationTypeUser, resultFailure, "unregistered")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(s.metrics.invalidTokensRemovedTotal.WithLabelValues("test_project")))
+}
+
+func TestTokenRegistrationMetrics(t *testing.T) {
+	s, _ := setupTestEnvironment(t)
+
+	for i := 0; i < 2; i++ {
+		_, err := s.AddToken(context.Background(), "test_project", "test_site", "test_user", "new-token")
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(s.metrics.tokenRegistrationsTotal.WithLabelValues("test_project", "add", resultSuccess)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(s.metrics.tokenRegistrationsTotal.WithLabelValues("test_project", "add", "duplicate")))
+}
+
+func TestFCMErrorCode(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{errors.New("Requested entity was not found."), "unregistered"},
+		{errors.New("something else"), "unknown"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, fcmErrorCode(tt.err), tt.err.Error())
+	}
+}
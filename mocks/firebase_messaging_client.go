@@ -7,7 +7,7 @@ import (
 	"github.com/stretchr/testify/mock"
 )
 
-// MockFirebaseMessagingClient is a mock implementation of FirebaseMessagingClient
+// MockFirebaseMessagingClient is a mock implementation of delivery.Client
 type MockFirebaseMessagingClient struct {
 	mock.Mock
 }
@@ -18,6 +18,22 @@ func (m *MockFirebaseMessagingClient) Send(ctx context.Context, message *messagi
 	return args.String(0), args.Error(1)
 }
 
+// SendEach sends a batch of messages via Firebase Cloud Messaging
+func (m *MockFirebaseMessagingClient) SendEach(ctx context.Context, messages []*messaging.Message) (*messaging.BatchResponse, error) {
+	args := m.Called(ctx, messages)
+	if respond, ok := args.Get(0).(func(context.Context, []*messaging.Message) *messaging.BatchResponse); ok {
+		return respond(ctx, messages), args.Error(1)
+	}
+	response, _ := args.Get(0).(*messaging.BatchResponse)
+	return response, args.Error(1)
+}
+
+// SendDryRun validates a message without delivering it
+func (m *MockFirebaseMessagingClient) SendDryRun(ctx context.Context, message *messaging.Message) (string, error) {
+	args := m.Called(ctx, message)
+	return args.String(0), args.Error(1)
+}
+
 // SubscribeToTopic subscribes tokens to a topic
 func (m *MockFirebaseMessagingClient) SubscribeToTopic(ctx context.Context, tokens []string, topic string) (*messaging.TopicManagementResponse, error) {
 	args := m.Called(ctx, tokens, topic)
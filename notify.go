@@ -0,0 +1,265 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"firebase.google.com/go/v4/messaging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/your-username/notification-relay/delivery"
+	"github.com/your-username/notification-relay/store"
+)
+
+// removeInvalidToken removes an invalid token from the user's device map and
+// the topic subscriptions of the user
+func (s *Server) removeInvalidToken(ctx context.Context, key, userID, invalidToken string) {
+	s.devicesMu.Lock()
+	removed := s.devices.Remove(key, userID, func(token string) bool { return token == invalidToken })
+	var err error
+	if removed > 0 {
+		err = s.saveJSON(UserDeviceMapJSON, s.devices)
+	}
+	s.devicesMu.Unlock()
+	if removed == 0 {
+		return
+	}
+
+	if err != nil {
+		storeLog.ErrorContext(ctx, "Failed to save user device map after removing invalid token",
+			"key", key, "user_id", userID, "error", err)
+	} else {
+		storeLog.InfoContext(ctx, "Removed invalid token", "key", key, "user_id", userID, "fcm_token", invalidToken)
+	}
+
+	// FCM drops invalid tokens from its topics itself
+	s.dropDeviceTopics(ctx, key, userID, invalidToken)
+}
+
+// projectOfKey returns the project of a site key. Key format is
+// "projectName_siteName" and project names may contain underscores, so the
+// shortest prefix naming a configured project wins. Falls back to the first part.
+func (s *Server) projectOfKey(key string) string {
+	parts := strings.Split(key, "_")
+	for i := 1; i < len(parts); i++ {
+		candidate := strings.Join(parts[:i], "_")
+		if _, exists := s.currentConfig().Projects[candidate]; exists {
+			return candidate
+		}
+	}
+	return parts[0]
+}
+
+// Tokens returns the device tokens registered for a user
+func (s *Server) Tokens(projectName, siteName, userID string) []string {
+	return s.deviceTokens(store.Key(projectName, siteName), userID)
+}
+
+// deviceTokens returns the tokens of a user. The slice is never modified in
+// place, so it can be used after the lock is released.
+func (s *Server) deviceTokens(key, userID string) []string {
+	s.devicesMu.RLock()
+	defer s.devicesMu.RUnlock()
+	return s.devices.Tokens(key, userID)
+}
+
+// getUserTokens retrieves the user's tokens
+func (s *Server) getUserTokens(key, userID string) ([]string, error) {
+	if !strings.Contains(key, "_") {
+		return nil, newOpError(http.StatusNotFound, "invalid key format: %s", key)
+	}
+
+	// Validate project exists
+	projectName := s.projectOfKey(key)
+	if _, exists := s.currentConfig().Projects[projectName]; !exists {
+		return nil, newOpError(http.StatusNotFound, "project %s not found", projectName)
+	}
+
+	tokens := s.deviceTokens(key, userID)
+	if len(tokens) == 0 {
+		return nil, newOpError(http.StatusNotFound, "user %s not subscribed to push notifications", userID)
+	}
+	return tokens, nil
+}
+
+// ValidateNotification checks the required parameters for a notification
+func ValidateNotification(title, body string) error {
+	if title == "" {
+		return fmt.Errorf("title is required")
+	}
+	if body == "" {
+		return fmt.Errorf("body is required")
+	}
+	return nil
+}
+
+// applyDecorations applies decorations to the notification title based on project settings
+func (s *Server) applyDecorations(key, title string) string {
+	for _, decoration := range s.currentState().decorations[key] {
+		decorated, matched, err := decoration.Apply(title)
+		if err != nil {
+			deliveryLog.Warn("Invalid decoration pattern", "key", key, "pattern", decoration.Pattern, "error", err)
+			continue
+		}
+		if matched {
+			return decorated
+		}
+	}
+	return title
+}
+
+// addIconToConfig adds the project icon to the webpush configuration
+func (s *Server) addIconToConfig(key string, webpushConfig *messaging.WebpushConfig) {
+	if iconPath, exists := s.currentState().icons[key]; exists {
+		if webpushConfig.Data == nil {
+			webpushConfig.Data = make(map[string]string)
+		}
+		webpushConfig.Data["icon"] = iconPath
+	}
+}
+
+// applyTopicDecorations applies decorations to the notification title based on topic
+func (s *Server) applyTopicDecorations(topic, title string) string {
+	decoration, exists := s.currentState().topicDecorations[topic]
+	if !exists {
+		return title
+	}
+	decorated, _, err := decoration.Apply(title)
+	if err != nil {
+		deliveryLog.Warn("Invalid topic decoration pattern", "topic", topic, "pattern", decoration.Pattern, "error", err)
+	}
+	return decorated
+}
+
+// prepareWebPushConfig creates a web push notification configuration.
+// Applies decorations to the title, adds icon, and processes additional data.
+// If topic is provided, applies topic-specific decorations instead of project decorations.
+// Returns the webpush config and the converted data map (with HTTPS URLs).
+func (s *Server) prepareWebPushConfig(key, title, body, data, topic string) (*messaging.WebpushConfig, map[string]interface{}, error) {
+	// Apply decorations based on whether it's a topic notification or not
+	var decoratedTitle string
+	if topic != "" {
+		decoratedTitle = s.applyTopicDecorations(topic, title)
+	} else {
+		decoratedTitle = s.applyDecorations(key, title)
+	}
+
+	dataMap, err := delivery.ParseData(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	webpushConfig := delivery.WebPushConfig(decoratedTitle, body, dataMap)
+
+	// Topic notifications take the icon from data, user notifications use the project icon
+	if topic != "" {
+		if icon, ok := dataMap["icon"].(string); ok {
+			webpushConfig.Data = map[string]string{"icon": icon}
+		}
+	} else {
+		s.addIconToConfig(key, webpushConfig)
+	}
+
+	return webpushConfig, dataMap, nil
+}
+
+// Add logging helpers
+func logNotificationSent(ctx context.Context, messageType, recipient string, message *messaging.Message) {
+	// Log the message as a single JSON value so it stays on one line
+	msgBytes, err := json.Marshal(redactMessage(message))
+	if err != nil {
+		return
+	}
+	deliveryLog.DebugContext(ctx, "Sending notification",
+		"type", messageType,
+		"recipient", recipient,
+		"message", json.RawMessage(msgBytes))
+}
+
+func logNotificationResponse(ctx context.Context, messageType, recipient, response string) {
+	deliveryLog.InfoContext(ctx, "Notification sent", "type", messageType, "recipient", recipient, "fcm_message_id", response)
+}
+
+// sendFCMMessage sends a message to FCM inside a client span and records send metrics
+func (s *Server) sendFCMMessage(ctx context.Context, projectName, notificationType string, message *messaging.Message) (string, error) {
+	attrs := []attribute.KeyValue{
+		attribute.String("relay.project", projectName),
+		attribute.String("relay.notification_type", notificationType),
+	}
+	if message.Token != "" {
+		attrs = append(attrs, attribute.String("fcm.token", redactToken(message.Token)))
+	}
+	if message.Topic != "" {
+		attrs = append(attrs, attribute.String("fcm.topic", message.Topic))
+	}
+	done, err := s.deliveries.startIn(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer done()
+	ctx, span := s.tracer().Start(ctx, "fcm.send", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+
+	start := time.Now()
+	response, err := s.client.Send(ctx, message)
+	s.metrics.observeFCMSend(projectName, notificationType, start, err)
+
+	if err != nil {
+		span.SetAttributes(attribute.String("fcm.error_code", fcmErrorCode(err)))
+	} else {
+		span.SetAttributes(attribute.String("fcm.message_id", response))
+	}
+	endSpan(span, err)
+	return response, err
+}
+
+// sendFCMBatch sends up to delivery.MaxBatchSize messages to FCM inside a client
+// span and records send metrics for each message. The responses are in the order
+// of the messages; an error means none of them was sent.
+func (s *Server) sendFCMBatch(ctx context.Context, projectName, notificationType string, messages []*messaging.Message) ([]*messaging.SendResponse, error) {
+	done, err := s.deliveries.startIn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	ctx, span := s.tracer().Start(ctx, "fcm.send_each", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("relay.project", projectName),
+		attribute.String("relay.notification_type", notificationType),
+		attribute.Int("relay.message_count", len(messages)),
+	))
+
+	start := time.Now()
+	response, err := s.client.SendEach(ctx, messages)
+	if err == nil && response == nil {
+		err = errors.New("FCM returned no batch response")
+	} else if err == nil && len(response.Responses) != len(messages) {
+		err = fmt.Errorf("FCM returned %d responses for %d messages", len(response.Responses), len(messages))
+	}
+	if err != nil {
+		for range messages {
+			s.metrics.observeFCMSend(projectName, notificationType, start, err)
+		}
+		span.SetAttributes(attribute.String("fcm.error_code", fcmErrorCode(err)))
+		endSpan(span, err)
+		return nil, err
+	}
+
+	for _, r := range response.Responses {
+		s.metrics.observeFCMSend(projectName, notificationType, start, r.Error)
+	}
+	span.SetAttributes(attribute.Int("fcm.success_count", response.SuccessCount), attribute.Int("fcm.failure_count", response.FailureCount))
+	endSpan(span, nil)
+	return response.Responses, nil
+}
+
+// ValidateProject returns a 404 error when the project is not configured
+func (s *Server) ValidateProject(projectName string) error {
+	if _, exists := s.currentConfig().Projects[projectName]; !exists {
+		return newOpError(http.StatusNotFound, "project %s not found", projectName)
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+package relay
+
+import (
+	"fmt"
+	"testing"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/your-username/notification-relay/delivery"
+)
+
+var errInvalidToken = fmt.Errorf("invalid registration token")
+
+func TestValidateNotification(t *testing.T) {
+	tests := []struct {
+		name        string
+		title       string
+		body        string
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:        "valid params",
+			title:       "Test Title",
+			body:        "Test Body",
+			expectError: false,
+		},
+		{
+			name:        "missing title",
+			title:       "",
+			body:        "Test Body",
+			expectError: true,
+			errorMsg:    "title is required",
+		},
+		{
+			name:        "missing body",
+			title:       "Test Title",
+			body:        "",
+			expectError: true,
+			errorMsg:    "body is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateNotification(tt.title, tt.body)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Equal(t, tt.errorMsg, err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPrepareWebPushConfig(t *testing.T) {
+	s, _ := setupTestEnvironment(t)
+
+	tests := []struct {
+		name           string
+		key            string
+		title          string
+		body           string
+		data           string
+		setupData      func()
+		expectError    bool
+		validateConfig func(*testing.T, *messaging.WebpushConfig)
+	}{
+		{
+			name:  "basic config",
+			key:   "test_project",
+			title: "Test Title",
+			body:  "Test Body",
+			data:  "",
+			validateConfig: func(t *testing.T, webpushConfig *messaging.WebpushConfig) {
+				assert.Equal(t, "Test Title", webpushConfig.Notification.Title)
+				assert.Equal(t, "Test Body", webpushConfig.Notification.Body)
+			},
+		},
+		{
+			name:  "with click action",
+			key:   "test_project",
+			title: "Test Title",
+			body:  "Test Body",
+			data:  `{"click_action": "https://example.com"}`,
+			validateConfig: func(t *testing.T, webpushConfig *messaging.WebpushConfig) {
+				assert.Equal(t, "https://example.com", webpushConfig.FCMOptions.Link)
+			},
+		},
+		{
+			name:        "invalid data json",
+			key:         "test_project",
+			title:       "Test Title",
+			body:        "Test Body",
+			data:        "invalid json",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setupData != nil {
+				tt.setupData()
+			}
+
+			webpushConfig, _, err := s.prepareWebPushConfig(tt.key, tt.title, tt.body, tt.data, "")
+
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.NotNil(t, webpushConfig)
+			if tt.validateConfig != nil {
+				tt.validateConfig(t, webpushConfig)
+			}
+		})
+	}
+}
+
+func TestApplyDecorations(t *testing.T) {
+	tests := []struct {
+		name             string
+		key              string
+		title            string
+		setupDecorations func(s *Server)
+		expected         string
+	}{
+		{
+			name:  "no decorations",
+			key:   "test_project",
+			title: "Test Title",
+			setupDecorations: func(s *Server) {
+				s.updateState(func(next *reloadableState) { next.decorations = make(map[string]map[string]delivery.Decoration) })
+			},
+			expected: "Test Title",
+		},
+		{
+			name:  "matching decoration",
+			key:   "test_project",
+			title: "Alert: Test Message",
+			setupDecorations: func(s *Server) {
+				s.updateState(func(next *reloadableState) {
+					next.decorations = map[string]map[string]delivery.Decoration{
+						"test_project": {
+							"alert": {
+								Pattern:  "^Alert:",
+								Template: "🚨 {title}",
+							},
+						},
+					}
+				})
+			},
+			expected: "🚨 Alert: Test Message",
+		},
+		{
+			name:  "non-matching decoration",
+			key:   "test_project",
+			title: "Normal Message",
+			setupDecorations: func(s *Server) {
+				s.updateState(func(next *reloadableState) {
+					next.decorations = map[string]map[string]delivery.Decoration{
+						"test_project": {
+							"alert": {
+								Pattern:  "^Alert:",
+								Template: "🚨 {title}",
+							},
+						},
+					}
+				})
+			},
+			expected: "Normal Message",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := setupTestEnvironment(t)
+			tt.setupDecorations(s)
+			result := s.applyDecorations(tt.key, tt.title)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestAddIconToConfig(t *testing.T) {
+	tests := []struct {
+		name           string
+		key            string
+		setupIcons     func(s *Server)
+		validateConfig func(*testing.T, *messaging.WebpushConfig)
+	}{
+		{
+			name: "add icon when available",
+			key:  "test_project",
+			setupIcons: func(s *Server) {
+				s.updateState(func(next *reloadableState) {
+					next.icons = map[string]string{
+						"test_project": "/path/to/icon.png",
+					}
+				})
+			},
+			validateConfig: func(t *testing.T, webpushConfig *messaging.WebpushConfig) {
+				assert.Equal(t, "/path/to/icon.png", webpushConfig.Data["icon"])
+			},
+		},
+		{
+			name: "no icon available",
+			key:  "test_project",
+			setupIcons: func(s *Server) {
+				s.updateState(func(next *reloadableState) { next.icons = make(map[string]string) })
+			},
+			validateConfig: func(t *testing.T, webpushConfig *messaging.WebpushConfig) {
+				assert.Nil(t, webpushConfig.Data)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := setupTestEnvironment(t)
+			tt.setupIcons(s)
+			webpushConfig := &messaging.WebpushConfig{}
+			s.addIconToConfig(tt.key, webpushConfig)
+			tt.validateConfig(t, webpushConfig)
+		})
+	}
+}
+
+func TestApplyTopicDecorations(t *testing.T) {
+	tests := []struct {
+		name             string
+		topic            string
+		title            string
+		setupDecorations func(s *Server)
+		expected         string
+	}{
+		{
+			name:  "no decorations",
+			topic: "test_topic",
+			title: "Test Title",
+			setupDecorations: func(s *Server) {
+				s.updateState(func(next *reloadableState) { next.topicDecorations = make(map[string]delivery.TopicDecoration) })
+			},
+			expected: "Test Title",
+		},
+		{
+			name:  "matching decoration",
+			topic: "test_topic",
+			title: "Alert: Test Message",
+			setupDecorations: func(s *Server) {
+				s.updateState(func(next *reloadableState) {
+					next.topicDecorations = map[string]delivery.TopicDecoration{
+						"test_topic": {
+							Pattern:  "^Alert:",
+							Template: "📢 {title}",
+						},
+					}
+				})
+			},
+			expected: "📢 Alert: Test Message",
+		},
+		{
+			name:  "non-matching decoration",
+			topic: "test_topic",
+			title: "Normal Message",
+			setupDecorations: func(s *Server) {
+				s.updateState(func(next *reloadableState) {
+					next.topicDecorations = map[string]delivery.TopicDecoration{
+						"test_topic": {
+							Pattern:  "^Alert:",
+							Template: "📢 {title}",
+						},
+					}
+				})
+			},
+			expected: "Normal Message",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := setupTestEnvironment(t)
+			tt.setupDecorations(s)
+			result := s.applyTopicDecorations(tt.topic, tt.title)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestGetUserTokens(t *testing.T) {
+	s, _ := setupTestEnvironment(t)
+
+	tests := []struct {
+		name        string
+		key         string
+		userID      string
+		setupMap    func()
+		expectError bool
+		expected    []string
+	}{
+		{
+			name:   "existing user with tokens",
+			key:    "test_project_site",
+			userID: "test_user",
+			setupMap: func() {
+				s.devices = map[string]map[string][]string{
+					"test_project_site": {
+						"test_user": {"token1", "token2"},
+					},
+				}
+			},
+			expected: []string{"token1", "token2"},
+		},
+		{
+			name:   "user with no tokens",
+			key:    "test_project_site",
+			userID: "test_user",
+			setupMap: func() {
+				s.devices = map[string]map[string][]string{
+					"test_project_site": {
+						"test_user": {},
+					},
+				}
+			},
+			expectError: true,
+		},
+		{
+			name:   "nonexistent user",
+			key:    "test_project_site",
+			userID: "nonexistent_user",
+			setupMap: func() {
+				s.devices = map[string]map[string][]string{
+					"test_project_site": {},
+				}
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setupMap()
+
+			tokens, err := s.getUserTokens(tt.key, tt.userID)
+
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "not subscribed to push notifications")
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, tokens)
+			}
+		})
+	}
+}
@@ -0,0 +1,528 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"firebase.google.com/go/v4/messaging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/your-username/notification-relay/delivery"
+	"github.com/your-username/notification-relay/store"
+)
+
+// The operations in this file are shared by the Frappe-compatible API and the
+// v2 API in httpapi. They return errors instead of writing responses, so each
+// API can keep its own response format.
+
+// opError is an error from an operation that maps to an HTTP status
+type opError struct {
+	status  int
+	message string
+}
+
+func (e *opError) Error() string {
+	return e.message
+}
+
+// newOpError creates an opError with a formatted message
+func newOpError(status int, format string, args ...interface{}) error {
+	return &opError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// ErrorStatus returns the HTTP status of an error returned by a Server method,
+// or fallback when err carries none, such as an FCM error
+func ErrorStatus(err error, fallback int) int {
+	var opErr *opError
+	if errors.As(err, &opErr) {
+		return opErr.status
+	}
+	return fallback
+}
+
+// AddToken registers a token for a user and saves the device map.
+// It reports false when the user already has the token. New tokens are
+// subscribed to the topics the user is subscribed to.
+func (s *Server) AddToken(ctx context.Context, projectName, siteName, userID, token string) (bool, error) {
+	key := store.Key(projectName, siteName)
+	s.devicesMu.Lock()
+	added := s.devices.Add(key, userID, token)
+	var err error
+	if added {
+		err = s.saveJSON(UserDeviceMapJSON, s.devices)
+	}
+	s.devicesMu.Unlock()
+
+	if !added {
+		s.metrics.tokenRegistrationsTotal.WithLabelValues(projectName, "add", "duplicate").Inc()
+		return false, nil
+	}
+	if err != nil {
+		s.metrics.tokenRegistrationsTotal.WithLabelValues(projectName, "add", resultFailure).Inc()
+		return false, newOpError(http.StatusInternalServerError, "Failed to save user device map: %v", err)
+	}
+	s.metrics.tokenRegistrationsTotal.WithLabelValues(projectName, "add", resultSuccess).Inc()
+	s.subscribeDeviceTopics(ctx, projectName, siteName, userID, token)
+	return true, nil
+}
+
+// RemoveToken removes a token of a user and saves the device map.
+// It reports false when the user doesn't have the token. The token is
+// unsubscribed from the topics of the user.
+func (s *Server) RemoveToken(ctx context.Context, projectName, siteName, userID, token string) (bool, error) {
+	key := store.Key(projectName, siteName)
+	s.devicesMu.Lock()
+	removed := s.devices.Remove(key, userID, func(t string) bool { return t == token }) > 0
+	var err error
+	if removed {
+		err = s.saveJSON(UserDeviceMapJSON, s.devices)
+	}
+	s.devicesMu.Unlock()
+
+	if !removed {
+		s.metrics.tokenRegistrationsTotal.WithLabelValues(projectName, "remove", "not_found").Inc()
+		return false, nil
+	}
+	if err != nil {
+		s.metrics.tokenRegistrationsTotal.WithLabelValues(projectName, "remove", resultFailure).Inc()
+		return false, newOpError(http.StatusInternalServerError, "Failed to save user device map: %v", err)
+	}
+	s.metrics.tokenRegistrationsTotal.WithLabelValues(projectName, "remove", resultSuccess).Inc()
+	s.unsubscribeDeviceTopics(ctx, projectName, siteName, userID, token)
+	return true, nil
+}
+
+// updateTopicSubscription subscribes or unsubscribes tokens to the FCM topic a
+// topic of a site maps to. The timeout is detached from the request so a
+// disconnecting caller doesn't leave a half-applied subscription.
+func (s *Server) updateTopicSubscription(ctx context.Context, projectName, siteName, topic string, tokens []string, subscribe bool) (*messaging.TopicManagementResponse, error) {
+	topic = s.fcmTopic(projectName, siteName, topic)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	operation := "unsubscribe"
+	update := s.client.UnsubscribeFromTopic
+	if subscribe {
+		operation = "subscribe"
+		update = s.client.SubscribeToTopic
+	}
+
+	ctx, span := s.startSpan(ctx, "fcm."+operation,
+		attribute.String("fcm.topic", topic), attribute.Int("relay.token_count", len(tokens)))
+	response, err := update(ctx, tokens, topic)
+	endSpan(span, err)
+	s.metrics.observeTopicSubscription(projectName, operation, response, err)
+	return response, err
+}
+
+// topicRetries is how often tokens failing a topic subscription change with a
+// transient error are tried again
+const topicRetries = 2
+
+// topicRetryDelay is the pause before the first retry, doubled for each retry
+var topicRetryDelay = 500 * time.Millisecond
+
+// manageTopic subscribes or unsubscribes the tokens of a user to a topic and
+// reports the outcome for each token. Tokens FCM reports as invalid are
+// removed from the user, tokens failing with a transient error are retried.
+// An error is returned only when the first FCM call fails as a whole. Otherwise
+// a subscribing user is recorded even if every token failed, so devices the
+// user adds later are subscribed to the topic. Unsubscribing a user without
+// tokens only drops the user's subscription from the registry.
+func (s *Server) manageTopic(ctx context.Context, projectName, siteName, userID, topic string, tokens []string, subscribe bool) (TopicSubscription, error) {
+	key := store.Key(projectName, siteName)
+	outcomes := make(map[string]TopicTokenResult, len(tokens))
+	pending := tokens
+retries:
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt > 0 {
+			// Stop waiting when the caller is gone
+			select {
+			case <-time.After(topicRetryDelay << (attempt - 1)):
+			case <-ctx.Done():
+				deliveryLog.WarnContext(ctx, "Stopped retrying topic subscription change",
+					"key", key, "user_id", userID, "topic", topic, "attempt", attempt, "error", ctx.Err())
+				break retries
+			}
+		}
+
+		response, err := s.updateTopicSubscription(ctx, projectName, siteName, topic, pending, subscribe)
+		if err != nil {
+			if attempt == 0 {
+				return TopicSubscription{}, err
+			}
+			// Tokens still pending keep the reason of their last failure
+			deliveryLog.WarnContext(ctx, "Failed to retry topic subscription change",
+				"key", key, "user_id", userID, "topic", topic, "attempt", attempt, "error", err)
+			break
+		}
+
+		failures := make(map[int]string, len(response.Errors))
+		for _, info := range response.Errors {
+			failures[info.Index] = info.Reason
+		}
+		var retry []string
+		for i, token := range pending {
+			reason, failed := failures[i]
+			switch {
+			case !failed:
+				outcomes[token] = TopicTokenResult{Device: DeviceID(token), Success: true}
+			case delivery.IsInvalidTopicToken(reason):
+				s.removeInvalidToken(ctx, key, userID, token)
+				s.metrics.invalidTokensRemovedTotal.WithLabelValues(projectName).Inc()
+				outcomes[token] = TopicTokenResult{Device: DeviceID(token), Error: reason, Removed: true}
+			default:
+				outcomes[token] = TopicTokenResult{Device: DeviceID(token), Error: reason}
+				if delivery.IsTransientTopicError(reason) {
+					retry = append(retry, token)
+				}
+			}
+		}
+		if attempt == topicRetries {
+			break
+		}
+		pending = retry
+	}
+
+	result := TopicSubscription{Topic: topic, Results: make([]TopicTokenResult, 0, len(tokens))}
+	var succeeded []string
+	for _, token := range tokens {
+		outcome := outcomes[token]
+		if outcome.Success {
+			result.SuccessCount++
+			succeeded = append(succeeded, token)
+		} else {
+			result.FailureCount++
+		}
+		result.Results = append(result.Results, outcome)
+	}
+
+	if subscribe {
+		s.recordTopicSubscription(ctx, key, userID, topic, succeeded, true)
+	} else {
+		s.recordTopicSubscription(ctx, key, userID, topic, nil, false)
+	}
+	return result, nil
+}
+
+// NotifyUser sends a notification to every device of the user in req and
+// removes tokens FCM reports as invalid. Failing to reach any device is not an
+// error; callers decide from the returned counts.
+func (s *Server) NotifyUser(ctx context.Context, req NotificationRequest) (DeliveryResult, error) {
+	logger := deliveryLog.With(
+		"project", req.ProjectName,
+		"site", req.SiteName,
+		"user_id", req.UserID)
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		logger = logger.With("trace_id", spanContext.TraceID().String())
+	}
+	return s.deliverToUser(ctx, req, logger)
+}
+
+// deliverToUser is NotifyUser logging to logger
+func (s *Server) deliverToUser(ctx context.Context, req NotificationRequest, logger *slog.Logger) (DeliveryResult, error) {
+	var result DeliveryResult
+	projectName := req.ProjectName
+	key := store.Key(req.ProjectName, req.SiteName)
+	userID := req.UserID
+	data := string(req.Data)
+
+	// Get user's tokens
+	_, span := s.startSpan(ctx, "store.get_user_tokens", attribute.String("relay.key", key))
+	tokens, err := s.getUserTokens(key, userID)
+	span.SetAttributes(attribute.Int("relay.token_count", len(tokens)))
+	endSpan(span, err)
+	if err != nil {
+		return result, err
+	}
+
+	webpushConfig, deduplicationID, err := s.userWebpushConfig(ctx, key, req.Title, req.Body, data, logger)
+	if err != nil {
+		return result, err
+	}
+
+	// Send notification to all user tokens. The timeout is detached from the
+	// request so a disconnecting caller doesn't abort the remaining sends.
+	sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	logger.InfoContext(ctx, "Sending user notification", "token_count", len(tokens))
+	for i, token := range tokens {
+		message := userMessage(token, req.Title, req.Body, webpushConfig)
+
+		logger.DebugContext(ctx, "Sending notification to token",
+			"attempt", i+1,
+			"token_count", len(tokens),
+			"fcm_token", token,
+			"deduplication_id", deduplicationID)
+
+		response, err := s.sendFCMMessage(sendCtx, projectName, notificationTypeUser, message)
+		if err != nil {
+			logger.WarnContext(ctx, "Failed to send notification", "fcm_token", token, "error", err)
+			result.Failed++
+
+			// If token is invalid, remove it from user's device map
+			if delivery.IsInvalidTokenError(err) {
+				logger.InfoContext(ctx, "Token is invalid, removing from user device map", "fcm_token", token)
+				_, span = s.startSpan(ctx, "store.remove_invalid_token", attribute.String("relay.key", key))
+				s.removeInvalidToken(ctx, key, userID, token)
+				span.End()
+				s.metrics.invalidTokensRemovedTotal.WithLabelValues(projectName).Inc()
+				result.Removed++
+			}
+			continue
+		}
+
+		logger.DebugContext(ctx, "Notification sent", "fcm_token", token, "fcm_message_id", response)
+		result.Sent++
+	}
+	logger.InfoContext(ctx, "User notification completed", "sent", result.Sent, "token_count", len(tokens))
+	return result, nil
+}
+
+// maxBulkUsers limits the users of one bulk send
+const maxBulkUsers = 1000
+
+// deliveryProgress is called after each batch of a bulk send with the number of
+// messages processed so far and the totals up to then
+type deliveryProgress func(processed, total int, totals DeliveryResult)
+
+// NotifyUsers sends a notification to every device of the users in req.
+// Messages for all users are sent together in batches of delivery.MaxBatchSize.
+// Users that can't be notified, for example because they have no devices, are
+// reported in the result instead of failing the request.
+func (s *Server) NotifyUsers(ctx context.Context, projectName, siteName string, req BulkMessageRequest) BulkDeliveryResult {
+	logger := deliveryLog.With("project", projectName, "site", siteName)
+	return s.deliverToUsers(ctx, projectName, siteName, req, nil, logger)
+}
+
+// deliverToUsers is NotifyUsers reporting progress to progress, which may be
+// nil, and logging to logger
+func (s *Server) deliverToUsers(ctx context.Context, projectName, siteName string, req BulkMessageRequest, progress deliveryProgress, logger *slog.Logger) BulkDeliveryResult {
+	key := store.Key(projectName, siteName)
+	result := BulkDeliveryResult{Users: make([]UserDelivery, 0, len(req.UserIDs))}
+
+	// Build the messages of every device, remembering which user each is for
+	var messages []*messaging.Message
+	var recipients []int
+	seen := make(map[string]bool, len(req.UserIDs))
+	for _, userID := range req.UserIDs {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		result.Users = append(result.Users, UserDelivery{UserID: userID})
+		user := &result.Users[len(result.Users)-1]
+
+		tokens, err := s.getUserTokens(key, userID)
+		if err != nil {
+			user.Error = err.Error()
+			continue
+		}
+		title, body, data, err := req.forUser(userID)
+		if err != nil {
+			user.Error = err.Error()
+			continue
+		}
+		webpushConfig, _, err := s.userWebpushConfig(ctx, key, title, body, data, logger)
+		if err != nil {
+			user.Error = err.Error()
+			continue
+		}
+		for _, token := range tokens {
+			messages = append(messages, userMessage(token, title, body, webpushConfig))
+			recipients = append(recipients, len(result.Users)-1)
+		}
+	}
+
+	logger.InfoContext(ctx, "Sending bulk notification", "user_count", len(result.Users), "token_count", len(messages))
+	sent := 0
+	var totals DeliveryResult
+	for _, batch := range delivery.Batches(messages, delivery.MaxBatchSize) {
+		// Each batch gets its own timeout, detached from the request like single sends
+		sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		responses, batchErr := s.sendFCMBatch(sendCtx, projectName, notificationTypeUser, batch)
+		cancel()
+
+		for i, message := range batch {
+			user := &result.Users[recipients[sent+i]]
+			err := batchErr
+			if err == nil {
+				err = responses[i].Error
+			}
+			if err == nil {
+				user.Sent++
+				totals.Sent++
+				continue
+			}
+
+			user.Failed++
+			totals.Failed++
+			logger.WarnContext(ctx, "Failed to send notification", "user_id", user.UserID, "fcm_token", message.Token, "error", err)
+			if delivery.IsInvalidTokenError(err) {
+				s.removeInvalidToken(ctx, key, user.UserID, message.Token)
+				s.metrics.invalidTokensRemovedTotal.WithLabelValues(projectName).Inc()
+				user.Removed++
+				totals.Removed++
+			}
+		}
+		sent += len(batch)
+		logger.DebugContext(ctx, "Bulk notification batch sent", "sent", sent, "token_count", len(messages))
+		if progress != nil {
+			progress(sent, len(messages), totals)
+		}
+	}
+
+	for _, user := range result.Users {
+		result.Sent += user.Sent
+		result.Failed += user.Failed
+		result.Removed += user.Removed
+	}
+	logger.InfoContext(ctx, "Bulk notification completed", "sent", result.Sent, "failed", result.Failed, "removed", result.Removed)
+	return result
+}
+
+// userWebpushConfig builds the web push config of a user notification. The data
+// carries a deduplication ID derived from its message_id, which is also the tag
+// that lets browsers replace repeated notifications.
+func (s *Server) userWebpushConfig(ctx context.Context, key, title, body, data string, logger *slog.Logger) (*messaging.WebpushConfig, string, error) {
+	// Parse the data for notification settings
+	dataMap, err := delivery.ParseData(data)
+	if err != nil {
+		return nil, "", newOpError(http.StatusBadRequest, "%v", err)
+	}
+
+	// Convert data fields to string values for FCM
+	notificationData := delivery.StringMap(dataMap)
+
+	// Add deduplication key
+	// Use message_id only (without timestamp) to ensure same message_id gets same deduplicationID
+	// even if function is called multiple times
+	messageID := notificationData["message_id"]
+	if messageID == "" {
+		messageID = fmt.Sprintf("msg_%d", time.Now().Unix())
+	}
+	deduplicationID := fmt.Sprintf("raven_%s", messageID)
+	notificationData["deduplication_id"] = deduplicationID
+	attachRequestID(ctx, notificationData)
+	logger.DebugContext(ctx, "Generated deduplication ID", "deduplication_id", deduplicationID, "message_id", messageID)
+
+	// Prepare web push config with decorations and icons (no topic for user notifications)
+	_, span := s.startSpan(ctx, "notification.decorate", attribute.String("relay.key", key))
+	webpushConfig, convertedDataMap, err := s.prepareWebPushConfig(key, title, body, data, "")
+	endSpan(span, err)
+	if err != nil {
+		return nil, "", newOpError(http.StatusBadRequest, "Failed to prepare notification: %v", err)
+	}
+
+	// Update notificationData with converted click_action if it was converted
+	if convertedDataMap != nil {
+		if convertedClickAction, ok := convertedDataMap["click_action"].(string); ok {
+			notificationData["click_action"] = convertedClickAction
+		}
+	}
+
+	// Add deduplication tag and other notification settings
+	if webpushConfig.Notification != nil {
+		webpushConfig.Notification.Tag = deduplicationID
+		webpushConfig.Notification.RequireInteraction = true
+		webpushConfig.Notification.Renotify = false
+	}
+
+	// Merge notification data into webpush config data
+	if webpushConfig.Data == nil {
+		webpushConfig.Data = make(map[string]string)
+	}
+	for k, v := range notificationData {
+		webpushConfig.Data[k] = v
+	}
+	return webpushConfig, deduplicationID, nil
+}
+
+// userMessage creates the message of a user notification for one device
+func userMessage(token, title, body string, webpushConfig *messaging.WebpushConfig) *messaging.Message {
+	return &messaging.Message{
+		Token: token,
+		Notification: &messaging.Notification{
+			Title: title,
+			Body:  body,
+		},
+		Webpush: webpushConfig,
+	}
+}
+
+// NotifyTopic sends a notification to the topic or condition in req and
+// returns the FCM message ID. Errors from FCM are returned wrapped and carry no
+// status.
+func (s *Server) NotifyTopic(ctx context.Context, req NotificationRequest) (string, error) {
+	topic := req.TopicName
+	key := store.Key(req.ProjectName, req.SiteName)
+	data := string(req.Data)
+
+	// Conditions are sent in their normalized form, with the topics mapped
+	// like topic names are
+	var condition, fcmCondition string
+	if req.Condition != "" {
+		parsed, err := delivery.ParseCondition(req.Condition)
+		if err != nil {
+			return "", newOpError(http.StatusBadRequest, "%v", err)
+		}
+		condition = parsed.String()
+		fcmCondition = parsed.MapTopics(func(topic string) string { return s.fcmTopic(req.ProjectName, req.SiteName, topic) }).String()
+	}
+	recipient := topic
+	if condition != "" {
+		recipient = condition
+	}
+
+	// Prepare web push config (pass the recipient for topic-specific handling).
+	// Topic decorations are keyed by topic name, so none apply to conditions.
+	_, span := s.startSpan(ctx, "notification.decorate",
+		attribute.String("relay.key", key), attribute.String("fcm.topic", topic), attribute.String("fcm.condition", condition))
+	webpushConfig, _, err := s.prepareWebPushConfig(key, req.Title, req.Body, data, recipient)
+	endSpan(span, err)
+	if err != nil {
+		return "", newOpError(http.StatusBadRequest, "Failed to prepare notification: %v", err)
+	}
+
+	// Parse notification data
+	dataMap, err := delivery.ParseData(data)
+	if err != nil {
+		return "", newOpError(http.StatusBadRequest, "%v", err)
+	}
+
+	// Convert data fields to string values for FCM
+	notificationData := delivery.StringMap(dataMap)
+	attachRequestID(ctx, notificationData)
+
+	var fcmTopicName string
+	if topic != "" {
+		fcmTopicName = s.fcmTopic(req.ProjectName, req.SiteName, topic)
+	}
+	message := &messaging.Message{
+		Topic:     fcmTopicName,
+		Condition: fcmCondition,
+		Notification: &messaging.Notification{
+			Title: req.Title,
+			Body:  req.Body,
+		},
+		Webpush: webpushConfig,
+		Data:    notificationData,
+	}
+
+	logNotificationSent(ctx, "topic", recipient, message)
+
+	// Send the message
+	response, err := s.sendFCMMessage(context.WithoutCancel(ctx), req.ProjectName, notificationTypeTopic, message)
+	if err != nil {
+		return "", fmt.Errorf("Failed to send notification: %w", err)
+	}
+
+	logNotificationResponse(ctx, "topic", recipient, response)
+	return response, nil
+}
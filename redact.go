@@ -0,0 +1,212 @@
+package relay
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"firebase.google.com/go/v4/messaging"
+
+	"github.com/your-username/notification-relay/store"
+)
+
+const (
+	// redactedValue replaces secrets in logs
+	redactedValue = "[REDACTED]"
+	// tokenPrefixLength is how much of an FCM token is kept in logs
+	tokenPrefixLength = 10
+	// minRegisteredSecretLength avoids redacting short, common strings
+	minRegisteredSecretLength = 8
+)
+
+var (
+	// DefaultRedactKeys are data and query keys whose values never reach the logs.
+	// Additional keys can be configured with redact_keys in config.json.
+	DefaultRedactKeys = []string{"password", "secret", "token", "api_key", "api_secret", "authorization"}
+
+	// Patterns scrubbed from every log line as a last line of defense
+	authSchemePattern = regexp.MustCompile(`(?i)\b(basic|bearer)\s+[A-Za-z0-9+/=._~-]{8,}`)
+	fcmTokenPattern   = regexp.MustCompile(`\b([A-Za-z0-9_-]{10})[A-Za-z0-9_-]*:APA91b[A-Za-z0-9_-]+`)
+	queryParamPattern = regexp.MustCompile(`\b(fcm_token|token|api_secret)=([^&\s"']*)`)
+
+	secretsMu         sync.RWMutex
+	registeredSecrets = make(map[string]struct{})
+
+	// redactKeys are the redact_keys of the configuration loaded last, lower-cased
+	redactKeys atomic.Pointer[[]string]
+)
+
+// setRedactKeys replaces the configured keys redacted in addition to DefaultRedactKeys
+func setRedactKeys(keys []string) {
+	lower := make([]string, len(keys))
+	for i, k := range keys {
+		lower[i] = strings.ToLower(k)
+	}
+	redactKeys.Store(&lower)
+}
+
+// registerSecret makes the redacting writer scrub the value from all log output
+func registerSecret(secret string) {
+	if len(secret) < minRegisteredSecretLength {
+		return
+	}
+	secretsMu.Lock()
+	registeredSecrets[secret] = struct{}{}
+	secretsMu.Unlock()
+}
+
+// registerCredentialSecrets registers all API secrets from the credentials map
+func registerCredentialSecrets(creds store.Credentials) {
+	for _, secret := range creds {
+		registerSecret(secret)
+	}
+}
+
+// redactToken keeps only a short prefix of an FCM token so log lines stay correlatable
+func redactToken(token string) string {
+	if len(token) <= tokenPrefixLength {
+		return redactedValue
+	}
+	return token[:tokenPrefixLength] + "..."
+}
+
+// DeviceID identifies a device in responses without revealing its FCM token:
+// the first 16 hex digits of the token's SHA-256. Callers that know the token
+// can compute it to match results to their devices.
+func DeviceID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// isRedactedKey reports whether values stored under key must not be logged
+func isRedactedKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range DefaultRedactKeys {
+		if key == k {
+			return true
+		}
+	}
+	if keys := redactKeys.Load(); keys != nil {
+		for _, k := range *keys {
+			if key == k {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// redactData returns a copy of notification data with configured keys redacted
+func redactData(data map[string]string) map[string]string {
+	if data == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(data))
+	for k, v := range data {
+		if isRedactedKey(k) {
+			v = redactedValue
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
+// RedactQuery returns a copy of the query parameters that is safe to log.
+// FCM tokens keep their prefix, the data JSON has configured keys redacted.
+func RedactQuery(query url.Values) url.Values {
+	redacted := make(url.Values, len(query))
+	for k, values := range query {
+		for _, v := range values {
+			switch {
+			case k == "fcm_token":
+				v = redactToken(v)
+			case k == "data":
+				v = redactDataJSON(v)
+			case isRedactedKey(k):
+				v = redactedValue
+			}
+			redacted.Add(k, v)
+		}
+	}
+	return redacted
+}
+
+// redactDataJSON redacts configured keys of a JSON object string
+func redactDataJSON(data string) string {
+	var dataMap map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &dataMap); err != nil {
+		return data
+	}
+	for k := range dataMap {
+		if isRedactedKey(k) {
+			dataMap[k] = redactedValue
+		}
+	}
+	redacted, err := json.Marshal(dataMap)
+	if err != nil {
+		return redactedValue
+	}
+	return string(redacted)
+}
+
+// redactMessage returns a copy of an FCM message that is safe to log
+func redactMessage(message *messaging.Message) *messaging.Message {
+	redacted := *message
+	if redacted.Token != "" {
+		redacted.Token = redactToken(redacted.Token)
+	}
+	redacted.Data = redactData(message.Data)
+	if message.Webpush != nil {
+		webpush := *message.Webpush
+		webpush.Data = redactData(message.Webpush.Data)
+		redacted.Webpush = &webpush
+	}
+	return &redacted
+}
+
+// redactSecrets scrubs credentials, registered secrets and FCM tokens from a log line
+func redactSecrets(line string) string {
+	line = authSchemePattern.ReplaceAllString(line, "$1 "+redactedValue)
+	line = fcmTokenPattern.ReplaceAllString(line, "$1...")
+	line = queryParamPattern.ReplaceAllStringFunc(line, func(match string) string {
+		parts := strings.SplitN(match, "=", 2)
+		if parts[0] == "fcm_token" {
+			if unescaped, err := url.QueryUnescape(parts[1]); err == nil {
+				return parts[0] + "=" + redactToken(unescaped)
+			}
+		}
+		return parts[0] + "=" + redactedValue
+	})
+
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for secret := range registeredSecrets {
+		line = strings.ReplaceAll(line, secret, redactedValue)
+	}
+	return line
+}
+
+// redactingWriter scrubs secrets from everything written to the underlying writer.
+// It is installed as the output of the standard logger and gin's request logger.
+type redactingWriter struct {
+	out io.Writer
+}
+
+// NewRedactingWriter returns a writer that scrubs secrets from everything
+// written to out
+func NewRedactingWriter(out io.Writer) io.Writer {
+	return &redactingWriter{out: out}
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.out, redactSecrets(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
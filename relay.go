@@ -0,0 +1,179 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	firebase "firebase.google.com/go/v4"
+	"google.golang.org/api/option"
+
+	"github.com/your-username/notification-relay/delivery"
+)
+
+// Configuration file names
+const (
+	// ConfigJSON is the main configuration file
+	ConfigJSON = "config.json"
+	// CredentialsJSON stores API credentials
+	CredentialsJSON = "credentials.json"
+	// UserDeviceMapJSON maps users to their device tokens
+	UserDeviceMapJSON = "user-device-map.json"
+	// TopicSubscriptionsJSON records the topics users are subscribed to
+	TopicSubscriptionsJSON = "topic-subscriptions.json"
+	// DecorationJSON contains notification decoration rules
+	DecorationJSON      = "decoration.json"
+	TopicDecorationJSON = "topic-decoration.json"
+	// IconsJSON maps projects to their icon paths
+	IconsJSON = "icons.json"
+	// DefaultTrustedProxies defines default CIDR ranges for trusted proxies
+	DefaultTrustedProxies = "127.0.0.1/32,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16"
+)
+
+// allowedFiles is the whitelist of configuration and data files
+var allowedFiles = map[string]bool{
+	ConfigJSON:             true,
+	CredentialsJSON:        true,
+	UserDeviceMapJSON:      true,
+	TopicSubscriptionsJSON: true,
+	DecorationJSON:         true,
+	IconsJSON:              true,
+	TopicDecorationJSON:    true,
+	"test.json":            true,
+}
+
+// newFirebaseClient creates the FCM client of a service account file
+var newFirebaseClient = func(serviceAccountPath string) (delivery.Client, error) {
+	if serviceAccountPath == "" {
+		return nil, fmt.Errorf("failed to initialize Firebase: no service account file found")
+	}
+
+	content, err := readAndValidateServiceAccount(serviceAccountPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return initializeFirebaseApp(serviceAccountPath, content)
+}
+
+// initClient creates the FCM client unless the server has one
+func (s *Server) initClient() error {
+	if s.client != nil {
+		return nil
+	}
+	client, err := newFirebaseClient(s.serviceAccountPath)
+	if err != nil {
+		return err
+	}
+	s.client = client
+	return nil
+}
+
+// findServiceAccountPath returns the service account file from GOOGLE_APPLICATION_CREDENTIALS
+// or the first default location that exists, or "" if there is none
+func findServiceAccountPath() string {
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		return path
+	}
+	for _, path := range []string{
+		"./service-account.json",
+		"/etc/notification-relay/service-account.json",
+	} {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+func validateServiceAccount(jsonContent map[string]interface{}) error {
+	requiredFields := []string{
+		"type",
+		"project_id",
+		"private_key_id",
+		"private_key",
+		"client_email",
+		"client_id",
+		"auth_uri",
+		"token_uri",
+		"auth_provider_x509_cert_url",
+		"client_x509_cert_url",
+	}
+
+	for _, field := range requiredFields {
+		if _, ok := jsonContent[field].(string); !ok {
+			return fmt.Errorf("missing or invalid required field: %s", field)
+		}
+	}
+
+	// Verify it's a service account
+	if accountType, _ := jsonContent["type"].(string); accountType != "service_account" {
+		return fmt.Errorf("invalid account type: %s", accountType)
+	}
+
+	return nil
+}
+
+func readAndValidateServiceAccount(serviceAccountPath string) ([]byte, error) {
+	if _, err := os.Stat(serviceAccountPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to initialize Firebase: service account file not found")
+		}
+		return nil, fmt.Errorf("failed to initialize Firebase: error accessing service account file: %v", err)
+	}
+
+	// #nosec G304 -- serviceAccountPath is validated before use
+	content, err := os.ReadFile(serviceAccountPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize Firebase: could not read service account file: %v", err)
+	}
+
+	return content, nil
+}
+
+func initializeFirebaseApp(serviceAccountPath string, content []byte) (delivery.Client, error) {
+	var jsonContent map[string]interface{}
+	if err := json.Unmarshal(content, &jsonContent); err != nil {
+		return nil, fmt.Errorf("failed to initialize Firebase: invalid service account JSON: %v", err)
+	}
+
+	if err := validateServiceAccount(jsonContent); err != nil {
+		return nil, fmt.Errorf("failed to initialize Firebase: %v", err)
+	}
+
+	ctx := context.Background()
+	opt := option.WithCredentialsFile(serviceAccountPath)
+	app, err := firebase.NewApp(ctx, nil, opt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize Firebase: %v", err)
+	}
+
+	client, err := app.Messaging(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize messaging client: %v", err)
+	}
+
+	return client, nil
+}
+
+// ValidateTrustedProxies checks a comma-separated list of CIDRs, "*" or "none"
+func ValidateTrustedProxies(trustedProxies string) error {
+	trustedProxies = strings.TrimSpace(trustedProxies)
+	if trustedProxies == "" || trustedProxies == "none" || trustedProxies == "*" {
+		return nil
+	}
+
+	for _, proxy := range strings.Split(trustedProxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil {
+			return fmt.Errorf("invalid CIDR %q: %v", proxy, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/MicahParks/keyfunc"
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/your-username/notification-relay/delivery"
+)
+
+// reloadDebounce groups the burst of events editors produce when saving a file
+const reloadDebounce = 500 * time.Millisecond
+
+// reloadableFiles are the files re-read on SIGHUP or when they change on disk
+var reloadableFiles = []string{ConfigJSON, DecorationJSON, TopicDecorationJSON, IconsJSON}
+
+// reloadableState holds configuration and data files that can change without a
+// restart, along with the JWKS loaded for its JWT settings. A published state
+// must not be modified.
+type reloadableState struct {
+	config           Config
+	decorations      map[string]map[string]delivery.Decoration
+	topicDecorations map[string]delivery.TopicDecoration
+	icons            map[string]string
+	jwksFile         []byte // Contents of jwt.jwks_file, so rotated keys are noticed
+	jwtKeys          *keyfunc.JWKS
+}
+
+// newReloadableState returns an empty state
+func newReloadableState() *reloadableState {
+	return &reloadableState{
+		decorations:      make(map[string]map[string]delivery.Decoration),
+		topicDecorations: make(map[string]delivery.TopicDecoration),
+		icons:            make(map[string]string),
+	}
+}
+
+// currentState returns the configuration in use. Handlers should load it once
+// and read all related settings from the same state.
+func (s *Server) currentState() *reloadableState {
+	return s.state.Load()
+}
+
+// currentConfig returns config.json as currently in use
+func (s *Server) currentConfig() *Config {
+	return &s.currentState().config
+}
+
+// updateState publishes a copy of the state in use changed by update
+func (s *Server) updateState(update func(next *reloadableState)) {
+	next := *s.currentState()
+	update(&next)
+	s.state.Store(&next)
+}
+
+// loadReloadableState strictly decodes and validates all reloadable files
+func (s *Server) loadReloadableState() (*reloadableState, error) {
+	state := &reloadableState{}
+	files := map[string]interface{}{
+		ConfigJSON:          &state.config,
+		DecorationJSON:      &state.decorations,
+		TopicDecorationJSON: &state.topicDecorations,
+		IconsJSON:           &state.icons,
+	}
+	var problems []validationProblem
+	for _, filename := range reloadableFiles {
+		problems = append(problems, s.decodeFile(filename, files[filename], filename == ConfigJSON)...)
+	}
+	if len(problems) == 0 {
+		problems = state.validate(s.configPath)
+	}
+	if len(problems) == 0 {
+		jwksFile, err := readJWKSFile(s.configPath, state.config.JWT)
+		if err != nil {
+			problems = append(problems, validationProblem{File: ConfigJSON, Field: "jwt.jwks_file", Message: err.Error()})
+		}
+		state.jwksFile = jwksFile
+	}
+	if err := joinProblems(problems); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Reload re-reads config.json, the decoration and icon files and the JWKS file
+// and swaps them in when they are valid. On any error the configuration in use
+// stays in place. Changes to trusted_proxies need a new HTTP handler.
+func (s *Server) Reload() error {
+	return s.reloadConfig("reload")
+}
+
+// reloadConfig re-reads the reloadable files and swaps them in when valid.
+// On any error the current configuration stays in place.
+func (s *Server) reloadConfig(source string) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	// The service account file isn't reloaded, but readiness reports its state
+	s.refreshServiceAccountCheck()
+
+	next, err := s.loadReloadableState()
+	if err != nil {
+		appLog.Error("Configuration reload failed, keeping current configuration", "source", source, "error", err)
+		return err
+	}
+
+	previous := s.currentState()
+	changes := diffState(previous, next)
+	if len(changes) == 0 {
+		appLog.Info("Configuration reloaded without changes", "source", source)
+		return nil
+	}
+
+	// Fetch the new JWKS before publishing, so bearer authentication keeps
+	// working with the current keys when fetching fails
+	next.jwtKeys = previous.jwtKeys
+	jwtChanged := !reflect.DeepEqual(previous.config.JWT, next.config.JWT) || !bytes.Equal(previous.jwksFile, next.jwksFile)
+	if jwtChanged {
+		keys, err := loadJWTKeys(next.config.JWT, next.jwksFile)
+		if err != nil {
+			appLog.Error("Configuration reload failed, keeping current configuration", "source", source, "error", err)
+			return err
+		}
+		next.jwtKeys = keys
+	}
+
+	s.state.Store(next)
+	if err := initLogging(&next.config); err != nil {
+		s.state.Store(previous)
+		if restoreErr := initLogging(&previous.config); restoreErr != nil {
+			appLog.Error("Failed to restore previous configuration", "error", restoreErr)
+		}
+		if jwtChanged && next.jwtKeys != nil {
+			next.jwtKeys.EndBackground()
+		}
+		appLog.Error("Configuration reload failed, keeping current configuration", "source", source, "error", err)
+		return err
+	}
+	if jwtChanged && previous.jwtKeys != nil {
+		previous.jwtKeys.EndBackground()
+	}
+
+	for _, change := range changes {
+		appLog.Info("Configuration changed", "change", change)
+	}
+	if previous.config.TrustedProxies != next.config.TrustedProxies {
+		appLog.Warn("trusted_proxies changed, restart to apply")
+	}
+	if !reflect.DeepEqual(topicMapping(previous.config.Topics), topicMapping(next.config.Topics)) {
+		appLog.Warn("topics.namespace or topics.global_topics changed, devices stay subscribed to the previous FCM topics until they subscribe again")
+	}
+	appLog.Info("Configuration reloaded", "source", source, "changes", len(changes))
+	return nil
+}
+
+// topicMapping returns the topic settings that decide which FCM topic a topic
+// maps to, nil when topics are not namespaced
+func topicMapping(topics *TopicConfig) *TopicConfig {
+	if topics == nil || !topics.Namespace {
+		return nil
+	}
+	return &TopicConfig{Namespace: true, GlobalTopics: topics.GlobalTopics}
+}
+
+// diffState describes what changed between two states, without logging any values
+func diffState(previous, next *reloadableState) []string {
+	var changes []string
+	changes = append(changes, diffMap("project", previous.config.Projects, next.config.Projects)...)
+
+	// Compare remaining config.json settings by their JSON name
+	prevValue := reflect.ValueOf(previous.config)
+	nextValue := reflect.ValueOf(next.config)
+	configType := prevValue.Type()
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		if field.Name == "Projects" {
+			continue
+		}
+		if !reflect.DeepEqual(prevValue.Field(i).Interface(), nextValue.Field(i).Interface()) {
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			changes = append(changes, fmt.Sprintf("%s changed", name))
+		}
+	}
+
+	changes = append(changes, diffMap("decoration", previous.decorations, next.decorations)...)
+	changes = append(changes, diffMap("topic decoration", previous.topicDecorations, next.topicDecorations)...)
+	changes = append(changes, diffMap("icon", previous.icons, next.icons)...)
+	if !bytes.Equal(previous.jwksFile, next.jwksFile) {
+		changes = append(changes, "jwks_file contents changed")
+	}
+	return changes
+}
+
+// diffMap lists added, removed and changed keys in sorted order
+func diffMap[V any](kind string, previous, next map[string]V) []string {
+	var changes []string
+	for key, value := range next {
+		old, exists := previous[key]
+		switch {
+		case !exists:
+			changes = append(changes, fmt.Sprintf("%s added: %s", kind, key))
+		case !reflect.DeepEqual(old, value):
+			changes = append(changes, fmt.Sprintf("%s changed: %s", kind, key))
+		}
+	}
+	for key := range previous {
+		if _, exists := next[key]; !exists {
+			changes = append(changes, fmt.Sprintf("%s removed: %s", kind, key))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// WatchConfig reloads the configuration on SIGHUP and, when watch_config is
+// set, when its files change on disk, until ctx is done
+func (s *Server) WatchConfig(ctx context.Context) {
+	s.handleReloadSignals(ctx)
+	if s.currentConfig().WatchConfig {
+		if err := s.watchConfigFiles(ctx); err != nil {
+			appLog.Warn("Configuration file watching disabled", "error", err)
+		}
+	}
+}
+
+// handleReloadSignals reloads the configuration on SIGHUP until ctx is done
+func (s *Server) handleReloadSignals(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				// Failures are logged and the current configuration stays in place
+				_ = s.reloadConfig("SIGHUP")
+			}
+		}
+	}()
+}
+
+// watchConfigFiles reloads the configuration when a reloadable file or the
+// jwt.jwks_file in use at startup changes on disk. The directories are watched
+// rather than the files, since editors and config management tools often
+// replace files instead of writing them in place.
+func (s *Server) watchConfigFiles(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %v", err)
+	}
+
+	dir := filepath.Dir(s.configPath)
+	watched := make(map[string]bool, len(reloadableFiles)+1)
+	for _, filename := range reloadableFiles {
+		watched[filepath.Clean(filepath.Join(dir, filename))] = true
+	}
+	dirs := []string{dir}
+	if jwtConfig := s.currentConfig().JWT; jwtConfig != nil && jwtConfig.JWKSFile != "" {
+		jwksPath := jwksFilePath(s.configPath, jwtConfig.JWKSFile)
+		watched[jwksPath] = true
+		if jwksDir := filepath.Dir(jwksPath); jwksDir != filepath.Clean(dir) {
+			dirs = append(dirs, jwksDir)
+		}
+	}
+	for _, d := range dirs {
+		if err := watcher.Add(d); err != nil {
+			if closeErr := watcher.Close(); closeErr != nil {
+				appLog.Warn("Failed to close file watcher", "error", closeErr)
+			}
+			return fmt.Errorf("failed to watch %s: %v", d, err)
+		}
+	}
+
+	go func() {
+		defer func() {
+			if err := watcher.Close(); err != nil {
+				appLog.Warn("Failed to close file watcher", "error", err)
+			}
+		}()
+
+		var timer *time.Timer
+		for {
+			select {
+			case <-ctx.Done():
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !watched[filepath.Clean(event.Name)] || event.Op == fsnotify.Chmod {
+					continue
+				}
+				if timer == nil {
+					timer = time.AfterFunc(reloadDebounce, func() {
+						_ = s.reloadConfig("file watcher")
+					})
+				} else {
+					timer.Reset(reloadDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				appLog.Warn("File watcher error", "error", err)
+			}
+		}
+	}()
+
+	appLog.Info("Watching configuration files", "dirs", dirs)
+	return nil
+}
@@ -0,0 +1,236 @@
+package relay
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/your-username/notification-relay/delivery"
+	"github.com/your-username/notification-relay/store"
+)
+
+func TestReloadConfig(t *testing.T) {
+	tests := []struct {
+		name          string
+		setup         func(t *testing.T, s *Server, tmpDir string)
+		expectError   bool
+		expectProject bool
+		expectedLogs  []string
+	}{
+		{
+			name: "valid changes are applied",
+			setup: func(t *testing.T, s *Server, tmpDir string) {
+				newConfig := *s.currentConfig()
+				newConfig.Projects = map[string]ProjectConfig{
+					"test_project": s.currentConfig().Projects["test_project"],
+					"new_project":  {VapidPublicKey: "new-key", FirebaseConfig: s.currentConfig().Projects["test_project"].FirebaseConfig},
+				}
+				newConfig.AllowedOrigins = []string{"https://example.com"}
+				writeTestJSON(t, filepath.Join(tmpDir, ConfigJSON), newConfig)
+				writeTestJSON(t, filepath.Join(tmpDir, IconsJSON), map[string]string{"new_project_site": "/icon.png"})
+			},
+			expectProject: true,
+			expectedLogs: []string{
+				"change=\"project added: new_project\"",
+				"change=\"allowed_origins changed\"",
+				"change=\"icon added: new_project_site\"",
+			},
+		},
+		{
+			name: "namespace changes are warned about",
+			setup: func(t *testing.T, s *Server, tmpDir string) {
+				newConfig := *s.currentConfig()
+				newConfig.Topics = &TopicConfig{Namespace: true}
+				writeTestJSON(t, filepath.Join(tmpDir, ConfigJSON), newConfig)
+			},
+			expectedLogs: []string{
+				"change=\"topics changed\"",
+				"topics.namespace or topics.global_topics changed, devices stay subscribed to the previous FCM topics",
+			},
+		},
+		{
+			name: "invalid JSON keeps current configuration",
+			setup: func(t *testing.T, s *Server, tmpDir string) {
+				require.NoError(t, os.WriteFile(filepath.Join(tmpDir, ConfigJSON), []byte("{invalid"), defaultFileMode))
+			},
+			expectError:  true,
+			expectedLogs: []string{"keeping current configuration"},
+		},
+		{
+			name: "invalid decoration pattern keeps current configuration",
+			setup: func(t *testing.T, s *Server, tmpDir string) {
+				newConfig := *s.currentConfig()
+				newConfig.Projects = map[string]ProjectConfig{"new_project": {}}
+				writeTestJSON(t, filepath.Join(tmpDir, ConfigJSON), newConfig)
+				writeTestJSON(t, filepath.Join(tmpDir, DecorationJSON), map[string]map[string]delivery.Decoration{
+					"new_project_site": {"broken": {Pattern: "([", Template: "{title}"}},
+				})
+			},
+			expectError:  true,
+			expectedLogs: []string{"invalid pattern", "projects.new_project.vapid_public_key: is required"},
+		},
+		{
+			name: "unknown field keeps current configuration",
+			setup: func(t *testing.T, s *Server, tmpDir string) {
+				require.NoError(t, os.WriteFile(filepath.Join(tmpDir, ConfigJSON),
+					[]byte(`{"projects": {"new_project": {"vapid_public_key": "k", "vapid_key": "typo"}}}`), defaultFileMode))
+			},
+			expectError:  true,
+			expectedLogs: []string{"projects.new_project.vapid_key: unknown field"},
+		},
+		{
+			name: "failed JWKS fetch keeps current configuration",
+			setup: func(t *testing.T, s *Server, tmpDir string) {
+				newConfig := *s.currentConfig()
+				newConfig.Projects = map[string]ProjectConfig{"new_project": s.currentConfig().Projects["test_project"]}
+				newConfig.JWT = &JWTConfig{JWKSFile: "jwks.json"}
+				writeTestJSON(t, filepath.Join(tmpDir, ConfigJSON), newConfig)
+				require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "jwks.json"), []byte(`{"keys": "none"}`), defaultFileMode))
+			},
+			expectError:  true,
+			expectedLogs: []string{"failed to parse JWKS file"},
+		},
+		{
+			name: "failed reinitialization restores current configuration",
+			setup: func(t *testing.T, s *Server, tmpDir string) {
+				newConfig := *s.currentConfig()
+				newConfig.Projects = map[string]ProjectConfig{"new_project": s.currentConfig().Projects["test_project"]}
+				writeTestJSON(t, filepath.Join(tmpDir, ConfigJSON), newConfig)
+				t.Setenv("LOG_FORMAT", "xml")
+			},
+			expectError:  true,
+			expectedLogs: []string{"invalid log format"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, tmpDir := setupTestEnvironment(t)
+			resetLogging(t)
+			logs := captureLogs(t, false)
+
+			previous := s.currentState()
+			tt.setup(t, s, tmpDir)
+
+			err := s.reloadConfig("test")
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Equal(t, previous, s.currentState())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			_, exists := s.currentConfig().Projects["new_project"]
+			assert.Equal(t, tt.expectProject, exists)
+			for _, expected := range tt.expectedLogs {
+				assert.Contains(t, logs.String(), expected)
+			}
+		})
+	}
+}
+
+func TestDiffState(t *testing.T) {
+	previous := &reloadableState{
+		config: Config{
+			Projects:   map[string]ProjectConfig{"a": {VapidPublicKey: "1"}, "b": {}},
+			JWT:        &JWTConfig{JWKSURL: "https://idp/jwks"},
+			RedactKeys: []string{"x"},
+		},
+		decorations:      map[string]map[string]delivery.Decoration{"a_site": {"d": {Pattern: "x"}}},
+		topicDecorations: map[string]delivery.TopicDecoration{"news": {Pattern: "x"}},
+		icons:            map[string]string{"a_site": "/a.png"},
+	}
+	next := &reloadableState{
+		config: Config{
+			Projects:   map[string]ProjectConfig{"a": {VapidPublicKey: "2"}, "c": {}},
+			JWT:        &JWTConfig{JWKSURL: "https://idp/jwks"},
+			RedactKeys: []string{"x", "y"},
+		},
+		decorations:      map[string]map[string]delivery.Decoration{"a_site": {"d": {Pattern: "y"}}},
+		topicDecorations: map[string]delivery.TopicDecoration{},
+		icons:            map[string]string{"a_site": "/a.png"},
+	}
+
+	assert.Equal(t, []string{
+		"project added: c",
+		"project changed: a",
+		"project removed: b",
+		"redact_keys changed",
+		"decoration changed: a_site",
+		"topic decoration removed: news",
+	}, diffState(previous, next))
+	assert.Empty(t, diffState(previous, previous))
+}
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	s, tmpDir := setupTestEnvironment(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.handleReloadSignals(ctx)
+
+	writeTestJSON(t, filepath.Join(tmpDir, IconsJSON), map[string]string{"test_project_site": "/hup.png"})
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+
+	assert.Eventually(t, func() bool {
+		return s.currentState().icons["test_project_site"] == "/hup.png"
+	}, 2*time.Second, 20*time.Millisecond)
+}
+
+func TestWatchConfigFiles(t *testing.T) {
+	s, tmpDir := setupTestEnvironment(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, s.watchConfigFiles(ctx))
+
+	// Files outside the reloadable set are ignored
+	writeTestJSON(t, filepath.Join(tmpDir, UserDeviceMapJSON), map[string]map[string][]string{})
+
+	writeTestJSON(t, filepath.Join(tmpDir, TopicDecorationJSON), map[string]delivery.TopicDecoration{
+		"news": {Pattern: ".*", Template: "📰 {title}"},
+	})
+
+	assert.Eventually(t, func() bool {
+		_, exists := s.currentState().topicDecorations["news"]
+		return exists
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestReloadRotatedJWKSFile(t *testing.T) {
+	s, tmpDir := setupTestEnvironment(t)
+	defer func() {
+		require.NoError(t, s.Close(context.Background()))
+	}()
+
+	setupTestJWKS(t, s, tmpDir)
+	writeTestJSON(t, filepath.Join(tmpDir, ConfigJSON), *s.currentConfig())
+	jwksPath := filepath.Join(tmpDir, "jwks.json")
+	rotate := func(kid string) {
+		var jwks map[string][]map[string]string
+		require.NoError(t, store.ReadJSON(jwksPath, &jwks))
+		jwks["keys"][0]["kid"] = kid
+		writeTestJSON(t, jwksPath, jwks)
+	}
+
+	// Reloads pick up rotated keys even though config.json is unchanged
+	rotate("rotated-key")
+	require.NoError(t, s.reloadConfig("SIGHUP"))
+	assert.Equal(t, []string{"rotated-key"}, s.currentState().jwtKeys.KIDs())
+
+	// The watcher reloads when the JWKS file changes
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, s.watchConfigFiles(ctx))
+	rotate("watched-key")
+	assert.Eventually(t, func() bool {
+		kids := s.currentState().jwtKeys.KIDs()
+		return len(kids) == 1 && kids[0] == "watched-key"
+	}, 3*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,50 @@
+package relay
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// requestIDDataKey is the FCM data key the request ID is attached under
+const requestIDDataKey = "request_id"
+
+// requestIDKey is the request context key holding the request ID
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID, which
+// logs and FCM messages sent within it are tagged with
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// NewRequestID generates a random 128-bit request ID
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		appLog.Error("Failed to generate request ID", "error", err)
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// RequestIDFromContext returns the request ID stored by ContextWithRequestID, if any
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// attachRequestID adds the request ID to FCM message data for correlation,
+// without overwriting a value supplied by the caller
+func attachRequestID(ctx context.Context, data map[string]string) {
+	id := RequestIDFromContext(ctx)
+	if id == "" || data == nil {
+		return
+	}
+	if _, exists := data[requestIDDataKey]; !exists {
+		data[requestIDDataKey] = id
+	}
+}
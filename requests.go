@@ -0,0 +1,111 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/your-username/notification-relay/delivery"
+)
+
+// NotificationData is the data of a notification as a JSON object. JSON bodies
+// may send it as an object or, like query parameters, as a JSON encoded string.
+type NotificationData string
+
+// UnmarshalJSON accepts an object, a string holding an object, or null
+func (d *NotificationData) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	switch {
+	case bytes.Equal(b, []byte("null")):
+		*d = ""
+	case bytes.HasPrefix(b, []byte(`"`)):
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*d = NotificationData(s)
+	case bytes.HasPrefix(b, []byte("{")):
+		*d = NotificationData(b)
+	default:
+		return errors.New("data must be a JSON object")
+	}
+	return nil
+}
+
+// ValidateTopicName checks a topic name against the characters FCM accepts
+func ValidateTopicName(name string) error {
+	if !delivery.ValidTopicName(name) {
+		return fmt.Errorf("invalid topic name %q: only letters, digits and -_.~%% are allowed", name)
+	}
+	return nil
+}
+
+// ValidateTopic checks the parameters sending to a topic requires. Either
+// topic_name or a valid condition is required, not both.
+func (r *NotificationRequest) ValidateTopic() error {
+	switch {
+	case r.TopicName != "" && r.Condition != "":
+		return errors.New("topic_name and condition must not both be set")
+	case r.Condition != "":
+		if _, err := delivery.ParseCondition(r.Condition); err != nil {
+			return err
+		}
+	case r.TopicName == "":
+		return errors.New("topic_name is required")
+	default:
+		if err := ValidateTopicName(r.TopicName); err != nil {
+			return err
+		}
+	}
+	return ValidateNotification(r.Title, r.Body)
+}
+
+// Validate checks the parameters sending to several users requires
+func (r *BulkMessageRequest) Validate() error {
+	if len(r.UserIDs) == 0 {
+		return errors.New("user_ids is required")
+	}
+	if len(r.UserIDs) > maxBulkUsers {
+		return fmt.Errorf("user_ids must not contain more than %d users", maxBulkUsers)
+	}
+	return ValidateNotification(r.Title, r.Body)
+}
+
+// forUser returns the notification for a user with the user's overrides applied
+func (r *BulkMessageRequest) forUser(userID string) (title, body, data string, err error) {
+	title, body, data = r.Title, r.Body, string(r.Data)
+	override, exists := r.Overrides[userID]
+	if !exists {
+		return title, body, data, nil
+	}
+	if override.Title != "" {
+		title = override.Title
+	}
+	if override.Body != "" {
+		body = override.Body
+	}
+	if override.Data == "" {
+		return title, body, data, nil
+	}
+
+	merged, err := delivery.ParseData(data)
+	if err != nil {
+		return "", "", "", err
+	}
+	overrideData, err := delivery.ParseData(string(override.Data))
+	if err != nil {
+		return "", "", "", err
+	}
+	if merged == nil {
+		merged = overrideData
+	}
+	for k, v := range overrideData {
+		merged[k] = v
+	}
+	encoded, err := json.Marshal(merged)
+	if err != nil {
+		return "", "", "", err
+	}
+	return title, body, string(encoded), nil
+}
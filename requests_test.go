@@ -0,0 +1,43 @@
+package relay
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkMessageRequest(t *testing.T) {
+	req := BulkMessageRequest{
+		UserIDs: []string{"alice", "bob", "carol"},
+		Title:   "Hi",
+		Body:    "There",
+		Data:    NotificationData(`{"message_id": "1", "room": "all"}`),
+		Overrides: map[string]NotificationOverride{
+			"bob":   {Title: "Hi Bob", Data: NotificationData(`{"room": "bob"}`)},
+			"carol": {Body: "Just you", Data: NotificationData(`[1]`)},
+		},
+	}
+	require.NoError(t, req.Validate())
+
+	title, body, data, err := req.forUser("alice")
+	require.NoError(t, err)
+	assert.Equal(t, "Hi", title)
+	assert.Equal(t, "There", body)
+	assert.JSONEq(t, `{"message_id": "1", "room": "all"}`, data)
+
+	// Overrides replace the title and body and merge into the data
+	title, body, data, err = req.forUser("bob")
+	require.NoError(t, err)
+	assert.Equal(t, "Hi Bob", title)
+	assert.Equal(t, "There", body)
+	assert.JSONEq(t, `{"message_id": "1", "room": "bob"}`, data)
+
+	_, _, _, err = req.forUser("carol")
+	assert.Error(t, err)
+
+	req.UserIDs = nil
+	assert.EqualError(t, req.Validate(), "user_ids is required")
+	req.UserIDs = make([]string, maxBulkUsers+1)
+	assert.EqualError(t, req.Validate(), "user_ids must not contain more than 1000 users")
+}
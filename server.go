@@ -0,0 +1,209 @@
+// Package relay is the notification relay: it registers the FCM tokens of
+// Frappe site users, keeps track of their topic subscriptions and sends them web
+// push notifications through Firebase Cloud Messaging.
+//
+// A Server holds everything one relay works with: its configuration and data
+// files, API credentials, FCM client, metrics and the deliveries in progress.
+// Servers are independent of each other, so a process may run several with
+// different data directories. The HTTP API serving a Server is in the httpapi
+// package, and the notification-relay binary is RunCLI.
+//
+// Logging is set up per process: the log levels and format follow the
+// configuration loaded last, and secrets of every server are redacted from all
+// log output.
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/MicahParks/keyfunc"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/your-username/notification-relay/delivery"
+	"github.com/your-username/notification-relay/store"
+)
+
+// Options configure where the relay finds its files. Empty paths are taken from
+// the environment or the default locations.
+type Options struct {
+	// ConfigPath is the path of config.json; the data files are kept next to it.
+	// Defaults to NOTIFICATION_RELAY_CONFIG, ./config.json or /etc/notification-relay/config.json.
+	ConfigPath string
+	// ServiceAccountPath is the Firebase service account file. Defaults to
+	// GOOGLE_APPLICATION_CREDENTIALS, ./service-account.json or /etc/notification-relay/service-account.json.
+	ServiceAccountPath string
+	// Client sends messages to FCM. Defaults to a Firebase client of the
+	// service account; hosts may share or wrap a client of their own.
+	Client delivery.Client
+	// TracerProvider creates the spans of deliveries and FCM calls. Defaults to
+	// an OTLP exporter configured through the OTEL_* environment variables, or
+	// the global provider when none is configured.
+	TracerProvider trace.TracerProvider
+}
+
+// locate returns the file paths of the options, discovering the ones not set
+func (o Options) locate() (configPath, serviceAccountPath string, err error) {
+	configPath, serviceAccountPath = o.ConfigPath, o.ServiceAccountPath
+	if configPath == "" {
+		if configPath, err = findConfigPath(); err != nil {
+			return "", "", err
+		}
+	}
+	if serviceAccountPath == "" {
+		serviceAccountPath = findServiceAccountPath()
+	}
+	return configPath, serviceAccountPath, nil
+}
+
+// Server is a relay with its configuration and data files loaded and its FCM
+// client initialized. Its methods are safe for concurrent use.
+type Server struct {
+	configPath         string
+	serviceAccountPath string
+
+	// state is the configuration in use. Reloads publish a new state instead of
+	// changing the one in use, so readers never see a half-applied configuration.
+	state atomic.Pointer[reloadableState]
+	// reloadMu serializes reloads
+	reloadMu sync.Mutex
+
+	// devicesMu guards devices and subscriptions, which requests, metrics
+	// scrapes and shutdown use concurrently. It is held while saving, so files
+	// are written in the order of the changes.
+	devicesMu     sync.RWMutex
+	devices       store.Devices
+	subscriptions store.Subscriptions
+
+	// credentialsMu guards credentials, which requests read and create
+	credentialsMu sync.RWMutex
+	credentials   store.Credentials
+
+	client         delivery.Client
+	health         healthState
+	deliveries     deliveryTracker
+	broadcasts     *broadcastTracker
+	metrics        *serverMetrics
+	tracerProvider trace.TracerProvider
+
+	shutdownTracing func(context.Context) error
+	// lock keeps commands from changing the data files while the server runs
+	lock      *dataLock
+	closeOnce sync.Once
+}
+
+// newServer returns a server for the files at the given paths, with nothing loaded
+func newServer(configPath, serviceAccountPath string) *Server {
+	s := &Server{
+		configPath:         configPath,
+		serviceAccountPath: serviceAccountPath,
+		devices:            make(store.Devices),
+		subscriptions:      make(store.Subscriptions),
+		credentials:        make(store.Credentials),
+		broadcasts:         newBroadcastTracker(),
+		tracerProvider:     otel.GetTracerProvider(),
+		shutdownTracing:    func(context.Context) error { return nil },
+	}
+	s.state.Store(newReloadableState())
+	s.metrics = newServerMetrics(s)
+	return s
+}
+
+// NewServer validates and loads all files and initializes the FCM client,
+// authentication keys and tracing. It fails while another server or command
+// holds the lock of the data directory.
+func NewServer(opts Options) (_ *Server, err error) {
+	configPath, serviceAccountPath, err := opts.locate()
+	if err != nil {
+		return nil, err
+	}
+	s := newServer(configPath, serviceAccountPath)
+	if s.lock, err = lockDataDir(configPath); err != nil {
+		if errors.Is(err, errDataLocked) {
+			return nil, fmt.Errorf("%s is in use by another relay server or command", filepath.Dir(configPath))
+		}
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = s.Close(context.Background())
+		}
+	}()
+
+	// Check every file up front instead of failing at request time
+	if problems := s.validateFiles(); len(problems) > 0 {
+		for _, problem := range problems {
+			appLog.Error("Invalid configuration", "file", problem.File, "field", problem.Field, "error", problem.Message)
+		}
+		return nil, fmt.Errorf("configuration is invalid: %d problem(s), run 'notification-relay validate' for details", len(problems))
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.client = opts.Client
+	if err := s.initClient(); err != nil {
+		return nil, err
+	}
+	if err := s.initJWTAuth(); err != nil {
+		return nil, fmt.Errorf("failed to initialize JWT authentication: %v", err)
+	}
+	s.refreshServiceAccountCheck()
+	s.health.markDataLoaded()
+
+	// Export traces when an OTLP endpoint is configured
+	if opts.TracerProvider != nil {
+		s.tracerProvider = opts.TracerProvider
+	} else {
+		provider, err := initTracing(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize tracing: %v", err)
+		}
+		if provider != nil {
+			s.tracerProvider, s.shutdownTracing = provider, provider.Shutdown
+		}
+	}
+	return s, nil
+}
+
+// Config returns config.json as currently in use. It must not be modified.
+func (s *Server) Config() *Config {
+	return s.currentConfig()
+}
+
+// JWT returns the settings of bearer token authentication with the keys loaded
+// for them, both nil when it is not configured
+func (s *Server) JWT() (*JWTConfig, *keyfunc.JWKS) {
+	state := s.currentState()
+	return state.config.JWT, state.jwtKeys
+}
+
+// Metrics returns the registry of the server's metrics, which includes the Go
+// runtime and process collectors
+func (s *Server) Metrics() *prometheus.Registry {
+	return s.metrics.registry
+}
+
+// TracerProvider returns the provider the server creates spans with
+func (s *Server) TracerProvider() trace.TracerProvider {
+	return s.tracerProvider
+}
+
+// Close flushes pending traces, stops refreshing JWT keys and releases the data
+// directory lock. Shut the server down first so no registration is lost.
+func (s *Server) Close(ctx context.Context) error {
+	var err error
+	s.closeOnce.Do(func() {
+		if keys := s.currentState().jwtKeys; keys != nil {
+			keys.EndBackground()
+		}
+		err = errors.Join(s.shutdownTracing(ctx), s.lock.release())
+	})
+	return err
+}
@@ -0,0 +1,146 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/your-username/notification-relay/delivery"
+)
+
+func TestOptionsLocate(t *testing.T) {
+	tests := []struct {
+		name               string
+		options            Options
+		env                map[string]string
+		expectedConfig     string
+		expectedAccount    string
+		expectedErrMessage string
+	}{
+		{
+			name:            "explicit paths",
+			options:         Options{ConfigPath: "/srv/relay/config.json", ServiceAccountPath: "/srv/relay/sa.json"},
+			env:             map[string]string{"NOTIFICATION_RELAY_CONFIG": "/env/config.json"},
+			expectedConfig:  "/srv/relay/config.json",
+			expectedAccount: "/srv/relay/sa.json",
+		},
+		{
+			name: "environment",
+			env: map[string]string{
+				"NOTIFICATION_RELAY_CONFIG":      "/env/config.json",
+				"GOOGLE_APPLICATION_CREDENTIALS": "/env/sa.json",
+			},
+			expectedConfig:  "/env/config.json",
+			expectedAccount: "/env/sa.json",
+		},
+		{
+			name:               "no config file",
+			expectedErrMessage: "no config file found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := os.Stat("/etc/notification-relay/" + ConfigJSON); err == nil && tt.expectedErrMessage != "" {
+				t.Skip("a config file is installed on this machine")
+			}
+			t.Setenv("NOTIFICATION_RELAY_CONFIG", "")
+			t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			// Default locations are relative to the working directory
+			wd, err := os.Getwd()
+			require.NoError(t, err)
+			require.NoError(t, os.Chdir(t.TempDir()))
+			defer func() { require.NoError(t, os.Chdir(wd)) }()
+
+			configPath, serviceAccountPath, err := tt.options.locate()
+			if tt.expectedErrMessage != "" {
+				assert.ErrorContains(t, err, tt.expectedErrMessage)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedConfig, configPath)
+			assert.Equal(t, tt.expectedAccount, serviceAccountPath)
+		})
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	tests := []struct {
+		name               string
+		setup              func(t *testing.T, tmpDir string)
+		expectedErrMessage string
+	}{
+		{
+			name:  "valid configuration",
+			setup: func(t *testing.T, tmpDir string) {},
+		},
+		{
+			name: "invalid configuration",
+			setup: func(t *testing.T, tmpDir string) {
+				require.NoError(t, os.WriteFile(filepath.Join(tmpDir, ConfigJSON), []byte(`{"projects": {}}`), defaultFileMode))
+			},
+			expectedErrMessage: "configuration is invalid: 1 problem(s)",
+		},
+		{
+			name: "firebase failure",
+			setup: func(t *testing.T, tmpDir string) {
+				newFirebaseClient = func(string) (delivery.Client, error) {
+					return nil, errors.New("failed to initialize Firebase: boom")
+				}
+			},
+			expectedErrMessage: "failed to initialize Firebase: boom",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, tmpDir := setupTestEnvironment(t)
+			setupTestCLI(t, s)
+			tt.setup(t, tmpDir)
+
+			srv, err := NewServer(Options{ConfigPath: s.configPath})
+			if tt.expectedErrMessage != "" {
+				assert.ErrorContains(t, err, tt.expectedErrMessage)
+				return
+			}
+			require.NoError(t, err)
+			defer func() { assert.NoError(t, srv.Close(context.Background())) }()
+
+			for name, err := range srv.Checks() {
+				assert.NoError(t, err, name)
+			}
+		})
+	}
+}
+
+func TestNewServerPerDataDir(t *testing.T) {
+	first, _ := setupTestEnvironment(t)
+	second, _ := setupTestEnvironment(t)
+	setupTestCLI(t, first)
+
+	// Servers of different data directories run side by side
+	srv, err := NewServer(Options{ConfigPath: first.configPath})
+	require.NoError(t, err)
+	other, err := NewServer(Options{ConfigPath: second.configPath})
+	require.NoError(t, err)
+	assert.NotSame(t, srv.Metrics(), other.Metrics(), "servers don't share metrics")
+	require.NoError(t, other.Close(context.Background()))
+
+	_, err = NewServer(Options{ConfigPath: first.configPath})
+	assert.ErrorContains(t, err, "in use by another relay server or command")
+
+	require.NoError(t, srv.Close(context.Background()))
+	require.NoError(t, srv.Close(context.Background()), "closing twice is harmless")
+	next, err := NewServer(Options{ConfigPath: first.configPath})
+	require.NoError(t, err)
+	assert.NoError(t, next.Close(context.Background()))
+}
@@ -0,0 +1,114 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// errShuttingDown refuses deliveries that start after shutdown began waiting
+var errShuttingDown = errors.New("server is shutting down")
+
+// deliveryTracker counts FCM deliveries in progress so shutdown can wait for them
+type deliveryTracker struct {
+	mu sync.Mutex
+	// closed is set once wait was called, after which no delivery may start
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// start registers a delivery; the returned function marks it as finished.
+// It fails with errShuttingDown once shutdown is waiting for deliveries.
+func (d *deliveryTracker) start() (func(), error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, errShuttingDown
+	}
+	d.wg.Add(1)
+	var once sync.Once
+	return func() { once.Do(d.wg.Done) }, nil
+}
+
+// heldDeliveryKey marks a context whose work already holds a delivery
+type heldDeliveryKey struct{}
+
+// withHeldDelivery marks ctx as running inside a delivery started with start,
+// so the sends it makes don't start deliveries of their own. A broadcast holds
+// one delivery for all its batches, which would otherwise be refused once
+// shutdown waits for it.
+func withHeldDelivery(ctx context.Context) context.Context {
+	return context.WithValue(ctx, heldDeliveryKey{}, true)
+}
+
+// startIn registers a delivery unless ctx already holds one
+func (d *deliveryTracker) startIn(ctx context.Context) (func(), error) {
+	if held, _ := ctx.Value(heldDeliveryKey{}).(bool); held {
+		return func() {}, nil
+	}
+	return d.start()
+}
+
+// wait refuses new deliveries and blocks until all running ones finished or
+// ctx is done
+func (d *deliveryTracker) wait(ctx context.Context) error {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("deliveries still in progress: %v", ctx.Err())
+	}
+}
+
+// BeginShutdown makes readiness fail, so load balancers stop routing new
+// requests while the ones in flight are still served
+func (s *Server) BeginShutdown() {
+	s.health.markShuttingDown()
+}
+
+// Shutdown refuses new deliveries, waits until the running ones finished or ctx
+// is done and writes the user device map and topic subscriptions to disk. Stop
+// serving requests first, so no registration arrives after the flush.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.BeginShutdown()
+	var errs []error
+	if err := s.deliveries.wait(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.flushState(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// flushState writes the user device map and topic subscriptions to disk so no
+// registration is lost
+func (s *Server) flushState() error {
+	s.devicesMu.Lock()
+	defer s.devicesMu.Unlock()
+
+	if s.devices == nil || !allowedFiles[UserDeviceMapJSON] {
+		return nil
+	}
+	if err := s.saveJSON(UserDeviceMapJSON, s.devices); err != nil {
+		return fmt.Errorf("failed to flush user device map: %v", err)
+	}
+	if s.subscriptions == nil || !allowedFiles[TopicSubscriptionsJSON] {
+		return nil
+	}
+	if err := s.saveJSON(TopicSubscriptionsJSON, s.subscriptions); err != nil {
+		return fmt.Errorf("failed to flush topic subscriptions: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package relay
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/your-username/notification-relay/store"
+)
+
+func TestShutdownFlushesState(t *testing.T) {
+	s, tmpDir := setupTestEnvironment(t)
+	setupTestHealth(s)
+
+	// A token registered without a save must still reach the disk
+	s.devices["test_project_test_site"] = map[string][]string{"user": {"token"}}
+	s.subscriptions.Subscribe("test_project_test_site", "user", "news")
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.Error(t, s.checkShutdown(), "readiness fails once shutdown began")
+
+	var saved store.Devices
+	require.NoError(t, store.ReadJSON(filepath.Join(tmpDir, UserDeviceMapJSON), &saved))
+	assert.Equal(t, []string{"token"}, saved.Tokens("test_project_test_site", "user"))
+	var subscriptions store.Subscriptions
+	require.NoError(t, store.ReadJSON(filepath.Join(tmpDir, TopicSubscriptionsJSON), &subscriptions))
+	assert.Equal(t, []string{"news"}, subscriptions.Topics("test_project_test_site", "user"))
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	s, tmpDir := setupTestEnvironment(t)
+	setupTestHealth(s)
+
+	done, err := s.deliveries.start()
+	require.NoError(t, err)
+	defer done()
+	s.devices["test_project_test_site"] = map[string][]string{"user": {"token"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = s.Shutdown(ctx)
+	assert.ErrorContains(t, err, "deliveries still in progress")
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	// State is flushed even though deliveries are still running
+	content, err := os.ReadFile(filepath.Join(tmpDir, UserDeviceMapJSON))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "token")
+
+	// Deliveries starting after the timeout are refused instead of racing the wait
+	_, err = s.deliveries.start()
+	assert.ErrorIs(t, err, errShuttingDown)
+}
+
+func TestDeliveryTracker(t *testing.T) {
+	tracker := &deliveryTracker{}
+	done, err := tracker.start()
+	require.NoError(t, err)
+	done()
+	done() // finishing twice must not panic
+
+	assert.NoError(t, tracker.wait(context.Background()))
+	_, err = tracker.start()
+	assert.ErrorIs(t, err, errShuttingDown, "no delivery starts once shutdown waits")
+
+	_, err = tracker.startIn(context.Background())
+	assert.ErrorIs(t, err, errShuttingDown)
+	done, err = tracker.startIn(withHeldDelivery(context.Background()))
+	require.NoError(t, err, "work inside a held delivery keeps sending")
+	done()
+}
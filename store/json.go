@@ -0,0 +1,62 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// writeMu serializes file writes
+var writeMu sync.Mutex
+
+// ReadJSON decodes a .json file into v
+func ReadJSON(path string, v interface{}) error {
+	if filepath.Ext(path) != ".json" {
+		return fmt.Errorf("invalid file extension for %s: must be .json", filepath.Base(path))
+	}
+
+	// Use filepath.Clean to sanitize the path
+	content, err := os.ReadFile(filepath.Clean(path)) // #nosec G304 -- path is sanitized
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
+
+// WriteJSON writes v to a file readable by the owner only. It writes through a
+// temporary file and renames it into place, so an interrupted write never leaves
+// a truncated file behind. Panics if v can't be encoded.
+func WriteJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		panic(fmt.Sprintf("Failed to marshal JSON: %v", err))
+	}
+
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	// The temporary file only remains when the write failed
+	defer func() { _ = os.Remove(tmpPath) }()
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
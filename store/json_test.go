@@ -0,0 +1,42 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteJSONIsAtomic(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "state.json")
+	require.NoError(t, WriteJSON(path, map[string]string{"a": "1"}))
+	require.NoError(t, WriteJSON(path, map[string]string{"a": "2"}))
+
+	var result map[string]string
+	require.NoError(t, ReadJSON(path, &result))
+	assert.Equal(t, map[string]string{"a": "2"}, result)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	entries, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.NotContains(t, entry.Name(), ".tmp-", "temporary files must be cleaned up")
+	}
+}
+
+func TestReadJSON(t *testing.T) {
+	tmpDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "state.txt"), []byte(`{}`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "broken.json"), []byte(`{`), 0o600))
+
+	var v map[string]string
+	assert.ErrorContains(t, ReadJSON(filepath.Join(tmpDir, "state.txt"), &v), "must be .json")
+	assert.Error(t, ReadJSON(filepath.Join(tmpDir, "broken.json"), &v))
+	assert.True(t, os.IsNotExist(ReadJSON(filepath.Join(tmpDir, "missing.json"), &v)))
+}
@@ -0,0 +1,131 @@
+// Package store holds the relay's data: the FCM tokens of each user per site, the
+// topics they are subscribed to and the API credentials. Data files are plain
+// JSON and are written atomically.
+package store
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Credentials maps API keys to their secrets
+type Credentials map[string]string
+
+// Devices maps site keys to users and the FCM tokens of their devices
+type Devices map[string]map[string][]string
+
+// Key returns the site key of a site in a project, "<project>_<site>"
+func Key(projectName, siteName string) string {
+	return fmt.Sprintf("%s_%s", projectName, siteName)
+}
+
+// Tokens returns the tokens of a user, or nil if the user has none
+func (d Devices) Tokens(key, userID string) []string {
+	return d[key][userID]
+}
+
+// Users returns the users of a site that have tokens, sorted
+func (d Devices) Users(key string) []string {
+	users := make([]string, 0, len(d[key]))
+	for userID, tokens := range d[key] {
+		if len(tokens) > 0 {
+			users = append(users, userID)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// Add registers a token for a user. Returns false if it was already registered.
+func (d Devices) Add(key, userID, token string) bool {
+	if d[key] == nil {
+		d[key] = make(map[string][]string)
+	}
+	for _, existing := range d[key][userID] {
+		if existing == token {
+			return false
+		}
+	}
+	d[key][userID] = append(d[key][userID], token)
+	return true
+}
+
+// Remove removes the tokens of a user matching remove and drops the user if no
+// tokens are left. Returns the number of removed tokens.
+func (d Devices) Remove(key, userID string, remove func(token string) bool) int {
+	tokens, exists := d[key][userID]
+	if !exists {
+		return 0
+	}
+	kept := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if !remove(token) {
+			kept = append(kept, token)
+		}
+	}
+	if len(kept) == 0 {
+		delete(d[key], userID)
+	} else {
+		d[key][userID] = kept
+	}
+	return len(tokens) - len(kept)
+}
+
+// Normalize drops empty and duplicate tokens, users without tokens and sites
+// without users. Returns the number of removed tokens and users.
+func (d Devices) Normalize() (tokens, users int) {
+	for key, siteUsers := range d {
+		for userID, userTokens := range siteUsers {
+			seen := make(map[string]bool, len(userTokens))
+			kept := make([]string, 0, len(userTokens))
+			for _, token := range userTokens {
+				if token == "" || seen[token] {
+					tokens++
+					continue
+				}
+				seen[token] = true
+				kept = append(kept, token)
+			}
+			if len(kept) == 0 {
+				delete(siteUsers, userID)
+				users++
+				continue
+			}
+			siteUsers[userID] = kept
+		}
+		if len(siteUsers) == 0 {
+			delete(d, key)
+		}
+	}
+	return tokens, users
+}
+
+// SiteCount is the number of users and tokens of a site
+type SiteCount struct {
+	Users  int
+	Tokens int
+}
+
+// CountSites returns the number of users and tokens of each site
+func (d Devices) CountSites() map[string]SiteCount {
+	counts := make(map[string]SiteCount, len(d))
+	for key, siteUsers := range d {
+		count := SiteCount{Users: len(siteUsers)}
+		for _, userTokens := range siteUsers {
+			count.Tokens += len(userTokens)
+		}
+		counts[key] = count
+	}
+	return counts
+}
+
+// Count returns the number of users and tokens
+func (d Devices) Count() (users, tokens int) {
+	for _, siteUsers := range d {
+		users += len(siteUsers)
+		for _, userTokens := range siteUsers {
+			tokens += len(userTokens)
+		}
+	}
+	return users, tokens
+}
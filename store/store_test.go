@@ -0,0 +1,56 @@
+package store
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDevices(t *testing.T) {
+	devices := make(Devices)
+	key := Key("my_project", "example.com")
+	assert.Equal(t, "my_project_example.com", key)
+
+	assert.True(t, devices.Add(key, "alice", "token-a"))
+	assert.True(t, devices.Add(key, "alice", "token-b"))
+	assert.False(t, devices.Add(key, "alice", "token-a"), "duplicates are not added")
+	assert.True(t, devices.Add(key, "bob", "token-c"))
+	assert.Equal(t, []string{"token-a", "token-b"}, devices.Tokens(key, "alice"))
+	assert.Nil(t, devices.Tokens("other_site", "alice"))
+	assert.Equal(t, []string{"alice", "bob"}, devices.Users(key))
+	assert.Empty(t, devices.Users("other_site"))
+
+	users, tokens := devices.Count()
+	assert.Equal(t, 2, users)
+	assert.Equal(t, 3, tokens)
+	assert.Equal(t, map[string]SiteCount{key: {Users: 2, Tokens: 3}}, devices.CountSites())
+
+	assert.Equal(t, 1, devices.Remove(key, "alice", func(token string) bool { return token == "token-a" }))
+	assert.Equal(t, []string{"token-b"}, devices.Tokens(key, "alice"))
+	assert.Equal(t, 1, devices.Remove(key, "bob", func(string) bool { return true }))
+	assert.NotContains(t, devices[key], "bob", "users without tokens are dropped")
+	assert.Equal(t, 0, devices.Remove(key, "carol", func(string) bool { return true }))
+}
+
+func TestDevicesNormalize(t *testing.T) {
+	devices := Devices{
+		"p_site": {
+			"alice": {"token-a", "token-a", ""},
+			"bob":   {},
+		},
+		"p_empty": {"carol": {""}},
+	}
+
+	tokens, users := devices.Normalize()
+	assert.Equal(t, 3, tokens)
+	assert.Equal(t, 2, users)
+	assert.Equal(t, Devices{"p_site": {"alice": {"token-a"}}}, devices)
+}
+
+func TestDevicesRemoveKeepsOrder(t *testing.T) {
+	devices := Devices{"p_site": {"alice": {"t1", "x2", "t3", "x4"}}}
+	removed := devices.Remove("p_site", "alice", func(token string) bool { return strings.HasPrefix(token, "x") })
+	assert.Equal(t, 2, removed)
+	assert.Equal(t, []string{"t1", "t3"}, devices.Tokens("p_site", "alice"))
+}
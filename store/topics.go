@@ -0,0 +1,134 @@
+package store
+
+import "sort"
+
+// Subscriptions maps site keys to users, the topics each user is subscribed to
+// and the tokens subscribed to each topic. FCM doesn't list topic memberships,
+// so the relay records the subscriptions it makes.
+type Subscriptions map[string]map[string]map[string][]string
+
+// Subscribe records a user as subscribed to a topic, with no tokens yet, so
+// devices added later can be subscribed to it. Returns false if the user was
+// subscribed already.
+func (s Subscriptions) Subscribe(key, userID, topic string) bool {
+	if s.Subscribed(key, userID, topic) {
+		return false
+	}
+	if s[key] == nil {
+		s[key] = make(map[string]map[string][]string)
+	}
+	if s[key][userID] == nil {
+		s[key][userID] = make(map[string][]string)
+	}
+	s[key][userID][topic] = []string{}
+	return true
+}
+
+// Add records tokens of a user as subscribed to a topic. Returns the number of
+// tokens that weren't recorded yet.
+func (s Subscriptions) Add(key, userID, topic string, tokens []string) int {
+	if len(tokens) == 0 {
+		return 0
+	}
+	s.Subscribe(key, userID, topic)
+
+	added := 0
+	for _, token := range tokens {
+		if !contains(s[key][userID][topic], token) {
+			s[key][userID][topic] = append(s[key][userID][topic], token)
+			added++
+		}
+	}
+	return added
+}
+
+// Drop removes the subscription of a user to a topic and drops users and sites
+// left without subscriptions. Returns false if the user wasn't subscribed.
+func (s Subscriptions) Drop(key, userID, topic string) bool {
+	if _, exists := s[key][userID][topic]; !exists {
+		return false
+	}
+	delete(s[key][userID], topic)
+	if len(s[key][userID]) == 0 {
+		delete(s[key], userID)
+	}
+	if len(s[key]) == 0 {
+		delete(s, key)
+	}
+	return true
+}
+
+// RemoveToken removes a token from every topic of a user and returns the topics
+// it was subscribed to, sorted. The user stays subscribed to the topics, so
+// devices added later can be subscribed to them.
+func (s Subscriptions) RemoveToken(key, userID, token string) []string {
+	var topics []string
+	for topic, tokens := range s[key][userID] {
+		if !contains(tokens, token) {
+			continue
+		}
+		kept := make([]string, 0, len(tokens)-1)
+		for _, t := range tokens {
+			if t != token {
+				kept = append(kept, t)
+			}
+		}
+		s[key][userID][topic] = kept
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Subscribed reports whether a user is subscribed to a topic
+func (s Subscriptions) Subscribed(key, userID, topic string) bool {
+	_, exists := s[key][userID][topic]
+	return exists
+}
+
+// Tokens returns the tokens of a user subscribed to a topic
+func (s Subscriptions) Tokens(key, userID, topic string) []string {
+	return s[key][userID][topic]
+}
+
+// Topics returns the topics a user is subscribed to, sorted
+func (s Subscriptions) Topics(key, userID string) []string {
+	topics := make([]string, 0, len(s[key][userID]))
+	for topic := range s[key][userID] {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Users returns the users of a site subscribed to a topic, sorted
+func (s Subscriptions) Users(key, topic string) []string {
+	users := make([]string, 0)
+	for userID, topics := range s[key] {
+		if _, exists := topics[topic]; exists {
+			users = append(users, userID)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// Count returns the number of user subscriptions to topics
+func (s Subscriptions) Count() int {
+	count := 0
+	for _, users := range s {
+		for _, topics := range users {
+			count += len(topics)
+		}
+	}
+	return count
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
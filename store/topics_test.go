@@ -0,0 +1,51 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriptions(t *testing.T) {
+	subscriptions := make(Subscriptions)
+	key := Key("my_project", "example.com")
+
+	assert.Equal(t, 2, subscriptions.Add(key, "alice", "news", []string{"token-a", "token-b"}))
+	assert.Equal(t, 0, subscriptions.Add(key, "alice", "news", []string{"token-a"}), "tokens are recorded once")
+	assert.Equal(t, 1, subscriptions.Add(key, "alice", "alerts", []string{"token-a"}))
+	assert.Equal(t, 1, subscriptions.Add(key, "bob", "news", []string{"token-c"}))
+	assert.Equal(t, 0, subscriptions.Add(key, "carol", "news", nil))
+
+	assert.True(t, subscriptions.Subscribed(key, "alice", "news"))
+	assert.False(t, subscriptions.Subscribed(key, "carol", "news"))
+	assert.False(t, subscriptions.Subscribed("other_site", "alice", "news"))
+	assert.Equal(t, []string{"token-a", "token-b"}, subscriptions.Tokens(key, "alice", "news"))
+	assert.Equal(t, []string{"alerts", "news"}, subscriptions.Topics(key, "alice"))
+	assert.Empty(t, subscriptions.Topics(key, "carol"))
+	assert.Equal(t, []string{"alice", "bob"}, subscriptions.Users(key, "news"))
+	assert.Empty(t, subscriptions.Users(key, "sports"))
+	assert.Equal(t, 3, subscriptions.Count())
+
+	assert.Equal(t, []string{"alerts", "news"}, subscriptions.RemoveToken(key, "alice", "token-a"))
+	assert.Empty(t, subscriptions.RemoveToken(key, "alice", "token-a"))
+	assert.Equal(t, []string{"token-b"}, subscriptions.Tokens(key, "alice", "news"))
+	assert.True(t, subscriptions.Subscribed(key, "alice", "alerts"), "users stay subscribed without tokens")
+	assert.Empty(t, subscriptions.Tokens(key, "alice", "alerts"))
+	assert.Equal(t, 3, subscriptions.Count())
+
+	assert.True(t, subscriptions.Drop(key, "alice", "news"))
+	assert.False(t, subscriptions.Drop(key, "alice", "news"))
+	assert.Equal(t, []string{"bob"}, subscriptions.Users(key, "news"))
+	assert.True(t, subscriptions.Drop(key, "alice", "alerts"))
+	assert.NotContains(t, subscriptions[key], "alice", "users without subscriptions are dropped")
+	assert.True(t, subscriptions.Drop(key, "bob", "news"))
+	assert.Empty(t, subscriptions, "sites without subscriptions are dropped")
+
+	assert.True(t, subscriptions.Subscribe(key, "dave", "news"))
+	assert.False(t, subscriptions.Subscribe(key, "dave", "news"))
+	assert.True(t, subscriptions.Subscribed(key, "dave", "news"), "users are recorded without tokens")
+	assert.Empty(t, subscriptions.Tokens(key, "dave", "news"))
+	assert.Equal(t, 1, subscriptions.Add(key, "dave", "news", []string{"token-d"}))
+	assert.False(t, subscriptions.Subscribe(key, "dave", "news"))
+	assert.Equal(t, []string{"token-d"}, subscriptions.Tokens(key, "dave", "news"), "subscribing keeps the tokens")
+}
@@ -0,0 +1,196 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/your-username/notification-relay/store"
+)
+
+// topicSeparator separates the project, the site and the topic name in
+// namespaced topics. Project and site are escaped, so the first two separators
+// end them.
+const topicSeparator = "~"
+
+// fcmTopic returns the FCM topic a topic of a site maps to. With namespacing
+// enabled topics are prefixed with the project and site, so sites sharing a
+// Firebase project don't reach each other's subscribers. Global topics are shared.
+func (s *Server) fcmTopic(projectName, siteName, topic string) string {
+	topics := s.currentConfig().Topics
+	if topics == nil || !topics.Namespace || slices.Contains(topics.GlobalTopics, topic) {
+		return topic
+	}
+	return escapeTopicPrefix(projectName) + topicSeparator + escapeTopicPrefix(siteName) + topicSeparator + topic
+}
+
+// escapeTopicPrefix percent-encodes the bytes of a project or site name FCM
+// doesn't accept in topic names, the separator and the percent sign, so
+// different names never map to the same prefix
+func escapeTopicPrefix(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// recordTopicSubscription records a topic subscription change FCM applied in
+// the local registry and saves it. Subscribing records the user and the tokens
+// that succeeded, unsubscribing drops the user's subscription. Failing to save is only logged: FCM already
+// applied the change, and the registry is written again on shutdown.
+func (s *Server) recordTopicSubscription(ctx context.Context, key, userID, topic string, tokens []string, subscribe bool) {
+	s.devicesMu.Lock()
+	defer s.devicesMu.Unlock()
+
+	changed := false
+	if subscribe {
+		changed = s.subscriptions.Subscribe(key, userID, topic)
+		changed = s.subscriptions.Add(key, userID, topic, tokens) > 0 || changed
+	} else {
+		changed = s.subscriptions.Drop(key, userID, topic)
+	}
+	if !changed {
+		return
+	}
+
+	s.saveTopicSubscriptions(ctx, key, userID)
+}
+
+// Subscribe subscribes the devices of a user to a topic and records the
+// subscription, so devices the user adds later are subscribed too. It fails
+// with 404 when the user has no devices. FCM failures for single devices are
+// reported in the result, a failed FCM call is returned as the error.
+func (s *Server) Subscribe(ctx context.Context, projectName, siteName, userID, topic string) (TopicSubscription, error) {
+	if err := ValidateTopicName(topic); err != nil {
+		return TopicSubscription{}, newOpError(http.StatusBadRequest, "%v", err)
+	}
+	tokens, err := s.getUserTokens(store.Key(projectName, siteName), userID)
+	if err != nil {
+		return TopicSubscription{}, err
+	}
+	return s.manageTopic(ctx, projectName, siteName, userID, topic, tokens, true)
+}
+
+// Unsubscribe unsubscribes the devices of a user from a topic and drops the
+// subscription. A user without devices left can still leave the topic, only
+// the registry is updated then.
+func (s *Server) Unsubscribe(ctx context.Context, projectName, siteName, userID, topic string) (TopicSubscription, error) {
+	if err := s.ValidateProject(projectName); err != nil {
+		return TopicSubscription{}, err
+	}
+	if err := ValidateTopicName(topic); err != nil {
+		return TopicSubscription{}, newOpError(http.StatusBadRequest, "%v", err)
+	}
+	tokens := s.deviceTokens(store.Key(projectName, siteName), userID)
+	return s.manageTopic(ctx, projectName, siteName, userID, topic, tokens, false)
+}
+
+// UserTopics returns the topics a user is subscribed to, from the local registry
+func (s *Server) UserTopics(projectName, siteName, userID string) []string {
+	s.devicesMu.RLock()
+	defer s.devicesMu.RUnlock()
+	return s.subscriptions.Topics(store.Key(projectName, siteName), userID)
+}
+
+// TopicUsers returns the users of a site subscribed to a topic, from the local registry
+func (s *Server) TopicUsers(projectName, siteName, topic string) []string {
+	s.devicesMu.RLock()
+	defer s.devicesMu.RUnlock()
+	return s.subscriptions.Users(store.Key(projectName, siteName), topic)
+}
+
+// TopicDevices returns the IDs of the devices of a user subscribed to a topic,
+// see DeviceID, and whether the user is subscribed, from the local registry
+func (s *Server) TopicDevices(projectName, siteName, userID, topic string) ([]string, bool) {
+	key := store.Key(projectName, siteName)
+	s.devicesMu.RLock()
+	subscribed := s.subscriptions.Subscribed(key, userID, topic)
+	tokens := s.subscriptions.Tokens(key, userID, topic)
+	s.devicesMu.RUnlock()
+	if !subscribed {
+		return nil, false
+	}
+	devices := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		devices = append(devices, DeviceID(token))
+	}
+	return devices, true
+}
+
+// subscribeDeviceTopics subscribes a new token of a user to the topics the user
+// is subscribed to, so every device of the user receives them. Failures are
+// only logged: the token is registered either way, and subscribing the user to
+// the topic again retries them.
+func (s *Server) subscribeDeviceTopics(ctx context.Context, projectName, siteName, userID, token string) {
+	key := store.Key(projectName, siteName)
+	s.devicesMu.RLock()
+	topics := s.subscriptions.Topics(key, userID)
+	s.devicesMu.RUnlock()
+
+	// FCM is called without holding the lock
+	var subscribed []string
+	for _, topic := range topics {
+		response, err := s.updateTopicSubscription(ctx, projectName, siteName, topic, []string{token}, true)
+		if err == nil && len(response.Errors) > 0 {
+			err = errors.New(response.Errors[0].Reason)
+		}
+		if err != nil {
+			deliveryLog.WarnContext(ctx, "Failed to subscribe new token to topic",
+				"key", key, "user_id", userID, "topic", topic, "error", err)
+			continue
+		}
+		subscribed = append(subscribed, topic)
+	}
+
+	s.devicesMu.Lock()
+	defer s.devicesMu.Unlock()
+	changed := false
+	for _, topic := range subscribed {
+		changed = s.subscriptions.Add(key, userID, topic, []string{token}) > 0 || changed
+	}
+	if changed {
+		s.saveTopicSubscriptions(ctx, key, userID)
+	}
+}
+
+// unsubscribeDeviceTopics unsubscribes a removed token of a user from the
+// topics it was subscribed to. The user stays subscribed to the topics.
+func (s *Server) unsubscribeDeviceTopics(ctx context.Context, projectName, siteName, userID, token string) {
+	key := store.Key(projectName, siteName)
+	for _, topic := range s.dropDeviceTopics(ctx, key, userID, token) {
+		if _, err := s.updateTopicSubscription(ctx, projectName, siteName, topic, []string{token}, false); err != nil {
+			deliveryLog.WarnContext(ctx, "Failed to unsubscribe removed token from topic",
+				"key", key, "user_id", userID, "topic", topic, "error", err)
+		}
+	}
+}
+
+// dropDeviceTopics removes a token of a user from the registry and returns the
+// topics it was subscribed to
+func (s *Server) dropDeviceTopics(ctx context.Context, key, userID, token string) []string {
+	s.devicesMu.Lock()
+	defer s.devicesMu.Unlock()
+
+	topics := s.subscriptions.RemoveToken(key, userID, token)
+	if len(topics) > 0 {
+		s.saveTopicSubscriptions(ctx, key, userID)
+	}
+	return topics
+}
+
+// saveTopicSubscriptions saves the registry, logging failures. Callers must
+// hold devicesMu.
+func (s *Server) saveTopicSubscriptions(ctx context.Context, key, userID string) {
+	if err := s.saveJSON(TopicSubscriptionsJSON, s.subscriptions); err != nil {
+		storeLog.ErrorContext(ctx, "Failed to save topic subscriptions", "key", key, "user_id", userID, "error", err)
+	}
+}
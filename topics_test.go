@@ -0,0 +1,346 @@
+package relay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/your-username/notification-relay/mocks"
+	"github.com/your-username/notification-relay/store"
+)
+
+func TestDeviceTopicSync(t *testing.T) {
+	s, _ := setupTestEnvironment(t)
+
+	key := "test_project_test_site"
+	ctx := context.Background()
+	s.devices[key] = map[string][]string{"alice": {"alice-phone"}}
+	s.subscriptions.Add(key, "alice", "news", []string{"alice-phone"})
+	s.subscriptions.Add(key, "alice", "alerts", []string{"alice-phone"})
+
+	mockClient := &mocks.MockFirebaseMessagingClient{}
+	s.client = mockClient
+
+	// New devices are subscribed to the user's topics
+	mockClient.On("SubscribeToTopic", mock.Anything, []string{"alice-laptop"}, "alerts").
+		Return(&messaging.TopicManagementResponse{SuccessCount: 1}, nil).Once()
+	mockClient.On("SubscribeToTopic", mock.Anything, []string{"alice-laptop"}, "news").
+		Return(&messaging.TopicManagementResponse{FailureCount: 1, Errors: []*messaging.ErrorInfo{{Index: 0, Reason: "TOO_MANY_TOPICS"}}}, nil).Once()
+	added, err := s.AddToken(ctx, "test_project", "test_site", "alice", "alice-laptop")
+	require.NoError(t, err)
+	assert.True(t, added)
+	mockClient.AssertExpectations(t)
+	assert.Equal(t, []string{"alice-phone", "alice-laptop"}, s.subscriptions.Tokens(key, "alice", "alerts"))
+	assert.Equal(t, []string{"alice-phone"}, s.subscriptions.Tokens(key, "alice", "news"), "failed subscriptions are not recorded")
+
+	var saved store.Subscriptions
+	require.NoError(t, s.loadJSON(TopicSubscriptionsJSON, &saved))
+	assert.Equal(t, s.subscriptions, saved)
+
+	// Duplicate tokens are not subscribed again
+	mockClient = &mocks.MockFirebaseMessagingClient{}
+	s.client = mockClient
+	added, err = s.AddToken(ctx, "test_project", "test_site", "alice", "alice-laptop")
+	require.NoError(t, err)
+	assert.False(t, added)
+	mockClient.AssertNotCalled(t, "SubscribeToTopic", mock.Anything, mock.Anything, mock.Anything)
+
+	// Removed devices are unsubscribed from the topics they were subscribed to
+	mockClient.On("UnsubscribeFromTopic", mock.Anything, []string{"alice-phone"}, "alerts").
+		Return(&messaging.TopicManagementResponse{SuccessCount: 1}, nil).Once()
+	mockClient.On("UnsubscribeFromTopic", mock.Anything, []string{"alice-phone"}, "news").
+		Return(&messaging.TopicManagementResponse{SuccessCount: 1}, nil).Once()
+	removed, err := s.RemoveToken(ctx, "test_project", "test_site", "alice", "alice-phone")
+	require.NoError(t, err)
+	assert.True(t, removed)
+	mockClient.AssertExpectations(t)
+	assert.Equal(t, []string{"alice-laptop"}, s.subscriptions.Tokens(key, "alice", "alerts"))
+	assert.True(t, s.subscriptions.Subscribed(key, "alice", "news"), "the user stays subscribed")
+	assert.Empty(t, s.subscriptions.Tokens(key, "alice", "news"))
+
+	// Invalid tokens are dropped from the registry without calling FCM
+	mockClient = &mocks.MockFirebaseMessagingClient{}
+	s.client = mockClient
+	s.removeInvalidToken(ctx, key, "alice", "alice-laptop")
+	mockClient.AssertNotCalled(t, "UnsubscribeFromTopic", mock.Anything, mock.Anything, mock.Anything)
+	assert.Empty(t, s.subscriptions.Tokens(key, "alice", "alerts"))
+	assert.Equal(t, []string{"alerts", "news"}, s.subscriptions.Topics(key, "alice"))
+
+	// A device added later is subscribed to every topic again
+	mockClient.On("SubscribeToTopic", mock.Anything, []string{"alice-tablet"}, mock.Anything).
+		Return(&messaging.TopicManagementResponse{SuccessCount: 1}, nil).Twice()
+	_, err = s.AddToken(ctx, "test_project", "test_site", "alice", "alice-tablet")
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	assert.Equal(t, []string{"alice-tablet"}, s.subscriptions.Tokens(key, "alice", "news"))
+}
+
+func TestManageTopic(t *testing.T) {
+	s, _ := setupTestEnvironment(t)
+	delay := topicRetryDelay
+	topicRetryDelay = 0
+	defer func() { topicRetryDelay = delay }()
+
+	key := "test_project_test_site"
+	ctx := context.Background()
+	failed := func(reasons map[int]string) *messaging.TopicManagementResponse {
+		response := &messaging.TopicManagementResponse{}
+		for index, reason := range reasons {
+			response.Errors = append(response.Errors, &messaging.ErrorInfo{Index: index, Reason: reason})
+		}
+		response.FailureCount = len(reasons)
+		return response
+	}
+
+	tests := []struct {
+		name            string
+		subscribe       bool
+		setupMock       func(m *mocks.MockFirebaseMessagingClient)
+		expectedError   string
+		expectedResults []TopicTokenResult
+		expectedTokens  []string // Tokens recorded for the topic
+		expectedDevices []string
+	}{
+		{
+			name:      "reports each token and retries transient failures",
+			subscribe: true,
+			setupMock: func(m *mocks.MockFirebaseMessagingClient) {
+				m.On("SubscribeToTopic", mock.Anything, []string{"good", "stale", "flaky", "full"}, "news").
+					Return(failed(map[int]string{1: "NOT_FOUND", 2: "INTERNAL", 3: "TOO_MANY_TOPICS"}), nil).Once()
+				m.On("SubscribeToTopic", mock.Anything, []string{"flaky"}, "news").
+					Return(failed(map[int]string{0: "UNAVAILABLE"}), nil).Once()
+				m.On("SubscribeToTopic", mock.Anything, []string{"flaky"}, "news").
+					Return(&messaging.TopicManagementResponse{SuccessCount: 1}, nil).Once()
+			},
+			expectedResults: []TopicTokenResult{
+				{Device: DeviceID("good"), Success: true},
+				{Device: DeviceID("stale"), Error: "NOT_FOUND", Removed: true},
+				{Device: DeviceID("flaky"), Success: true},
+				{Device: DeviceID("full"), Error: "TOO_MANY_TOPICS"},
+			},
+			expectedTokens:  []string{"good", "flaky"},
+			expectedDevices: []string{"good", "flaky", "full"},
+		},
+		{
+			name:      "gives up after the retries",
+			subscribe: true,
+			setupMock: func(m *mocks.MockFirebaseMessagingClient) {
+				m.On("SubscribeToTopic", mock.Anything, []string{"good", "stale", "flaky", "full"}, "news").
+					Return(failed(map[int]string{2: "INTERNAL"}), nil).Once()
+				m.On("SubscribeToTopic", mock.Anything, []string{"flaky"}, "news").
+					Return(failed(map[int]string{0: "INTERNAL"}), nil).Times(topicRetries)
+			},
+			expectedResults: []TopicTokenResult{
+				{Device: DeviceID("good"), Success: true},
+				{Device: DeviceID("stale"), Success: true},
+				{Device: DeviceID("flaky"), Error: "INTERNAL"},
+				{Device: DeviceID("full"), Success: true},
+			},
+			expectedTokens:  []string{"good", "stale", "full"},
+			expectedDevices: []string{"good", "stale", "flaky", "full"},
+		},
+		{
+			name:      "keeps the last reason when a retry fails",
+			subscribe: true,
+			setupMock: func(m *mocks.MockFirebaseMessagingClient) {
+				m.On("SubscribeToTopic", mock.Anything, []string{"good", "stale", "flaky", "full"}, "news").
+					Return(failed(map[int]string{2: "INTERNAL"}), nil).Once()
+				m.On("SubscribeToTopic", mock.Anything, []string{"flaky"}, "news").
+					Return(&messaging.TopicManagementResponse{}, assert.AnError).Once()
+			},
+			expectedResults: []TopicTokenResult{
+				{Device: DeviceID("good"), Success: true},
+				{Device: DeviceID("stale"), Success: true},
+				{Device: DeviceID("flaky"), Error: "INTERNAL"},
+				{Device: DeviceID("full"), Success: true},
+			},
+			expectedTokens:  []string{"good", "stale", "full"},
+			expectedDevices: []string{"good", "stale", "flaky", "full"},
+		},
+		{
+			name:      "records the user when every token fails",
+			subscribe: true,
+			setupMock: func(m *mocks.MockFirebaseMessagingClient) {
+				m.On("SubscribeToTopic", mock.Anything, []string{"good", "stale", "flaky", "full"}, "news").
+					Return(failed(map[int]string{0: "TOO_MANY_TOPICS", 1: "NOT_FOUND", 2: "TOO_MANY_TOPICS", 3: "TOO_MANY_TOPICS"}), nil).Once()
+			},
+			expectedResults: []TopicTokenResult{
+				{Device: DeviceID("good"), Error: "TOO_MANY_TOPICS"},
+				{Device: DeviceID("stale"), Error: "NOT_FOUND", Removed: true},
+				{Device: DeviceID("flaky"), Error: "TOO_MANY_TOPICS"},
+				{Device: DeviceID("full"), Error: "TOO_MANY_TOPICS"},
+			},
+			expectedTokens:  []string{},
+			expectedDevices: []string{"good", "flaky", "full"},
+		},
+		{
+			name: "unsubscribes and removes invalid tokens",
+			setupMock: func(m *mocks.MockFirebaseMessagingClient) {
+				m.On("UnsubscribeFromTopic", mock.Anything, []string{"good", "stale", "flaky", "full"}, "news").
+					Return(failed(map[int]string{1: "INVALID_ARGUMENT"}), nil).Once()
+			},
+			expectedResults: []TopicTokenResult{
+				{Device: DeviceID("good"), Success: true},
+				{Device: DeviceID("stale"), Error: "INVALID_ARGUMENT", Removed: true},
+				{Device: DeviceID("flaky"), Success: true},
+				{Device: DeviceID("full"), Success: true},
+			},
+			expectedDevices: []string{"good", "flaky", "full"},
+		},
+		{
+			name:      "fails when FCM can't be reached",
+			subscribe: true,
+			setupMock: func(m *mocks.MockFirebaseMessagingClient) {
+				m.On("SubscribeToTopic", mock.Anything, mock.Anything, "news").
+					Return(&messaging.TopicManagementResponse{}, assert.AnError).Once()
+			},
+			expectedError:   assert.AnError.Error(),
+			expectedDevices: []string{"good", "stale", "flaky", "full"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devices := []string{"good", "stale", "flaky", "full"}
+			s.devices[key] = map[string][]string{"alice": devices}
+			s.subscriptions = make(store.Subscriptions)
+			if !tt.subscribe {
+				s.subscriptions.Add(key, "alice", "news", devices)
+			}
+			mockClient := &mocks.MockFirebaseMessagingClient{}
+			s.client = mockClient
+			tt.setupMock(mockClient)
+
+			result, err := s.manageTopic(ctx, "test_project", "test_site", "alice", "news", devices, tt.subscribe)
+			mockClient.AssertExpectations(t)
+			assert.Equal(t, tt.expectedDevices, s.devices.Tokens(key, "alice"))
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				assert.False(t, s.subscriptions.Subscribed(key, "alice", "news"), "failed calls are not recorded")
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "news", result.Topic)
+			assert.Equal(t, tt.expectedResults, result.Results)
+			successes := 0
+			for _, outcome := range tt.expectedResults {
+				if outcome.Success {
+					successes++
+				}
+			}
+			assert.Equal(t, successes, result.SuccessCount)
+			assert.Equal(t, len(tt.expectedResults)-successes, result.FailureCount)
+			assert.Equal(t, tt.expectedTokens, s.subscriptions.Tokens(key, "alice", "news"))
+			assert.Equal(t, tt.subscribe, s.subscriptions.Subscribed(key, "alice", "news"))
+		})
+	}
+}
+
+func TestManageTopicCancelled(t *testing.T) {
+	s, _ := setupTestEnvironment(t)
+	delay := topicRetryDelay
+	topicRetryDelay = time.Hour
+	defer func() { topicRetryDelay = delay }()
+
+	key := "test_project_test_site"
+	s.devices[key] = map[string][]string{"alice": {"good", "flaky"}}
+	mockClient := &mocks.MockFirebaseMessagingClient{}
+	s.client = mockClient
+	mockClient.On("SubscribeToTopic", mock.Anything, []string{"good", "flaky"}, "news").
+		Return(&messaging.TopicManagementResponse{
+			SuccessCount: 1,
+			FailureCount: 1,
+			Errors:       []*messaging.ErrorInfo{{Index: 1, Reason: "INTERNAL"}},
+		}, nil).Once()
+
+	// The caller is gone before the first retry is due
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := s.manageTopic(ctx, "test_project", "test_site", "alice", "news", []string{"good", "flaky"}, true)
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	assert.Equal(t, []TopicTokenResult{
+		{Device: DeviceID("good"), Success: true},
+		{Device: DeviceID("flaky"), Error: "INTERNAL"},
+	}, result.Results)
+	assert.Equal(t, []string{"good"}, s.subscriptions.Tokens(key, "alice", "news"))
+}
+
+func TestFCMTopic(t *testing.T) {
+	s, _ := setupTestEnvironment(t)
+
+	tests := []struct {
+		name     string
+		topics   *TopicConfig
+		project  string
+		site     string
+		topic    string
+		expected string
+	}{
+		{name: "not configured", project: "p", site: "example.com", topic: "alerts", expected: "alerts"},
+		{name: "disabled", topics: &TopicConfig{}, project: "p", site: "example.com", topic: "alerts", expected: "alerts"},
+		{name: "namespaced", topics: &TopicConfig{Namespace: true}, project: "p", site: "example.com", topic: "alerts", expected: "p~example.com~alerts"},
+		{
+			name:     "global topic",
+			topics:   &TopicConfig{Namespace: true, GlobalTopics: []string{"alerts"}},
+			project:  "p",
+			site:     "example.com",
+			topic:    "alerts",
+			expected: "alerts",
+		},
+		{
+			name:     "escapes project and site",
+			topics:   &TopicConfig{Namespace: true},
+			project:  "p~1%",
+			site:     "example.com:8000~x",
+			topic:    "a~b",
+			expected: "p%7E1%25~example.com%3A8000%7Ex~a~b",
+		},
+		{
+			name:     "underscores stay within project or site",
+			topics:   &TopicConfig{Namespace: true},
+			project:  "a_b",
+			site:     "c",
+			topic:    "alerts",
+			expected: "a_b~c~alerts",
+		},
+		{
+			name:     "same key, different project",
+			topics:   &TopicConfig{Namespace: true},
+			project:  "a",
+			site:     "b_c",
+			topic:    "alerts",
+			expected: "a~b_c~alerts",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.updateState(func(next *reloadableState) { next.config.Topics = tt.topics })
+			assert.Equal(t, tt.expected, s.fcmTopic(tt.project, tt.site, tt.topic))
+		})
+	}
+
+	t.Run("subscribes at the namespaced topic", func(t *testing.T) {
+		s.updateState(func(next *reloadableState) { next.config.Topics = &TopicConfig{Namespace: true} })
+		key := "test_project_test_site"
+		s.devices[key] = map[string][]string{"alice": {"alice-phone"}}
+
+		mockClient := &mocks.MockFirebaseMessagingClient{}
+		s.client = mockClient
+		mockClient.On("SubscribeToTopic", mock.Anything, []string{"alice-phone"}, "test_project~test_site~alerts").
+			Return(&messaging.TopicManagementResponse{SuccessCount: 1}, nil).Once()
+
+		result, err := s.manageTopic(context.Background(), "test_project", "test_site", "alice", "alerts", []string{"alice-phone"}, true)
+		require.NoError(t, err)
+		mockClient.AssertExpectations(t)
+		assert.Equal(t, "alerts", result.Topic)
+		assert.Equal(t, []string{"alerts"}, s.subscriptions.Topics(key, "alice"), "the registry keeps the site's topic names")
+	})
+}
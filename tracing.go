@@ -0,0 +1,95 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultServiceName is reported when OTEL_SERVICE_NAME is not set
+	DefaultServiceName = "notification-relay"
+	tracerName         = "github.com/your-username/notification-relay"
+)
+
+func init() {
+	// Propagate W3C trace context even when no exporter is configured,
+	// so downstream services still see the caller's trace
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+}
+
+// initTracing creates a tracer provider exporting to OTLP, configured through
+// the standard OTEL_* environment variables. It returns nil unless an OTLP
+// endpoint is set or OTEL_TRACES_EXPORTER=otlp.
+func initTracing(ctx context.Context) (*sdktrace.TracerProvider, error) {
+	exporter := strings.ToLower(os.Getenv("OTEL_TRACES_EXPORTER"))
+	endpointSet := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" ||
+		os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+	switch {
+	case exporter == "none":
+		return nil, nil
+	case exporter != "" && exporter != "otlp":
+		return nil, fmt.Errorf("unsupported OTEL_TRACES_EXPORTER %q: must be otlp or none", exporter)
+	case exporter == "" && !endpointSet:
+		return nil, nil
+	}
+
+	otlpExporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %v", err)
+	}
+
+	provider, err := newTracerProvider(sdktrace.WithBatcher(otlpExporter))
+	if err != nil {
+		return nil, err
+	}
+
+	appLog.Info("Tracing enabled", "exporter", "otlp")
+	return provider, nil
+}
+
+// newTracerProvider creates a tracer provider describing this service.
+// The resource honours OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES.
+func newTracerProvider(opts ...sdktrace.TracerProviderOption) (*sdktrace.TracerProvider, error) {
+	res, err := resource.Merge(
+		resource.NewSchemaless(semconv.ServiceName(DefaultServiceName)),
+		resource.Environment(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %v", err)
+	}
+	return sdktrace.NewTracerProvider(append(opts, sdktrace.WithResource(res))...), nil
+}
+
+// tracer returns the relay tracer of the server's provider
+func (s *Server) tracer() trace.Tracer {
+	return s.tracerProvider.Tracer(tracerName)
+}
+
+// startSpan starts an internal span with the given attributes
+func (s *Server) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return s.tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err on the span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
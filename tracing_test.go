@@ -0,0 +1,58 @@
+package relay
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitTracing(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		expectError bool
+	}{
+		{
+			name: "disabled without endpoint",
+		},
+		{
+			name: "disabled explicitly",
+			env: map[string]string{
+				"OTEL_TRACES_EXPORTER":        "none",
+				"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318",
+			},
+		},
+		{
+			name: "otlp endpoint",
+			env:  map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318"},
+		},
+		{
+			name:        "unsupported exporter",
+			env:         map[string]string{"OTEL_TRACES_EXPORTER": "zipkin"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OTEL_TRACES_EXPORTER", "")
+			t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+			t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			provider, err := initTracing(context.Background())
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if provider != nil {
+				assert.NoError(t, provider.Shutdown(context.Background()))
+			}
+		})
+	}
+}
@@ -1,69 +1,76 @@
-package main
+package relay
 
 // Config represents the application configuration structure
 type Config struct {
-	Projects       map[string]ProjectConfig `json:"projects"`
-	TrustedProxies string                   `json:"trusted_proxies,omitempty"`
-	AllowedOrigins []string                 `json:"allowed_origins"`
+	Projects          map[string]ProjectConfig `json:"projects"`
+	TrustedProxies    string                   `json:"trusted_proxies,omitempty"`
+	AllowedOrigins    []string                 `json:"allowed_origins"`
+	HMACMaxSkew       int                      `json:"hmac_max_skew,omitempty"` // Seconds, defaults to 300
+	JWT               *JWTConfig               `json:"jwt,omitempty"`
+	BruteForce        *BruteForceConfig        `json:"brute_force,omitempty"`
+	AdminAPIKeys      []string                 `json:"admin_api_keys,omitempty"` // API keys allowed to use admin endpoints
+	RedactKeys        []string                 `json:"redact_keys,omitempty"`    // Extra data keys redacted in logs
+	Logging           *LoggingConfig           `json:"logging,omitempty"`
+	ShutdownTimeout   int                      `json:"shutdown_timeout,omitempty"`    // Seconds, defaults to 20
+	ShutdownDrain     *int                     `json:"shutdown_drain,omitempty"`      // Seconds readiness fails before connections are refused, defaults to 5
+	WatchConfig       bool                     `json:"watch_config,omitempty"`        // Reload when configuration files change
+	BroadcastMaxUsers int                      `json:"broadcast_max_users,omitempty"` // Users a broadcast may reach, defaults to 10000
+	Topics            *TopicConfig             `json:"topics,omitempty"`
 }
 
-// ProjectConfig represents project-specific Firebase configuration
-type ProjectConfig struct {
-	VapidPublicKey string         `json:"vapid_public_key"`
-	FirebaseConfig FirebaseConfig `json:"firebase_config"`
-	Exc            string         `json:"exc,omitempty"`
-}
-
-// ConfigResponse represents the response structure for the getConfig endpoint
-type ConfigResponse struct {
-	VapidPublicKey string                 `json:"vapid_public_key"`
-	Config         map[string]interface{} `json:"config"`
-	Exc            string                 `json:"exc,omitempty"`
-}
-
-// CredentialRequest represents a request for API credentials with validation requirements
-type CredentialRequest struct {
-	Endpoint     string `json:"endpoint"`      // Required: The endpoint URL
-	Protocol     string `json:"protocol"`      // The protocol (http/https)
-	Port         string `json:"port"`          // Optional: The port number
-	Token        string `json:"token"`         // Required: Authentication token
-	WebhookRoute string `json:"webhook_route"` // The webhook route path
+// TopicConfig configures how the topic names sites use map to FCM topics
+type TopicConfig struct {
+	Namespace    bool     `json:"namespace,omitempty"`     // Prefix topics with the project and site they belong to
+	GlobalTopics []string `json:"global_topics,omitempty"` // Topics shared by every site, never prefixed
 }
 
-// CredentialResponse represents an API credentials response
-type CredentialResponse struct {
-	Success     bool               `json:"success"`
-	Message     string             `json:"message,omitempty"`
-	Credentials *CredentialDetails `json:"credentials,omitempty"`
-	Exc         string             `json:"exc,omitempty"`
+// LoggingConfig configures log output. LOG_FORMAT, LOG_LEVEL and LOG_LEVEL_<SUBSYSTEM>
+// environment variables take precedence.
+type LoggingConfig struct {
+	Format string            `json:"format,omitempty"` // text or json, defaults to text
+	Level  string            `json:"level,omitempty"`  // Default level: debug, info, warn or error
+	Levels map[string]string `json:"levels,omitempty"` // Per-subsystem levels, e.g. {"cors": "debug"}
 }
 
-// CredentialDetails contains generated API credentials
-type CredentialDetails struct {
-	APIKey    string `json:"api_key"`
-	APISecret string `json:"api_secret"`
+// BruteForceConfig configures lockouts after repeated authentication failures.
+// Durations are in seconds; zero values use the defaults.
+type BruteForceConfig struct {
+	MaxFailures   int `json:"max_failures,omitempty"`   // Failures before a lockout, defaults to 5
+	FailureWindow int `json:"failure_window,omitempty"` // Window failures are counted in, defaults to 900
+	BaseLockout   int `json:"base_lockout,omitempty"`   // First lockout duration, doubled on each repeat, defaults to 60
+	MaxLockout    int `json:"max_lockout,omitempty"`    // Upper bound for lockout duration, defaults to 3600
 }
 
-// Credentials represents a map of API credentials
-type Credentials map[string]string
-
-// Response represents the standard API response structure with optional fields
-type Response struct {
-	Message interface{} `json:"message,omitempty"` // Success message or status information
-	Data    interface{} `json:"data,omitempty"`    // Response payload data
-	Exc     string      `json:"exc,omitempty"`     // Error message for critical failures
+// JWTConfig configures bearer token authentication for service-to-service calls
+type JWTConfig struct {
+	JWKSFile        string   `json:"jwks_file,omitempty"`        // Path to a JWKS file, relative to the config directory
+	JWKSURL         string   `json:"jwks_url,omitempty"`         // URL of a JWKS endpoint, used when jwks_file is empty
+	RefreshInterval int      `json:"refresh_interval,omitempty"` // Seconds between JWKS refreshes, defaults to 3600
+	Issuer          string   `json:"issuer,omitempty"`           // Required "iss" claim, if set
+	Audience        string   `json:"audience,omitempty"`         // Required "aud" claim, if set
+	ProjectsClaim   string   `json:"projects_claim,omitempty"`   // Claim listing allowed projects, defaults to "projects"
+	SitesClaim      string   `json:"sites_claim,omitempty"`      // Claim listing allowed sites, defaults to "sites"
+	RouteGroups     []string `json:"route_groups,omitempty"`     // Route groups accepting bearer tokens, all when empty
 }
 
-// Decoration represents a notification title decoration rule for user notifications
-type Decoration struct {
-	Pattern  string `json:"pattern"`
-	Template string `json:"template"`
+// ProjectConfig represents project-specific Firebase configuration
+type ProjectConfig struct {
+	VapidPublicKey string         `json:"vapid_public_key"`
+	FirebaseConfig FirebaseConfig `json:"firebase_config"`
+	Exc            string         `json:"exc,omitempty"`
 }
 
-// TopicDecoration represents a notification title decoration rule for topic notifications
-type TopicDecoration struct {
-	Pattern  string `json:"pattern"`
-	Template string `json:"template"`
+// NotificationRequest holds the parameters of the send endpoints. UserID is used
+// when sending to a user and TopicName or Condition when sending to topics.
+type NotificationRequest struct {
+	ProjectName string           `json:"project_name" form:"project_name"`
+	SiteName    string           `json:"site_name" form:"site_name"`
+	UserID      string           `json:"user_id,omitempty" form:"user_id"`
+	TopicName   string           `json:"topic_name,omitempty" form:"topic_name"`
+	Condition   string           `json:"condition,omitempty" form:"condition"` // FCM topic condition, instead of TopicName
+	Title       string           `json:"title" form:"title"`
+	Body        string           `json:"body" form:"body"`
+	Data        NotificationData `json:"data,omitempty" form:"data"`
 }
 
 // NotificationPayload represents the structure of the notification payload
@@ -83,4 +90,74 @@ type FirebaseConfig struct {
 	StorageBucket     string `json:"storageBucket"`
 	MessagingSenderId string `json:"messagingSenderId"`
 	AppId             string `json:"appId"`
+	MeasurementID     string `json:"measurementId"`
+}
+
+// BulkMessageRequest is a notification for several users. Overrides replace
+// the title or body for single users and add to or replace keys of the data.
+type BulkMessageRequest struct {
+	UserIDs   []string                        `json:"user_ids" form:"user_ids"`
+	Title     string                          `json:"title" form:"title"`
+	Body      string                          `json:"body" form:"body"`
+	Data      NotificationData                `json:"data" form:"data"`
+	Overrides map[string]NotificationOverride `json:"overrides" form:"-"` // By user ID, JSON bodies only
+}
+
+// NotificationOverride changes a bulk notification for one user
+type NotificationOverride struct {
+	Title string           `json:"title,omitempty"`
+	Body  string           `json:"body,omitempty"`
+	Data  NotificationData `json:"data,omitempty"`
+}
+
+// BroadcastRequest holds the parameters of the broadcast endpoint
+type BroadcastRequest struct {
+	ProjectName string           `json:"project_name" form:"project_name"`
+	SiteName    string           `json:"site_name" form:"site_name"`
+	Title       string           `json:"title" form:"title"`
+	Body        string           `json:"body" form:"body"`
+	Data        NotificationData `json:"data" form:"data"`
+	Confirm     bool             `json:"confirm" form:"confirm"`     // Required to send
+	MaxUsers    int              `json:"max_users" form:"max_users"` // Refuse sites with more users, optional
+}
+
+// TopicSubscription is the result of subscribing or unsubscribing a user's devices
+type TopicSubscription struct {
+	Topic        string             `json:"topic"`
+	SuccessCount int                `json:"success_count"`
+	FailureCount int                `json:"failure_count"`
+	Results      []TopicTokenResult `json:"results"`
+}
+
+// TopicTokenResult is the outcome of a topic subscription change for one device.
+// Devices are identified by DeviceID, so responses don't carry FCM tokens.
+type TopicTokenResult struct {
+	Device  string `json:"device"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`   // Reason FCM reported for a failure
+	Removed bool   `json:"removed,omitempty"` // Token was invalid and removed from the user
+}
+
+// DeliveryResult counts the devices a user notification was sent to
+type DeliveryResult struct {
+	Sent    int `json:"sent"`    // Devices FCM accepted the notification for
+	Failed  int `json:"failed"`  // Devices the send failed for
+	Removed int `json:"removed"` // Failed devices removed because their token is invalid
+}
+
+// UserDelivery is the result of a bulk send for one user
+type UserDelivery struct {
+	UserID  string `json:"user_id"`
+	Sent    int    `json:"sent"`
+	Failed  int    `json:"failed"`
+	Removed int    `json:"removed"`
+	Error   string `json:"error,omitempty"` // Why nothing was sent, e.g. the user has no devices
+}
+
+// BulkDeliveryResult summarizes a bulk send, with totals over all users
+type BulkDeliveryResult struct {
+	Sent    int            `json:"sent"`
+	Failed  int            `json:"failed"`
+	Removed int            `json:"removed"`
+	Users   []UserDelivery `json:"users"`
 }
@@ -1,14 +1,19 @@
-package main
+package relay
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"errors"
 	"fmt"
-	"log"
+	"math/big"
 	"os"
 	"path/filepath"
+
+	"github.com/your-username/notification-relay/delivery"
+	"github.com/your-username/notification-relay/store"
 )
 
-func getConfigPath(filename string) string {
+// getConfigPath returns the path of a whitelisted file next to the configuration
+func getConfigPath(configPath, filename string) string {
 	// Check if file is allowed
 	if !allowedFiles[filename] {
 		panic(fmt.Sprintf("Unauthorized file access attempt: %s", filename))
@@ -19,119 +24,183 @@ func getConfigPath(filename string) string {
 	return filepath.Join(configDir, filename)
 }
 
-var (
-	credentials Credentials
-)
+// filePath returns the path of a configuration or data file of the server
+func (s *Server) filePath(filename string) string {
+	return getConfigPath(s.configPath, filename)
+}
 
-// Add initialization function that will be called after configPath is set
-func initCredentials() {
-	// Load credentials from file
-	ensureFileExists(CredentialsJSON, make(Credentials))
-	if err := loadJSON(CredentialsJSON, &credentials); err != nil {
-		log.Fatalf("Failed to load credentials: %v", err)
+// Credential returns the secret of an API key
+func (s *Server) Credential(apiKey string) (string, bool) {
+	s.credentialsMu.RLock()
+	defer s.credentialsMu.RUnlock()
+	secret, exists := s.credentials[apiKey]
+	return secret, exists
+}
+
+// CreateCredentials generates a new API key and secret and saves them to the credentials file
+func (s *Server) CreateCredentials() (apiKey, apiSecret string, err error) {
+	apiKey = generateSecureToken(32)
+	apiSecret = generateSecureToken(48)
+
+	registerSecret(apiSecret)
+	s.credentialsMu.Lock()
+	defer s.credentialsMu.Unlock()
+	s.credentials[apiKey] = apiSecret
+	if err := s.saveJSON(CredentialsJSON, s.credentials); err != nil {
+		return "", "", err
 	}
+	return apiKey, apiSecret, nil
 }
 
-// initConfig initializes the configuration path
-func initConfig() {
-	// Check for config path in environment
-	configPath = os.Getenv("NOTIFICATION_RELAY_CONFIG")
-	if configPath == "" {
-		// Default paths in order of preference
-		paths := []string{
-			"./" + ConfigJSON,
-			"/etc/notification-relay/" + ConfigJSON,
-		}
-		for _, path := range paths {
-			if _, err := os.Stat(path); err == nil {
-				configPath = path
-				break
-			}
-		}
-		if configPath == "" {
-			log.Fatal("No config file found")
+// generateSecureToken generates a cryptographically secure random token of the specified length
+// using characters from the charset (a-z, A-Z, 0-9). Returns the generated token as a string.
+func generateSecureToken(length int) string {
+	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+		if err != nil {
+			authLog.Error("Failed to generate random number", "error", err)
+			continue
 		}
+		b[i] = charset[n.Int64()]
 	}
+	return string(b)
 }
 
-func init() {
-	initConfig()
+// initCredentials loads the credentials file, creating it if it doesn't exist
+func (s *Server) initCredentials() error {
+	if err := s.ensureFileExists(CredentialsJSON, make(store.Credentials)); err != nil {
+		return err
+	}
+	if err := s.loadJSON(CredentialsJSON, &s.credentials); err != nil {
+		return fmt.Errorf("failed to load credentials: %v", err)
+	}
+	registerCredentialSecrets(s.credentials)
+	return nil
 }
 
-// loadDataFiles loads all required data files and initializes global variables
-func loadDataFiles() {
-	// Add files to allowed files map first
-	allowedFiles[UserDeviceMapJSON] = true
-	allowedFiles[DecorationJSON] = true
-	allowedFiles[TopicDecorationJSON] = true
-	allowedFiles[IconsJSON] = true
+// defaultConfigPath is where the installers put the configuration, and where
+// import writes it when no configuration exists yet
+var defaultConfigPath = "/etc/notification-relay/" + ConfigJSON
+
+// errConfigNotFound is returned when no configuration file exists
+var errConfigNotFound = errors.New("no config file found, set NOTIFICATION_RELAY_CONFIG")
+
+// findConfigPath returns the config file from NOTIFICATION_RELAY_CONFIG or the
+// first default location that exists
+func findConfigPath() (string, error) {
+	if path := os.Getenv("NOTIFICATION_RELAY_CONFIG"); path != "" {
+		return path, nil
+	}
+	for _, path := range []string{
+		"./" + ConfigJSON,
+		defaultConfigPath,
+	} {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", errConfigNotFound
+}
+
+// load loads the configuration, credentials and data files and applies the
+// logging configuration
+func (s *Server) load() error {
+	var config Config
+	if err := s.loadJSON(ConfigJSON, &config); err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	s.updateState(func(next *reloadableState) { next.config = config })
+	if err := initLogging(&config); err != nil {
+		return fmt.Errorf("failed to configure logging: %v", err)
+	}
+	if err := s.initCredentials(); err != nil {
+		return err
+	}
+	return s.loadDataFiles()
+}
 
+// loadDataFiles loads all data files, creating missing ones with defaults.
+// Files that fail to load are logged and replaced by empty data.
+func (s *Server) loadDataFiles() error {
 	// Load user device map
-	ensureFileExists(UserDeviceMapJSON, make(map[string]map[string][]string))
-	if err := loadJSON(UserDeviceMapJSON, &userDeviceMap); err != nil {
-		log.Printf("Warning: Failed to load user device map: %v", err)
-		userDeviceMap = make(map[string]map[string][]string)
+	if err := s.ensureFileExists(UserDeviceMapJSON, make(map[string]map[string][]string)); err != nil {
+		return err
+	}
+	if err := s.loadJSON(UserDeviceMapJSON, &s.devices); err != nil {
+		storeLog.Warn("Failed to load user device map", "file", UserDeviceMapJSON, "error", err)
+		s.devices = make(map[string]map[string][]string)
+	}
+
+	// Load topic subscriptions
+	if err := s.ensureFileExists(TopicSubscriptionsJSON, make(store.Subscriptions)); err != nil {
+		return err
+	}
+	if err := s.loadJSON(TopicSubscriptionsJSON, &s.subscriptions); err != nil {
+		storeLog.Warn("Failed to load topic subscriptions", "file", TopicSubscriptionsJSON, "error", err)
+		s.subscriptions = make(store.Subscriptions)
 	}
 
 	// Load decorations
-	ensureFileExists(DecorationJSON, make(map[string]map[string]Decoration))
-	if err := loadJSON(DecorationJSON, &decorations); err != nil {
-		log.Printf("Warning: Failed to load decorations: %v", err)
-		decorations = make(map[string]map[string]Decoration)
+	var decorations map[string]map[string]delivery.Decoration
+	if err := s.ensureFileExists(DecorationJSON, make(map[string]map[string]delivery.Decoration)); err != nil {
+		return err
+	}
+	if err := s.loadJSON(DecorationJSON, &decorations); err != nil {
+		storeLog.Warn("Failed to load decorations", "file", DecorationJSON, "error", err)
+		decorations = make(map[string]map[string]delivery.Decoration)
 	}
 
 	// Load topic decorations
-	ensureFileExists(TopicDecorationJSON, make(map[string]TopicDecoration))
-	if err := loadJSON(TopicDecorationJSON, &topicDecorations); err != nil {
-		log.Printf("Warning: Failed to load topic decorations: %v", err)
-		topicDecorations = make(map[string]TopicDecoration)
+	var topicDecorations map[string]delivery.TopicDecoration
+	if err := s.ensureFileExists(TopicDecorationJSON, make(map[string]delivery.TopicDecoration)); err != nil {
+		return err
+	}
+	if err := s.loadJSON(TopicDecorationJSON, &topicDecorations); err != nil {
+		storeLog.Warn("Failed to load topic decorations", "file", TopicDecorationJSON, "error", err)
+		topicDecorations = make(map[string]delivery.TopicDecoration)
 	}
 
 	// Load icons
-	ensureFileExists(IconsJSON, make(map[string]string))
-	if err := loadJSON(IconsJSON, &icons); err != nil {
-		log.Printf("Warning: Failed to load icons: %v", err)
+	var icons map[string]string
+	if err := s.ensureFileExists(IconsJSON, make(map[string]string)); err != nil {
+		return err
+	}
+	if err := s.loadJSON(IconsJSON, &icons); err != nil {
+		storeLog.Warn("Failed to load icons", "file", IconsJSON, "error", err)
 		icons = make(map[string]string)
 	}
-}
 
-func ensureFileExists(filename string, defaultValue interface{}) {
-	fullPath := getConfigPath(filename)
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-		if err := os.MkdirAll(filepath.Dir(fullPath), 0o700); err != nil {
-			log.Fatalf("Failed to create directory for %s: %v", filename, err)
-		}
-		if err := writeJSONToFile(fullPath, defaultValue); err != nil {
-			log.Fatalf("Failed to save default value for %s: %v", filename, err)
-		}
-	}
+	s.updateState(func(next *reloadableState) {
+		next.decorations = decorations
+		next.topicDecorations = topicDecorations
+		next.icons = icons
+	})
+	return nil
 }
 
-func loadJSON(filename string, v interface{}) error {
-	fullPath := getConfigPath(filename)
-	// Validate file extension
-	if filepath.Ext(fullPath) != ".json" {
-		return fmt.Errorf("invalid file extension for %s: must be .json", filename)
+// ensureFileExists creates a data file with its default value if it doesn't exist
+func (s *Server) ensureFileExists(filename string, defaultValue interface{}) error {
+	fullPath := s.filePath(filename)
+	if _, err := os.Stat(fullPath); !os.IsNotExist(err) {
+		return nil
 	}
-
-	// Use filepath.Clean to sanitize the path
-	cleanPath := filepath.Clean(fullPath)
-	file, err := os.ReadFile(cleanPath) // #nosec G304 -- path is sanitized
-	if err != nil {
-		return err
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0o700); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %v", filename, err)
 	}
-	return json.Unmarshal(file, v)
+	if err := store.WriteJSON(fullPath, defaultValue); err != nil {
+		return fmt.Errorf("failed to create %s: %v", filename, err)
+	}
+	return nil
 }
 
-func writeJSONToFile(fullPath string, v interface{}) error {
-	data, err := json.MarshalIndent(v, "", "    ")
-	if err != nil {
-		panic(fmt.Sprintf("Failed to marshal JSON: %v", err))
-	}
-	return os.WriteFile(fullPath, data, 0o600)
+func (s *Server) loadJSON(filename string, v interface{}) error {
+	return store.ReadJSON(s.filePath(filename), v)
 }
 
-func saveJSON(filename string, v interface{}) error {
-	fullPath := getConfigPath(filename)
-	return writeJSONToFile(fullPath, v)
+func (s *Server) saveJSON(filename string, v interface{}) error {
+	err := store.WriteJSON(s.filePath(filename), v)
+	s.health.recordStoreResult(err)
+	return err
 }
@@ -1,4 +1,4 @@
-package main
+package relay
 
 import (
 	"os"
@@ -11,8 +11,7 @@ import (
 )
 
 func TestGetConfigPath(t *testing.T) {
-	_, cleanup := setupTestEnvironment(t)
-	defer cleanup()
+	s, _ := setupTestEnvironment(t)
 
 	tests := []struct {
 		name          string
@@ -35,20 +34,19 @@ func TestGetConfigPath(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			if tt.expectedPanic {
 				assert.Panics(t, func() {
-					getConfigPath(tt.filename)
+					getConfigPath(s.configPath, tt.filename)
 				})
 				return
 			}
 
-			path := getConfigPath(tt.filename)
-			assert.Equal(t, filepath.Join(filepath.Dir(configPath), tt.filename), path)
+			path := getConfigPath(s.configPath, tt.filename)
+			assert.Equal(t, filepath.Join(filepath.Dir(s.configPath), tt.filename), path)
 		})
 	}
 }
 
 func TestLoadJSON(t *testing.T) {
-	tmpDir, cleanup := setupTestEnvironment(t)
-	defer cleanup()
+	s, tmpDir := setupTestEnvironment(t)
 
 	// Updated testConfig with Projects map
 	testConfig := Config{
@@ -63,7 +61,7 @@ func TestLoadJSON(t *testing.T) {
 		TrustedProxies: "127.0.0.1",
 	}
 
-	writeTestJSON(t, "test.json", testConfig)
+	writeTestJSON(t, filepath.Join(tmpDir, "test.json"), testConfig)
 
 	tests := []struct {
 		name        string
@@ -107,7 +105,7 @@ func TestLoadJSON(t *testing.T) {
 			require.NoError(t, err)
 
 			var result map[string]interface{}
-			err = loadJSON(tt.filename, &result)
+			err = s.loadJSON(tt.filename, &result)
 
 			if tt.expectError {
 				assert.Error(t, err)
@@ -120,8 +118,7 @@ func TestLoadJSON(t *testing.T) {
 }
 
 func TestEnsureFileExists(t *testing.T) {
-	tmpDir, cleanup := setupTestEnvironment(t)
-	defer cleanup()
+	s, tmpDir := setupTestEnvironment(t)
 
 	tests := []struct {
 		name         string
@@ -139,7 +136,7 @@ func TestEnsureFileExists(t *testing.T) {
 				assert.FileExists(t, path)
 
 				var result map[string]string
-				err := loadJSON("test.json", &result)
+				err := s.loadJSON("test.json", &result)
 				require.NoError(t, err)
 				assert.Equal(t, "value", result["key"])
 			},
@@ -152,7 +149,7 @@ func TestEnsureFileExists(t *testing.T) {
 			},
 			checkResult: func(t *testing.T, path string) {
 				var result map[string]string
-				err := loadJSON("existing.json", &result)
+				err := s.loadJSON("existing.json", &result)
 				require.NoError(t, err)
 				assert.Equal(t, "original", result["existing"])
 			},
@@ -171,17 +168,16 @@ func TestEnsureFileExists(t *testing.T) {
 				writeTestJSON(t, path, existingData)
 			}
 
-			ensureFileExists(tt.filename, tt.defaultValue)
+			require.NoError(t, s.ensureFileExists(tt.filename, tt.defaultValue))
 
-			path := getConfigPath(tt.filename)
+			path := s.filePath(tt.filename)
 			tt.checkResult(t, path)
 		})
 	}
 }
 
 func TestSaveJSON(t *testing.T) {
-	_, cleanup := setupTestEnvironment(t)
-	defer cleanup()
+	s, _ := setupTestEnvironment(t)
 
 	tests := []struct {
 		name        string
@@ -232,19 +228,19 @@ func TestSaveJSON(t *testing.T) {
 
 			if tt.expectError && tt.errorMsg == "Unauthorized file access attempt: unauthorized.json" {
 				assert.PanicsWithValue(t, tt.errorMsg, func() {
-					_ = saveJSON(tt.filename, tt.data)
+					_ = s.saveJSON(tt.filename, tt.data)
 				})
 				return
 			}
 
 			if tt.expectError && strings.Contains(tt.errorMsg, "Failed to marshal JSON") {
 				assert.PanicsWithValue(t, tt.errorMsg, func() {
-					_ = saveJSON(tt.filename, tt.data)
+					_ = s.saveJSON(tt.filename, tt.data)
 				})
 				return
 			}
 
-			err := saveJSON(tt.filename, tt.data)
+			err := s.saveJSON(tt.filename, tt.data)
 
 			if tt.expectError {
 				assert.Error(t, err)
@@ -255,10 +251,46 @@ func TestSaveJSON(t *testing.T) {
 				assert.NoError(t, err)
 				// Verify file contents
 				var result map[string]string
-				err = loadJSON(tt.filename, &result)
+				err = s.loadJSON(tt.filename, &result)
 				require.NoError(t, err)
 				assert.Equal(t, tt.data, result)
 			}
 		})
 	}
 }
+
+func TestGenerateSecureToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int
+	}{
+		{
+			name:   "32 characters",
+			length: 32,
+		},
+		{
+			name:   "48 characters",
+			length: 48,
+		},
+		{
+			name:   "64 characters",
+			length: 64,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := generateSecureToken(tt.length)
+			assert.Len(t, token, tt.length)
+
+			// Verify token contains only allowed characters
+			for _, char := range token {
+				assert.Contains(t, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", string(char))
+			}
+
+			// Generate another token and verify it's different
+			anotherToken := generateSecureToken(tt.length)
+			assert.NotEqual(t, token, anotherToken, "Tokens should be random")
+		})
+	}
+}
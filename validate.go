@@ -0,0 +1,445 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/your-username/notification-relay/delivery"
+	"github.com/your-username/notification-relay/store"
+)
+
+// validationProblem is a problem found in a configuration file, located by field path
+type validationProblem struct {
+	File    string
+	Field   string
+	Message string
+}
+
+func (p validationProblem) Error() string {
+	if p.Field == "" {
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", p.File, p.Field, p.Message)
+}
+
+// joinProblems returns the problems as a single error, or nil if there are none
+func joinProblems(problems []validationProblem) error {
+	errs := make([]error, len(problems))
+	for i, problem := range problems {
+		errs[i] = problem
+	}
+	return errors.Join(errs...)
+}
+
+// validateFiles checks every configuration and data file and reports all problems.
+// Data files that do not exist yet are created with defaults at startup and are skipped.
+func (s *Server) validateFiles() []validationProblem {
+	state := &reloadableState{}
+	var storedCredentials store.Credentials
+	var devices map[string]map[string][]string
+	var subscriptions store.Subscriptions
+
+	files := []struct {
+		name     string
+		target   interface{}
+		required bool
+	}{
+		{ConfigJSON, &state.config, true},
+		{DecorationJSON, &state.decorations, false},
+		{TopicDecorationJSON, &state.topicDecorations, false},
+		{IconsJSON, &state.icons, false},
+		{CredentialsJSON, &storedCredentials, false},
+		{UserDeviceMapJSON, &devices, false},
+		{TopicSubscriptionsJSON, &subscriptions, false},
+	}
+
+	var problems []validationProblem
+	for _, file := range files {
+		problems = append(problems, s.decodeFile(file.name, file.target, file.required)...)
+	}
+	// Only check the contents once all files decoded, to avoid follow-up noise
+	if len(problems) == 0 {
+		problems = append(problems, state.validate(s.configPath)...)
+	}
+	return append(problems, validateServiceAccountFile(s.serviceAccountPath)...)
+}
+
+// decodeFile strictly decodes a file into target: unknown fields and values of
+// the wrong type are reported with their field path
+func (s *Server) decodeFile(filename string, target interface{}, required bool) []validationProblem {
+	content, err := os.ReadFile(filepath.Clean(s.filePath(filename))) // #nosec G304 -- filename is whitelisted
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil
+		}
+		return []validationProblem{{File: filename, Message: err.Error()}}
+	}
+
+	var raw interface{}
+	if err := json.Unmarshal(content, &raw); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line, column := jsonPosition(content, syntaxErr.Offset)
+			return []validationProblem{{
+				File:    filename,
+				Message: fmt.Sprintf("invalid JSON at line %d, column %d: %v", line, column, err),
+			}}
+		}
+		return []validationProblem{{File: filename, Message: fmt.Sprintf("invalid JSON: %v", err)}}
+	}
+
+	if problems := checkSchema(filename, "", raw, reflect.TypeOf(target).Elem()); len(problems) > 0 {
+		return problems
+	}
+	if err := json.Unmarshal(content, target); err != nil {
+		return []validationProblem{{File: filename, Message: err.Error()}}
+	}
+	return nil
+}
+
+// jsonPosition converts the offset of a syntax error, which points just past the
+// offending character, into the 1-based line and column of that character
+func jsonPosition(content []byte, offset int64) (line, column int) {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	if offset > 0 {
+		offset--
+	}
+	before := content[:offset]
+	line = strings.Count(string(before), "\n") + 1
+	column = len(before) - strings.LastIndex(string(before), "\n")
+	return line, column
+}
+
+// checkSchema compares a decoded JSON value against the Go type it is loaded into
+func checkSchema(file, path string, value interface{}, t reflect.Type) []validationProblem {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	// null leaves optional values at their zero value
+	if value == nil {
+		return nil
+	}
+
+	mismatch := func(expected string) []validationProblem {
+		return []validationProblem{{File: file, Field: path, Message: "must be " + expected}}
+	}
+
+	var problems []validationProblem
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch("an object")
+		}
+		fields := jsonFields(t)
+		for _, key := range sortedKeys(object) {
+			field, exists := fields[key]
+			if !exists {
+				problems = append(problems, validationProblem{File: file, Field: joinFieldPath(path, key), Message: "unknown field"})
+				continue
+			}
+			problems = append(problems, checkSchema(file, joinFieldPath(path, key), object[key], field.Type)...)
+		}
+	case reflect.Map:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch("an object")
+		}
+		for _, key := range sortedKeys(object) {
+			problems = append(problems, checkSchema(file, joinFieldPath(path, key), object[key], t.Elem())...)
+		}
+	case reflect.Slice:
+		array, ok := value.([]interface{})
+		if !ok {
+			return mismatch("an array")
+		}
+		for i, item := range array {
+			problems = append(problems, checkSchema(file, fmt.Sprintf("%s[%d]", path, i), item, t.Elem())...)
+		}
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			return mismatch("a string")
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			return mismatch("a boolean")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, ok := value.(float64)
+		if !ok || number != float64(int64(number)) {
+			return mismatch("an integer")
+		}
+	}
+	return problems
+}
+
+// jsonFields maps the JSON names of a struct's fields to the fields
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+func joinFieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validate checks the state for errors that would otherwise only surface when
+// sending notifications. Relative paths are resolved against configPath.
+func (s *reloadableState) validate(configPath string) []validationProblem {
+	problems := validateConfig(configPath, &s.config)
+	problem := func(file, field, format string, args ...any) {
+		problems = append(problems, validationProblem{File: file, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, key := range sortedKeys(s.decorations) {
+		for _, name := range sortedKeys(s.decorations[key]) {
+			decoration := s.decorations[key][name]
+			field := joinFieldPath(key, name)
+			if _, err := regexp.Compile(decoration.Pattern); err != nil {
+				problem(DecorationJSON, field+".pattern", "invalid pattern: %v", err)
+			}
+			if decoration.Template == "" {
+				problem(DecorationJSON, field+".template", "is required")
+			}
+		}
+	}
+	for _, topic := range sortedKeys(s.topicDecorations) {
+		decoration := s.topicDecorations[topic]
+		if _, err := regexp.Compile(decoration.Pattern); err != nil {
+			problem(TopicDecorationJSON, topic+".pattern", "invalid pattern: %v", err)
+		}
+		if decoration.Template == "" {
+			problem(TopicDecorationJSON, topic+".template", "is required")
+		}
+	}
+	for _, key := range sortedKeys(s.icons) {
+		if s.icons[key] == "" {
+			problem(IconsJSON, key, "must not be empty")
+		}
+	}
+	return problems
+}
+
+// validateConfig checks config.json settings, the one at configPath
+func validateConfig(configPath string, cfg *Config) []validationProblem {
+	var problems []validationProblem
+	problem := func(field, format string, args ...any) {
+		problems = append(problems, validationProblem{File: ConfigJSON, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(cfg.Projects) == 0 {
+		problem("projects", "at least one project is required")
+	}
+	for _, name := range sortedKeys(cfg.Projects) {
+		project := cfg.Projects[name]
+		field := joinFieldPath("projects", name)
+		required := map[string]string{
+			"vapid_public_key":                  project.VapidPublicKey,
+			"firebase_config.apiKey":            project.FirebaseConfig.ApiKey,
+			"firebase_config.projectId":         project.FirebaseConfig.ProjectID,
+			"firebase_config.messagingSenderId": project.FirebaseConfig.MessagingSenderId,
+			"firebase_config.appId":             project.FirebaseConfig.AppId,
+		}
+		for _, key := range sortedKeys(required) {
+			if required[key] == "" {
+				problem(joinFieldPath(field, key), "is required")
+			}
+		}
+	}
+
+	if err := ValidateTrustedProxies(cfg.TrustedProxies); err != nil {
+		problem("trusted_proxies", "%v", err)
+	}
+	for i, origin := range cfg.AllowedOrigins {
+		field := fmt.Sprintf("allowed_origins[%d]", i)
+		if origin == "*" {
+			if len(cfg.AllowedOrigins) > 1 {
+				problem(field, "\"*\" must be the only entry")
+			}
+			continue
+		}
+		if err := validateOrigin(origin); err != nil {
+			problem(field, "%v", err)
+		}
+	}
+
+	nonNegative := map[string]int{
+		"hmac_max_skew":       cfg.HMACMaxSkew,
+		"shutdown_timeout":    cfg.ShutdownTimeout,
+		"broadcast_max_users": cfg.BroadcastMaxUsers,
+	}
+	if cfg.ShutdownDrain != nil {
+		nonNegative["shutdown_drain"] = *cfg.ShutdownDrain
+	}
+	if cfg.BruteForce != nil {
+		nonNegative["brute_force.max_failures"] = cfg.BruteForce.MaxFailures
+		nonNegative["brute_force.failure_window"] = cfg.BruteForce.FailureWindow
+		nonNegative["brute_force.base_lockout"] = cfg.BruteForce.BaseLockout
+		nonNegative["brute_force.max_lockout"] = cfg.BruteForce.MaxLockout
+	}
+	if cfg.JWT != nil {
+		nonNegative["jwt.refresh_interval"] = cfg.JWT.RefreshInterval
+	}
+	for _, field := range sortedKeys(nonNegative) {
+		if nonNegative[field] < 0 {
+			problem(field, "must not be negative")
+		}
+	}
+
+	if cfg.JWT != nil {
+		switch {
+		case cfg.JWT.JWKSFile != "":
+			if _, err := os.Stat(jwksFilePath(configPath, cfg.JWT.JWKSFile)); err != nil {
+				problem("jwt.jwks_file", "%v", err)
+			}
+		case cfg.JWT.JWKSURL != "":
+			if u, err := url.Parse(cfg.JWT.JWKSURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				problem("jwt.jwks_url", "must be an http or https URL")
+			}
+		default:
+			problem("jwt", "jwks_file or jwks_url is required")
+		}
+		for i, group := range cfg.JWT.RouteGroups {
+			switch {
+			case group == RouteGroupAdmin:
+				problem(fmt.Sprintf("jwt.route_groups[%d]", i), "admin endpoints require an API key listed in admin_api_keys, bearer tokens are not accepted")
+			case !slices.Contains(routeGroups, group):
+				problem(fmt.Sprintf("jwt.route_groups[%d]", i), "unknown route group %q, expected one of %s", group, strings.Join(routeGroups, ", "))
+			}
+		}
+	}
+
+	if cfg.Topics != nil {
+		for i, topic := range cfg.Topics.GlobalTopics {
+			field := fmt.Sprintf("topics.global_topics[%d]", i)
+			switch {
+			case !delivery.ValidTopicName(topic):
+				problem(field, "invalid topic name %q: only letters, digits and -_.~%% are allowed", topic)
+			case strings.Contains(topic, topicSeparator):
+				problem(field, "must not contain %q, which separates namespaced topics", topicSeparator)
+			}
+		}
+	}
+
+	for i, key := range cfg.AdminAPIKeys {
+		if key == "" {
+			problem(fmt.Sprintf("admin_api_keys[%d]", i), "must not be empty")
+		}
+	}
+
+	if cfg.Logging != nil {
+		if format := strings.ToLower(cfg.Logging.Format); format != "" && format != LogFormatText && format != LogFormatJSON {
+			problem("logging.format", "invalid log format %q: must be text or json", cfg.Logging.Format)
+		}
+		if cfg.Logging.Level != "" {
+			var level slog.Level
+			if err := level.UnmarshalText([]byte(cfg.Logging.Level)); err != nil {
+				problem("logging.level", "invalid log level %q", cfg.Logging.Level)
+			}
+		}
+		for _, subsystem := range sortedKeys(cfg.Logging.Levels) {
+			field := joinFieldPath("logging.levels", subsystem)
+			if _, exists := logLevels[strings.ToLower(subsystem)]; !exists {
+				problem(field, "unknown log subsystem, must be one of %s", strings.Join(logSubsystems, ", "))
+				continue
+			}
+			var level slog.Level
+			if err := level.UnmarshalText([]byte(cfg.Logging.Levels[subsystem])); err != nil {
+				problem(field, "invalid log level %q", cfg.Logging.Levels[subsystem])
+			}
+		}
+	}
+	return problems
+}
+
+// validateOrigin checks that an allowed origin matches what browsers send in the Origin header
+func validateOrigin(origin string) error {
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" ||
+		u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("invalid origin %q: must be scheme and host, such as https://example.com", origin)
+	}
+	return nil
+}
+
+// validateServiceAccountFile checks the Firebase service account file
+func validateServiceAccountFile(serviceAccountPath string) []validationProblem {
+	if serviceAccountPath == "" {
+		return []validationProblem{{
+			File:    "service-account.json",
+			Message: "no service account file found, set GOOGLE_APPLICATION_CREDENTIALS",
+		}}
+	}
+	file := filepath.Base(serviceAccountPath)
+	content, err := os.ReadFile(filepath.Clean(serviceAccountPath)) // #nosec G304 -- path comes from the environment
+	if err != nil {
+		return []validationProblem{{File: file, Message: err.Error()}}
+	}
+	var jsonContent map[string]interface{}
+	if err := json.Unmarshal(content, &jsonContent); err != nil {
+		return []validationProblem{{File: file, Message: fmt.Sprintf("invalid JSON: %v", err)}}
+	}
+	if err := validateServiceAccount(jsonContent); err != nil {
+		return []validationProblem{{File: file, Message: err.Error()}}
+	}
+	return nil
+}
+
+// runValidate implements the validate command
+func runValidate(args []string, out io.Writer) error {
+	if err := parseFlags(newFlagSet("validate", "", out), args, 0); err != nil {
+		return err
+	}
+	configPath, serviceAccountPath, err := (Options{}).locate()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(configPath)
+	problems := newServer(configPath, serviceAccountPath).validateFiles()
+	for _, problem := range problems {
+		fmt.Fprintln(out, problem.Error())
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(out, "%d problem(s) found in %s\n", len(problems), dir)
+		return errSilent
+	}
+	fmt.Fprintf(out, "Configuration in %s is valid\n", dir)
+	return nil
+}
@@ -0,0 +1,195 @@
+package relay
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/your-username/notification-relay/delivery"
+)
+
+func TestValidateFiles(t *testing.T) {
+	tests := []struct {
+		name             string
+		setup            func(t *testing.T, s *Server, tmpDir string)
+		expectedProblems []string
+	}{
+		{
+			name:  "valid configuration",
+			setup: func(t *testing.T, s *Server, tmpDir string) {},
+		},
+		{
+			name: "missing data files are skipped",
+			setup: func(t *testing.T, s *Server, tmpDir string) {
+				require.NoError(t, os.Remove(filepath.Join(tmpDir, IconsJSON)))
+				require.NoError(t, os.Remove(filepath.Join(tmpDir, UserDeviceMapJSON)))
+			},
+		},
+		{
+			name: "missing config file",
+			setup: func(t *testing.T, s *Server, tmpDir string) {
+				require.NoError(t, os.Remove(filepath.Join(tmpDir, ConfigJSON)))
+			},
+			expectedProblems: []string{"config.json: open "},
+		},
+		{
+			name: "syntax error reports position",
+			setup: func(t *testing.T, s *Server, tmpDir string) {
+				require.NoError(t, os.WriteFile(filepath.Join(tmpDir, IconsJSON), []byte("{\n  \"a\": \"b\",\n}"), defaultFileMode))
+			},
+			expectedProblems: []string{"icons.json: invalid JSON at line 3, column 1"},
+		},
+		{
+			name: "unknown fields and wrong types",
+			setup: func(t *testing.T, s *Server, tmpDir string) {
+				require.NoError(t, os.WriteFile(filepath.Join(tmpDir, ConfigJSON), []byte(`{
+					"projects": {"p": {"vapid_public_key": 1, "firebase": {}}},
+					"allowed_origins": "https://example.com",
+					"shutdown_timeout": 1.5
+				}`), defaultFileMode))
+				writeTestJSON(t, filepath.Join(tmpDir, UserDeviceMapJSON), map[string]map[string]string{"p_site": {"user": "token"}})
+			},
+			expectedProblems: []string{
+				"config.json: allowed_origins: must be an array",
+				"config.json: projects.p.firebase: unknown field",
+				"config.json: projects.p.vapid_public_key: must be a string",
+				"config.json: shutdown_timeout: must be an integer",
+				"user-device-map.json: p_site.user: must be an array",
+			},
+		},
+		{
+			name: "invalid values",
+			setup: func(t *testing.T, s *Server, tmpDir string) {
+				writeTestJSON(t, filepath.Join(tmpDir, ConfigJSON), Config{
+					Projects:          map[string]ProjectConfig{"p": {FirebaseConfig: FirebaseConfig{ApiKey: "key"}}},
+					TrustedProxies:    "10.0.0.0/8, 10.0.0.1/33",
+					AllowedOrigins:    []string{"https://example.com", "https://example.com/app", "*"},
+					ShutdownTimeout:   -1,
+					ShutdownDrain:     func() *int { drain := -1; return &drain }(),
+					BroadcastMaxUsers: -1,
+					JWT:               &JWTConfig{JWKSURL: "ftp://idp/jwks", RouteGroups: []string{"v2", "v3", "admin"}},
+					Topics:            &TopicConfig{Namespace: true, GlobalTopics: []string{"alerts", "two words", "a~b"}},
+					Logging:           &LoggingConfig{Format: "xml", Levels: map[string]string{"db": "debug", "cors": "loud"}},
+				})
+				writeTestJSON(t, filepath.Join(tmpDir, DecorationJSON), map[string]map[string]delivery.Decoration{
+					"p_site": {"broken": {Pattern: "([", Template: "{title}"}},
+				})
+				writeTestJSON(t, filepath.Join(tmpDir, TopicDecorationJSON), map[string]delivery.TopicDecoration{
+					"news": {Pattern: ".*"},
+				})
+			},
+			expectedProblems: []string{
+				"config.json: projects.p.firebase_config.appId: is required",
+				"config.json: projects.p.firebase_config.messagingSenderId: is required",
+				"config.json: projects.p.firebase_config.projectId: is required",
+				"config.json: projects.p.vapid_public_key: is required",
+				"config.json: trusted_proxies: invalid CIDR \"10.0.0.1/33\"",
+				"config.json: allowed_origins[1]: invalid origin \"https://example.com/app\"",
+				"config.json: allowed_origins[2]: \"*\" must be the only entry",
+				"config.json: broadcast_max_users: must not be negative",
+				"config.json: shutdown_drain: must not be negative",
+				"config.json: shutdown_timeout: must not be negative",
+				"config.json: jwt.jwks_url: must be an http or https URL",
+				"config.json: jwt.route_groups[1]: unknown route group \"v3\", expected one of api, v2",
+				"config.json: jwt.route_groups[2]: admin endpoints require an API key listed in admin_api_keys",
+				"config.json: topics.global_topics[1]: invalid topic name \"two words\"",
+				"config.json: topics.global_topics[2]: must not contain \"~\"",
+				"config.json: logging.format: invalid log format \"xml\"",
+				"config.json: logging.levels.cors: invalid log level \"loud\"",
+				"config.json: logging.levels.db: unknown log subsystem",
+				"decoration.json: p_site.broken.pattern: invalid pattern",
+				"topic-decoration.json: news.template: is required",
+			},
+		},
+		{
+			name: "documented config.json example",
+			setup: func(t *testing.T, s *Server, tmpDir string) {
+				example := docExample(t, "docs/configuration.md", "## config.json")
+				require.NoError(t, os.WriteFile(filepath.Join(tmpDir, ConfigJSON), example, defaultFileMode))
+			},
+		},
+		{
+			name: "Firebase setup example",
+			setup: func(t *testing.T, s *Server, tmpDir string) {
+				example := docExample(t, "docs/firebase-setup.md", "")
+				require.NoError(t, os.WriteFile(filepath.Join(tmpDir, ConfigJSON), example, defaultFileMode))
+			},
+		},
+		{
+			name: "invalid service account",
+			setup: func(t *testing.T, s *Server, tmpDir string) {
+				writeTestJSON(t, s.serviceAccountPath, map[string]string{"type": "service_account"})
+			},
+			expectedProblems: []string{"service-account.json: missing or invalid required field: project_id"},
+		},
+		{
+			name: "missing service account",
+			setup: func(t *testing.T, s *Server, tmpDir string) {
+				s.serviceAccountPath = ""
+			},
+			expectedProblems: []string{"service-account.json: no service account file found"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, tmpDir := setupTestEnvironment(t)
+			tt.setup(t, s, tmpDir)
+
+			problems := s.validateFiles()
+			require.Len(t, problems, len(tt.expectedProblems), "%v", problems)
+			for i, expected := range tt.expectedProblems {
+				assert.Contains(t, problems[i].Error(), expected)
+			}
+		})
+	}
+}
+
+// docExample returns the first JSON code block of a document after heading, or
+// of the whole document when heading is empty
+func docExample(t *testing.T, path, heading string) []byte {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	text := string(content)
+	if heading != "" {
+		start := strings.Index(text, "\n"+heading+"\n")
+		require.GreaterOrEqual(t, start, 0, "heading %q not found in %s", heading, path)
+		text = text[start:]
+	}
+	start := strings.Index(text, "```json\n")
+	require.GreaterOrEqual(t, start, 0, "no JSON example in %s", path)
+	text = text[start+len("```json\n"):]
+	end := strings.Index(text, "```")
+	require.GreaterOrEqual(t, end, 0)
+	return []byte(text[:end])
+}
+
+func TestRunValidate(t *testing.T) {
+	s, tmpDir := setupTestEnvironment(t)
+	setupTestCLI(t, s)
+
+	var out bytes.Buffer
+	assert.NoError(t, runValidate(nil, &out))
+	assert.Contains(t, out.String(), "is valid")
+
+	writeTestJSON(t, filepath.Join(tmpDir, ConfigJSON), map[string]interface{}{"projects": map[string]interface{}{}, "port": 80})
+	out.Reset()
+	assert.ErrorIs(t, runValidate(nil, &out), errSilent)
+	assert.Contains(t, out.String(), "config.json: port: unknown field")
+	assert.Contains(t, out.String(), "1 problem(s) found in "+tmpDir)
+}
+
+func TestValidateTrustedProxies(t *testing.T) {
+	for _, value := range []string{"", "none", "*", "10.0.0.0/8", "10.0.0.0/8, 2001:db8::/32"} {
+		assert.NoError(t, ValidateTrustedProxies(value), value)
+	}
+	for _, value := range []string{"10.0.0.1", "10.0.0.0/8,invalid"} {
+		assert.Error(t, ValidateTrustedProxies(value), value)
+	}
+}